@@ -0,0 +1,290 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// annoLoadBalancerAdoptHostKeyFingerprint is the annotation specifying the SHA256 fingerprint of the host key of the server, which is adopted through annoLoadBalancerID.
+	// The fingerprint is required with the host key policy 'strict'.
+	// Defaults to no pinned host key.
+	annoLoadBalancerAdoptHostKeyFingerprint = "kubernetes.cloud.dk/load-balancer-adopt-host-key-fingerprint"
+
+	// annoLoadBalancerAdoptSSHUser is the annotation specifying the user, which has authorized the SSH key of the controller on the server adopted through annoLoadBalancerID.
+	// Defaults to root.
+	annoLoadBalancerAdoptSSHUser = "kubernetes.cloud.dk/load-balancer-adopt-ssh-user"
+)
+
+var (
+	// reAdoptHostKeyFingerprint matches the SHA256 fingerprints of SSH host keys.
+	reAdoptHostKeyFingerprint = regexp.MustCompile(`^SHA256:[0-9A-Za-z+/]{43}$`)
+
+	// reAdoptSSHUser matches the user names, which are accepted for the adoption of a server.
+	reAdoptSSHUser = regexp.MustCompile(`^[a-z_][0-9a-z_-]{0,31}$`)
+)
+
+// adoptLoadBalancer takes over an existing server as the load balancer of a service.
+// The server must authorize the SSH key of the controller for the specified user. It is renamed to the hostname of the load balancer, which marks it as owned by the controller,
+// and the load balancer software is installed, before the service is annotated with the server id. Unlike new servers, the server is never destroyed, if the adoption fails.
+// Servers, which back a node of the cluster, are never adopted, as the load balancer would otherwise destroy the node, when the service is deleted.
+func adoptLoadBalancer(ctx context.Context, c *CloudConfiguration, server *CloudServer, service *v1.Service, hostname string, sshUser string, hostKeyFingerprint string) error {
+	loadBalancerName := getLoadBalancerNameByService(service)
+
+	if service.Spec.Type != v1.ServiceTypeLoadBalancer {
+		return fmt.Errorf("The service '%s/%s' is not of type '%s'", service.Namespace, service.Name, v1.ServiceTypeLoadBalancer)
+	}
+
+	nodeName, err := getNodeNameByServerID(c, server.Information.Identifier)
+
+	if err != nil {
+		return err
+	} else if nodeName != "" {
+		return fmt.Errorf("The server '%s' cannot be adopted, as it backs the node '%s'", server.Information.Identifier, nodeName)
+	}
+
+	existing := CloudServer{
+		CloudConfiguration: c,
+	}
+
+	notFound, err := existing.InitializeByHostname(hostname)
+
+	if err == nil && existing.Information.Identifier != server.Information.Identifier {
+		return fmt.Errorf("The service '%s/%s' already has a load balancer (id: %s)", service.Namespace, service.Name, existing.Information.Identifier)
+	} else if err != nil && !notFound {
+		return err
+	}
+
+	engine, err := getLoadBalancerEngine(service)
+
+	if err != nil {
+		return err
+	}
+
+	debugCloudAction(rtLoadBalancers, "Adopting server '%s' (name: %s)", server.Information.Identifier, loadBalancerName)
+
+	err = server.pinHostKeys(sshUser, hostKeyFingerprint)
+
+	if err != nil {
+		return fmt.Errorf("Failed to verify the host keys of server '%s' - Error: %s", server.Information.Identifier, err.Error())
+	}
+
+	sshClient, err := server.sshAsUser(sshUser)
+
+	if err != nil {
+		return fmt.Errorf("Failed to establish an SSH connection to server '%s' - Error: %s", server.Information.Identifier, err.Error())
+	}
+
+	defer sshClient.Close()
+
+	if c.SSHPerServiceKeys {
+		server.SSHKeyPair, err = ensureServiceSSHKeyPair(c, service)
+
+		if err != nil {
+			return err
+		}
+
+		debugCloudAction(rtLoadBalancers, "Authorizing dedicated SSH key pair (name: %s)", loadBalancerName)
+
+		output, err := server.RunCommand(sshClient, getAuthorizeSSHKeyCommand(sshUser, server.SSHKeyPair.PublicKey))
+
+		if err != nil {
+			return fmt.Errorf("Failed to authorize the dedicated SSH key pair - Output: %s - Error: %s", string(output), err.Error())
+		}
+	}
+
+	err = server.Rename(hostname)
+
+	if err != nil {
+		return err
+	}
+
+	setProvisioningState(c, service, provisioningStateBooted)
+
+	err = provisionLoadBalancer(ctx, c, server, service)
+
+	if err != nil {
+		return err
+	}
+
+	output, err := server.RunCommand(sshClient, fmt.Sprintf("%s -v", engine))
+
+	if err != nil {
+		return fmt.Errorf("Failed to verify the %s installation - Output: %s - Error: %s", engine, string(output), err.Error())
+	}
+
+	setProvisioningState(c, service, provisioningStateConfigured)
+	updateLoadBalancerStatus(c, service, map[string]string{
+		statusKeyIPAddresses: getServerIPAddresses(server),
+		statusKeyServerID:    server.Information.Identifier,
+	})
+
+	return setLoadBalancerIDAnnotation(c, service, server.Information.Identifier)
+}
+
+// adoptAnnotatedServer adopts the server referenced by the id annotation of a service, which allows a hand-built load balancer to be taken over without the 'lb adopt' command.
+// Servers with the hostname format of the controller are never adopted, as they belong to another load balancer.
+// The adoption must be enabled with CLOUDDK_LOAD_BALANCER_ADOPTION, as anyone, who can create a service, could otherwise take over any server in the account.
+// A nil object is returned, if the service does not reference a server, which can be adopted.
+func adoptAnnotatedServer(ctx context.Context, c *CloudConfiguration, hostname string, service *v1.Service) (*CloudServer, error) {
+	id := service.Annotations[annoLoadBalancerID]
+
+	if id == "" {
+		return nil, nil
+	}
+
+	server := &CloudServer{
+		CloudConfiguration: c,
+	}
+
+	notFound, err := server.InitializeByID(id)
+
+	if err != nil {
+		if notFound {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	if strings.HasPrefix(server.Information.Hostname, fmt.Sprintf(fmtLoadBalancerHostname, "")) {
+		return nil, nil
+	}
+
+	if !c.LoadBalancerAdoption {
+		return nil, newConfigurationError(fmt.Errorf("The annotation '%s' references server '%s', which cannot be adopted, as %s is disabled", annoLoadBalancerID, id, envLoadBalancerAdoption))
+	}
+
+	if isSharedLoadBalancer(service) {
+		return nil, newConfigurationError(fmt.Errorf("The annotation '%s' cannot adopt servers for shared load balancers", annoLoadBalancerID))
+	}
+
+	sshUser := service.Annotations[annoLoadBalancerAdoptSSHUser]
+
+	if sshUser == "" {
+		sshUser = "root"
+	}
+
+	err = validateAdoptSSHUser(sshUser)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", annoLoadBalancerAdoptSSHUser, getLoadBalancerNameByService(service))
+
+		return nil, newConfigurationError(err)
+	}
+
+	hostKeyFingerprint := service.Annotations[annoLoadBalancerAdoptHostKeyFingerprint]
+
+	if hostKeyFingerprint != "" {
+		err = validateAdoptHostKeyFingerprint(hostKeyFingerprint)
+
+		if err != nil {
+			debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", annoLoadBalancerAdoptHostKeyFingerprint, getLoadBalancerNameByService(service))
+
+			return nil, newConfigurationError(err)
+		}
+	}
+
+	err = adoptLoadBalancer(ctx, c, server, service, hostname, sshUser, hostKeyFingerprint)
+
+	if err != nil {
+		return nil, err
+	}
+
+	recordServiceEvent(c, service, v1.EventTypeNormal, eventReasonLoadBalancerAdopted, "Adopted server '%s' (hostname: %s)", server.Information.Identifier, hostname)
+
+	return server, nil
+}
+
+// getAuthorizeSSHKeyCommand generates the command, which adds a public key to the authorized keys of a user, unless the key is already present.
+// The home directory is looked up explicitly, as the command runs through sudo for unprivileged users, where '~' refers to the home directory of root.
+func getAuthorizeSSHKeyCommand(user string, publicKey string) string {
+	return fmt.Sprintf(
+		`home="$(getent passwd %[1]s | cut -d: -f6)" && [ -n "$home" ] && `+
+			`mkdir -p -m 700 "$home/.ssh" && touch "$home/.ssh/authorized_keys" && chmod 600 "$home/.ssh/authorized_keys" && `+
+			`{ grep -qxF %[2]s "$home/.ssh/authorized_keys" || echo %[2]s >> "$home/.ssh/authorized_keys"; } && `+
+			`chown %[1]s: "$home/.ssh" "$home/.ssh/authorized_keys"`,
+		shellQuote(user),
+		shellQuote(publicKey),
+	)
+}
+
+// getNodeNameByServerID retrieves the name of the node, whose provider id references a server.
+// An empty string is returned, if the server does not back a node.
+func getNodeNameByServerID(c *CloudConfiguration, id string) (string, error) {
+	if c.KubeClient == nil {
+		return "", errors.New("Cannot verify that the server does not back a node without a Kubernetes client")
+	}
+
+	nodes, err := c.KubeClient.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{})
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to retrieve the nodes - Error: %s", err.Error())
+	}
+
+	for _, node := range nodes.Items {
+		if node.Spec.ProviderID != "" && trimProviderID(node.Spec.ProviderID) == id {
+			return node.Name, nil
+		}
+	}
+
+	return "", nil
+}
+
+// validateAdoptHostKeyFingerprint validates the host key fingerprint of a server, which is adopted through annoLoadBalancerID.
+func validateAdoptHostKeyFingerprint(value string) error {
+	if !reAdoptHostKeyFingerprint.MatchString(value) {
+		return fmt.Errorf("The value '%s' is not a SHA256 fingerprint", value)
+	}
+
+	return nil
+}
+
+// validateAdoptSSHUser validates the user, which is used to adopt a server through annoLoadBalancerID.
+func validateAdoptSSHUser(value string) error {
+	if !reAdoptSSHUser.MatchString(value) {
+		return fmt.Errorf("The value '%s' is not a valid user name", value)
+	}
+
+	return nil
+}
+
+// setLoadBalancerIDAnnotation stores the id of the load balancer server in the annotations of a service.
+func setLoadBalancerIDAnnotation(c *CloudConfiguration, service *v1.Service, id string) error {
+	if c.KubeClient == nil {
+		return nil
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current, err := c.KubeClient.CoreV1().Services(service.Namespace).Get(context.Background(), service.Name, metav1.GetOptions{})
+
+		if err != nil {
+			return err
+		}
+
+		if current.Annotations[annoLoadBalancerID] == id {
+			return nil
+		}
+
+		if current.Annotations == nil {
+			current.Annotations = map[string]string{}
+		}
+
+		current.Annotations[annoLoadBalancerID] = id
+
+		_, err = c.KubeClient.CoreV1().Services(service.Namespace).Update(context.Background(), current, metav1.UpdateOptions{})
+
+		return err
+	})
+}
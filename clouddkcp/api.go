@@ -0,0 +1,347 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danitso/terraform-provider-clouddk/clouddk"
+	"golang.org/x/net/http2"
+)
+
+const (
+	// apiIdleConnTimeout specifies how long an idle connection to the API is kept open for reuse.
+	apiIdleConnTimeout = 90 * time.Second
+
+	// apiMaxIdleConnsPerHost specifies the number of idle connections to the API, which are kept open for reuse.
+	// The default value of the HTTP transport is 2, which causes concurrent reconciles to repeat the TLS handshake.
+	apiMaxIdleConnsPerHost = 32
+)
+
+var (
+	// tlsVersions maps the supported values of the minimum TLS version to their constants.
+	tlsVersions = map[string]uint16{
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+)
+
+// newAPIClient initializes a new HTTP client for the Cloud.dk API.
+// The certificates in the CA bundle are trusted in addition to the system certificates.
+// When public keys have been pinned, the certificate chain presented by the API must contain at least one of them.
+func newAPIClient(caBundlePath string, minVersion string, pinnedKeys []string, strictCrypto bool) (*http.Client, error) {
+	tlsConfig := &tls.Config{}
+
+	if caBundlePath != "" {
+		caBundle, err := ioutil.ReadFile(caBundlePath)
+
+		if err != nil {
+			return nil, err
+		}
+
+		rootCAs, err := x509.SystemCertPool()
+
+		if err != nil || rootCAs == nil {
+			rootCAs = x509.NewCertPool()
+		}
+
+		if !rootCAs.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf("The file '%s' does not contain any PEM encoded certificates", caBundlePath)
+		}
+
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	version, ok := tlsVersions[minVersion]
+
+	if !ok {
+		return nil, fmt.Errorf("The TLS version '%s' is not supported", minVersion)
+	}
+
+	tlsConfig.MinVersion = version
+
+	if strictCrypto {
+		applyTLSCryptoPolicy(tlsConfig)
+	}
+
+	if len(pinnedKeys) > 0 {
+		pins := map[string]bool{}
+
+		for _, k := range pinnedKeys {
+			pins[strings.TrimPrefix(k, "sha256/")] = true
+		}
+
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			for _, chain := range verifiedChains {
+				for _, cert := range chain {
+					hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+
+					if pins[base64.StdEncoding.EncodeToString(hash[:])] {
+						return nil
+					}
+				}
+			}
+
+			return errors.New("The certificate chain presented by the API does not contain any of the pinned public keys")
+		}
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ExpectContinueTimeout: 1 * time.Second,
+		IdleConnTimeout:       apiIdleConnTimeout,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   apiMaxIdleConnsPerHost,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   10 * time.Second,
+	}
+
+	// HTTP/2 is not enabled automatically for transports with a custom dialer or TLS configuration.
+	err := http2.ConfigureTransport(transport)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{
+		Transport: transport,
+	}, nil
+}
+
+// APIError describes a request against the Cloud.dk API, which did not succeed.
+type APIError struct {
+	Message    string
+	Method     string
+	Path       string
+	StatusCode int
+}
+
+// Error returns the error message.
+func (e *APIError) Error() string {
+	return fmt.Sprintf("Failed to query the API - Reason: %s - Method: %s - Path: %s", e.Message, e.Method, e.Path)
+}
+
+// NotFoundError indicates that the requested resource does not exist.
+type NotFoundError struct {
+	*APIError
+}
+
+// RateLimitedError indicates that the API rejected the request, because the rate limit has been exceeded.
+type RateLimitedError struct {
+	*APIError
+	RetryAfter time.Duration
+}
+
+// ServerError indicates that the API failed to process the request.
+type ServerError struct {
+	*APIError
+}
+
+// newAPIError initializes the error type, which matches the status code of a response.
+func newAPIError(response *http.Response, message string, method string, path string) error {
+	apiError := &APIError{
+		Message:    message,
+		Method:     method,
+		Path:       path,
+		StatusCode: response.StatusCode,
+	}
+
+	switch {
+	case response.StatusCode == http.StatusNotFound:
+		return &NotFoundError{apiError}
+	case response.StatusCode == http.StatusTooManyRequests:
+		return &RateLimitedError{APIError: apiError, RetryAfter: getRetryAfter(response)}
+	case response.StatusCode >= 500:
+		return &ServerError{apiError}
+	default:
+		return apiError
+	}
+}
+
+// getRetryAfter retrieves the delay requested by the Retry-After header of a response, which may contain either seconds or a date.
+func getRetryAfter(response *http.Response) time.Duration {
+	value := response.Header.Get("Retry-After")
+
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+	}
+
+	return 0
+}
+
+// isIndeterminateError returns whether a failed request may nevertheless have been processed by the API.
+// This is the case for transport errors such as timeouts and for server errors, while any other response proves that the request was rejected.
+func isIndeterminateError(err error) bool {
+	if err == nil || isCircuitOpenError(err) {
+		return false
+	}
+
+	switch err.(type) {
+	case *APIError, *NotFoundError, *RateLimitedError:
+		return false
+	default:
+		return true
+	}
+}
+
+// isNotFoundError returns whether an error indicates that the requested resource does not exist.
+func isNotFoundError(err error) bool {
+	_, ok := err.(*NotFoundError)
+
+	return ok
+}
+
+// doClientRequest performs a request against the Cloud.dk API, unless a cached response is available for a GET request.
+// Concurrent GET requests for the same path are coalesced into a single request. Any other request invalidates the cached responses for the resource, even if it fails, as the resource may have been changed.
+func doClientRequest(c *CloudConfiguration, method string, path string, body *bytes.Buffer, successCodes []int, retryLimit int, retryDelay int) (*http.Response, error) {
+	if method != "GET" {
+		defer c.ResponseCache.Invalidate(path)
+
+		if getResponseCacheResource(path) == "cloudservers" {
+			defer c.ServerList.Invalidate()
+		}
+
+		return doUncachedClientRequest(c, method, path, body, successCodes, retryLimit, retryDelay)
+	}
+
+	if response, ok := c.ResponseCache.Get(path); ok {
+		return response, nil
+	}
+
+	return c.RequestGroup.Do(path, func() (*http.Response, error) {
+		response, err := doUncachedClientRequest(c, method, path, body, successCodes, retryLimit, retryDelay)
+
+		if err != nil {
+			return response, err
+		}
+
+		return c.ResponseCache.Put(path, response)
+	})
+}
+
+// doUncachedClientRequest performs a request against the Cloud.dk API and retries it, if required.
+// It mirrors clouddk.DoClientRequest, but uses the HTTP client from the cloud configuration.
+// Requests are paced by the shared rate limiter and are not sent, while the circuit breaker is open. Missing resources fail immediately, server errors are retried every retryDelay seconds and rate limited requests are retried
+// after the delay requested by the API or with exponential backoff, as long as the next attempt falls within retryLimit*retryDelay seconds.
+func doUncachedClientRequest(c *CloudConfiguration, method string, path string, body *bytes.Buffer, successCodes []int, retryLimit int, retryDelay int) (*http.Response, error) {
+	timeMax := time.Duration(retryLimit*retryDelay) * time.Second
+	timeStart := time.Now()
+	rateLimitDelay := time.Duration(retryDelay) * time.Second
+
+	bodyString := body.String()
+
+	for {
+		requestBody := bytes.NewBufferString(bodyString)
+		request, err := clouddk.GetClientRequestObject(c.getClientSettings(), method, path, requestBody)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if requestBody.Len() > 0 {
+			request.Header.Set("Content-Type", "application/json")
+		}
+
+		c.RateLimiter.Wait()
+
+		if !c.CircuitBreaker.Allow() {
+			return nil, errCircuitOpen
+		}
+
+		response, err := c.APIClient.Do(request)
+
+		if err != nil {
+			c.CircuitBreaker.RecordFailure()
+
+			return response, err
+		}
+
+		if response.StatusCode >= 500 {
+			c.CircuitBreaker.RecordFailure()
+		} else {
+			c.CircuitBreaker.RecordSuccess()
+		}
+
+		if response.StatusCode == http.StatusTooManyRequests {
+			c.RateLimiter.Throttle(getRetryAfter(response))
+		} else {
+			c.RateLimiter.Recover()
+		}
+
+		for _, v := range successCodes {
+			if response.StatusCode == v {
+				return response, nil
+			}
+		}
+
+		errorBody := clouddk.ErrorBody{}
+		json.NewDecoder(response.Body).Decode(&errorBody)
+
+		// The body must be read to the end, before the connection can be reused.
+		io.Copy(ioutil.Discard, response.Body)
+		response.Body.Close()
+
+		errorMessage := fmt.Sprintf("HTTP %s", response.Status)
+
+		if len(errorBody.Message) > 0 {
+			errorMessage = fmt.Sprintf("%s (HTTP %d)", errorBody.Message, response.StatusCode)
+		}
+
+		err = newAPIError(response, errorMessage, method, path)
+		delay := time.Duration(retryDelay) * time.Second
+
+		switch e := err.(type) {
+		case *RateLimitedError:
+			delay = rateLimitDelay
+			rateLimitDelay = rateLimitDelay * 2
+
+			if e.RetryAfter > 0 {
+				delay = e.RetryAfter
+			}
+		case *ServerError:
+		default:
+			// Servers, which have not finished building yet, are reported as bad requests and must be retried.
+			if response.StatusCode != http.StatusBadRequest || !strings.Contains(errorBody.Message, "CloudServer that is not yet built") {
+				return response, err
+			}
+		}
+
+		if time.Now().Sub(timeStart)+delay >= timeMax {
+			return response, err
+		}
+
+		time.Sleep(delay)
+	}
+}
@@ -0,0 +1,257 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// auditOutputLimit specifies the maximum number of bytes of command output to include in an audit record.
+	auditOutputLimit = 4096
+
+	// auditRecordTypeCommand specifies the record type for remote commands.
+	auditRecordTypeCommand = "command"
+
+	// auditRecordTypeMutation specifies the record type for mutations of cloud resources.
+	auditRecordTypeMutation = "mutation"
+
+	// auditRecordTypeUpload specifies the record type for file uploads.
+	auditRecordTypeUpload = "upload"
+
+	// auditWebhookQueueSize specifies the maximum number of audit records waiting to be delivered to the webhook.
+	auditWebhookQueueSize = 1000
+
+	mutationActionConfigReload   = "config_reload"
+	mutationActionHAProxyUpgrade = "haproxy_upgrade"
+	mutationActionRuntimeUpdate  = "runtime_update"
+	mutationActionServerCreate   = "server_create"
+	mutationActionServerDestroy  = "server_destroy"
+	mutationActionServerRename   = "server_rename"
+	mutationActionServerResize   = "server_resize"
+)
+
+// AuditLog writes audit records for the actions performed on managed servers.
+type AuditLog struct {
+	file            *os.File
+	mutex           sync.Mutex
+	mutationWebhook *auditWebhook
+	webhook         *auditWebhook
+}
+
+// AuditRecord describes an action performed on a managed server.
+type AuditRecord struct {
+	Action    string            `json:"action,omitempty"`
+	Command   string            `json:"command,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+	Duration  float64           `json:"duration_seconds"`
+	Error     string            `json:"error,omitempty"`
+	ExitCode  int               `json:"exit_code"`
+	Hostname  string            `json:"hostname"`
+	Mode      string            `json:"mode,omitempty"`
+	Output    string            `json:"output,omitempty"`
+	Path      string            `json:"path,omitempty"`
+	ServerID  string            `json:"server_id"`
+	Size      int               `json:"size,omitempty"`
+	Timestamp string            `json:"timestamp"`
+	Truncated bool              `json:"truncated,omitempty"`
+	Type      string            `json:"type"`
+}
+
+// auditWebhook delivers audit records to a webhook in the background.
+type auditWebhook struct {
+	client *http.Client
+	queue  chan []byte
+	url    string
+}
+
+// newAuditLog initializes a new audit log, which writes records to a file and/or a webhook.
+// The mutation webhook only receives the records for mutations of cloud resources and must use HTTPS.
+// The returned value is nil, if neither a file nor a webhook has been specified.
+func newAuditLog(filePath string, webhookURL string, mutationWebhookURL string) (*AuditLog, error) {
+	if filePath == "" && webhookURL == "" && mutationWebhookURL == "" {
+		return nil, nil
+	}
+
+	a := &AuditLog{}
+
+	if filePath == "-" {
+		a.file = os.Stdout
+	} else if filePath != "" {
+		file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, fileModePrivate)
+
+		if err != nil {
+			return nil, err
+		}
+
+		a.file = file
+	}
+
+	if webhookURL != "" {
+		a.webhook = newAuditWebhook(webhookURL)
+	}
+
+	if mutationWebhookURL != "" {
+		u, err := url.Parse(mutationWebhookURL)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("The mutation webhook URL '%s' is not an HTTPS URL", mutationWebhookURL)
+		}
+
+		a.mutationWebhook = newAuditWebhook(mutationWebhookURL)
+	}
+
+	return a, nil
+}
+
+// newAuditWebhook initializes a new auditWebhook object and starts delivering records.
+func newAuditWebhook(webhookURL string) *auditWebhook {
+	w := &auditWebhook{
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan []byte, auditWebhookQueueSize),
+		url:    webhookURL,
+	}
+
+	go w.deliver()
+
+	return w
+}
+
+// RecordCommand writes an audit record for a remote command.
+func (a *AuditLog) RecordCommand(s *CloudServer, command string, output []byte, err error, timeStart time.Time) {
+	if a == nil {
+		return
+	}
+
+	record := a.newRecord(s, auditRecordTypeCommand, err, timeStart)
+	record.Command = command
+	record.Output = string(output)
+
+	if len(output) > auditOutputLimit {
+		record.Output = string(output[:auditOutputLimit])
+		record.Truncated = true
+	}
+
+	a.write(record)
+}
+
+// RecordMutation writes an audit record for a mutation of a cloud resource, such as the creation of a server or a configuration reload.
+func (a *AuditLog) RecordMutation(s *CloudServer, action string, details map[string]string, err error, timeStart time.Time) {
+	if a == nil {
+		return
+	}
+
+	record := a.newRecord(s, auditRecordTypeMutation, err, timeStart)
+	record.Action = action
+	record.Details = details
+
+	a.write(record)
+}
+
+// RecordUpload writes an audit record for a file upload.
+func (a *AuditLog) RecordUpload(s *CloudServer, filePath string, mode os.FileMode, size int, err error, timeStart time.Time) {
+	if a == nil {
+		return
+	}
+
+	record := a.newRecord(s, auditRecordTypeUpload, err, timeStart)
+	record.Mode = fmt.Sprintf("%04o", mode)
+	record.Path = filePath
+	record.Size = size
+
+	a.write(record)
+}
+
+// deliver posts queued audit records to the webhook.
+func (w *auditWebhook) deliver() {
+	for body := range w.queue {
+		res, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+
+		if err != nil {
+			debugCloudAction(rtCloud, "Failed to deliver audit record to webhook - Error: %s", err.Error())
+
+			continue
+		}
+
+		res.Body.Close()
+
+		if res.StatusCode < 200 || res.StatusCode >= 300 {
+			debugCloudAction(rtCloud, "Failed to deliver audit record to webhook - Status: %d", res.StatusCode)
+		}
+	}
+}
+
+// enqueue queues an audit record for delivery to the webhook.
+func (w *auditWebhook) enqueue(body []byte) {
+	select {
+	case w.queue <- body:
+	default:
+		debugCloudAction(rtCloud, "Dropping audit record because the webhook queue is full")
+	}
+}
+
+// newRecord initializes a new audit record.
+func (a *AuditLog) newRecord(s *CloudServer, recordType string, err error, timeStart time.Time) AuditRecord {
+	record := AuditRecord{
+		Duration:  time.Now().Sub(timeStart).Seconds(),
+		Hostname:  s.Information.Hostname,
+		ServerID:  s.Information.Identifier,
+		Timestamp: timeStart.UTC().Format(time.RFC3339Nano),
+		Type:      recordType,
+	}
+
+	if err != nil {
+		record.Error = err.Error()
+		record.ExitCode = -1
+
+		if exitError, ok := err.(*ssh.ExitError); ok {
+			record.ExitCode = exitError.ExitStatus()
+		}
+	}
+
+	return record
+}
+
+// write writes an audit record to the configured destinations.
+func (a *AuditLog) write(record AuditRecord) {
+	body, err := json.Marshal(record)
+
+	if err != nil {
+		debugCloudAction(rtCloud, "Failed to encode audit record - Error: %s", err.Error())
+
+		return
+	}
+
+	if a.file != nil {
+		a.mutex.Lock()
+		_, err = a.file.Write(append(body, '\n'))
+		a.mutex.Unlock()
+
+		if err != nil {
+			debugCloudAction(rtCloud, "Failed to write audit record - Error: %s", err.Error())
+		}
+	}
+
+	if a.webhook != nil {
+		a.webhook.enqueue(body)
+	}
+
+	if a.mutationWebhook != nil && record.Type == auditRecordTypeMutation {
+		a.mutationWebhook.enqueue(body)
+	}
+}
@@ -0,0 +1,185 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/danitso/terraform-provider-clouddk/clouddk"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	circuitStateClosed   = "closed"
+	circuitStateHalfOpen = "half-open"
+	circuitStateOpen     = "open"
+)
+
+var (
+	// errCircuitOpen is returned instead of sending requests to the API, while the circuit breaker is open.
+	errCircuitOpen = errors.New("The Cloud.dk API is unavailable and requests have been suspended until it recovers")
+)
+
+// CircuitBreaker suspends requests to the API after a number of consecutive failures.
+// Once the cooldown has passed, a single trial request is allowed through, which either closes the circuit or opens it again.
+type CircuitBreaker struct {
+	cooldown  time.Duration
+	failures  int
+	mutex     sync.Mutex
+	notified  map[string]bool
+	openedAt  time.Time
+	servers   map[string]clouddk.ServerBody
+	state     string
+	threshold int
+}
+
+// newCircuitBreaker initializes a new CircuitBreaker object. The circuit never opens, if the threshold is zero.
+func newCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		cooldown:  cooldown,
+		notified:  map[string]bool{},
+		servers:   map[string]clouddk.ServerBody{},
+		state:     circuitStateClosed,
+		threshold: threshold,
+	}
+}
+
+// Allow determines whether a request may be sent to the API.
+func (b *CircuitBreaker) Allow() bool {
+	if b == nil || b.threshold == 0 {
+		return true
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case circuitStateHalfOpen:
+		return false
+	case circuitStateOpen:
+		if time.Now().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+
+		debugCloudAction(rtCloud, "Circuit breaker is half-open - Sending trial request to the API")
+
+		b.state = circuitStateHalfOpen
+	}
+
+	return true
+}
+
+// IsOpen returns whether the controller is running in degraded mode due to an API outage.
+func (b *CircuitBreaker) IsOpen() bool {
+	if b == nil {
+		return false
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.state != circuitStateClosed
+}
+
+// Notify returns whether an outage has yet to be reported for the specified key.
+func (b *CircuitBreaker) Notify(key string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.notified[key] {
+		return false
+	}
+
+	b.notified[key] = true
+
+	return true
+}
+
+// RecordFailure records a request, which failed due to a network or server error.
+func (b *CircuitBreaker) RecordFailure() {
+	if b == nil || b.threshold == 0 {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.failures++
+
+	if b.state == circuitStateHalfOpen || (b.state == circuitStateClosed && b.failures >= b.threshold) {
+		debugCloudAction(rtCloud, "WARNING: Circuit breaker opened after %d consecutive failures - Entering degraded mode for %s", b.failures, b.cooldown.String())
+
+		b.openedAt = time.Now()
+		b.state = circuitStateOpen
+
+		apiCircuitBreakerOpen.Set(1)
+	}
+}
+
+// RecordSuccess records a request, which reached the API.
+func (b *CircuitBreaker) RecordSuccess() {
+	if b == nil || b.threshold == 0 {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.failures = 0
+
+	if b.state != circuitStateClosed {
+		debugCloudAction(rtCloud, "Circuit breaker closed - Leaving degraded mode")
+
+		b.notified = map[string]bool{}
+		b.state = circuitStateClosed
+
+		apiCircuitBreakerOpen.Set(0)
+	}
+}
+
+// GetServer retrieves the most recently observed information about a server by either its identifier or hostname.
+func (b *CircuitBreaker) GetServer(key string) (clouddk.ServerBody, bool) {
+	if b == nil {
+		return clouddk.ServerBody{}, false
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	server, ok := b.servers[key]
+
+	return server, ok
+}
+
+// PutServer remembers the information about a server, which is served in degraded mode.
+func (b *CircuitBreaker) PutServer(server clouddk.ServerBody) {
+	if b == nil || b.threshold == 0 {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.servers["id/"+server.Identifier] = server
+	b.servers["hostname/"+server.Hostname] = server
+}
+
+// isCircuitOpenError returns whether a request was suspended by the circuit breaker.
+func isCircuitOpenError(err error) bool {
+	return err == errCircuitOpen
+}
+
+// deferLoadBalancerMutation returns the error, which postpones changes to a load balancer while the API is unavailable.
+// A single event is emitted on the service per outage instead of a failure for every sync.
+func deferLoadBalancerMutation(c *CloudConfiguration, service *v1.Service) error {
+	if c.CircuitBreaker.Notify(getServiceKey(service)) {
+		debugCloudAction(rtLoadBalancers, "Deferring changes to load balancer due to API outage (name: %s)", getLoadBalancerNameByService(service))
+		recordServiceEvent(c, service, v1.EventTypeWarning, eventReasonCloudAPIUnavailable, "The Cloud.dk API is unavailable - Changes to the load balancer have been deferred until it recovers")
+	}
+
+	return errCircuitOpen
+}
@@ -0,0 +1,151 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// responseCacheStaticTTL specifies how long responses for resources, which rarely change, are cached.
+	responseCacheStaticTTL = 10 * time.Minute
+)
+
+var (
+	// responseCacheResources specifies the API resources, whose responses may be cached, and whether they are static.
+	responseCacheResources = map[string]bool{
+		"cloudservers": false,
+		"locations":    true,
+		"packages":     true,
+	}
+)
+
+// ResponseCache caches successful GET responses from the API for a short period of time.
+// Any mutation of a resource invalidates the cached responses for all paths of the same resource.
+type ResponseCache struct {
+	entries map[string]ResponseCacheEntry
+	mutex   sync.Mutex
+	ttl     time.Duration
+}
+
+// ResponseCacheEntry describes a cached response.
+type ResponseCacheEntry struct {
+	Body       []byte
+	Expires    time.Time
+	Header     http.Header
+	Status     string
+	StatusCode int
+}
+
+// newResponseCache initializes a new ResponseCache object. Responses are not cached, if the TTL is zero.
+func newResponseCache(ttl time.Duration) *ResponseCache {
+	return &ResponseCache{
+		entries: map[string]ResponseCacheEntry{},
+		ttl:     ttl,
+	}
+}
+
+// Get retrieves a copy of the cached response for a path, if it has not expired.
+func (c *ResponseCache) Get(path string) (*http.Response, bool) {
+	if c == nil || c.ttl == 0 {
+		return nil, false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[path]
+
+	if !ok {
+		return nil, false
+	}
+
+	if time.Now().After(entry.Expires) {
+		delete(c.entries, path)
+
+		return nil, false
+	}
+
+	return &http.Response{
+		Body:       ioutil.NopCloser(bytes.NewReader(entry.Body)),
+		Header:     entry.Header,
+		Status:     entry.Status,
+		StatusCode: entry.StatusCode,
+	}, true
+}
+
+// Invalidate removes the cached responses for the resource, which the path belongs to.
+func (c *ResponseCache) Invalidate(path string) {
+	if c == nil || c.ttl == 0 {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	resource := getResponseCacheResource(path)
+
+	for k := range c.entries {
+		if getResponseCacheResource(k) == resource {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// Put caches a successful response, if the path is cacheable, and returns a response, which can still be read by the caller.
+func (c *ResponseCache) Put(path string, response *http.Response) (*http.Response, error) {
+	if c == nil || c.ttl == 0 || response.StatusCode != http.StatusOK {
+		return response, nil
+	}
+
+	static, ok := responseCacheResources[getResponseCacheResource(path)]
+
+	if !ok || !isResponseCachePath(path) {
+		return response, nil
+	}
+
+	body, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := c.ttl
+
+	if static {
+		ttl = responseCacheStaticTTL
+	}
+
+	c.mutex.Lock()
+	c.entries[path] = ResponseCacheEntry{
+		Body:       body,
+		Expires:    time.Now().Add(ttl),
+		Header:     response.Header,
+		Status:     response.Status,
+		StatusCode: response.StatusCode,
+	}
+	c.mutex.Unlock()
+
+	response.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	return response, nil
+}
+
+// getResponseCacheResource retrieves the name of the resource, which a path belongs to.
+func getResponseCacheResource(path string) string {
+	return strings.SplitN(strings.SplitN(path, "?", 2)[0], "/", 2)[0]
+}
+
+// isResponseCachePath determines whether a path refers to either a list of resources or a single resource.
+// Paths of sub-resources, like the actions of a server, are not cached, as they are polled while waiting for changes.
+func isResponseCachePath(path string) bool {
+	return strings.Count(strings.SplitN(path, "?", 2)[0], "/") <= 1
+}
@@ -9,32 +9,241 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io"
+	"net"
+	"net/http"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	cloudprovider "k8s.io/cloud-provider"
 
 	"github.com/danitso/terraform-provider-clouddk/clouddk"
 )
 
 const (
+	// addressFamilyAuto specifies that IPv4 addresses are preferred with IPv6 addresses as a fallback.
+	addressFamilyAuto = "auto"
+
+	// addressFamilyIPv4 specifies that only IPv4 addresses are used.
+	addressFamilyIPv4 = "ipv4"
+
+	// addressFamilyIPv6 specifies that only IPv6 addresses are used.
+	addressFamilyIPv6 = "ipv6"
+
 	// ProviderName specifies the name of the cloud controller manager defined in this file.
 	ProviderName = "clouddk"
 
+	// componentName specifies the name used for the Kubernetes client and as the source of events.
+	componentName = "clouddk-cloud-controller-manager"
+
 	// envAPIEndpoint specifies the name of the environment variable containing the Cloud.dk API endpoint.
 	envAPIEndpoint = "CLOUDDK_API_ENDPOINT"
 
+	// envAPICABundle specifies the name of the environment variable containing the path to a PEM encoded CA bundle, which is trusted for API connections.
+	envAPICABundle = "CLOUDDK_API_CA_BUNDLE"
+
+	// envAPICacheTTL specifies the name of the environment variable containing the number of seconds to cache API responses for.
+	envAPICacheTTL = "CLOUDDK_API_CACHE_TTL"
+
+	// envAPICircuitBreakerCooldown specifies the name of the environment variable containing the number of seconds to suspend API requests for, once the circuit breaker opens.
+	envAPICircuitBreakerCooldown = "CLOUDDK_API_CIRCUIT_BREAKER_COOLDOWN"
+
+	// envAPICircuitBreakerThreshold specifies the name of the environment variable containing the number of consecutive API failures, which open the circuit breaker.
+	envAPICircuitBreakerThreshold = "CLOUDDK_API_CIRCUIT_BREAKER_THRESHOLD"
+
 	// envAPIKey specifies the name of the environment variable containing the Cloud.dk API key.
 	envAPIKey = "CLOUDDK_API_KEY"
 
+	// envAPIPinnedKeys specifies the name of the environment variable containing a space or comma separated list of pinned public key hashes for API connections.
+	envAPIPinnedKeys = "CLOUDDK_API_PINNED_KEYS"
+
+	// envAPIRateLimit specifies the name of the environment variable containing the maximum number of API requests per second.
+	envAPIRateLimit = "CLOUDDK_API_RATE_LIMIT"
+
+	// envAPITLSMinVersion specifies the name of the environment variable containing the minimum TLS version for API connections.
+	envAPITLSMinVersion = "CLOUDDK_API_TLS_MIN_VERSION"
+
+	// envAuditLogFile specifies the name of the environment variable containing the path to the audit log file, or '-' for standard output.
+	envAuditLogFile = "CLOUDDK_AUDIT_LOG_FILE"
+
+	// envAuditWebhookURL specifies the name of the environment variable containing the URL of a webhook, which receives audit records.
+	envAuditWebhookURL = "CLOUDDK_AUDIT_WEBHOOK_URL"
+
+	// envCISHardening specifies the name of the environment variable containing whether to apply CIS hardening to new servers.
+	envCISHardening = "CLOUDDK_CIS_HARDENING"
+
+	// envCloudEventPollInterval specifies the name of the environment variable containing the number of seconds between polls for changes to servers.
+	envCloudEventPollInterval = "CLOUDDK_CLOUD_EVENT_POLL_INTERVAL"
+
+	// envCloudEventSource specifies the name of the environment variable containing the name of the source, which delivers events about changes to servers.
+	envCloudEventSource = "CLOUDDK_CLOUD_EVENT_SOURCE"
+
+	// envExternalDNSOwnerID specifies the name of the environment variable containing the owner id, which is written to the external-dns ownership records of load balancers.
+	envExternalDNSOwnerID = "CLOUDDK_EXTERNAL_DNS_OWNER_ID"
+
+	// envFailureReportInterval specifies the name of the environment variable containing the number of seconds between reports of repeated identical failures.
+	envFailureReportInterval = "CLOUDDK_FAILURE_REPORT_INTERVAL"
+
+	// envGatewaySyncInterval specifies the name of the environment variable containing the number of seconds between reconciliations of the Gateway API resources.
+	envGatewaySyncInterval = "CLOUDDK_GATEWAY_SYNC_INTERVAL"
+
+	// envHAProxyAppArmor specifies the name of the environment variable containing whether to confine HAProxy with an AppArmor profile on load balancers.
+	envHAProxyAppArmor = "CLOUDDK_HAPROXY_APPARMOR"
+
+	// envHAProxyDeployment specifies the name of the environment variable containing the default HAProxy deployment mode for load balancers.
+	envHAProxyDeployment = "CLOUDDK_HAPROXY_DEPLOYMENT"
+
+	// envHAProxyImage specifies the name of the environment variable containing the default HAProxy container image for load balancers.
+	envHAProxyImage = "CLOUDDK_HAPROXY_IMAGE"
+
+	// envHAProxyRepository specifies the name of the environment variable containing the APT repository, which provides HAProxy for load balancers.
+	envHAProxyRepository = "CLOUDDK_HAPROXY_REPOSITORY"
+
+	// envHAProxyTemplates specifies the name of the environment variable containing a reference to a ConfigMap, which overrides the HAProxy templates.
+	envHAProxyTemplates = "CLOUDDK_HAPROXY_TEMPLATES"
+
+	// envHAProxyVersion specifies the name of the environment variable containing the HAProxy version, which is installed on load balancers.
+	envHAProxyVersion = "CLOUDDK_HAPROXY_VERSION"
+
+	// envHealthBindAddress specifies the name of the environment variable containing the address for the health check endpoints.
+	envHealthBindAddress = "CLOUDDK_HEALTH_BIND_ADDRESS"
+
+	// envLoadBalancerAdoption specifies the name of the environment variable containing whether services may adopt existing servers through the id annotation.
+	envLoadBalancerAdoption = "CLOUDDK_LOAD_BALANCER_ADOPTION"
+
+	// envLoadBalancerClassRequired specifies the name of the environment variable containing whether to ignore the services, which do not set spec.loadBalancerClass explicitly.
+	envLoadBalancerClassRequired = "CLOUDDK_LOAD_BALANCER_CLASS_REQUIRED"
+
+	// envLogShippingEndpoint specifies the name of the environment variable containing the default endpoint, which receives the logs of load balancers.
+	envLogShippingEndpoint = "CLOUDDK_LOG_SHIPPING_ENDPOINT"
+
+	// envMinimalPermissions specifies the name of the environment variable containing whether to disable the features, which require additional Kubernetes API access.
+	envMinimalPermissions = "CLOUDDK_MINIMAL_PERMISSIONS"
+
+	// envMutationWebhookURL specifies the name of the environment variable containing the HTTPS URL, which receives a record of every mutation of a cloud resource.
+	envMutationWebhookURL = "CLOUDDK_MUTATION_WEBHOOK_URL"
+
+	// envNodeSyncDelay specifies the name of the environment variable containing the number of seconds to wait for node events to settle, before the load balancers are updated.
+	envNodeSyncDelay = "CLOUDDK_NODE_SYNC_DELAY"
+
+	// envNodeSyncWorkers specifies the name of the environment variable containing the number of load balancers to update concurrently, when the nodes change.
+	envNodeSyncWorkers = "CLOUDDK_NODE_SYNC_WORKERS"
+
+	// envNTPServers specifies the name of the environment variable containing a space or comma separated list of NTP servers for managed servers.
+	envNTPServers = "CLOUDDK_NTP_SERVERS"
+
+	// envProvisioningSigningKey specifies the name of the environment variable containing the Base 64 encoded ECDSA private key, which signs the provisioning files.
+	envProvisioningSigningKey = "CLOUDDK_PROVISIONING_SIGNING_KEY"
+
+	// envSecretProvider specifies the name of the environment variable containing the name of the provider, which the credentials are retrieved from.
+	envSecretProvider = "CLOUDDK_SECRET_PROVIDER"
+
+	// envSecretRefreshInterval specifies the name of the environment variable containing the number of seconds between refreshes of the credentials.
+	envSecretRefreshInterval = "CLOUDDK_SECRET_REFRESH_INTERVAL"
+
+	// envSecurityUpgrades specifies the name of the environment variable containing whether to install security updates automatically on managed servers.
+	envSecurityUpgrades = "CLOUDDK_SECURITY_UPGRADES"
+
+	// envServerListInterval specifies the name of the environment variable containing the number of seconds between retrievals of the shared server list.
+	envServerListInterval = "CLOUDDK_SERVER_LIST_INTERVAL"
+
+	// envServerTemplate specifies the name of the environment variable containing the default OS template for new load balancers.
+	envServerTemplate = "CLOUDDK_SERVER_TEMPLATE"
+
+	// envSSHAddressFamily specifies the name of the environment variable containing the address family for SSH connections.
+	envSSHAddressFamily = "CLOUDDK_SSH_ADDRESS_FAMILY"
+
+	// envSSHAllowedCIDRs specifies the name of the environment variable containing a space or comma separated list of CIDRs, which are allowed to connect to managed servers over SSH.
+	envSSHAllowedCIDRs = "CLOUDDK_SSH_ALLOWED_CIDRS"
+
+	// envSSHCAPrivateKey specifies the name of the environment variable containing the Base 64 encoded private key of the certificate authority for SSH connections.
+	envSSHCAPrivateKey = "CLOUDDK_SSH_CA_PRIVATE_KEY"
+
+	// envSSHCertificateValidity specifies the name of the environment variable containing the number of minutes that SSH certificates remain valid.
+	envSSHCertificateValidity = "CLOUDDK_SSH_CERTIFICATE_VALIDITY"
+
+	// envSSHDialTimeout specifies the name of the environment variable containing the number of seconds to wait for an SSH connection to be established.
+	envSSHDialTimeout = "CLOUDDK_SSH_DIAL_TIMEOUT"
+
+	// envSSHHostKeyPolicy specifies the name of the environment variable containing the policy for verifying the host keys of servers.
+	envSSHHostKeyPolicy = "CLOUDDK_SSH_HOST_KEY_POLICY"
+
+	// envSSHKeepAliveCountMax specifies the name of the environment variable containing the number of unanswered keepalive requests before an SSH connection is closed.
+	envSSHKeepAliveCountMax = "CLOUDDK_SSH_KEEPALIVE_COUNT_MAX"
+
+	// envSSHKeepAliveInterval specifies the name of the environment variable containing the number of seconds between keepalive requests on SSH connections.
+	envSSHKeepAliveInterval = "CLOUDDK_SSH_KEEPALIVE_INTERVAL"
+
+	// envSSHPerServiceKeys specifies the name of the environment variable containing whether to generate a dedicated SSH key pair for each load balancer.
+	envSSHPerServiceKeys = "CLOUDDK_SSH_PER_SERVICE_KEYS"
+
 	// envSSHPrivateKey specifies the name of the environment variable containing the Base 64 encoded private key for SSH connections.
 	envSSHPrivateKey = "CLOUDDK_SSH_PRIVATE_KEY"
 
 	// envSSHPublicKey specifies the name of the environment variable containing the Base 64 encoded public key for SSH connections.
 	envSSHPublicKey = "CLOUDDK_SSH_PUBLIC_KEY"
+
+	// envSSHUser specifies the name of the environment variable containing the user name for SSH connections.
+	envSSHUser = "CLOUDDK_SSH_USER"
+
+	// envStatsInterval specifies the name of the environment variable containing the number of seconds between queries of the HAProxy stats on load balancers.
+	envStatsInterval = "CLOUDDK_STATS_INTERVAL"
+
+	// envStrictCrypto specifies the name of the environment variable containing whether to restrict the cryptographic algorithms to an approved list.
+	envStrictCrypto = "CLOUDDK_STRICT_CRYPTO"
+
+	// envStuckActionDeadline specifies the name of the environment variable containing the number of minutes before pending actions on a server are considered stuck.
+	envStuckActionDeadline = "CLOUDDK_STUCK_ACTION_DEADLINE"
+
+	// envStuckActionRecreate specifies the name of the environment variable containing whether to recreate load balancers with stuck actions.
+	envStuckActionRecreate = "CLOUDDK_STUCK_ACTION_RECREATE"
+
+	// envTraceFile specifies the name of the environment variable containing the path to a file, which receives finished trace spans, or '-' for standard output.
+	envTraceFile = "CLOUDDK_TRACE_FILE"
+
+	// envTuningProfile specifies the name of the environment variable containing the default kernel tuning profile for load balancers.
+	envTuningProfile = "CLOUDDK_TUNING_PROFILE"
+
+	// envTuningProfileFile specifies the name of the environment variable containing the path to a file with kernel parameters for the custom tuning profile.
+	envTuningProfileFile = "CLOUDDK_TUNING_PROFILE_FILE"
+
+	// envVaultAddress specifies the name of the environment variable containing the address of the Vault server.
+	envVaultAddress = "CLOUDDK_VAULT_ADDRESS"
+
+	// envVaultAuthPath specifies the name of the environment variable containing the mount path of the Kubernetes auth method in Vault.
+	envVaultAuthPath = "CLOUDDK_VAULT_AUTH_PATH"
+
+	// envVaultCABundle specifies the name of the environment variable containing the path to a PEM encoded CA bundle for the Vault server.
+	envVaultCABundle = "CLOUDDK_VAULT_CA_BUNDLE"
+
+	// envVaultRole specifies the name of the environment variable containing the role used to authenticate with the Kubernetes auth method in Vault.
+	envVaultRole = "CLOUDDK_VAULT_ROLE"
+
+	// envVaultSecretPath specifies the name of the environment variable containing the path to the secret in Vault, which contains the credentials.
+	envVaultSecretPath = "CLOUDDK_VAULT_SECRET_PATH"
+
+	// envVaultToken specifies the name of the environment variable containing the token used to authenticate with Vault.
+	envVaultToken = "CLOUDDK_VAULT_TOKEN"
+
+	// envWebhookBindAddress specifies the name of the environment variable containing the address for the admission webhooks.
+	envWebhookBindAddress = "CLOUDDK_WEBHOOK_BIND_ADDRESS"
+
+	// envWebhookDefaultAnnotationsFile specifies the name of the environment variable containing the path to a file with the annotations injected into new load balancers by the mutating admission webhook.
+	envWebhookDefaultAnnotationsFile = "CLOUDDK_WEBHOOK_DEFAULT_ANNOTATIONS_FILE"
+
+	// envWebhookTLSCertFile specifies the name of the environment variable containing the path to the TLS certificate for the validating admission webhook.
+	envWebhookTLSCertFile = "CLOUDDK_WEBHOOK_TLS_CERT_FILE"
+
+	// envWebhookTLSKeyFile specifies the name of the environment variable containing the path to the TLS private key for the validating admission webhook.
+	envWebhookTLSKeyFile = "CLOUDDK_WEBHOOK_TLS_KEY_FILE"
 )
 
 // Cloud implements the interface cloudprovider.Interface.
 type Cloud struct {
+	config        *CloudConfiguration
 	loadBalancers cloudprovider.LoadBalancer
 	instances     cloudprovider.Instances
 	zones         cloudprovider.Zones
@@ -42,9 +251,71 @@ type Cloud struct {
 
 // CloudConfiguration stores the cloud configuration.
 type CloudConfiguration struct {
-	ClientSettings *clouddk.ClientSettings
-	PrivateKey     string
-	PublicKey      string
+	APIClient               *http.Client
+	AuditLog                *AuditLog
+	CircuitBreaker          *CircuitBreaker
+	CISHardening            bool
+	ClientSettings          *clouddk.ClientSettings
+	CloudEventSource        CloudEventSource
+	EventRecorder           record.EventRecorder
+	ExternalDNSOwnerID      string
+	FailureLimiter          *FailureLimiter
+	GatewaySyncInterval     time.Duration
+	HAProxyAppArmor         bool
+	HAProxyDeployment       string
+	HAProxyImage            string
+	HAProxyRepository       string
+	HAProxyTemplates        string
+	HAProxyVersion          string
+	HealthBindAddress       string
+	KnownHosts              *KnownHostsStore
+	KubeClient              kubernetes.Interface
+	LoadBalancerAdoption    bool
+	LoadBalancerLocks       *LoadBalancerLocks
+	LoadBalancerRegistry    *LoadBalancerRegistry
+	LogShippingEndpoint     string
+	MaintenanceQueue        *MaintenanceQueue
+	MinimalPermissions      bool
+	NodeConditions          *NodeConditionCache
+	NodeSyncDelay           time.Duration
+	NodeSyncWorkers         int
+	NTPServers              []string
+	PrivateKey              string
+	ProvisioningSigner      *ProvisioningSigner
+	PublicKey               string
+	RateLimiter             *AdaptiveRateLimiter
+	RequestGroup            *RequestGroup
+	RequireExplicitClass    bool
+	ResponseCache           *ResponseCache
+	SecretProvider          SecretProvider
+	SecretRefreshInterval   time.Duration
+	SecurityUpgrades        bool
+	ServerList              *ServerList
+	ServerTemplate          string
+	SSHAddressFamily        string
+	SSHAllowedCIDRs         []string
+	SSHCertificateAuthority *SSHCertificateAuthority
+	SSHDial                 func(network string, address string) (net.Conn, error)
+	SSHDialTimeout          time.Duration
+	SSHHostKeyPolicy        string
+	SSHKeepAliveCountMax    int
+	SSHKeepAliveInterval    time.Duration
+	SSHPerServiceKeys       bool
+	SSHUser                 string
+	StatsInterval           time.Duration
+	StrictCrypto            bool
+	StuckActionDeadline     time.Duration
+	StuckActionRecreate     bool
+	TerminalErrors          *TerminalErrorCache
+	Tracer                  *Tracer
+	TuningProfile           string
+	TuningProfileFile       string
+	WebhookBindAddress      string
+	WebhookDefaults         map[string]string
+	WebhookTLSCertFile      string
+	WebhookTLSKeyFile       string
+
+	credentialsMutex sync.RWMutex
 }
 
 // init registers this cloud provider.
@@ -58,8 +329,34 @@ func init() {
 func newCloud() (cloudprovider.Interface, error) {
 	debugCloudAction(rtCloud, "Creating new cloud provider instance of '%s'", ProviderName)
 
+	config, err := newCloudConfiguration()
+
+	if err != nil {
+		return nil, err
+	}
+
+	debugCloudAction(rtCloud, "Configured new cloud provider instance of '%s' to use API endpoint '%s'", ProviderName, config.ClientSettings.Endpoint)
+
+	return Cloud{
+		config:        config,
+		loadBalancers: newLoadBalancers(config),
+		instances:     newInstances(config),
+		zones:         newZones(config),
+	}, nil
+}
+
+// newCloudConfiguration initializes a new CloudConfiguration object from the environment variables.
+func newCloudConfiguration() (*CloudConfiguration, error) {
+	var err error
+
 	config := CloudConfiguration{
-		ClientSettings: &clouddk.ClientSettings{},
+		ClientSettings:       &clouddk.ClientSettings{},
+		LoadBalancerLocks:    newLoadBalancerLocks(),
+		LoadBalancerRegistry: newLoadBalancerRegistry(),
+		MaintenanceQueue:     newMaintenanceQueue(),
+		NodeConditions:       newNodeConditionCache(),
+		RequestGroup:         newRequestGroup(),
+		TerminalErrors:       newTerminalErrorCache(),
 	}
 
 	config.ClientSettings.Endpoint = os.Getenv(envAPIEndpoint)
@@ -68,53 +365,542 @@ func newCloud() (cloudprovider.Interface, error) {
 		config.ClientSettings.Endpoint = "https://api.cloud.dk/v1"
 	}
 
-	config.ClientSettings.Key = os.Getenv(envAPIKey)
+	config.StrictCrypto, err = getBoolEnv(envStrictCrypto, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.SecretProvider, err = newSecretProvider(os.Getenv(envSecretProvider), config.StrictCrypto)
+
+	if err != nil {
+		return nil, fmt.Errorf("The environment variable '%s' is invalid - Error: %s", envSecretProvider, err.Error())
+	}
+
+	secrets := map[string]string{}
+
+	if config.SecretProvider != nil {
+		secrets, err = config.SecretProvider.GetSecrets()
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to retrieve the credentials (provider: %s) - Error: %s", config.SecretProvider.Name(), err.Error())
+		}
+	}
+
+	secretRefreshInterval, err := getIntEnv(envSecretRefreshInterval, 300, 0, 86400)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.SecretRefreshInterval = time.Duration(secretRefreshInterval) * time.Second
+	config.ClientSettings.Key = getCredential(secrets, credentialKeyAPIKey, os.Getenv(envAPIKey))
 
 	if config.ClientSettings.Key == "" {
 		return nil, fmt.Errorf("The environment variable '%s' is empty", envAPIKey)
 	}
 
-	config.PrivateKey = os.Getenv(envSSHPrivateKey)
+	apiTLSMinVersion := os.Getenv(envAPITLSMinVersion)
+
+	if apiTLSMinVersion == "" {
+		apiTLSMinVersion = "1.2"
+	}
+
+	config.APIClient, err = newAPIClient(
+		os.Getenv(envAPICABundle),
+		apiTLSMinVersion,
+		strings.Fields(strings.Replace(os.Getenv(envAPIPinnedKeys), ",", " ", -1)),
+		config.StrictCrypto,
+	)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to configure the API client - Error: %s", err.Error())
+	}
+
+	apiCircuitBreakerThreshold, err := getIntEnv(envAPICircuitBreakerThreshold, 5, 0, 1000)
+
+	if err != nil {
+		return nil, err
+	}
+
+	apiCircuitBreakerCooldown, err := getIntEnv(envAPICircuitBreakerCooldown, 60, 1, 3600)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.CircuitBreaker = newCircuitBreaker(apiCircuitBreakerThreshold, time.Duration(apiCircuitBreakerCooldown)*time.Second)
+
+	apiCacheTTL, err := getIntEnv(envAPICacheTTL, 10, 0, 300)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.ResponseCache = newResponseCache(time.Duration(apiCacheTTL) * time.Second)
+
+	apiRateLimit, err := getIntEnv(envAPIRateLimit, 10, 0, 1000)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.RateLimiter = newAdaptiveRateLimiter(float64(apiRateLimit))
+
+	serverListInterval, err := getIntEnv(envServerListInterval, 30, 0, 3600)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.ServerList = newServerList(time.Duration(serverListInterval) * time.Second)
+
+	sshCAPrivateKey, err := getBase64Env(envSSHCAPrivateKey)
+
+	if err != nil {
+		return nil, err
+	}
 
-	if config.PrivateKey != "" {
-		key, err := base64.StdEncoding.DecodeString(config.PrivateKey)
+	sshCAPrivateKey = getCredential(secrets, credentialKeySSHCAPrivateKey, sshCAPrivateKey)
+
+	sshCertificateValidity, err := getIntEnv(envSSHCertificateValidity, 5, 1, 1440)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.SSHCertificateAuthority, err = newSSHCertificateAuthority(sshCAPrivateKey, time.Duration(sshCertificateValidity)*time.Minute)
+
+	if err != nil {
+		return nil, fmt.Errorf("The environment variable '%s' is invalid - Error: %s", envSSHCAPrivateKey, err.Error())
+	}
+
+	// The static key pair is optional, when the controller authenticates with certificates.
+	config.PrivateKey, err = getBase64Env(envSSHPrivateKey)
+	config.PrivateKey = getCredential(secrets, credentialKeySSHPrivateKey, config.PrivateKey)
+
+	if err != nil {
+		return nil, err
+	} else if config.PrivateKey == "" && config.SSHCertificateAuthority == nil {
+		return nil, fmt.Errorf("The environment variable '%s' is empty", envSSHPrivateKey)
+	}
+
+	config.PublicKey, err = getBase64Env(envSSHPublicKey)
+	config.PublicKey = getCredential(secrets, credentialKeySSHPublicKey, config.PublicKey)
+
+	if err != nil {
+		return nil, err
+	} else if config.PublicKey == "" && config.SSHCertificateAuthority == nil {
+		return nil, fmt.Errorf("The environment variable '%s' is empty", envSSHPublicKey)
+	}
+
+	if config.StrictCrypto {
+		err = validateStrictSSHKey(sshCAPrivateKey)
 
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("The environment variable '%s' is invalid - Error: %s", envSSHCAPrivateKey, err.Error())
 		}
 
-		config.PrivateKey = bytes.NewBuffer(key).String()
-	} else {
-		return nil, fmt.Errorf("The environment variable '%s' is empty", envSSHPrivateKey)
+		err = validateStrictSSHKey(config.PrivateKey)
+
+		if err != nil {
+			return nil, fmt.Errorf("The environment variable '%s' is invalid - Error: %s", envSSHPrivateKey, err.Error())
+		}
 	}
 
-	config.PublicKey = os.Getenv(envSSHPublicKey)
+	provisioningSigningKey, err := getBase64Env(envProvisioningSigningKey)
 
-	if config.PublicKey != "" {
-		key, err := base64.StdEncoding.DecodeString(config.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	config.ProvisioningSigner, err = newProvisioningSigner(provisioningSigningKey)
+
+	if err != nil {
+		return nil, fmt.Errorf("The environment variable '%s' is invalid - Error: %s", envProvisioningSigningKey, err.Error())
+	}
+
+	config.AuditLog, err = newAuditLog(os.Getenv(envAuditLogFile), os.Getenv(envAuditWebhookURL), os.Getenv(envMutationWebhookURL))
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open the audit log - Error: %s", err.Error())
+	}
+
+	cloudEventPollInterval, err := getIntEnv(envCloudEventPollInterval, 15, 0, 3600)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.CloudEventSource, err = newCloudEventSource(os.Getenv(envCloudEventSource), time.Duration(cloudEventPollInterval)*time.Second)
+
+	if err != nil {
+		return nil, fmt.Errorf("The environment variable '%s' is invalid - Error: %s", envCloudEventSource, err.Error())
+	}
+
+	config.ExternalDNSOwnerID = os.Getenv(envExternalDNSOwnerID)
+	failureReportInterval, err := getIntEnv(envFailureReportInterval, 300, 0, 86400)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.FailureLimiter = newFailureLimiter(time.Duration(failureReportInterval) * time.Second)
+
+	gatewaySyncInterval, err := getIntEnv(envGatewaySyncInterval, 0, 0, 3600)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.GatewaySyncInterval = time.Duration(gatewaySyncInterval) * time.Second
+
+	config.CISHardening, err = getBoolEnv(envCISHardening, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.HAProxyAppArmor, err = getBoolEnv(envHAProxyAppArmor, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.HAProxyDeployment, err = parseStringAnnotation(
+		os.Getenv(envHAProxyDeployment),
+		haProxyDeploymentHost,
+		[]string{haProxyDeploymentContainer, haProxyDeploymentHost},
+	)
+
+	if err != nil {
+		return nil, fmt.Errorf("The environment variable '%s' is invalid - Error: %s", envHAProxyDeployment, err.Error())
+	}
+
+	config.HAProxyImage = os.Getenv(envHAProxyImage)
+
+	if config.HAProxyImage == "" {
+		config.HAProxyImage = "docker.io/library/haproxy:2.4.24"
+	}
+
+	err = validateContainerImage(config.HAProxyImage)
+
+	if err != nil {
+		return nil, fmt.Errorf("The environment variable '%s' is invalid - Error: %s", envHAProxyImage, err.Error())
+	}
+
+	config.HAProxyRepository = os.Getenv(envHAProxyRepository)
+	config.HAProxyVersion = os.Getenv(envHAProxyVersion)
+
+	if config.HAProxyVersion != "" {
+		err = validateHAProxyVersion(config.HAProxyVersion)
+
+		if err != nil {
+			return nil, fmt.Errorf("The environment variable '%s' is invalid - Error: %s", envHAProxyVersion, err.Error())
+		}
+	}
+
+	config.ServerTemplate = os.Getenv(envServerTemplate)
+
+	if config.ServerTemplate == "" {
+		config.ServerTemplate = serverTemplateDefault
+	}
+
+	err = validateServerTemplate(config.ServerTemplate)
+
+	if err != nil {
+		return nil, fmt.Errorf("The environment variable '%s' is invalid - Error: %s", envServerTemplate, err.Error())
+	}
+
+	config.HAProxyTemplates = os.Getenv(envHAProxyTemplates)
+
+	if config.HAProxyTemplates != "" {
+		_, _, err = parseNamespacedName(config.HAProxyTemplates)
 
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("The environment variable '%s' is invalid - Error: %s", envHAProxyTemplates, err.Error())
 		}
+	}
 
-		config.PublicKey = bytes.NewBuffer(key).String()
-	} else {
-		return nil, fmt.Errorf("The environment variable '%s' is empty", envSSHPublicKey)
+	config.HealthBindAddress = os.Getenv(envHealthBindAddress)
+
+	if config.HealthBindAddress == "" {
+		config.HealthBindAddress = ":10260"
 	}
 
-	debugCloudAction(rtCloud, "Configured new cloud provider instance of '%s' to use API endpoint '%s'", ProviderName, config.ClientSettings.Endpoint)
+	config.LogShippingEndpoint = os.Getenv(envLogShippingEndpoint)
 
-	return Cloud{
-		loadBalancers: newLoadBalancers(&config),
-		instances:     newInstances(&config),
-		zones:         newZones(&config),
-	}, nil
+	if config.LogShippingEndpoint != "" {
+		_, err = getFluentBitConf(config.LogShippingEndpoint)
+
+		if err != nil {
+			return nil, fmt.Errorf("The environment variable '%s' is invalid - Error: %s", envLogShippingEndpoint, err.Error())
+		}
+	}
+
+	nodeSyncDelay, err := getIntEnv(envNodeSyncDelay, 10, 0, 600)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.NodeSyncDelay = time.Duration(nodeSyncDelay) * time.Second
+	config.NodeSyncWorkers, err = getIntEnv(envNodeSyncWorkers, 4, 1, 64)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.NTPServers = strings.Fields(strings.Replace(os.Getenv(envNTPServers), ",", " ", -1))
+
+	if len(config.NTPServers) == 0 {
+		config.NTPServers = []string{"0.dk.pool.ntp.org", "1.dk.pool.ntp.org", "2.dk.pool.ntp.org", "3.dk.pool.ntp.org"}
+	}
+
+	config.SecurityUpgrades, err = getBoolEnv(envSecurityUpgrades, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.SSHAddressFamily = os.Getenv(envSSHAddressFamily)
+
+	if config.SSHAddressFamily == "" {
+		config.SSHAddressFamily = addressFamilyAuto
+	} else if config.SSHAddressFamily != addressFamilyAuto && config.SSHAddressFamily != addressFamilyIPv4 && config.SSHAddressFamily != addressFamilyIPv6 {
+		return nil, fmt.Errorf("The environment variable '%s' contains an unsupported value '%s'", envSSHAddressFamily, config.SSHAddressFamily)
+	}
+
+	switch sshAllowedCIDRs := strings.TrimSpace(os.Getenv(envSSHAllowedCIDRs)); sshAllowedCIDRs {
+	case "", sshAllowedCIDRsAuto:
+		config.SSHAllowedCIDRs = nil
+	case sshAllowedCIDRsAny:
+		config.SSHAllowedCIDRs = []string{"0.0.0.0/0", "::/0"}
+	default:
+		config.SSHAllowedCIDRs, err = parseCIDRList(sshAllowedCIDRs)
+
+		if err != nil {
+			return nil, fmt.Errorf("The environment variable '%s' is invalid - Error: %s", envSSHAllowedCIDRs, err.Error())
+		}
+	}
+
+	sshDialTimeout, err := getIntEnv(envSSHDialTimeout, 30, 1, 600)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.SSHDialTimeout = time.Duration(sshDialTimeout) * time.Second
+	config.SSHHostKeyPolicy, err = parseStringAnnotation(
+		os.Getenv(envSSHHostKeyPolicy),
+		hostKeyPolicyTOFU,
+		[]string{hostKeyPolicyInsecure, hostKeyPolicyStrict, hostKeyPolicyTOFU},
+	)
+
+	if err != nil {
+		return nil, fmt.Errorf("The environment variable '%s' is invalid - Error: %s", envSSHHostKeyPolicy, err.Error())
+	}
+
+	config.SSHKeepAliveCountMax, err = getIntEnv(envSSHKeepAliveCountMax, 3, 1, 100)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sshKeepAliveInterval, err := getIntEnv(envSSHKeepAliveInterval, 15, 1, 3600)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.SSHKeepAliveInterval = time.Duration(sshKeepAliveInterval) * time.Second
+	config.SSHPerServiceKeys, err = getBoolEnv(envSSHPerServiceKeys, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.MinimalPermissions, _ = parseBoolAnnotation(os.Getenv(envMinimalPermissions), false)
+	config.RequireExplicitClass, _ = parseBoolAnnotation(os.Getenv(envLoadBalancerClassRequired), false)
+	config.LoadBalancerAdoption, err = getBoolEnv(envLoadBalancerAdoption, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if config.MinimalPermissions && config.SSHPerServiceKeys {
+		debugCloudAction(rtCloud, "WARNING: Dedicated SSH key pairs have been disabled, as they require access to Secrets in minimal-permission mode")
+
+		config.SSHPerServiceKeys = false
+	}
+
+	if config.MinimalPermissions && config.HAProxyTemplates != "" {
+		debugCloudAction(rtCloud, "WARNING: The HAProxy templates have been reset to the defaults, as they require access to ConfigMaps in minimal-permission mode")
+
+		config.HAProxyTemplates = ""
+	}
+
+	if config.MinimalPermissions && config.ExternalDNSOwnerID != "" {
+		debugCloudAction(rtCloud, "WARNING: The external-dns ownership records have been disabled, as they require access to ConfigMaps in minimal-permission mode")
+
+		config.ExternalDNSOwnerID = ""
+	}
+
+	if config.MinimalPermissions && config.GatewaySyncInterval != 0 {
+		debugCloudAction(rtCloud, "WARNING: The gateway controller has been disabled, as it requires access to Gateway API resources in minimal-permission mode")
+
+		config.GatewaySyncInterval = 0
+	}
+
+	config.SSHUser = os.Getenv(envSSHUser)
+
+	if config.SSHUser == "" {
+		config.SSHUser = "haproxy-admin"
+	}
+
+	statsInterval, err := getIntEnv(envStatsInterval, 60, 0, 3600)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.StatsInterval = time.Duration(statsInterval) * time.Second
+
+	stuckActionDeadline, err := getIntEnv(envStuckActionDeadline, 30, 1, 1440)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.StuckActionDeadline = time.Duration(stuckActionDeadline) * time.Minute
+	config.StuckActionRecreate, err = getBoolEnv(envStuckActionRecreate, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.Tracer, err = newTracer(os.Getenv(envTraceFile))
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open the trace file - Error: %s", err.Error())
+	}
+
+	config.TuningProfile, err = parseStringAnnotation(
+		os.Getenv(envTuningProfile),
+		tuningProfileDefault,
+		[]string{tuningProfileAggressive, tuningProfileCustom, tuningProfileDefault},
+	)
+
+	if err != nil {
+		return nil, fmt.Errorf("The environment variable '%s' is invalid - Error: %s", envTuningProfile, err.Error())
+	}
+
+	config.TuningProfileFile = os.Getenv(envTuningProfileFile)
+	config.WebhookBindAddress = os.Getenv(envWebhookBindAddress)
+	config.WebhookDefaults, err = loadWebhookDefaultAnnotations(os.Getenv(envWebhookDefaultAnnotationsFile))
+
+	if err != nil {
+		return nil, fmt.Errorf("The environment variable '%s' is invalid - Error: %s", envWebhookDefaultAnnotationsFile, err.Error())
+	}
+
+	config.WebhookTLSCertFile = os.Getenv(envWebhookTLSCertFile)
+	config.WebhookTLSKeyFile = os.Getenv(envWebhookTLSKeyFile)
+
+	if config.WebhookBindAddress != "" && (config.WebhookTLSCertFile == "" || config.WebhookTLSKeyFile == "") {
+		return nil, fmt.Errorf("The environment variables '%s' and '%s' are required by the admission webhook", envWebhookTLSCertFile, envWebhookTLSKeyFile)
+	}
+
+	config.KnownHosts = newKnownHostsStore(&config)
+
+	return &config, nil
+}
+
+// getBase64Env retrieves a Base 64 encoded value from an environment variable.
+func getBase64Env(name string) (string, error) {
+	value, err := base64.StdEncoding.DecodeString(os.Getenv(name))
+
+	if err != nil {
+		return "", fmt.Errorf("The environment variable '%s' is invalid - Error: %s", name, err.Error())
+	}
+
+	return bytes.NewBuffer(value).String(), nil
+}
+
+// getBoolEnv retrieves a boolean from an environment variable.
+// Values other than 'true' and 'false' are rejected, as a typo would otherwise silently disable the setting.
+func getBoolEnv(name string, defaultValue bool) (bool, error) {
+	value := os.Getenv(name)
+
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	if value != "true" && value != "false" {
+		return defaultValue, fmt.Errorf("The environment variable '%s' is invalid - Error: Unsupported value '%s'", name, value)
+	}
+
+	return value == "true", nil
+}
+
+// getIntEnv retrieves an integer from an environment variable.
+func getIntEnv(name string, defaultValue int, minValue int, maxValue int) (int, error) {
+	value, err := parseIntAnnotation(os.Getenv(name), defaultValue, minValue, maxValue)
+
+	if err != nil {
+		return value, fmt.Errorf("The environment variable '%s' is invalid - Error: %s", name, err.Error())
+	}
+
+	return value, nil
 }
 
 // Initialize provides the cloud with a kubernetes client builder and may spawn goroutines to perform housekeeping or run custom controllers specific to the cloud provider.
 // Any tasks started here should be cleaned up when the stop channel closes.
 func (c Cloud) Initialize(clientBuilder cloudprovider.ControllerClientBuilder, stop <-chan struct{}) {
 	debugCloudAction(rtCloud, "Initializing cloud provider '%s'", c.ProviderName())
+
+	// The client and the event recorder are set up before the background servers are started, as the servers read them without synchronization.
+	client, err := clientBuilder.Client(componentName)
+
+	if err != nil {
+		debugCloudAction(rtCloud, "Failed to create Kubernetes client for cloud provider '%s' - Error: %s", c.ProviderName(), err.Error())
+
+		startBackgroundServers(c.config, stop)
+
+		return
+	}
+
+	c.config.KubeClient = client
+
+	if c.config.MinimalPermissions {
+		debugCloudAction(rtCloud, "Running in minimal-permission mode - Events, status ConfigMaps, persisted host keys and dedicated SSH key pairs are disabled")
+	} else {
+		c.config.EventRecorder = newEventRecorder(client, stop)
+	}
+
+	nodeSyncer := startNodeSync(c.config, client, c.loadBalancers, stop)
+	startCloudEventSource(c.config, nodeSyncer, stop)
+	startMaintenanceQueue(c.config, nodeSyncer, stop)
+	startStatsCredentialsSync(c.config, client, c.loadBalancers, stop)
+
+	if c.config.GatewaySyncInterval != 0 {
+		restConfig, err := clientBuilder.Config(componentName)
+
+		if err != nil {
+			debugCloudAction(rtCloud, "Failed to create Kubernetes client configuration for the gateway controller - Error: %s", err.Error())
+		} else {
+			startGatewayController(c.config, restConfig, c.loadBalancers, stop)
+		}
+	}
+
+	startBackgroundServers(c.config, stop)
+}
+
+// startBackgroundServers starts the servers and monitors, which run independently of the Kubernetes controllers.
+func startBackgroundServers(c *CloudConfiguration, stop <-chan struct{}) {
+	startHealthServer(c, stop)
+	startSecretRefresh(c, stop)
+	startHAProxyStatsMonitor(c, stop)
+	startWebhookServer(c, stop)
 }
 
 // LoadBalancer returns a balancer interface. Also returns true if the interface is supported, false otherwise.
@@ -127,6 +913,12 @@ func (c Cloud) Instances() (cloudprovider.Instances, bool) {
 	return c.instances, true
 }
 
+// InstancesV2 returns an instances interface optimized for external cloud providers. Also returns true if the interface is supported, false otherwise.
+// The interface is not supported, which makes the node controllers fall back to Instances.
+func (c Cloud) InstancesV2() (cloudprovider.InstancesV2, bool) {
+	return nil, false
+}
+
 // Zones returns a zones interface. Also returns true if the interface is supported, false otherwise.
 func (c Cloud) Zones() (cloudprovider.Zones, bool) {
 	return c.zones, true
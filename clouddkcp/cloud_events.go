@@ -0,0 +1,222 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/danitso/terraform-provider-clouddk/clouddk"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// cloudEventServerDeleted indicates that a server has been deleted.
+	cloudEventServerDeleted = "ServerDeleted"
+
+	// cloudEventServerPoweredOff indicates that a server has been shut down.
+	cloudEventServerPoweredOff = "ServerPoweredOff"
+
+	// cloudEventServerPoweredOn indicates that a server has been booted.
+	cloudEventServerPoweredOn = "ServerPoweredOn"
+
+	cloudEventSourceNone = "none"
+	cloudEventSourcePoll = "poll"
+)
+
+// CloudEvent describes a change to a server, which was not necessarily initiated by this controller.
+type CloudEvent struct {
+	Hostname string
+	ServerID string
+	Type     string
+}
+
+// CloudEventSource delivers events about changes to servers.
+// The Cloud.dk API only supports polling, but the interface allows a source based on webhooks or streaming to be added later.
+type CloudEventSource interface {
+	Name() string
+	Run(c *CloudConfiguration, events chan<- CloudEvent, stop <-chan struct{})
+}
+
+// pollingCloudEventSource generates events by comparing periodic listings of the servers.
+type pollingCloudEventSource struct {
+	interval time.Duration
+}
+
+// newCloudEventSource initializes the cloud event source with the specified name.
+// A nil object is returned for the 'none' source, or if the poll interval is zero.
+func newCloudEventSource(name string, interval time.Duration) (CloudEventSource, error) {
+	switch name {
+	case "", cloudEventSourcePoll:
+		if interval == 0 {
+			return nil, nil
+		}
+
+		return &pollingCloudEventSource{
+			interval: interval,
+		}, nil
+	case cloudEventSourceNone:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("Unsupported cloud event source '%s'", name)
+	}
+}
+
+// Name returns the name of the source.
+func (s *pollingCloudEventSource) Name() string {
+	return cloudEventSourcePoll
+}
+
+// Run polls the servers until the stop channel closes.
+// The first listing only serves as a baseline, as the controllers reconcile every resource on startup.
+func (s *pollingCloudEventSource) Run(c *CloudConfiguration, events chan<- CloudEvent, stop <-chan struct{}) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	var previous map[string]clouddk.ServerBody
+
+	for {
+		current, err := s.list(c)
+
+		if err != nil {
+			debugCloudAction(rtCloud, "Failed to poll for cloud events - Error: %s", err.Error())
+		} else {
+			if previous != nil {
+				for _, e := range getCloudEvents(previous, current) {
+					select {
+					case <-stop:
+						return
+					case events <- e:
+					}
+				}
+			}
+
+			previous = current
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// list retrieves the servers keyed by their id.
+// The response cache is bypassed, as a cached listing would delay the events it is supposed to speed up.
+func (s *pollingCloudEventSource) list(c *CloudConfiguration) (map[string]clouddk.ServerBody, error) {
+	res, err := doUncachedClientRequest(c, "GET", "cloudservers", new(bytes.Buffer), []int{200}, 1, 1)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	list := make(clouddk.ServerListBody, 0)
+	err = json.NewDecoder(res.Body).Decode(&list)
+
+	if err != nil {
+		return nil, err
+	}
+
+	servers := make(map[string]clouddk.ServerBody, len(list))
+
+	for _, v := range list {
+		servers[v.Identifier] = v
+	}
+
+	return servers, nil
+}
+
+// getCloudEvents retrieves the events, which describe the changes between two listings of the servers.
+func getCloudEvents(previous map[string]clouddk.ServerBody, current map[string]clouddk.ServerBody) []CloudEvent {
+	events := make([]CloudEvent, 0)
+
+	for id, server := range previous {
+		e := CloudEvent{
+			Hostname: server.Hostname,
+			ServerID: id,
+		}
+
+		currentServer, ok := current[id]
+
+		if !ok {
+			e.Type = cloudEventServerDeleted
+		} else if server.Booted && !currentServer.Booted {
+			e.Type = cloudEventServerPoweredOff
+		} else if !server.Booted && currentServer.Booted {
+			e.Type = cloudEventServerPoweredOn
+		} else {
+			continue
+		}
+
+		events = append(events, e)
+	}
+
+	return events
+}
+
+// handleCloudEvent discards the cached server information and reconciles the load balancer affected by an event.
+// The node controllers pick up the change on their next lookup, as the shared server list is retrieved again.
+func handleCloudEvent(c *CloudConfiguration, syncer *NodeSyncer, e CloudEvent) {
+	debugCloudAction(rtCloud, "Received cloud event '%s' (id: %s, hostname: %s)", e.Type, e.ServerID, e.Hostname)
+
+	c.ServerList.Invalidate()
+	c.ResponseCache.Invalidate("cloudservers")
+
+	entry, ok := c.LoadBalancerRegistry.GetByHostname(e.Hostname)
+
+	if !ok || c.KubeClient == nil {
+		return
+	}
+
+	service, err := c.KubeClient.CoreV1().Services(entry.Namespace).Get(context.Background(), entry.ServiceName, metav1.GetOptions{})
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to retrieve service for cloud event (name: %s) - Error: %s", entry.LoadBalancerName, err.Error())
+
+		return
+	}
+
+	switch e.Type {
+	case cloudEventServerDeleted:
+		recordServiceEvent(c, service, v1.EventTypeWarning, eventReasonLoadBalancerServerDeleted, "The server of the load balancer has been deleted outside of the controller (id: %s)", e.ServerID)
+
+		if syncer != nil && service.DeletionTimestamp == nil && service.Spec.Type == v1.ServiceTypeLoadBalancer {
+			syncer.Ensure(entry, service)
+		}
+	case cloudEventServerPoweredOff:
+		recordServiceEvent(c, service, v1.EventTypeWarning, eventReasonLoadBalancerServerPoweredOff, "The server of the load balancer has been shut down (id: %s)", e.ServerID)
+	}
+}
+
+// startCloudEventSource consumes the events of the cloud event source until the stop channel closes.
+func startCloudEventSource(c *CloudConfiguration, syncer *NodeSyncer, stop <-chan struct{}) {
+	if c.CloudEventSource == nil {
+		return
+	}
+
+	debugCloudAction(rtCloud, "Starting cloud event source '%s'", c.CloudEventSource.Name())
+
+	events := make(chan CloudEvent, 64)
+
+	go c.CloudEventSource.Run(c, events, stop)
+
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case e := <-events:
+				handleCloudEvent(c, syncer, e)
+			}
+		}
+	}()
+}
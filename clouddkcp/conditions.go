@@ -0,0 +1,221 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// conditionInstanceResolved specifies the node condition, which reflects whether the server backing a node could be resolved.
+	conditionInstanceResolved v1.NodeConditionType = "CloudDKInstanceResolved"
+
+	// conditionReasonCloudAPIError specifies the reason for conditions, which are unknown due to a failed API request.
+	conditionReasonCloudAPIError = "CloudAPIError"
+
+	// conditionReasonCloudAPIUnavailable specifies the reason for conditions, which are false, because the circuit breaker has suspended API requests.
+	conditionReasonCloudAPIUnavailable = "CloudAPIUnavailable"
+
+	// conditionReasonConfigurationError specifies the reason for conditions, which are false due to an invalid configuration.
+	conditionReasonConfigurationError = "ConfigurationError"
+
+	// conditionReasonInstanceFound specifies the reason for conditions, which are true, because the server exists.
+	conditionReasonInstanceFound = "InstanceFound"
+
+	// conditionReasonInstanceNotFound specifies the reason for conditions, which are false, because the server no longer exists.
+	conditionReasonInstanceNotFound = "InstanceNotFound"
+
+	// conditionReasonReconcileFailed specifies the reason for conditions, which are false due to a transient error.
+	conditionReasonReconcileFailed = "ReconcileFailed"
+
+	// conditionReasonReconciled specifies the reason for conditions, which are true, because the reconciliation succeeded.
+	conditionReasonReconciled = "Reconciled"
+)
+
+// NodeConditionCache keeps track of the node conditions written by this controller.
+// The nodes are resolved on every status update, which is why the conditions are only written, when they change.
+type NodeConditionCache struct {
+	entries map[string]string
+	mutex   sync.Mutex
+}
+
+// newNodeConditionCache initializes a new NodeConditionCache object.
+func newNodeConditionCache() *NodeConditionCache {
+	return &NodeConditionCache{
+		entries: map[string]string{},
+	}
+}
+
+// Changed records the status and reason of a condition and returns whether they differ from the previously recorded values.
+func (n *NodeConditionCache) Changed(key string, status v1.ConditionStatus, reason string) bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	value := string(status) + "/" + reason
+
+	if n.entries[key] == value {
+		return false
+	}
+
+	n.entries[key] = value
+
+	return true
+}
+
+// Forget removes the recorded condition, which causes it to be written again on the next change.
+func (n *NodeConditionCache) Forget(key string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	delete(n.entries, key)
+}
+
+// getInstanceResolvedCondition retrieves the status and reason of the instance condition for the outcome of a server lookup.
+func getInstanceResolvedCondition(notFound bool, err error) (v1.ConditionStatus, string, string) {
+	if notFound {
+		return v1.ConditionFalse, conditionReasonInstanceNotFound, "The server backing the node no longer exists"
+	}
+
+	if err != nil {
+		return v1.ConditionUnknown, conditionReasonCloudAPIError, err.Error()
+	}
+
+	return v1.ConditionTrue, conditionReasonInstanceFound, "The server backing the node has been resolved"
+}
+
+// getLoadBalancerReadyCondition retrieves the status values of the load balancer condition for the outcome of a reconciliation.
+// Services do not have conditions in the supported Kubernetes version, which is why the condition is exported in the status ConfigMap.
+func getLoadBalancerReadyCondition(err error) map[string]string {
+	status := v1.ConditionTrue
+	reason := conditionReasonReconciled
+
+	if isCircuitOpenError(err) {
+		status = v1.ConditionFalse
+		reason = conditionReasonCloudAPIUnavailable
+	} else if err != nil {
+		status = v1.ConditionFalse
+		reason = conditionReasonReconcileFailed
+
+		if getErrorClass(err) == errorClassTerminal {
+			reason = conditionReasonConfigurationError
+		}
+	}
+
+	return map[string]string{
+		statusKeyReady:               string(status),
+		statusKeyReadyReason:         reason,
+		statusKeyReadyTransitionTime: time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// setNodeInstanceCondition writes the instance condition on the node with the specified name or provider id.
+// An empty name causes the node to be resolved by its provider id.
+func setNodeInstanceCondition(c *CloudConfiguration, name string, providerID string, notFound bool, err error) {
+	if c.KubeClient == nil || c.NodeConditions == nil {
+		return
+	}
+
+	key := name
+
+	if key == "" {
+		key = "providerID/" + trimProviderID(providerID)
+	}
+
+	status, reason, message := getInstanceResolvedCondition(notFound, err)
+
+	if !c.NodeConditions.Changed(key, status, reason) {
+		return
+	}
+
+	if name == "" {
+		nodes, listErr := c.KubeClient.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{})
+
+		if listErr != nil {
+			debugCloudAction(rtInstances, "Failed to retrieve nodes for condition '%s' (id: %s) - Error: %s", conditionInstanceResolved, providerID, listErr.Error())
+			c.NodeConditions.Forget(key)
+
+			return
+		}
+
+		for _, node := range nodes.Items {
+			if trimProviderID(node.Spec.ProviderID) == trimProviderID(providerID) {
+				name = node.Name
+
+				break
+			}
+		}
+
+		if name == "" {
+			c.NodeConditions.Forget(key)
+
+			return
+		}
+	}
+
+	updateErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := c.KubeClient.CoreV1().Nodes().Get(context.Background(), name, metav1.GetOptions{})
+
+		if err != nil {
+			return err
+		}
+
+		if !setNodeCondition(node, conditionInstanceResolved, status, reason, message) {
+			return nil
+		}
+
+		_, err = c.KubeClient.CoreV1().Nodes().UpdateStatus(context.Background(), node, metav1.UpdateOptions{})
+
+		return err
+	})
+
+	if updateErr != nil {
+		debugCloudAction(rtInstances, "Failed to update condition '%s' (name: %s) - Error: %s", conditionInstanceResolved, name, updateErr.Error())
+		c.NodeConditions.Forget(key)
+	}
+}
+
+// setNodeCondition adds or updates a condition on a node and returns whether the node has changed.
+// The transition time is only updated, when the status of the condition changes.
+func setNodeCondition(node *v1.Node, conditionType v1.NodeConditionType, status v1.ConditionStatus, reason string, message string) bool {
+	now := metav1.Now()
+
+	for i, condition := range node.Status.Conditions {
+		if condition.Type != conditionType {
+			continue
+		}
+
+		if condition.Status == status && condition.Reason == reason && condition.Message == message {
+			return false
+		}
+
+		if condition.Status != status {
+			node.Status.Conditions[i].LastTransitionTime = now
+		}
+
+		node.Status.Conditions[i].LastHeartbeatTime = now
+		node.Status.Conditions[i].Message = message
+		node.Status.Conditions[i].Reason = reason
+		node.Status.Conditions[i].Status = status
+
+		return true
+	}
+
+	node.Status.Conditions = append(node.Status.Conditions, v1.NodeCondition{
+		Type:               conditionType,
+		Status:             status,
+		LastHeartbeatTime:  now,
+		LastTransitionTime: now,
+		Reason:             reason,
+		Message:            message,
+	})
+
+	return true
+}
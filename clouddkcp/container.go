@@ -0,0 +1,107 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/MakeNowJust/heredoc"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// annoLoadBalancerHAProxyDeployment is the annotation specifying how HAProxy is deployed on a load balancer.
+	// Options are container and host.
+	// Defaults to the value of the environment variable CLOUDDK_HAPROXY_DEPLOYMENT.
+	annoLoadBalancerHAProxyDeployment = "kubernetes.cloud.dk/load-balancer-haproxy-deployment"
+
+	// annoLoadBalancerHAProxyImage is the annotation specifying the HAProxy container image for load balancers deployed in container mode.
+	// Defaults to the value of the environment variable CLOUDDK_HAPROXY_IMAGE.
+	annoLoadBalancerHAProxyImage = "kubernetes.cloud.dk/load-balancer-haproxy-image"
+
+	// haProxyDeploymentContainer specifies that HAProxy runs as a Docker container.
+	haProxyDeploymentContainer = "container"
+
+	// haProxyDeploymentHost specifies that HAProxy is installed as a regular package.
+	haProxyDeploymentHost = "host"
+
+	pathHAProxyContainerEnv     = "/etc/default/haproxy-container"
+	pathHAProxyContainerService = "/etc/systemd/system/haproxy.service"
+	pathHAProxyContainerWrapper = "/usr/local/sbin/haproxy"
+)
+
+var (
+	haProxyContainerService = heredoc.Doc(`
+		[Unit]
+		Description=HAProxy Load Balancer (container)
+		After=docker.service network-online.target
+		Requires=docker.service
+
+		[Service]
+		EnvironmentFile=/etc/default/haproxy-container
+		ExecStartPre=-/usr/bin/docker rm -f haproxy
+		ExecStart=/usr/bin/docker run --rm --name haproxy --network host --ulimit nofile=1048576:1048576 -v /etc/haproxy:/etc/haproxy:ro -v /etc/ssl:/etc/ssl:ro -v /run/haproxy:/run/haproxy -v /var/lib/haproxy:/var/lib/haproxy ${HAPROXY_IMAGE} haproxy -W -db -f /etc/haproxy/haproxy.cfg -x /run/haproxy/admin.sock
+		ExecReload=/usr/bin/docker kill -s USR2 haproxy
+		ExecStop=/usr/bin/docker stop haproxy
+		Restart=always
+
+		[Install]
+		WantedBy=multi-user.target
+	`)
+	haProxyContainerWrapper = heredoc.Doc(`
+		#!/bin/sh
+		# Run HAProxy commands like configuration checks inside the pinned container image.
+		. /etc/default/haproxy-container
+		exec /usr/bin/docker run --rm --network host -v /etc/haproxy:/etc/haproxy:ro -v /etc/ssl:/etc/ssl:ro "$HAPROXY_IMAGE" haproxy "$@"
+	`)
+)
+
+// getHAProxyContainerEnv retrieves the environment file for the HAProxy container service.
+func getHAProxyContainerEnv(image string) string {
+	return fmt.Sprintf("HAPROXY_IMAGE=%s\n", image)
+}
+
+// getHAProxyContainerImage retrieves the HAProxy container image on a load balancer.
+// An empty string is returned, if the load balancer has not been deployed in container mode.
+func getHAProxyContainerImage(server *CloudServer, sshClient *ssh.Client) (string, error) {
+	output, err := server.RunCommand(sshClient, fmt.Sprintf("cat %s 2>/dev/null || true", pathHAProxyContainerEnv))
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimPrefix(strings.TrimSpace(string(output)), "HAPROXY_IMAGE="), nil
+}
+
+// updateHAProxyContainerImage replaces the HAProxy container image on a load balancer deployed in container mode.
+// The returned value is true, if the image was changed and the service must be restarted.
+func updateHAProxyContainerImage(server *CloudServer, sshClient *ssh.Client, image string) (bool, error) {
+	currentImage, err := getHAProxyContainerImage(server, sshClient)
+
+	if err != nil {
+		return false, err
+	}
+
+	if currentImage == "" || currentImage == image {
+		return false, nil
+	}
+
+	contents := getHAProxyContainerEnv(image)
+	output, err := server.RunCommand(sshClient, fmt.Sprintf("docker pull %s", shellQuote(image)))
+
+	if err != nil {
+		return false, fmt.Errorf("Failed to pull image '%s' - Output: %s - Error: %s", image, string(output), err.Error())
+	}
+
+	err = server.UploadFile(sshClient, nil, pathHAProxyContainerEnv, bytes.NewBufferString(contents), fileModeConfig, 0, 0)
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,90 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// haProxyStrictBindCiphers specifies the TLS ciphers, which HAProxy accepts in strict crypto mode.
+	haProxyStrictBindCiphers = "ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES256-GCM-SHA384:ECDHE-RSA-AES256-GCM-SHA384"
+
+	// haProxyStrictBindOptions specifies the TLS bind options, which HAProxy uses in strict crypto mode.
+	haProxyStrictBindOptions = "no-sslv3 no-tlsv10 no-tlsv11 no-tls-tickets"
+)
+
+var (
+	// strictSSHCiphers specifies the SSH ciphers, which are allowed in strict crypto mode.
+	strictSSHCiphers = []string{"aes128-gcm@openssh.com", "aes256-ctr", "aes192-ctr", "aes128-ctr"}
+
+	// strictSSHHostKeyAlgorithms specifies the SSH host key algorithms, which are allowed in strict crypto mode.
+	strictSSHHostKeyAlgorithms = []string{ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521}
+
+	// strictSSHKeyExchanges specifies the SSH key exchange algorithms, which are allowed in strict crypto mode.
+	strictSSHKeyExchanges = []string{"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521"}
+
+	// strictSSHMACs specifies the SSH MAC algorithms, which are allowed in strict crypto mode.
+	strictSSHMACs = []string{"hmac-sha2-256-etm@openssh.com", "hmac-sha2-256"}
+
+	// strictTLSCipherSuites specifies the TLS 1.2 cipher suites, which are allowed in strict crypto mode.
+	// The cipher suites of TLS 1.3 cannot be configured, but they are all based on approved algorithms.
+	strictTLSCipherSuites = []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	}
+
+	// strictTLSCurves specifies the elliptic curves, which are allowed in strict crypto mode.
+	strictTLSCurves = []tls.CurveID{tls.CurveP256, tls.CurveP384, tls.CurveP521}
+)
+
+// applySSHCryptoPolicy restricts the algorithms of an SSH client configuration, if strict crypto mode is enabled.
+func applySSHCryptoPolicy(c *CloudConfiguration, sshConfig *ssh.ClientConfig) {
+	if !c.StrictCrypto {
+		return
+	}
+
+	sshConfig.Ciphers = strictSSHCiphers
+	sshConfig.HostKeyAlgorithms = strictSSHHostKeyAlgorithms
+	sshConfig.KeyExchanges = strictSSHKeyExchanges
+	sshConfig.MACs = strictSSHMACs
+}
+
+// applyTLSCryptoPolicy restricts the TLS versions, cipher suites and curves of a TLS configuration.
+func applyTLSCryptoPolicy(tlsConfig *tls.Config) {
+	if tlsConfig.MinVersion < tls.VersionTLS12 {
+		tlsConfig.MinVersion = tls.VersionTLS12
+	}
+
+	tlsConfig.CipherSuites = strictTLSCipherSuites
+	tlsConfig.CurvePreferences = strictTLSCurves
+}
+
+// validateStrictSSHKey verifies that an SSH private key is based on an approved algorithm.
+// RSA keys are rejected, as the SSH library signs with SHA-1 when authenticating with them.
+func validateStrictSSHKey(privateKey string) error {
+	if privateKey == "" {
+		return nil
+	}
+
+	signer, err := ssh.ParsePrivateKey([]byte(privateKey))
+
+	if err != nil {
+		return err
+	}
+
+	for _, algorithm := range strictSSHHostKeyAlgorithms {
+		if signer.PublicKey().Type() == algorithm {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("The key type '%s' is not allowed in strict crypto mode", signer.PublicKey().Type())
+}
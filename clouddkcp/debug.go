@@ -0,0 +1,273 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// diagnosticCommand describes a command, whose output is included in a diagnostic bundle.
+type diagnosticCommand struct {
+	Command  string
+	FileName string
+}
+
+// getDiagnosticCommands retrieves the commands, whose output is included in a diagnostic bundle.
+func getDiagnosticCommands(journalLines int) []diagnosticCommand {
+	return []diagnosticCommand{
+		{FileName: "haproxy.cfg", Command: fmt.Sprintf("cat %s", pathHAProxyConf)},
+		{FileName: "haproxy-version.txt", Command: "haproxy -vv"},
+		{FileName: "haproxy-info.txt", Command: fmt.Sprintf("echo 'show info' | socat stdio UNIX-CONNECT:%s", pathHAProxyAdminSocket)},
+		{FileName: "haproxy-stats.csv", Command: fmt.Sprintf("echo 'show stat' | socat stdio UNIX-CONNECT:%s", pathHAProxyAdminSocket)},
+		{FileName: "systemctl-status.txt", Command: "systemctl status haproxy --no-pager --full"},
+		{FileName: "systemctl-failed.txt", Command: "systemctl list-units --failed --no-pager"},
+		{FileName: "journal-haproxy.txt", Command: fmt.Sprintf("journalctl -u haproxy -n %d --no-pager", journalLines)},
+		{FileName: "journal-system.txt", Command: fmt.Sprintf("journalctl -n %d --no-pager", journalLines)},
+		{FileName: "tuning-status.txt", Command: fmt.Sprintf("cat %s", pathTuningStatus)},
+		{FileName: "uptime.txt", Command: "uptime"},
+		{FileName: "disk-usage.txt", Command: "df -h"},
+	}
+}
+
+// NewDebugCommand creates the 'debug' command, which contains troubleshooting tools for managed servers.
+func NewDebugCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "debug",
+		Short: "Troubleshoot servers managed by the cloud controller manager",
+	}
+
+	command.AddCommand(newDebugCollectCommand())
+
+	setCommandHelp(command)
+
+	return command
+}
+
+// newDebugCollectCommand creates the 'debug collect' command.
+func newDebugCollectCommand() *cobra.Command {
+	var hostname, id, output, service string
+	var journalLines int
+
+	command := &cobra.Command{
+		Use:   "collect",
+		Short: "Collect a diagnostic bundle from a load balancer",
+		Long:  "Collect the HAProxy configuration, version, service status, recent journal entries and stats from a load balancer over SSH and store them in a local tarball.\nThe configuration is read from the same environment variables as the controller.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if (hostname == "") == (id == "") {
+				return errors.New("Exactly one of the flags --hostname and --id must be specified")
+			}
+
+			c, err := newCommandConfiguration()
+
+			if err != nil {
+				return err
+			}
+
+			server := CloudServer{
+				CloudConfiguration: c,
+				ReadOnly:           true,
+			}
+
+			if id != "" {
+				_, err = server.InitializeByID(id)
+			} else {
+				_, err = server.InitializeByHostname(hostname)
+			}
+
+			if err != nil {
+				return err
+			}
+
+			if service != "" {
+				parts := strings.SplitN(service, "/", 2)
+
+				if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+					return errors.New("The flag --service must be of the form <namespace>/<name>")
+				}
+
+				server.SSHKeyPair, err = getServiceSSHKeyPair(c, parts[0], parts[1])
+
+				if err != nil {
+					return err
+				}
+			}
+
+			if output == "" {
+				output = fmt.Sprintf("%s-%s.tar.gz", server.Information.Hostname, time.Now().UTC().Format("20060102T150405Z"))
+			}
+
+			err = collectDiagnosticBundle(&server, output, journalLines)
+
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Wrote diagnostic bundle to '%s'\n", output)
+
+			return nil
+		},
+	}
+
+	command.Flags().StringVar(&hostname, "hostname", "", "The hostname of the server")
+	command.Flags().StringVar(&id, "id", "", "The id of the server")
+	command.Flags().IntVar(&journalLines, "journal-lines", 1000, "The number of journal entries to collect")
+	command.Flags().StringVarP(&output, "output", "o", "", "The path to the tarball (default <hostname>-<timestamp>.tar.gz)")
+	command.Flags().StringVar(&service, "service", "", "The service in the form <namespace>/<name>, whose dedicated SSH key pair to use")
+
+	return command
+}
+
+// collectDiagnosticBundle collects the output of the diagnostic commands from a server and writes it to a tarball.
+// Failing commands do not abort the collection, since the error output is often what is needed.
+func collectDiagnosticBundle(server *CloudServer, filePath string, journalLines int) error {
+	sshClient, err := server.SSH()
+
+	if err != nil {
+		return err
+	}
+
+	defer sshClient.Close()
+
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fileModePrivate)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	gzipWriter := gzip.NewWriter(file)
+	tarWriter := tar.NewWriter(gzipWriter)
+	timeNow := time.Now()
+
+	writeFile := func(name string, contents []byte) error {
+		err := tarWriter.WriteHeader(&tar.Header{
+			ModTime: timeNow,
+			Mode:    fileModeConfig,
+			Name:    name,
+			Size:    int64(len(contents)),
+		})
+
+		if err != nil {
+			return err
+		}
+
+		_, err = tarWriter.Write(contents)
+
+		return err
+	}
+
+	serverInformation, err := json.MarshalIndent(server.Information, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	err = writeFile("server.json", serverInformation)
+
+	if err != nil {
+		return err
+	}
+
+	for _, c := range getDiagnosticCommands(journalLines) {
+		debugCloudAction(rtServers, "Collecting '%s' (hostname: %s)", c.FileName, server.Information.Hostname)
+
+		output, err := server.RunCommand(sshClient, c.Command)
+
+		if err != nil {
+			output = append(output, []byte(fmt.Sprintf("\n# Command '%s' failed - Error: %s\n", c.Command, err.Error()))...)
+		}
+
+		err = writeFile(c.FileName, output)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tarWriter.Close()
+
+	if err != nil {
+		return err
+	}
+
+	err = gzipWriter.Close()
+
+	if err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// newCommandConfiguration initializes the cloud configuration for a command from the environment variables.
+// A Kubernetes client is only available when running inside the cluster, which is required for the recorded host keys and the status of load balancers.
+func newCommandConfiguration() (*CloudConfiguration, error) {
+	c, err := newCloudConfiguration()
+
+	if err != nil {
+		return nil, err
+	}
+
+	restConfig, err := rest.InClusterConfig()
+
+	if err == nil {
+		c.KubeClient, err = kubernetes.NewForConfig(restConfig)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// setCommandHelp replaces the help and usage output of a command.
+// The controller manager overrides the help and usage output with its own flag sets, which do not apply to the additional commands.
+func setCommandHelp(command *cobra.Command) {
+	command.SetHelpFunc(func(cmd *cobra.Command, args []string) {
+		description := cmd.Long
+
+		if description == "" {
+			description = cmd.Short
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "%s\n\n", description)
+		cmd.SetOutput(cmd.OutOrStdout())
+		cmd.Usage()
+	})
+	command.SetUsageFunc(func(cmd *cobra.Command) error {
+		out := cmd.OutOrStderr()
+
+		fmt.Fprintf(out, "Usage:\n  %s\n", cmd.UseLine())
+
+		if cmd.HasAvailableSubCommands() {
+			fmt.Fprintf(out, "\nAvailable Commands:\n")
+
+			for _, c := range cmd.Commands() {
+				if c.IsAvailableCommand() {
+					fmt.Fprintf(out, "  %-12s %s\n", c.Name(), c.Short)
+				}
+			}
+		}
+
+		if cmd.HasAvailableLocalFlags() {
+			fmt.Fprintf(out, "\nFlags:\n%s", cmd.LocalFlags().FlagUsages())
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,133 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// errorClassTerminal specifies errors, which cannot be resolved by retrying until the configuration has been changed.
+	errorClassTerminal = "terminal"
+
+	// errorClassTransient specifies errors, which may be resolved by retrying.
+	errorClassTransient = "transient"
+
+	// terminalErrorHoldTime specifies how long a terminal error is returned without retrying, if the service has not been changed.
+	terminalErrorHoldTime = 10 * time.Minute
+)
+
+// ClassifiedError is an error, which has been classified as either terminal or transient.
+type ClassifiedError struct {
+	Class string
+	Err   error
+}
+
+// Error returns the error message.
+func (e *ClassifiedError) Error() string {
+	return e.Err.Error()
+}
+
+// newConfigurationError classifies an error caused by an invalid configuration as terminal.
+func newConfigurationError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &ClassifiedError{Class: errorClassTerminal, Err: err}
+}
+
+// getErrorClass retrieves the class of an error. Unclassified errors are considered transient.
+func getErrorClass(err error) string {
+	if e, ok := err.(*ClassifiedError); ok {
+		return e.Class
+	}
+
+	return errorClassTransient
+}
+
+// TerminalErrorCache remembers terminal errors for services in order to avoid repeating work, which is bound to fail, on every sync.
+type TerminalErrorCache struct {
+	entries map[string]TerminalErrorCacheEntry
+	mutex   sync.Mutex
+}
+
+// TerminalErrorCacheEntry describes a terminal error for a specific revision of a service.
+type TerminalErrorCacheEntry struct {
+	Err         error
+	Fingerprint string
+	Time        time.Time
+}
+
+// newTerminalErrorCache initializes a new TerminalErrorCache object.
+func newTerminalErrorCache() *TerminalErrorCache {
+	return &TerminalErrorCache{
+		entries: map[string]TerminalErrorCacheEntry{},
+	}
+}
+
+// Get returns the cached terminal error for a service, if the service has not been changed since the error occurred.
+func (c *TerminalErrorCache) Get(service *v1.Service) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	key := getServiceKey(service)
+	entry, ok := c.entries[key]
+
+	if !ok {
+		return nil
+	}
+
+	if entry.Fingerprint != getServiceFingerprint(service) || time.Now().Sub(entry.Time) > terminalErrorHoldTime {
+		delete(c.entries, key)
+
+		return nil
+	}
+
+	return entry.Err
+}
+
+// Update caches the error for a service, if it is terminal, and clears it otherwise.
+func (c *TerminalErrorCache) Update(service *v1.Service, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	key := getServiceKey(service)
+
+	if err == nil || getErrorClass(err) != errorClassTerminal {
+		delete(c.entries, key)
+
+		return
+	}
+
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+
+	c.entries[key] = TerminalErrorCacheEntry{
+		Err:         err,
+		Fingerprint: getServiceFingerprint(service),
+		Time:        time.Now(),
+	}
+}
+
+// getServiceFingerprint retrieves a hash of the parts of a service, which affect its load balancer.
+func getServiceFingerprint(service *v1.Service) string {
+	data, _ := json.Marshal(struct {
+		Annotations map[string]string
+		Spec        v1.ServiceSpec
+	}{
+		Annotations: service.Annotations,
+		Spec:        service.Spec,
+	})
+
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
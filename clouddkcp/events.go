@@ -0,0 +1,127 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// eventReasonCloudAPIUnavailable specifies the reason for events emitted when changes to a load balancer have been deferred due to an API outage.
+	eventReasonCloudAPIUnavailable = "CloudAPIUnavailable"
+
+	// eventReasonInstanceVanished specifies the reason for events emitted when the server backing a node no longer exists.
+	eventReasonInstanceVanished = "InstanceVanished"
+
+	// eventReasonKernelTuningMismatch specifies the reason for events emitted when kernel tuning was not fully applied on a load balancer.
+	eventReasonKernelTuningMismatch = "KernelTuningMismatch"
+
+	// eventReasonLoadBalancerAdopted specifies the reason for events emitted when an existing server has been adopted as a load balancer.
+	eventReasonLoadBalancerAdopted = "LBAdopted"
+
+	// eventReasonLoadBalancerConfigReloaded specifies the reason for events emitted when a load balancer has loaded a new configuration.
+	eventReasonLoadBalancerConfigReloaded = "LBConfigReloaded"
+
+	// eventReasonLoadBalancerDeleteFailed specifies the reason for events emitted when a load balancer could not be deleted.
+	eventReasonLoadBalancerDeleteFailed = "LBDeleteFailed"
+
+	// eventReasonLoadBalancerDeleted specifies the reason for events emitted when a load balancer has been deleted.
+	eventReasonLoadBalancerDeleted = "LBDeleted"
+
+	// eventReasonLoadBalancerHAProxyUpgraded specifies the reason for events emitted when the HAProxy version of a load balancer has been changed.
+	eventReasonLoadBalancerHAProxyUpgraded = "LBHAProxyUpgraded"
+
+	// eventReasonLoadBalancerMaintenancePending specifies the reason for events emitted when disruptive changes to a load balancer have been deferred until the maintenance window opens.
+	eventReasonLoadBalancerMaintenancePending = "LBMaintenancePending"
+
+	// eventReasonLoadBalancerProvisionFailed specifies the reason for events emitted when a new load balancer could not be provisioned.
+	eventReasonLoadBalancerProvisionFailed = "LBProvisionFailed"
+
+	// eventReasonLoadBalancerServerDeleted specifies the reason for events emitted when the server of a load balancer has been deleted outside of the controller.
+	eventReasonLoadBalancerServerDeleted = "LBServerDeleted"
+
+	// eventReasonLoadBalancerServerPoweredOff specifies the reason for events emitted when the server of a load balancer has been shut down.
+	eventReasonLoadBalancerServerPoweredOff = "LBServerPoweredOff"
+
+	// eventReasonLoadBalancerServersUpdated specifies the reason for events emitted when the backend servers of a load balancer have been updated without a reload.
+	eventReasonLoadBalancerServersUpdated = "LBServersUpdated"
+
+	// eventReasonLoadBalancerUpdateFailed specifies the reason for events emitted when a load balancer could not be updated.
+	eventReasonLoadBalancerUpdateFailed = "LBUpdateFailed"
+
+	// eventReasonServerCreated specifies the reason for events emitted when a server has been created.
+	eventReasonServerCreated = "ServerCreated"
+
+	// eventReasonServerDestroyed specifies the reason for events emitted when a server has been destroyed.
+	eventReasonServerDestroyed = "ServerDestroyed"
+
+	// eventReasonServerStuck specifies the reason for events emitted when a server has been stuck with pending actions past the deadline.
+	eventReasonServerStuck = "ServerStuck"
+)
+
+// newEventRecorder initializes a new event recorder, which publishes events until the stop channel closes.
+func newEventRecorder(client kubernetes.Interface, stop <-chan struct{}) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	loggingWatcher := broadcaster.StartLogging(func(format string, v ...interface{}) {
+		debugCloudAction(rtCloud, "Event: "+format, v...)
+	})
+	sinkWatcher := broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: client.CoreV1().Events(""),
+	})
+
+	go func() {
+		<-stop
+		loggingWatcher.Stop()
+		sinkWatcher.Stop()
+	}()
+
+	return broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: componentName})
+}
+
+// recordNodeEvent emits an event on the node with the specified provider id, if such a node exists.
+func recordNodeEvent(c *CloudConfiguration, providerID string, eventType string, reason string, format string, v ...interface{}) {
+	if c.EventRecorder == nil || c.KubeClient == nil {
+		return
+	}
+
+	nodes, err := c.KubeClient.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{})
+
+	if err != nil {
+		debugCloudAction(rtCloud, "Failed to retrieve nodes for event '%s' (id: %s) - Error: %s", reason, providerID, err.Error())
+
+		return
+	}
+
+	for _, node := range nodes.Items {
+		if trimProviderID(node.Spec.ProviderID) != trimProviderID(providerID) {
+			continue
+		}
+
+		ref := &v1.ObjectReference{
+			Kind: "Node",
+			Name: node.Name,
+			UID:  types.UID(node.Name),
+		}
+
+		c.EventRecorder.Eventf(ref, eventType, reason, format, v...)
+	}
+}
+
+// recordServiceEvent emits an event on a service.
+func recordServiceEvent(c *CloudConfiguration, service *v1.Service, eventType string, reason string, format string, v ...interface{}) {
+	if c.EventRecorder == nil {
+		return
+	}
+
+	c.EventRecorder.Eventf(service, eventType, reason, format, v...)
+}
@@ -0,0 +1,146 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/MakeNowJust/heredoc"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// annoLoadBalancerEnableMetrics is the annotation specifying whether a Prometheus exporter should be installed on a load balancer.
+	// The exporter is installed, when the load balancer is created.
+	// Defaults to false.
+	annoLoadBalancerEnableMetrics = "kubernetes.cloud.dk/load-balancer-enable-metrics"
+
+	// fmtLoadBalancerMetricsName specifies the name format for the headless Service, which points Prometheus at the exporter of a load balancer.
+	fmtLoadBalancerMetricsName = "clouddk-load-balancer-%s-metrics"
+
+	// haProxyExporterPort specifies the port, which the Prometheus exporter listens on.
+	haProxyExporterPort = 9101
+
+	// labelLoadBalancerMetrics is the label identifying the Services, which point Prometheus at the exporter of a load balancer.
+	labelLoadBalancerMetrics = "kubernetes.cloud.dk/load-balancer-metrics"
+
+	pathHAProxyExporterDefaults = "/etc/default/prometheus-haproxy-exporter"
+)
+
+var (
+	// haProxyExporterDefaults configures the exporter to scrape the stats listener, which HAProxy only exposes on the loopback interface.
+	// The stats socket is not used, as its permissions depend on whether HAProxy runs on the host or in a container.
+	haProxyExporterDefaults = heredoc.Doc(`
+		ARGS="--haproxy.scrape-uri=http://127.0.0.1:8405/;csv --web.listen-address=:9101"
+	`)
+)
+
+// isMetricsEnabled determines whether a service requests a Prometheus exporter on its load balancer.
+func isMetricsEnabled(service *v1.Service) bool {
+	enabled, _ := parseBoolAnnotation(service.Annotations[annoLoadBalancerEnableMetrics], false)
+
+	return enabled
+}
+
+// ensureLoadBalancerMetricsService creates or updates the headless Service and the Endpoints, which point Prometheus at the exporter of a load balancer.
+// The Service is removed, if the service no longer requests an exporter.
+func ensureLoadBalancerMetricsService(c *CloudConfiguration, server *CloudServer, service *v1.Service) error {
+	if c.KubeClient == nil || c.MinimalPermissions {
+		return nil
+	}
+
+	if !isMetricsEnabled(service) {
+		deleteLoadBalancerMetricsService(c, service)
+
+		return nil
+	}
+
+	name := fmt.Sprintf(fmtLoadBalancerMetricsName, service.Name)
+	objectMeta := metav1.ObjectMeta{
+		Name:      name,
+		Namespace: service.Namespace,
+		Labels: map[string]string{
+			labelLoadBalancerMetrics: "true",
+		},
+		OwnerReferences: []metav1.OwnerReference{
+			{
+				APIVersion: "v1",
+				Kind:       "Service",
+				Name:       service.Name,
+				UID:        service.UID,
+			},
+		},
+	}
+
+	services := c.KubeClient.CoreV1().Services(service.Namespace)
+	metricsService, err := services.Get(context.Background(), name, metav1.GetOptions{})
+
+	if apierrors.IsNotFound(err) {
+		_, err = services.Create(context.Background(), &v1.Service{
+			ObjectMeta: objectMeta,
+			Spec: v1.ServiceSpec{
+				ClusterIP: v1.ClusterIPNone,
+				Ports: []v1.ServicePort{
+					{Name: "metrics", Port: haProxyExporterPort, Protocol: v1.ProtocolTCP},
+				},
+			},
+		}, metav1.CreateOptions{})
+	} else if err == nil && metricsService.Labels[labelLoadBalancerMetrics] != "true" {
+		err = fmt.Errorf("The Service '%s/%s' is not managed by the controller", service.Namespace, name)
+	}
+
+	if err != nil {
+		return fmt.Errorf("Failed to ensure the metrics Service - Error: %s", err.Error())
+	}
+
+	addresses := []v1.EndpointAddress{}
+
+	for _, nic := range server.Information.NetworkInterfaces {
+		for _, ip := range nic.IPAddresses {
+			addresses = append(addresses, v1.EndpointAddress{IP: ip.Address})
+		}
+	}
+
+	endpoints := &v1.Endpoints{
+		ObjectMeta: objectMeta,
+		Subsets: []v1.EndpointSubset{
+			{
+				Addresses: addresses,
+				Ports: []v1.EndpointPort{
+					{Name: "metrics", Port: haProxyExporterPort, Protocol: v1.ProtocolTCP},
+				},
+			},
+		},
+	}
+
+	_, err = c.KubeClient.CoreV1().Endpoints(service.Namespace).Update(context.Background(), endpoints, metav1.UpdateOptions{})
+
+	if apierrors.IsNotFound(err) {
+		_, err = c.KubeClient.CoreV1().Endpoints(service.Namespace).Create(context.Background(), endpoints, metav1.CreateOptions{})
+	}
+
+	if err != nil {
+		return fmt.Errorf("Failed to ensure the metrics Endpoints - Error: %s", err.Error())
+	}
+
+	return nil
+}
+
+// deleteLoadBalancerMetricsService deletes the headless Service, which points Prometheus at the exporter of a load balancer, if it exists.
+// The Endpoints are deleted together with the Service.
+func deleteLoadBalancerMetricsService(c *CloudConfiguration, service *v1.Service) {
+	if c.KubeClient == nil || c.MinimalPermissions {
+		return
+	}
+
+	err := c.KubeClient.CoreV1().Services(service.Namespace).Delete(context.Background(), fmt.Sprintf(fmtLoadBalancerMetricsName, service.Name), metav1.DeleteOptions{})
+
+	if err != nil && !apierrors.IsNotFound(err) {
+		debugCloudAction(rtLoadBalancers, "Failed to delete metrics Service (name: %s) - Error: %s", getLoadBalancerNameByService(service), err.Error())
+	}
+}
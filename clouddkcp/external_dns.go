@@ -0,0 +1,96 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// annoExternalDNSHostname is the annotation, which external-dns reads the hostnames of a service from.
+	annoExternalDNSHostname = "external-dns.alpha.kubernetes.io/hostname"
+
+	// annoLoadBalancerHostname is the annotation specifying a hostname, which is published in the ingress status of a service instead of the IP addresses of its load balancer.
+	// Defaults to publishing the IP addresses.
+	annoLoadBalancerHostname = "kubernetes.cloud.dk/load-balancer-hostname"
+)
+
+// getExternalDNSHostname retrieves the first hostname in the external-dns annotation of a service, which can be published in the ingress status.
+// Wildcard hostnames are left to external-dns, as the ingress status only accepts regular DNS names.
+func getExternalDNSHostname(service *v1.Service) string {
+	for _, hostname := range strings.Split(service.Annotations[annoExternalDNSHostname], ",") {
+		hostname = strings.TrimSuffix(strings.TrimSpace(hostname), ".")
+
+		if hostname == "" {
+			continue
+		}
+
+		if len(validation.IsDNS1123Subdomain(hostname)) > 0 {
+			debugCloudAction(rtLoadBalancers, "Skipping invalid hostname %q in annotation '%s' (name: %s)", hostname, annoExternalDNSHostname, getLoadBalancerNameByService(service))
+
+			continue
+		}
+
+		return hostname
+	}
+
+	return ""
+}
+
+// getExternalDNSOwnershipRecord retrieves the contents of the TXT record, which external-dns uses to claim ownership of the DNS records for a service.
+// An empty string is returned, if no owner id has been configured or the service does not request any hostnames.
+func getExternalDNSOwnershipRecord(c *CloudConfiguration, service *v1.Service) string {
+	if c.ExternalDNSOwnerID == "" || service.Annotations[annoExternalDNSHostname] == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("\"heritage=external-dns,external-dns/owner=%s,external-dns/resource=service/%s/%s\"", c.ExternalDNSOwnerID, service.Namespace, service.Name)
+}
+
+// getLoadBalancerIngresses retrieves the ingress points of a load balancer.
+// The hostname from the external-dns annotation is published alongside every IP address, unless the service requests a hostname instead of the IP addresses.
+func getLoadBalancerIngresses(server *CloudServer, service *v1.Service) []v1.LoadBalancerIngress {
+	hostname := getExternalDNSHostname(service)
+	ingresses := make([]v1.LoadBalancerIngress, 0)
+	loadBalancerName := getLoadBalancerNameByService(service)
+
+	if ingressHostname := service.Annotations[annoLoadBalancerHostname]; ingressHostname != "" {
+		err := validateIngressHostname(ingressHostname)
+
+		if err == nil {
+			debugCloudAction(rtLoadBalancers, "Adding hostname '%s' to ingress (name: %s)", ingressHostname, loadBalancerName)
+
+			return append(ingresses, v1.LoadBalancerIngress{Hostname: ingressHostname})
+		}
+
+		debugCloudAction(rtLoadBalancers, "Ignoring annotation '%s' (name: %s) - Error: %s", annoLoadBalancerHostname, loadBalancerName, err.Error())
+	}
+
+	for _, nic := range server.Information.NetworkInterfaces {
+		for _, ip := range nic.IPAddresses {
+			debugCloudAction(rtLoadBalancers, "Adding IP address '%s' to ingress (name: %s)", ip.Address, loadBalancerName)
+
+			ingresses = append(ingresses, v1.LoadBalancerIngress{
+				Hostname: hostname,
+				IP:       ip.Address,
+			})
+		}
+	}
+
+	return ingresses
+}
+
+// validateIngressHostname validates a hostname, which is published in the ingress status of a service.
+func validateIngressHostname(value string) error {
+	if problems := validation.IsDNS1123Subdomain(value); len(problems) > 0 {
+		return fmt.Errorf("The hostname '%s' is invalid - Error: %s", value, strings.Join(problems, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// FailureLimiter collapses repeated identical failures into periodic summaries.
+type FailureLimiter struct {
+	entries  map[string]*FailureLimiterEntry
+	interval time.Duration
+	mutex    sync.Mutex
+}
+
+// FailureLimiterEntry describes the most recent failure for a key.
+type FailureLimiterEntry struct {
+	LastEmitted time.Time
+	Message     string
+	Suppressed  int
+}
+
+// newFailureLimiter initializes a new FailureLimiter object.
+func newFailureLimiter(interval time.Duration) *FailureLimiter {
+	return &FailureLimiter{
+		entries:  map[string]*FailureLimiterEntry{},
+		interval: interval,
+	}
+}
+
+// Allow determines whether a failure should be reported.
+// The returned count is the number of identical failures, which have been suppressed since the failure was last reported.
+func (f *FailureLimiter) Allow(key string, message string) (bool, int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	entry, ok := f.entries[key]
+
+	if !ok || entry.Message != message || f.interval == 0 || time.Now().Sub(entry.LastEmitted) >= f.interval {
+		suppressed := 0
+
+		if ok && entry.Message == message {
+			suppressed = entry.Suppressed
+		}
+
+		f.entries[key] = &FailureLimiterEntry{
+			LastEmitted: time.Now(),
+			Message:     message,
+		}
+
+		return true, suppressed
+	}
+
+	entry.Suppressed++
+
+	return false, entry.Suppressed
+}
+
+// Reset forgets the failures for all keys with the specified prefix.
+func (f *FailureLimiter) Reset(prefix string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for k := range f.entries {
+		if strings.HasPrefix(k, prefix) {
+			delete(f.entries, k)
+		}
+	}
+}
+
+// reportLoadBalancerFailure logs a failure and emits a warning event on the service, unless the same failure was reported recently.
+// Repeated failures are reported once per interval with the number of occurrences.
+func reportLoadBalancerFailure(c *CloudConfiguration, service *v1.Service, reason string, format string, v ...interface{}) {
+	message := fmt.Sprintf(format, v...)
+	allowed, suppressed := c.FailureLimiter.Allow(getServiceKey(service)+"/"+reason, message)
+
+	if !allowed {
+		return
+	}
+
+	if suppressed > 0 {
+		message = fmt.Sprintf("%s (occurred %d more times in the last %s)", message, suppressed, c.FailureLimiter.interval.String())
+	}
+
+	debugCloudAction(rtLoadBalancers, "%s: %s (name: %s)", reason, message, getLoadBalancerNameByService(service))
+	recordServiceEvent(c, service, v1.EventTypeWarning, reason, "%s", message)
+}
+
+// resetLoadBalancerFailures forgets the reported failures for a service, which has been reconciled successfully.
+func resetLoadBalancerFailures(c *CloudConfiguration, service *v1.Service) {
+	c.FailureLimiter.Reset(getServiceKey(service) + "/")
+}
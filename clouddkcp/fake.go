@@ -0,0 +1,437 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	cloudprovider "k8s.io/cloud-provider"
+)
+
+const (
+	// FakeProviderName specifies the name of the cloud provider, which is backed by an in-memory server store.
+	FakeProviderName = "clouddk-fake"
+
+	// fakeLocationID specifies the location of the servers in the in-memory server store.
+	fakeLocationID = "dk1"
+
+	// fakeNodePackageID specifies the package of the servers backing nodes in the in-memory server store.
+	fakeNodePackageID = "89833c1dfa7010"
+)
+
+// FakeCloud implements the interface cloudprovider.Interface without accessing the Cloud.dk API or any servers.
+// Servers are created on demand for nodes and load balancers and kept in memory, which makes it possible to run the controllers in local test clusters.
+type FakeCloud struct {
+	store *FakeServerStore
+}
+
+// FakeInstances implements the interface cloudprovider.Instances for the in-memory server store.
+type FakeInstances struct {
+	store *FakeServerStore
+}
+
+// FakeLoadBalancers implements the interface cloudprovider.LoadBalancer for the in-memory server store.
+type FakeLoadBalancers struct {
+	store *FakeServerStore
+}
+
+// FakeServer describes a server in the in-memory server store.
+type FakeServer struct {
+	Addresses   []string
+	Hostname    string
+	Identifier  string
+	IPAddress   string
+	PackageID   string
+	Ports       []v1.ServicePort
+	ServiceName string
+}
+
+// FakeServerStore stores the servers of the fake cloud provider.
+type FakeServerStore struct {
+	KubeClient kubernetes.Interface
+
+	mutex   sync.RWMutex
+	next    int
+	servers map[string]*FakeServer
+}
+
+// FakeZones implements the interface cloudprovider.Zones for the in-memory server store.
+type FakeZones struct {
+	store *FakeServerStore
+}
+
+// init registers the fake cloud provider.
+func init() {
+	cloudprovider.RegisterCloudProvider(FakeProviderName, func(io.Reader) (cloudprovider.Interface, error) {
+		debugCloudAction(rtCloud, "Creating new cloud provider instance of '%s'", FakeProviderName)
+
+		return FakeCloud{
+			store: newFakeServerStore(),
+		}, nil
+	})
+}
+
+// newFakeServerStore initializes a new FakeServerStore object.
+func newFakeServerStore() *FakeServerStore {
+	return &FakeServerStore{
+		servers: map[string]*FakeServer{},
+	}
+}
+
+// trimFakeProviderID removes the prefix from a provider id of the fake cloud provider.
+func trimFakeProviderID(id string) string {
+	return strings.TrimPrefix(id, FakeProviderName+"://")
+}
+
+// Initialize provides the cloud with a kubernetes client builder.
+// The client is only used to retrieve the addresses, which the nodes report themselves.
+func (c FakeCloud) Initialize(clientBuilder cloudprovider.ControllerClientBuilder, stop <-chan struct{}) {
+	debugCloudAction(rtCloud, "Initializing cloud provider '%s'", c.ProviderName())
+
+	client, err := clientBuilder.Client(componentName)
+
+	if err != nil {
+		debugCloudAction(rtCloud, "Failed to create Kubernetes client for cloud provider '%s' - Error: %s", c.ProviderName(), err.Error())
+
+		return
+	}
+
+	c.store.mutex.Lock()
+	c.store.KubeClient = client
+	c.store.mutex.Unlock()
+}
+
+// LoadBalancer returns a balancer interface. Also returns true if the interface is supported, false otherwise.
+func (c FakeCloud) LoadBalancer() (cloudprovider.LoadBalancer, bool) {
+	return FakeLoadBalancers{store: c.store}, true
+}
+
+// Instances returns an instances interface. Also returns true if the interface is supported, false otherwise.
+func (c FakeCloud) Instances() (cloudprovider.Instances, bool) {
+	return FakeInstances{store: c.store}, true
+}
+
+// InstancesV2 returns an instances interface optimized for external cloud providers. Also returns true if the interface is supported, false otherwise.
+func (c FakeCloud) InstancesV2() (cloudprovider.InstancesV2, bool) {
+	return nil, false
+}
+
+// Zones returns a zones interface. Also returns true if the interface is supported, false otherwise.
+func (c FakeCloud) Zones() (cloudprovider.Zones, bool) {
+	return FakeZones{store: c.store}, true
+}
+
+// Clusters returns a clusters interface.  Also returns true if the interface is supported, false otherwise.
+func (c FakeCloud) Clusters() (cloudprovider.Clusters, bool) {
+	return nil, false
+}
+
+// Routes returns a routes interface along with whether the interface is supported.
+func (c FakeCloud) Routes() (cloudprovider.Routes, bool) {
+	return nil, false
+}
+
+// ProviderName returns the cloud provider ID.
+func (c FakeCloud) ProviderName() string {
+	return FakeProviderName
+}
+
+// HasClusterID returns true if a ClusterID is required and set.
+func (c FakeCloud) HasClusterID() bool {
+	return false
+}
+
+// Delete removes the server with the specified hostname and returns whether it existed.
+func (s *FakeServerStore) Delete(hostname string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for id, server := range s.servers {
+		if server.Hostname == hostname {
+			delete(s.servers, id)
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// Ensure retrieves the server with the specified hostname and creates it, if it does not exist.
+// The IP addresses are allocated sequentially from the benchmarking range 198.18.0.0/15, which is never routed on the internet.
+func (s *FakeServerStore) Ensure(hostname string, packageID string) FakeServer {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, server := range s.servers {
+		if server.Hostname == hostname {
+			return *server
+		}
+	}
+
+	s.next++
+
+	server := &FakeServer{
+		Hostname:   hostname,
+		Identifier: fmt.Sprintf("fake%08x", s.next),
+		IPAddress:  fmt.Sprintf("198.18.%d.%d", s.next/254%256, s.next%254+1),
+		PackageID:  packageID,
+	}
+
+	s.servers[server.Identifier] = server
+
+	debugCloudAction(rtCloud, "Created fake server '%s' (hostname: %s)", server.Identifier, hostname)
+
+	return *server
+}
+
+// GetByHostname retrieves the server with the specified hostname.
+func (s *FakeServerStore) GetByHostname(hostname string) (FakeServer, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, server := range s.servers {
+		if server.Hostname == hostname {
+			return *server, true
+		}
+	}
+
+	return FakeServer{}, false
+}
+
+// GetByID retrieves the server with the specified id.
+func (s *FakeServerStore) GetByID(id string) (FakeServer, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	server, ok := s.servers[id]
+
+	if !ok {
+		return FakeServer{}, false
+	}
+
+	return *server, true
+}
+
+// Update replaces the server with the same id.
+func (s *FakeServerStore) Update(server FakeServer) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.servers[server.Identifier]; !ok {
+		return fmt.Errorf("The server '%s' does not exist", server.Identifier)
+	}
+
+	s.servers[server.Identifier] = &server
+
+	return nil
+}
+
+// getNodeAddresses retrieves the addresses of a server backing a node.
+// The addresses reported by the node itself are retained as internal addresses, while the server address is published as the external address.
+func (s *FakeServerStore) getNodeAddresses(server FakeServer) []v1.NodeAddress {
+	addresses := []v1.NodeAddress{
+		{Type: v1.NodeExternalIP, Address: server.IPAddress},
+	}
+
+	s.mutex.RLock()
+	client := s.KubeClient
+	s.mutex.RUnlock()
+
+	if client == nil {
+		return addresses
+	}
+
+	node, err := client.CoreV1().Nodes().Get(context.Background(), server.Hostname, metav1.GetOptions{})
+
+	if err != nil {
+		return addresses
+	}
+
+	for _, address := range node.Status.Addresses {
+		if address.Type == v1.NodeInternalIP || address.Type == v1.NodeHostName {
+			addresses = append(addresses, address)
+		}
+	}
+
+	return addresses
+}
+
+// NodeAddresses returns the addresses of the specified instance.
+func (i FakeInstances) NodeAddresses(ctx context.Context, name types.NodeName) ([]v1.NodeAddress, error) {
+	return i.store.getNodeAddresses(i.store.Ensure(string(name), fakeNodePackageID)), nil
+}
+
+// NodeAddressesByProviderID returns the addresses of the specified instance.
+func (i FakeInstances) NodeAddressesByProviderID(ctx context.Context, providerID string) ([]v1.NodeAddress, error) {
+	server, ok := i.store.GetByID(trimFakeProviderID(providerID))
+
+	if !ok {
+		return nil, cloudprovider.InstanceNotFound
+	}
+
+	return i.store.getNodeAddresses(server), nil
+}
+
+// InstanceID returns the cloud provider ID of the node with the specified NodeName.
+// A server is created for every node, which has not been seen before.
+func (i FakeInstances) InstanceID(ctx context.Context, nodeName types.NodeName) (string, error) {
+	return i.store.Ensure(string(nodeName), fakeNodePackageID).Identifier, nil
+}
+
+// InstanceType returns the type of the specified instance.
+func (i FakeInstances) InstanceType(ctx context.Context, name types.NodeName) (string, error) {
+	return i.store.Ensure(string(name), fakeNodePackageID).PackageID, nil
+}
+
+// InstanceTypeByProviderID returns the type of the specified instance.
+func (i FakeInstances) InstanceTypeByProviderID(ctx context.Context, providerID string) (string, error) {
+	server, ok := i.store.GetByID(trimFakeProviderID(providerID))
+
+	if !ok {
+		return "", cloudprovider.InstanceNotFound
+	}
+
+	return server.PackageID, nil
+}
+
+// AddSSHKeyToAllInstances adds an SSH public key as a legal identity for all instances expected format for the key is standard ssh-keygen format: <protocol> <blob>.
+func (i FakeInstances) AddSSHKeyToAllInstances(ctx context.Context, user string, keyData []byte) error {
+	return errors.New("Not implemented")
+}
+
+// CurrentNodeName returns the name of the node we are currently running on.
+func (i FakeInstances) CurrentNodeName(ctx context.Context, hostname string) (types.NodeName, error) {
+	return types.NodeName(hostname), nil
+}
+
+// InstanceExistsByProviderID returns true if the instance for the given provider exists.
+func (i FakeInstances) InstanceExistsByProviderID(ctx context.Context, providerID string) (bool, error) {
+	_, ok := i.store.GetByID(trimFakeProviderID(providerID))
+
+	return ok, nil
+}
+
+// InstanceShutdownByProviderID returns true if the instance is shutdown in cloudprovider.
+// The servers in the in-memory server store are never shut down.
+func (i FakeInstances) InstanceShutdownByProviderID(ctx context.Context, providerID string) (bool, error) {
+	_, ok := i.store.GetByID(trimFakeProviderID(providerID))
+
+	if !ok {
+		return false, cloudprovider.InstanceNotFound
+	}
+
+	return false, nil
+}
+
+// GetLoadBalancer returns whether the specified load balancer exists, and if so, what its status is.
+func (l FakeLoadBalancers) GetLoadBalancer(ctx context.Context, clusterName string, service *v1.Service) (status *v1.LoadBalancerStatus, exists bool, err error) {
+	server, ok := l.store.GetByHostname(getLoadBalancerHostname(clusterName, getLoadBalancerNameByService(service)))
+
+	if !ok {
+		return &v1.LoadBalancerStatus{}, false, nil
+	}
+
+	return getFakeLoadBalancerStatus(server, service), true, nil
+}
+
+// GetLoadBalancerName returns the name of the load balancer.
+func (l FakeLoadBalancers) GetLoadBalancerName(ctx context.Context, clusterName string, service *v1.Service) string {
+	return getLoadBalancerNameByService(service)
+}
+
+// EnsureLoadBalancer creates a new load balancer 'name', or updates the existing one. Returns the status of the balancer.
+// The annotations are validated like by the real cloud provider, which makes it possible to verify manifests before using a real account.
+func (l FakeLoadBalancers) EnsureLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
+	problems := validateLoadBalancerAnnotations(service)
+
+	if len(problems) > 0 {
+		return nil, newConfigurationError(fmt.Errorf("The service has invalid annotations: %s", strings.Join(problems, "; ")))
+	}
+
+	connectionLimit, _ := parseIntAnnotation(service.Annotations[annoLoadBalancerConnectionLimit], 1000, 1, 20000)
+	hostname := getLoadBalancerHostname(clusterName, getLoadBalancerNameByService(service))
+	packageID, _ := getPackageIDByService(service, connectionLimit)
+	server := l.store.Ensure(hostname, packageID)
+
+	err := l.UpdateLoadBalancer(ctx, clusterName, service, nodes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return getFakeLoadBalancerStatus(server, service), nil
+}
+
+// UpdateLoadBalancer updates hosts under the specified load balancer.
+func (l FakeLoadBalancers) UpdateLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) error {
+	loadBalancerName := getLoadBalancerNameByService(service)
+	server, ok := l.store.GetByHostname(getLoadBalancerHostname(clusterName, loadBalancerName))
+
+	if !ok {
+		return fmt.Errorf("The load balancer does not exist (name: %s)", loadBalancerName)
+	}
+
+	connectionLimit, _ := parseIntAnnotation(service.Annotations[annoLoadBalancerConnectionLimit], 1000, 1, 20000)
+
+	server.Addresses = getNodeAddresses(filterLoadBalancerNodes(nodes), loadBalancerName)
+	server.PackageID, _ = getPackageIDByService(service, connectionLimit)
+	server.Ports = service.Spec.Ports
+	server.ServiceName = service.Namespace + "/" + service.Name
+
+	debugCloudAction(rtLoadBalancers, "Updated fake load balancer '%s' (name: %s, nodes: %d, ports: %d)", server.Identifier, loadBalancerName, len(server.Addresses), len(server.Ports))
+
+	return l.store.Update(server)
+}
+
+// EnsureLoadBalancerDeleted deletes the specified load balancer if it exists, returning nil if the load balancer specified either didn't exist or was successfully deleted.
+func (l FakeLoadBalancers) EnsureLoadBalancerDeleted(ctx context.Context, clusterName string, service *v1.Service) error {
+	l.store.Delete(getLoadBalancerHostname(clusterName, getLoadBalancerNameByService(service)))
+
+	return nil
+}
+
+// getFakeLoadBalancerStatus retrieves the status of a load balancer in the in-memory server store.
+func getFakeLoadBalancerStatus(server FakeServer, service *v1.Service) *v1.LoadBalancerStatus {
+	ingress := v1.LoadBalancerIngress{
+		IP: server.IPAddress,
+	}
+
+	if hostname := getExternalDNSHostname(service); hostname != "" {
+		ingress.Hostname = hostname
+	}
+
+	return &v1.LoadBalancerStatus{Ingress: []v1.LoadBalancerIngress{ingress}}
+}
+
+// GetZone returns the Zone containing the current failure zone and locality region that the program is running in.
+func (z FakeZones) GetZone(ctx context.Context) (cloudprovider.Zone, error) {
+	return cloudprovider.Zone{FailureDomain: fakeLocationID, Region: fakeLocationID}, nil
+}
+
+// GetZoneByProviderID returns the Zone containing the current zone and locality region of the node specified by providerID.
+func (z FakeZones) GetZoneByProviderID(ctx context.Context, providerID string) (cloudprovider.Zone, error) {
+	_, ok := z.store.GetByID(trimFakeProviderID(providerID))
+
+	if !ok {
+		return cloudprovider.Zone{}, cloudprovider.InstanceNotFound
+	}
+
+	return z.GetZone(ctx)
+}
+
+// GetZoneByNodeName returns the Zone containing the current zone and locality region of the node specified by node name.
+func (z FakeZones) GetZoneByNodeName(ctx context.Context, nodeName types.NodeName) (cloudprovider.Zone, error) {
+	return z.GetZone(ctx)
+}
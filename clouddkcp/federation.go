@@ -0,0 +1,267 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/MakeNowJust/heredoc"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// annoLoadBalancerSharedName is the annotation specifying the name of a load balancer, which is shared with services in other clusters.
+	// Every service, which specifies the same name, co-manages a single server with its own configuration fragment.
+	// Defaults to no sharing.
+	annoLoadBalancerSharedName = "kubernetes.cloud.dk/load-balancer-shared-name"
+
+	// fmtHAProxyFragmentListen specifies the format for the names of the fragments containing the 'listen' sections of a service.
+	fmtHAProxyFragmentListen = "50-%s-%s.cfg"
+
+	// haProxyFragmentBase specifies the name of the fragment containing the 'global' and 'defaults' sections and the listener for the Prometheus exporter.
+	haProxyFragmentBase = "00-base.cfg"
+
+	pathHAProxyAssembleScript = "/usr/local/sbin/clouddk-assemble-haproxy"
+	pathHAProxyFragments      = "/etc/haproxy/fragments"
+)
+
+var (
+	// reLoadBalancerSharedName matches the names, which are accepted for shared load balancers.
+	reLoadBalancerSharedName = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$`)
+
+	haProxyAssembleScript = heredoc.Doc(`
+		#!/bin/bash
+		# Assemble the HAProxy configuration from the fragments of every cluster sharing this load balancer.
+		# The fragments in the staging directory, which is passed as the first argument, replace the current fragments, while the fragments named by the remaining arguments are removed.
+		set -e
+		staging="$1"
+		shift
+
+		mkdir -p /etc/haproxy/fragments
+		cd /etc/haproxy/fragments
+
+		exec 9>.lock
+		flock 9
+
+		assembly="$(mktemp -d)"
+		trap 'rm -rf "$assembly" "$staging" /etc/haproxy/haproxy.cfg.assembled' EXIT
+
+		cp *.cfg "$assembly/" 2>/dev/null || true
+		cp "$staging"/*.cfg "$assembly/" 2>/dev/null || true
+
+		for f in "$@"; do
+			rm -f "$assembly/$(basename "$f")"
+		done
+
+		cat "$assembly"/*.cfg > /etc/haproxy/haproxy.cfg.assembled
+		haproxy -c -q -f /etc/haproxy/haproxy.cfg.assembled
+
+		cp "$staging"/*.cfg . 2>/dev/null || true
+
+		for f in "$@"; do
+			rm -f "$(basename "$f")"
+		done
+
+		mv /etc/haproxy/haproxy.cfg.assembled /etc/haproxy/haproxy.cfg
+
+		# Report the number of fragments with 'listen' sections, which determines whether the server is still in use.
+		find . -maxdepth 1 -name '50-*.cfg' | wc -l
+	`)
+)
+
+// assembleHAProxyFragments stages the specified fragments on a shared load balancer, removes the fragments named by removals and assembles the configuration.
+// Every service stages its fragments in a separate directory, as the clusters sharing the load balancer may update it concurrently.
+// The number of remaining fragments with 'listen' sections is returned.
+func assembleHAProxyFragments(server *CloudServer, sshClient *ssh.Client, sftpClient *sftp.Client, staging string, fragments map[string]string, removals []string) (int, error) {
+	files := []provisioningFile{
+		{Path: pathHAProxyAssembleScript, Contents: haProxyAssembleScript, Mode: fileModeScript},
+	}
+
+	names := make([]string, 0, len(fragments))
+
+	for name := range fragments {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		files = append(files, provisioningFile{Path: path.Join(staging, name), Contents: fragments[name], Mode: fileModeConfig})
+	}
+
+	for _, f := range files {
+		err := server.UploadFile(sshClient, sftpClient, f.Path, bytes.NewBufferString(f.Contents), f.Mode, 0, 0)
+
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	verifyCommand, err := server.signProvisioningFiles(sshClient, sftpClient, files)
+
+	if err != nil {
+		return 0, err
+	}
+
+	assembleCommand := fmt.Sprintf("/bin/bash %s %s", pathHAProxyAssembleScript, shellQuote(staging))
+
+	for _, name := range removals {
+		assembleCommand += " " + shellQuote(name)
+	}
+
+	if verifyCommand != "" {
+		assembleCommand = verifyCommand + " && " + assembleCommand
+	}
+
+	output, err := server.RunCommand(sshClient, assembleCommand)
+
+	if err != nil {
+		return 0, fmt.Errorf("Failed to assemble the HAProxy configuration - Output: %s - Error: %s", string(output), err.Error())
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	remaining, err := strconv.Atoi(strings.TrimSpace(lines[len(lines)-1]))
+
+	if err != nil {
+		return 0, fmt.Errorf("Failed to determine the number of HAProxy fragments - Output: %s", string(output))
+	}
+
+	return remaining, nil
+}
+
+// getHAProxyListenFragmentName retrieves the name of the fragment containing the 'listen' sections of a service.
+func getHAProxyListenFragmentName(clusterName string, service *v1.Service) string {
+	return fmt.Sprintf(fmtHAProxyFragmentListen, sanitizeClusterName(clusterName), getLoadBalancerNameByService(service))
+}
+
+// getHAProxyStagingPath retrieves the path to the directory, which stages the fragments of a service, whose 'listen' sections are stored in the specified fragment.
+func getHAProxyStagingPath(name string) string {
+	return path.Join(pathHAProxyFragments, ".staging-"+strings.TrimSuffix(name, ".cfg"))
+}
+
+// getLoadBalancerHostnameByService retrieves the hostname for the load balancer of a service.
+// Shared load balancers use a hostname, which does not depend on the cluster, in order for every cluster to find the same server.
+func getLoadBalancerHostnameByService(clusterName string, service *v1.Service) (string, error) {
+	sharedName, err := getLoadBalancerSharedName(service)
+
+	if err != nil {
+		return "", err
+	}
+
+	if sharedName != "" {
+		return getSharedLoadBalancerHostname(sharedName), nil
+	}
+
+	return getLoadBalancerHostname(clusterName, getLoadBalancerNameByService(service)), nil
+}
+
+// getLoadBalancerSharedName retrieves the name of the shared load balancer of a service.
+// An empty string is returned, if the service does not share its load balancer.
+func getLoadBalancerSharedName(service *v1.Service) (string, error) {
+	sharedName := service.Annotations[annoLoadBalancerSharedName]
+
+	if sharedName == "" {
+		return "", nil
+	}
+
+	err := validateLoadBalancerSharedName(sharedName)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", annoLoadBalancerSharedName, getLoadBalancerNameByService(service))
+
+		return "", newConfigurationError(err)
+	}
+
+	return sharedName, nil
+}
+
+// getSharedLoadBalancerHostname retrieves the hostname for a shared load balancer.
+func getSharedLoadBalancerHostname(sharedName string) string {
+	loadBalancerHash := md5.New()
+
+	io.WriteString(loadBalancerHash, "shared/")
+	io.WriteString(loadBalancerHash, sharedName)
+
+	return fmt.Sprintf(fmtLoadBalancerHostname, fmt.Sprintf("%x", loadBalancerHash.Sum(nil)))
+}
+
+// isSharedLoadBalancer determines whether a service shares its load balancer with services in other clusters.
+func isSharedLoadBalancer(service *v1.Service) bool {
+	return service.Annotations[annoLoadBalancerSharedName] != ""
+}
+
+// removeSharedLoadBalancerFragment removes the fragment of a service from a shared load balancer.
+// The number of remaining fragments with 'listen' sections is returned, which is zero, when the server is no longer in use.
+func removeSharedLoadBalancerFragment(server *CloudServer, clusterName string, service *v1.Service) (int, error) {
+	sshClient, err := server.SSH()
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer sshClient.Close()
+
+	name := getHAProxyListenFragmentName(clusterName, service)
+	remaining, err := assembleHAProxyFragments(server, sshClient, nil, getHAProxyStagingPath(name), nil, []string{name})
+
+	if err != nil {
+		return 0, err
+	}
+
+	if remaining > 0 {
+		_, err = server.RunCommand(sshClient, "systemctl reload-or-restart haproxy")
+	}
+
+	return remaining, err
+}
+
+// renderHAProxyFragments renders the fragments of a service for a shared load balancer.
+// The 'global' and 'defaults' sections and the listener for the Prometheus exporter are shared by every cluster, which is why the most recently updated cluster determines their contents.
+func renderHAProxyFragments(t *template.Template, h HAProxyConfig, clusterName string, service *v1.Service) (map[string]string, error) {
+	base := strings.Builder{}
+
+	for _, name := range []string{haProxyTemplateGlobal, haProxyTemplateDefaults, haProxyTemplateStats} {
+		err := t.ExecuteTemplate(&base, name, h)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	listen := strings.Builder{}
+
+	for _, listener := range h.Listeners() {
+		err := t.ExecuteTemplate(&listen, haProxyTemplateListen, listener)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return map[string]string{
+		haProxyFragmentBase: base.String(),
+		getHAProxyListenFragmentName(clusterName, service): listen.String(),
+	}, nil
+}
+
+// validateLoadBalancerSharedName validates the name of a shared load balancer.
+func validateLoadBalancerSharedName(value string) error {
+	if !reLoadBalancerSharedName.MatchString(value) {
+		return fmt.Errorf("The name '%s' must consist of at most 63 lower case alphanumeric characters or '-'", value)
+	}
+
+	return nil
+}
@@ -0,0 +1,317 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/MakeNowJust/heredoc"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	servicehelpers "k8s.io/cloud-provider/service/helpers"
+)
+
+const (
+	// pathFirewallScript specifies the path to the script, which applies the firewall rules on a server.
+	pathFirewallScript = "/usr/local/sbin/clouddk-firewall"
+
+	// pathFirewallService specifies the path to the systemd unit, which applies the firewall rules on boot.
+	pathFirewallService = "/etc/systemd/system/clouddk-firewall.service"
+
+	// sshAllowedCIDRsAny specifies that SSH connections are accepted from any address.
+	sshAllowedCIDRsAny = "any"
+
+	// sshAllowedCIDRsAuto specifies that SSH connections are only accepted from the addresses of the cluster nodes.
+	sshAllowedCIDRsAuto = "auto"
+)
+
+var (
+	firewallService = heredoc.Doc(`
+		[Unit]
+		Description=Cloud.dk firewall rules
+		Before=network-pre.target haproxy.service
+		Wants=network-pre.target
+
+		[Service]
+		Type=oneshot
+		RemainAfterExit=yes
+		ExecStart=/bin/bash /usr/local/sbin/clouddk-firewall
+
+		[Install]
+		WantedBy=multi-user.target
+	`)
+)
+
+// parseCIDRList parses a space or comma separated list of CIDRs and IP addresses.
+func parseCIDRList(value string) ([]string, error) {
+	cidrs := []string{}
+
+	for _, v := range strings.Fields(strings.Replace(value, ",", " ", -1)) {
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+
+			if ip == nil {
+				return nil, fmt.Errorf("The value '%s' is not a valid IP address", v)
+			}
+
+			cidrs = append(cidrs, getHostCIDR(ip))
+
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(v)
+
+		if err != nil {
+			return nil, err
+		}
+
+		cidrs = append(cidrs, network.String())
+	}
+
+	return cidrs, nil
+}
+
+// getHostCIDR converts an IP address to a CIDR, which only contains the address itself.
+func getHostCIDR(ip net.IP) string {
+	if ip.To4() != nil {
+		return ip.String() + "/32"
+	}
+
+	return ip.String() + "/128"
+}
+
+// getSSHAllowedCIDRs retrieves the CIDRs, which are allowed to connect to managed servers over SSH.
+// The addresses of all cluster nodes are used when no CIDRs have been configured, as the controller may run on any of them.
+func getSSHAllowedCIDRs(c *CloudConfiguration, nodes []*v1.Node) ([]string, error) {
+	if c.SSHAllowedCIDRs != nil {
+		return c.SSHAllowedCIDRs, nil
+	}
+
+	if c.KubeClient != nil {
+		nodeList, err := c.KubeClient.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{})
+
+		if err != nil {
+			return nil, err
+		}
+
+		nodes = []*v1.Node{}
+
+		for i := range nodeList.Items {
+			nodes = append(nodes, &nodeList.Items[i])
+		}
+	}
+
+	cidrs := []string{}
+
+	for _, node := range nodes {
+		for _, address := range node.Status.Addresses {
+			if address.Type != v1.NodeExternalIP && address.Type != v1.NodeInternalIP {
+				continue
+			}
+
+			ip := net.ParseIP(address.Address)
+
+			if ip != nil {
+				cidrs = append(cidrs, getHostCIDR(ip))
+			}
+		}
+	}
+
+	return cidrs, nil
+}
+
+// getSortedCIDRs removes duplicates from a list of CIDRs and sorts it.
+func getSortedCIDRs(list []string) []string {
+	unique := map[string]bool{}
+
+	for _, cidr := range list {
+		unique[cidr] = true
+	}
+
+	cidrs := []string{}
+
+	for cidr := range unique {
+		cidrs = append(cidrs, cidr)
+	}
+
+	sort.Strings(cidrs)
+
+	return cidrs
+}
+
+// getIPTablesCommand retrieves the iptables command for the address family of a CIDR.
+func getIPTablesCommand(cidr string) string {
+	if strings.Contains(cidr, ":") {
+		return "ip6tables"
+	}
+
+	return "iptables"
+}
+
+// getFirewallScript generates a script, which restricts SSH connections and scrapes of the Prometheus exporter to the specified CIDRs.
+// Unless sourceRanges is nil, all other incoming traffic is restricted to the source ranges of the service as well.
+func getFirewallScript(sshAllowedCIDRs []string, sourceRanges []string) string {
+	script := heredoc.Doc(`
+		#!/bin/bash
+		# This file is managed by the Cloud.dk cloud controller manager.
+		set -e
+
+		for iptables in iptables ip6tables; do
+			$iptables -N CLOUDDK-SSH 2>/dev/null || true
+			$iptables -N CLOUDDK-LB 2>/dev/null || true
+			$iptables -F CLOUDDK-SSH
+			$iptables -F CLOUDDK-LB
+			$iptables -C INPUT -j CLOUDDK-LB 2>/dev/null || $iptables -I INPUT -j CLOUDDK-LB
+			$iptables -C INPUT -p tcp --dport 22 -j CLOUDDK-SSH 2>/dev/null || $iptables -I INPUT -p tcp --dport 22 -j CLOUDDK-SSH
+			$iptables -C INPUT -p tcp --dport 9101 -j CLOUDDK-SSH 2>/dev/null || $iptables -I INPUT -p tcp --dport 9101 -j CLOUDDK-SSH
+			$iptables -A CLOUDDK-SSH -m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT
+		done
+	`)
+
+	script = script + "\n"
+
+	for _, cidr := range getSortedCIDRs(sshAllowedCIDRs) {
+		script = script + fmt.Sprintf("%s -A CLOUDDK-SSH -s %s -j ACCEPT\n", getIPTablesCommand(cidr), cidr)
+	}
+
+	script = script + "\niptables -A CLOUDDK-SSH -j DROP\nip6tables -A CLOUDDK-SSH -j DROP\n"
+
+	if sourceRanges == nil {
+		return script
+	}
+
+	// SSH and the Prometheus exporter are handled by their own chain, while loopback traffic, replies and ICMP are always accepted, as the server would otherwise be unable to reach the API or discover the path MTU.
+	script = script + "\n" + heredoc.Doc(`
+		for iptables in iptables ip6tables; do
+			$iptables -A CLOUDDK-LB -p tcp --dport 22 -j RETURN
+			$iptables -A CLOUDDK-LB -p tcp --dport 9101 -j RETURN
+			$iptables -A CLOUDDK-LB -i lo -j RETURN
+			$iptables -A CLOUDDK-LB -m conntrack --ctstate ESTABLISHED,RELATED -j RETURN
+		done
+
+		iptables -A CLOUDDK-LB -p icmp -j RETURN
+		ip6tables -A CLOUDDK-LB -p ipv6-icmp -j RETURN
+	`) + "\n"
+
+	for _, cidr := range getSortedCIDRs(sourceRanges) {
+		script = script + fmt.Sprintf("%s -A CLOUDDK-LB -s %s -j RETURN\n", getIPTablesCommand(cidr), cidr)
+	}
+
+	script = script + "\niptables -A CLOUDDK-LB -j DROP\nip6tables -A CLOUDDK-LB -j DROP\n"
+
+	return script
+}
+
+// getFirewallFingerprint generates the firewall script for a service without the address of the controller, which is only known once connected.
+// The script is used to determine whether the firewall rules need to be updated.
+func getFirewallFingerprint(c *CloudConfiguration, service *v1.Service, nodes []*v1.Node) (string, error) {
+	sourceRanges, err := getServiceSourceRanges(service)
+
+	if err != nil {
+		return "", newConfigurationError(err)
+	}
+
+	sshAllowedCIDRs, err := getSSHAllowedCIDRs(c, nodes)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to determine the CIDRs allowed to connect over SSH - Error: %s", err.Error())
+	}
+
+	return getFirewallScript(getSortedCIDRs(sshAllowedCIDRs), sourceRanges), nil
+}
+
+// getServiceSourceRanges retrieves the source ranges of a service.
+// The returned value is nil, if traffic from any address is allowed.
+func getServiceSourceRanges(service *v1.Service) ([]string, error) {
+	ipnets, err := servicehelpers.GetLoadBalancerSourceRanges(service)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if servicehelpers.IsAllowAll(ipnets) {
+		return nil, nil
+	}
+
+	return ipnets.StringSlice(), nil
+}
+
+// updateFirewall restricts SSH connections to a server to the allowed CIDRs and other traffic to the source ranges of the service.
+// The address, which the server sees for the current connection, is always allowed in order to avoid locking out the controller.
+func updateFirewall(server *CloudServer, sshClient *ssh.Client, sftpClient *sftp.Client, service *v1.Service, nodes []*v1.Node) error {
+	sourceRanges, err := getServiceSourceRanges(service)
+
+	if err != nil {
+		return newConfigurationError(err)
+	}
+
+	sshAllowedCIDRs, err := getSSHAllowedCIDRs(server.CloudConfiguration, nodes)
+
+	if err != nil {
+		return fmt.Errorf("Failed to determine the CIDRs allowed to connect over SSH - Error: %s", err.Error())
+	}
+
+	// The command is executed without sudo, as the environment variable is not preserved by it.
+	sshSession, err := sshClient.NewSession()
+
+	if err != nil {
+		return err
+	}
+
+	output, err := sshSession.Output("echo \"${SSH_CLIENT%% *}\"")
+	sshSession.Close()
+
+	if err != nil {
+		return fmt.Errorf("Failed to determine the address of the controller - Output: %s - Error: %s", string(output), err.Error())
+	}
+
+	controllerIP := net.ParseIP(strings.TrimSpace(string(output)))
+
+	if controllerIP == nil {
+		return fmt.Errorf("Failed to determine the address of the controller - Output: %s", string(output))
+	}
+
+	sshAllowedCIDRs = append(sshAllowedCIDRs, getHostCIDR(controllerIP))
+
+	files := []provisioningFile{
+		{Path: pathFirewallScript, Contents: getFirewallScript(sshAllowedCIDRs, sourceRanges), Mode: fileModeScript},
+		{Path: pathFirewallService, Contents: firewallService, Mode: fileModeConfig},
+	}
+
+	for _, f := range files {
+		err = server.UploadFile(sshClient, sftpClient, f.Path, bytes.NewBufferString(f.Contents), f.Mode, 0, 0)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	verifyCommand, err := server.signProvisioningFiles(sshClient, sftpClient, files)
+
+	if err != nil {
+		return err
+	}
+
+	firewallCommand := fmt.Sprintf("systemctl daemon-reload && systemctl enable --quiet clouddk-firewall && /bin/bash %s", pathFirewallScript)
+
+	if verifyCommand != "" {
+		firewallCommand = verifyCommand + " && " + firewallCommand
+	}
+
+	output, err = server.RunCommand(sshClient, firewallCommand)
+
+	if err != nil {
+		return fmt.Errorf("Failed to apply the firewall rules - Output: %s - Error: %s", string(output), err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,469 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	cloudprovider "k8s.io/cloud-provider"
+)
+
+const (
+	// gatewayClusterName specifies the cluster name used for the hostnames of gateway load balancers, which matches the default of kube-controller-manager.
+	gatewayClusterName = "kubernetes"
+
+	// gatewayControllerName specifies the controller name, which GatewayClasses must reference in order to be handled by this controller.
+	gatewayControllerName = "kubernetes.cloud.dk/gateway-controller"
+
+	// gatewayFinalizer specifies the finalizer, which prevents a gateway from being deleted before its load balancer.
+	gatewayFinalizer = "kubernetes.cloud.dk/load-balancer"
+)
+
+var (
+	gatewayClassResource = schema.GroupVersionResource{Group: "gateway.networking.k8s.io", Version: "v1", Resource: "gatewayclasses"}
+	gatewayResource      = schema.GroupVersionResource{Group: "gateway.networking.k8s.io", Version: "v1", Resource: "gateways"}
+	tcpRouteResource     = schema.GroupVersionResource{Group: "gateway.networking.k8s.io", Version: "v1alpha2", Resource: "tcproutes"}
+)
+
+// GatewayController provisions a load balancer for every Gateway, whose GatewayClass references this controller.
+// Each TCP listener of a gateway forwards to the node port of the backend of the first TCPRoute attached to it.
+type GatewayController struct {
+	client        dynamic.Interface
+	config        *CloudConfiguration
+	loadBalancers cloudprovider.LoadBalancer
+}
+
+// startGatewayController periodically reconciles the gateways until the stop channel closes.
+func startGatewayController(c *CloudConfiguration, restConfig *rest.Config, loadBalancers cloudprovider.LoadBalancer, stop <-chan struct{}) {
+	if c.GatewaySyncInterval == 0 {
+		return
+	}
+
+	client, err := dynamic.NewForConfig(restConfig)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to create dynamic client for gateway controller - Error: %s", err.Error())
+
+		return
+	}
+
+	g := &GatewayController{
+		client:        client,
+		config:        c,
+		loadBalancers: loadBalancers,
+	}
+
+	go func() {
+		ticker := time.NewTicker(c.GatewaySyncInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				err := g.sync()
+
+				if err != nil {
+					debugCloudAction(rtLoadBalancers, "Failed to reconcile gateways - Error: %s", err.Error())
+				}
+			}
+		}
+	}()
+}
+
+// sync reconciles every gateway of the GatewayClasses, which reference this controller.
+func (g *GatewayController) sync() error {
+	classes, err := g.getGatewayClasses()
+
+	if err != nil {
+		return err
+	}
+
+	if len(classes) == 0 {
+		return nil
+	}
+
+	gateways, err := g.client.Resource(gatewayResource).List(context.Background(), metav1.ListOptions{})
+
+	if err != nil {
+		return err
+	}
+
+	routes, err := g.client.Resource(tcpRouteResource).List(context.Background(), metav1.ListOptions{})
+
+	if err != nil {
+		return err
+	}
+
+	nodes, err := getLoadBalancerNodes(g.config)
+
+	if err != nil {
+		return err
+	}
+
+	for i := range gateways.Items {
+		gateway := &gateways.Items[i]
+		className, _, _ := unstructured.NestedString(gateway.Object, "spec", "gatewayClassName")
+
+		if !classes[className] {
+			continue
+		}
+
+		if gateway.GetDeletionTimestamp() != nil {
+			err = g.delete(gateway)
+		} else {
+			err = g.reconcile(gateway, routes.Items, nodes)
+		}
+
+		if err != nil {
+			debugCloudAction(rtLoadBalancers, "Failed to reconcile gateway '%s/%s' - Error: %s", gateway.GetNamespace(), gateway.GetName(), err.Error())
+		}
+	}
+
+	return nil
+}
+
+// delete destroys the load balancer of a gateway, which is being deleted, and releases the gateway afterwards.
+func (g *GatewayController) delete(gateway *unstructured.Unstructured) error {
+	if !hasGatewayFinalizer(gateway) {
+		return nil
+	}
+
+	service := getGatewayService(gateway)
+	err := g.loadBalancers.EnsureLoadBalancerDeleted(context.Background(), gatewayClusterName, service)
+
+	if err != nil {
+		return err
+	}
+
+	finalizers := []string{}
+
+	for _, v := range gateway.GetFinalizers() {
+		if v != gatewayFinalizer {
+			finalizers = append(finalizers, v)
+		}
+	}
+
+	gateway.SetFinalizers(finalizers)
+
+	_, err = g.client.Resource(gatewayResource).Namespace(gateway.GetNamespace()).Update(context.Background(), gateway, metav1.UpdateOptions{})
+
+	return err
+}
+
+// getBackendNodePort retrieves the node port, which the first backend of a TCPRoute is exposed on.
+func (g *GatewayController) getBackendNodePort(route *unstructured.Unstructured) (int32, error) {
+	rules, _, _ := unstructured.NestedSlice(route.Object, "spec", "rules")
+
+	for _, rule := range rules {
+		ruleMap, ok := rule.(map[string]interface{})
+
+		if !ok {
+			continue
+		}
+
+		backendRefs, _, _ := unstructured.NestedSlice(ruleMap, "backendRefs")
+
+		for _, backendRef := range backendRefs {
+			backendRefMap, ok := backendRef.(map[string]interface{})
+
+			if !ok {
+				continue
+			}
+
+			kind, _, _ := unstructured.NestedString(backendRefMap, "kind")
+			name, _, _ := unstructured.NestedString(backendRefMap, "name")
+			namespace, _, _ := unstructured.NestedString(backendRefMap, "namespace")
+			port, _, _ := unstructured.NestedInt64(backendRefMap, "port")
+
+			if kind != "" && kind != "Service" {
+				continue
+			}
+
+			if namespace == "" {
+				namespace = route.GetNamespace()
+			}
+
+			service, err := g.config.KubeClient.CoreV1().Services(namespace).Get(context.Background(), name, metav1.GetOptions{})
+
+			if err != nil {
+				return 0, err
+			}
+
+			for _, servicePort := range service.Spec.Ports {
+				if int64(servicePort.Port) == port && servicePort.NodePort != 0 {
+					return servicePort.NodePort, nil
+				}
+			}
+
+			return 0, fmt.Errorf("The service '%s/%s' does not expose port %d on a node port", namespace, name, port)
+		}
+	}
+
+	return 0, fmt.Errorf("The TCPRoute '%s/%s' does not have any service backends", route.GetNamespace(), route.GetName())
+}
+
+// getGatewayClasses retrieves the names of the GatewayClasses, which reference this controller, and marks them as accepted.
+func (g *GatewayController) getGatewayClasses() (map[string]bool, error) {
+	list, err := g.client.Resource(gatewayClassResource).List(context.Background(), metav1.ListOptions{})
+
+	if err != nil {
+		return nil, err
+	}
+
+	classes := map[string]bool{}
+
+	for i := range list.Items {
+		class := &list.Items[i]
+		controllerName, _, _ := unstructured.NestedString(class.Object, "spec", "controllerName")
+
+		if controllerName != gatewayControllerName {
+			continue
+		}
+
+		classes[class.GetName()] = true
+
+		if setGatewayCondition(class, "Accepted", true, "Accepted", "The GatewayClass is handled by the Cloud.dk cloud controller manager") {
+			_, err = g.client.Resource(gatewayClassResource).UpdateStatus(context.Background(), class, metav1.UpdateOptions{})
+
+			if err != nil {
+				debugCloudAction(rtLoadBalancers, "Failed to update the status of GatewayClass '%s' - Error: %s", class.GetName(), err.Error())
+			}
+		}
+	}
+
+	return classes, nil
+}
+
+// reconcile ensures that the load balancer of a gateway exists and forwards the ports of its TCP listeners.
+func (g *GatewayController) reconcile(gateway *unstructured.Unstructured, routes []unstructured.Unstructured, nodes []*v1.Node) error {
+	if !hasGatewayFinalizer(gateway) {
+		gateway.SetFinalizers(append(gateway.GetFinalizers(), gatewayFinalizer))
+
+		updatedGateway, err := g.client.Resource(gatewayResource).Namespace(gateway.GetNamespace()).Update(context.Background(), gateway, metav1.UpdateOptions{})
+
+		if err != nil {
+			return err
+		}
+
+		gateway = updatedGateway
+	}
+
+	service := getGatewayService(gateway)
+	listeners, _, _ := unstructured.NestedSlice(gateway.Object, "spec", "listeners")
+
+	for _, listener := range listeners {
+		listenerMap, ok := listener.(map[string]interface{})
+
+		if !ok {
+			continue
+		}
+
+		name, _, _ := unstructured.NestedString(listenerMap, "name")
+		port, _, _ := unstructured.NestedInt64(listenerMap, "port")
+		protocol, _, _ := unstructured.NestedString(listenerMap, "protocol")
+
+		if protocol != "TCP" {
+			continue
+		}
+
+		route := getGatewayListenerRoute(gateway, listenerMap, routes)
+
+		if route == nil {
+			continue
+		}
+
+		nodePort, err := g.getBackendNodePort(route)
+
+		if err != nil {
+			debugCloudAction(rtLoadBalancers, "Skipping listener '%s' of gateway '%s/%s' - Error: %s", name, gateway.GetNamespace(), gateway.GetName(), err.Error())
+
+			continue
+		}
+
+		service.Spec.Ports = append(service.Spec.Ports, v1.ServicePort{
+			Name:     name,
+			NodePort: nodePort,
+			Port:     int32(port),
+			Protocol: v1.ProtocolTCP,
+		})
+	}
+
+	status, err := g.loadBalancers.EnsureLoadBalancer(context.Background(), gatewayClusterName, service, nodes)
+
+	if err != nil {
+		setGatewayCondition(gateway, "Programmed", false, "Invalid", err.Error())
+	} else {
+		addresses := []interface{}{}
+
+		for _, ingress := range status.Ingress {
+			addresses = append(addresses, map[string]interface{}{
+				"type":  "IPAddress",
+				"value": ingress.IP,
+			})
+		}
+
+		unstructured.SetNestedSlice(gateway.Object, addresses, "status", "addresses")
+		setGatewayCondition(gateway, "Programmed", true, "Programmed", "The load balancer has been provisioned")
+	}
+
+	setGatewayCondition(gateway, "Accepted", true, "Accepted", "The Gateway is handled by the Cloud.dk cloud controller manager")
+
+	_, statusErr := g.client.Resource(gatewayResource).Namespace(gateway.GetNamespace()).UpdateStatus(context.Background(), gateway, metav1.UpdateOptions{})
+
+	if statusErr != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to update the status of gateway '%s/%s' - Error: %s", gateway.GetNamespace(), gateway.GetName(), statusErr.Error())
+	}
+
+	return err
+}
+
+// getGatewayListenerRoute retrieves the first TCPRoute, which is attached to a listener of a gateway, in order of namespace and name.
+// Only routes from the namespace of the gateway are accepted, unless the listener allows routes from all namespaces.
+func getGatewayListenerRoute(gateway *unstructured.Unstructured, listener map[string]interface{}, routes []unstructured.Unstructured) *unstructured.Unstructured {
+	listenerName, _, _ := unstructured.NestedString(listener, "name")
+	from, _, _ := unstructured.NestedString(listener, "allowedRoutes", "namespaces", "from")
+
+	candidates := []*unstructured.Unstructured{}
+
+	for i := range routes {
+		route := &routes[i]
+
+		if from != "All" && route.GetNamespace() != gateway.GetNamespace() {
+			continue
+		}
+
+		parentRefs, _, _ := unstructured.NestedSlice(route.Object, "spec", "parentRefs")
+
+		for _, parentRef := range parentRefs {
+			parentRefMap, ok := parentRef.(map[string]interface{})
+
+			if !ok {
+				continue
+			}
+
+			kind, _, _ := unstructured.NestedString(parentRefMap, "kind")
+			name, _, _ := unstructured.NestedString(parentRefMap, "name")
+			namespace, _, _ := unstructured.NestedString(parentRefMap, "namespace")
+			sectionName, _, _ := unstructured.NestedString(parentRefMap, "sectionName")
+
+			if namespace == "" {
+				namespace = route.GetNamespace()
+			}
+
+			if (kind == "" || kind == "Gateway") && name == gateway.GetName() && namespace == gateway.GetNamespace() && (sectionName == "" || sectionName == listenerName) {
+				candidates = append(candidates, route)
+
+				break
+			}
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].GetNamespace()+"/"+candidates[i].GetName() < candidates[j].GetNamespace()+"/"+candidates[j].GetName()
+	})
+
+	return candidates[0]
+}
+
+// getGatewayService retrieves a service, which describes the load balancer of a gateway.
+// The object kind is set to Gateway, which causes events and status ConfigMaps to refer to the gateway instead of a service.
+func getGatewayService(gateway *unstructured.Unstructured) *v1.Service {
+	annotations := map[string]string{}
+
+	for k, v := range gateway.GetAnnotations() {
+		if strings.HasPrefix(k, "kubernetes.cloud.dk/") {
+			annotations[k] = v
+		}
+	}
+
+	return &v1.Service{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: gatewayResource.GroupVersion().String(),
+			Kind:       "Gateway",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: annotations,
+			Generation:  gateway.GetGeneration(),
+			Name:        gateway.GetName(),
+			Namespace:   gateway.GetNamespace(),
+			UID:         gateway.GetUID(),
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{},
+			Type:  v1.ServiceTypeLoadBalancer,
+		},
+	}
+}
+
+// hasGatewayFinalizer determines whether a gateway carries the finalizer of this controller.
+func hasGatewayFinalizer(gateway *unstructured.Unstructured) bool {
+	for _, v := range gateway.GetFinalizers() {
+		if v == gatewayFinalizer {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setGatewayCondition sets a condition in the status of a Gateway API object and returns whether the condition has changed.
+func setGatewayCondition(obj *unstructured.Unstructured, conditionType string, status bool, reason string, message string) bool {
+	conditionStatus := string(metav1.ConditionFalse)
+
+	if status {
+		conditionStatus = string(metav1.ConditionTrue)
+	}
+
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	condition := map[string]interface{}{
+		"lastTransitionTime": time.Now().UTC().Format(time.RFC3339),
+		"message":            message,
+		"observedGeneration": obj.GetGeneration(),
+		"reason":             reason,
+		"status":             conditionStatus,
+		"type":               conditionType,
+	}
+
+	for i, v := range conditions {
+		existing, ok := v.(map[string]interface{})
+
+		if !ok || existing["type"] != conditionType {
+			continue
+		}
+
+		if existing["status"] == conditionStatus && existing["reason"] == reason && existing["message"] == message && existing["observedGeneration"] == obj.GetGeneration() {
+			return false
+		}
+
+		if existing["status"] == conditionStatus {
+			condition["lastTransitionTime"] = existing["lastTransitionTime"]
+		}
+
+		conditions[i] = condition
+		unstructured.SetNestedSlice(obj.Object, conditions, "status", "conditions")
+
+		return true
+	}
+
+	unstructured.SetNestedSlice(obj.Object, append(conditions, condition), "status", "conditions")
+
+	return true
+}
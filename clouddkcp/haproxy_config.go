@@ -0,0 +1,480 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"text/template"
+
+	"github.com/MakeNowJust/heredoc"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// annoLoadBalancerTemplate is the annotation specifying a reference of the form <namespace>/<name> to a ConfigMap, which overrides the HAProxy templates for a service.
+	// Defaults to the value of the environment variable CLOUDDK_HAPROXY_TEMPLATES.
+	annoLoadBalancerTemplate = "kubernetes.cloud.dk/load-balancer-template"
+
+	haProxyTemplateConfig   = "haproxy.cfg"
+	haProxyTemplateDefaults = "defaults"
+	haProxyTemplateGlobal   = "global"
+	haProxyTemplateListen   = "listen"
+	haProxyTemplateStats    = "stats"
+)
+
+var (
+	// haProxyConfigTemplate renders the sections of the HAProxy configuration file in order.
+	haProxyConfigTemplate = `{{ template "global" . }}{{ template "defaults" . }}{{ template "stats" . }}{{ range .Listeners }}{{ template "listen" . }}{{ end }}`
+
+	// haProxyDefaultsTemplate renders the 'defaults' section with an HAProxyConfig object as context.
+	haProxyDefaultsTemplate = heredoc.Doc(`
+		defaults
+			balance {{ .Algorithm }}
+			log global
+			maxconn {{ .MaxConn }}
+			mode tcp
+
+			timeout check {{ .HealthCheckTimeout }}s
+			timeout client {{ .ClientTimeout }}s
+			timeout connect 5s
+			timeout server {{ .ServerTimeout }}s
+		{{- if .TunnelTimeout }}
+			timeout tunnel {{ .TunnelTimeout }}s
+		{{- end }}
+
+	`)
+
+	// haProxyGlobalTemplate renders the 'global' section with an HAProxyConfig object as context.
+	haProxyGlobalTemplate = heredoc.Doc(`
+		global
+			log /dev/log local0 info alert
+			log /dev/log local1 notice alert
+
+			chroot /var/lib/haproxy
+
+			stats socket /run/haproxy/admin.sock mode 660 level admin expose-fd listeners
+			stats timeout 30s
+
+			user haproxy
+			group haproxy
+
+			ca-base /etc/ssl/certs
+			crt-base /etc/ssl/private
+
+			ssl-default-bind-ciphers {{ .BindCiphers }}
+			ssl-default-bind-options {{ .BindOptions }}
+
+			nbproc {{ .ProcessorCount }}
+			nbthread 2
+
+		{{ range .CPUs }}	cpu-map {{ . }} {{ . }}
+		{{ end }}
+	`)
+
+	// haProxyListenTemplate renders a 'listen' section with an HAProxyListener object as context.
+	haProxyListenTemplate = heredoc.Doc(`
+		listen {{ .Port }}
+			bind 0.0.0.0:{{ .Port }}{{ if .TLSCertificate }} ssl crt {{ .TLSCertificate }}{{ end }}
+		{{- if eq .Protocol "http" }}
+
+			mode http
+			option forwardfor
+			http-request set-header X-Forwarded-Proto {{ if .TLSCertificate }}https{{ else }}http{{ end }}
+		{{- end }}
+		{{- if .SourceRanges }}
+
+			acl source_allowed src{{ range .SourceRanges }} {{ . }}{{ end }}
+			tcp-request connection reject if !source_allowed
+		{{- end }}
+		{{- if and .StickySessions (eq .Protocol "http") }}
+
+			cookie CLOUDDK_LB insert indirect nocache dynamic
+			dynamic-cookie-key {{ .DynamicCookieKey }}
+		{{- end }}
+		{{- with .StickTable }}
+
+			stick-table {{ . }}
+		{{- end }}
+		{{- if and .StickySessions (ne .Protocol "http") }}
+			stick on src
+		{{- end }}
+		{{- if or .RateLimitPerSecond .RateLimitConcurrent }}
+			tcp-request connection track-sc0 src
+		{{- end }}
+		{{- if .RateLimitPerSecond }}
+			tcp-request connection reject if { sc0_conn_rate gt {{ .RateLimitPerSecond }} }
+		{{- end }}
+		{{- if .RateLimitConcurrent }}
+			tcp-request connection reject if { sc0_conn_cur gt {{ .RateLimitConcurrent }} }
+		{{- end }}
+
+		{{- if eq .HealthCheckProtocol "http" }}
+
+			option httpchk GET {{ .HealthCheckPath }}
+			http-check expect status {{ .HealthCheckStatus }}
+		{{- else }}
+
+			option tcp-check
+		{{- end }}
+		{{- if .BackupAddresses }}
+			option allbackups
+		{{- end }}
+
+		{{ range .Addresses }}	server {{ . }}:{{ $.NodePort }} {{ . }}:{{ $.NodePort }}{{ $.ServerOptions }}{{ with index $.Weights . }} weight {{ . }}{{ end }}
+		{{ end -}}
+		{{ range .BackupAddresses }}	server {{ . }}:{{ $.NodePort }} {{ . }}:{{ $.NodePort }}{{ $.ServerOptions }}{{ with index $.Weights . }} weight {{ . }}{{ end }} backup
+		{{ end -}}
+	`)
+
+	// haProxyStatsTemplate renders the 'listen' sections for the stats page and the Prometheus exporter with an HAProxyConfig object as context.
+	haProxyStatsTemplate = heredoc.Doc(`
+		{{ if .Metrics }}listen metrics
+			bind 127.0.0.1:8405
+			mode http
+
+			stats enable
+			stats uri /
+
+		{{ end }}{{ with .Stats }}listen stats
+			bind 0.0.0.0:{{ .Port }}
+			mode http
+		{{- if $.SourceRanges }}
+
+			acl source_allowed src{{ range $.SourceRanges }} {{ . }}{{ end }}
+			tcp-request connection reject if !source_allowed
+		{{- end }}
+
+			stats enable
+			stats uri /
+			stats refresh 10s
+			stats auth {{ .Username }}:{{ .Password }}
+
+		{{ end }}`)
+
+	// haProxyDefaultTemplate contains the parsed default templates.
+	haProxyDefaultTemplate = template.Must(newHAProxyTemplate(nil))
+)
+
+// HAProxyConfig describes the values, which are rendered into the HAProxy configuration file of a load balancer.
+type HAProxyConfig struct {
+	Addresses                     []string
+	Algorithm                     string
+	Annotations                   map[string]string
+	BackupAddresses               []string
+	BindCiphers                   string
+	BindOptions                   string
+	ClientTimeout                 int
+	ConnectionLimit               int
+	DynamicCookieKey              string
+	HealthCheckInterval           int
+	HealthCheckPath               string
+	HealthCheckPort               int32
+	HealthCheckProtocol           string
+	HealthCheckStatus             int
+	HealthCheckThresholdHealthy   int
+	HealthCheckThresholdUnhealthy int
+	HealthCheckTimeout            int
+	Metrics                       bool
+	Ports                         []v1.ServicePort
+	ProcessorCount                int
+	Protocol                      string
+	ProxyProtocol                 bool
+	ProxyProtocolPorts            []int32
+	RateLimitConcurrent           int
+	RateLimitPerSecond            int
+	ServerTimeout                 int
+	SourceRanges                  []string
+	Stats                         *HAProxyStats
+	StickySessions                bool
+	TLSCertificate                string
+	TLSPorts                      []int32
+	TunnelTimeout                 int
+	Weights                       map[string]int
+}
+
+// HAProxyListener describes a 'listen' section of the HAProxy configuration file.
+type HAProxyListener struct {
+	Addresses           []string
+	Annotations         map[string]string
+	BackupAddresses     []string
+	DynamicCookieKey    string
+	HealthCheckPath     string
+	HealthCheckProtocol string
+	HealthCheckStatus   int
+	NodePort            int32
+	Port                int32
+	Protocol            string
+	RateLimitConcurrent int
+	RateLimitPerSecond  int
+	ServerOptions       string
+	SourceRanges        []string
+	StickySessions      bool
+	TLSCertificate      string
+	Weights             map[string]int
+}
+
+// CPUs returns the numbers of the processes, which are mapped to a CPU each.
+func (h HAProxyConfig) CPUs() []int {
+	cpus := make([]int, h.ProcessorCount)
+
+	for i := range cpus {
+		cpus[i] = i + 1
+	}
+
+	return cpus
+}
+
+// Listeners returns a 'listen' section for each port of the service.
+// The ports, which terminate TLS, reference the certificate of the load balancer.
+func (h HAProxyConfig) Listeners() []HAProxyListener {
+	listeners := make([]HAProxyListener, len(h.Ports))
+
+	for i, port := range h.Ports {
+		listeners[i] = HAProxyListener{
+			Addresses:           h.Addresses,
+			Annotations:         h.Annotations,
+			BackupAddresses:     h.BackupAddresses,
+			DynamicCookieKey:    h.DynamicCookieKey,
+			HealthCheckPath:     h.HealthCheckPath,
+			HealthCheckProtocol: h.HealthCheckProtocol,
+			HealthCheckStatus:   h.HealthCheckStatus,
+			NodePort:            port.NodePort,
+			Port:                port.Port,
+			Protocol:            h.Protocol,
+			RateLimitConcurrent: h.RateLimitConcurrent,
+			RateLimitPerSecond:  h.RateLimitPerSecond,
+			ServerOptions:       h.ServerOptions(port.Port),
+			SourceRanges:        h.SourceRanges,
+			StickySessions:      h.StickySessions,
+			Weights:             h.Weights,
+		}
+
+		if h.TLSCertificate != "" && containsPort(h.TLSPorts, port.Port) {
+			listeners[i].TLSCertificate = h.TLSCertificate
+		}
+	}
+
+	return listeners
+}
+
+// StickTable returns the definition of the stick-table, which tracks the clients of a port.
+// A single table stores the backend servers of the clients for sticky sessions in tcp mode as well as the counters used for rate limiting.
+// An empty string is returned, if the port does not need a stick-table.
+func (l HAProxyListener) StickTable() string {
+	sticky := l.StickySessions && l.Protocol != loadBalancerProtocolHTTP
+	counters := []string{}
+
+	if l.RateLimitPerSecond > 0 {
+		counters = append(counters, "conn_rate(1s)")
+	}
+
+	if l.RateLimitConcurrent > 0 {
+		counters = append(counters, "conn_cur")
+	}
+
+	if !sticky && len(counters) == 0 {
+		return ""
+	}
+
+	table := "type ip size 1m expire 60s"
+
+	if sticky {
+		table = "type ip size 1m expire 30m"
+	}
+
+	if len(counters) > 0 {
+		table = table + " store " + strings.Join(counters, ",")
+	}
+
+	return table
+}
+
+// MaxConn returns the connection limit per process.
+func (h HAProxyConfig) MaxConn() int {
+	return h.ConnectionLimit / h.ProcessorCount
+}
+
+// ServerOptions returns the options, which are identical for every backend server of a port.
+// The health checks target HealthCheckPort instead of the node port, if it is specified.
+// The PROXY protocol is limited to the ports in ProxyProtocolPorts, unless the list is empty.
+func (h HAProxyConfig) ServerOptions(port int32) string {
+	serverOptions := fmt.Sprintf(
+		" maxconn %d check inter %d fall %d rise %d",
+		h.MaxConn(),
+		h.HealthCheckInterval,
+		h.HealthCheckThresholdUnhealthy,
+		h.HealthCheckThresholdHealthy,
+	)
+
+	if h.HealthCheckPort != 0 {
+		serverOptions = serverOptions + fmt.Sprintf(" port %d", h.HealthCheckPort)
+	}
+
+	if h.ProxyProtocol && (len(h.ProxyProtocolPorts) == 0 || containsPort(h.ProxyProtocolPorts, port)) {
+		serverOptions = serverOptions + " send-proxy"
+	}
+
+	return serverOptions
+}
+
+// Render generates the contents of the HAProxy configuration file.
+// The file is written to a single buffer, which is sized up front, as it contains a line per node and port on large clusters.
+func (h HAProxyConfig) Render(t *template.Template) (string, error) {
+	b := strings.Builder{}
+	b.Grow(1024 + len(h.Ports)*(64+(len(h.Addresses)+len(h.BackupAddresses))*128))
+
+	err := t.Execute(&b, h)
+
+	if err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// containsPort determines whether a list of ports contains the specified port.
+func containsPort(ports []int32, port int32) bool {
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getHAProxyTemplate retrieves the templates for the HAProxy configuration file of a service.
+// The whole file or any of the sections may be overridden by the ConfigMap specified by the annotation kubernetes.cloud.dk/load-balancer-template,
+// or by the environment variable CLOUDDK_HAPROXY_TEMPLATES, if the service does not specify a ConfigMap.
+func getHAProxyTemplate(c *CloudConfiguration, service *v1.Service) (*template.Template, error) {
+	reference := c.HAProxyTemplates
+
+	if service.Annotations[annoLoadBalancerTemplate] != "" {
+		if c.MinimalPermissions {
+			return nil, fmt.Errorf("The annotation '%s' requires access to ConfigMaps, which is not available with %s", annoLoadBalancerTemplate, envMinimalPermissions)
+		}
+
+		reference = service.Annotations[annoLoadBalancerTemplate]
+	}
+
+	if reference == "" {
+		return haProxyDefaultTemplate, nil
+	}
+
+	if c.KubeClient == nil {
+		return nil, errors.New("Cannot retrieve the HAProxy templates without a Kubernetes client")
+	}
+
+	namespace, name, err := parseNamespacedName(reference)
+
+	if err != nil {
+		return nil, err
+	}
+
+	configMap, err := c.KubeClient.CoreV1().ConfigMaps(namespace).Get(context.Background(), name, metav1.GetOptions{})
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve the HAProxy templates from ConfigMap '%s' - Error: %s", reference, err.Error())
+	}
+
+	t, err := newHAProxyTemplate(configMap.Data)
+
+	if err != nil {
+		return nil, fmt.Errorf("The HAProxy templates in ConfigMap '%s' are invalid - Error: %s", reference, err.Error())
+	}
+
+	return t, nil
+}
+
+// getNodeAddresses retrieves the external IP addresses of the nodes, which are used as backend servers.
+// Node addresses are interpolated into the configuration, which is why anything but a plain IP address is skipped.
+func getNodeAddresses(nodes []*v1.Node, loadBalancerName string) []string {
+	addresses := make([]string, 0, len(nodes))
+
+	for _, node := range nodes {
+		for _, address := range node.Status.Addresses {
+			if address.Type != "ExternalIP" {
+				continue
+			}
+
+			if net.ParseIP(address.Address) == nil {
+				debugCloudAction(rtLoadBalancers, "Skipping invalid address %q for node '%s' (name: %s)", address.Address, node.Name, loadBalancerName)
+
+				continue
+			}
+
+			addresses = append(addresses, address.Address)
+		}
+	}
+
+	return addresses
+}
+
+// getNodeWeights retrieves the weights of the backend servers by address from the labels of the nodes.
+// Nodes without a valid weight are omitted, which gives them the default weight.
+func getNodeWeights(nodes []*v1.Node, loadBalancerName string) map[string]int {
+	weights := map[string]int{}
+
+	for _, node := range nodes {
+		value, ok := node.Labels[labelNodeLoadBalancerWeight]
+
+		if !ok {
+			continue
+		}
+
+		weight, err := parseIntAnnotation(value, 1, 1, 256)
+
+		if err != nil {
+			debugCloudAction(rtLoadBalancers, "Ignoring invalid weight %q for node '%s' (name: %s) - Error: %s", value, node.Name, loadBalancerName, err.Error())
+
+			continue
+		}
+
+		for _, address := range getNodeAddresses([]*v1.Node{node}, loadBalancerName) {
+			weights[address] = weight
+		}
+	}
+
+	return weights
+}
+
+// newHAProxyTemplate parses the templates for the HAProxy configuration file.
+// The default template is used for the file and the sections, which have not been overridden.
+func newHAProxyTemplate(overrides map[string]string) (*template.Template, error) {
+	sections := map[string]string{
+		haProxyTemplateConfig:   haProxyConfigTemplate,
+		haProxyTemplateDefaults: haProxyDefaultsTemplate,
+		haProxyTemplateGlobal:   haProxyGlobalTemplate,
+		haProxyTemplateListen:   haProxyListenTemplate,
+		haProxyTemplateStats:    haProxyStatsTemplate,
+	}
+
+	for k := range sections {
+		if overrides[k] != "" {
+			sections[k] = overrides[k]
+		}
+	}
+
+	t, err := template.New(haProxyTemplateConfig).Option("missingkey=error").Parse(sections[haProxyTemplateConfig])
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse the '%s' template - Error: %s", haProxyTemplateConfig, err.Error())
+	}
+
+	for _, k := range []string{haProxyTemplateDefaults, haProxyTemplateGlobal, haProxyTemplateListen, haProxyTemplateStats} {
+		_, err = t.New(k).Parse(sections[k])
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse the '%s' template - Error: %s", k, err.Error())
+		}
+	}
+
+	return t, nil
+}
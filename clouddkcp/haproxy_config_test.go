@@ -0,0 +1,163 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// update rewrites the golden files with the rendered output instead of comparing them.
+var update = flag.Bool("update", false, "update the golden files")
+
+// assertGolden compares the rendered output with the contents of a golden file in the testdata directory byte for byte.
+func assertGolden(t *testing.T, name string, actual string) {
+	t.Helper()
+
+	goldenPath := filepath.Join("testdata", name+".golden")
+
+	if *update {
+		err := ioutil.WriteFile(goldenPath, []byte(actual), 0644)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected, err := ioutil.ReadFile(goldenPath)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(expected, []byte(actual)) {
+		t.Errorf("The rendered output does not match %s (run 'go test -update' to regenerate)\n--- expected\n%s\n--- actual\n%s", goldenPath, expected, actual)
+	}
+}
+
+// newTestHAProxyConfig creates a configuration with the default settings of a load balancer, which forwards a number of ports to a number of nodes.
+func newTestHAProxyConfig(nodeCount int, portCount int) HAProxyConfig {
+	addresses := make([]string, nodeCount)
+
+	for i := range addresses {
+		addresses[i] = fmt.Sprintf("10.0.%d.%d", i/250, i%250+1)
+	}
+
+	ports := make([]v1.ServicePort, portCount)
+
+	for i := range ports {
+		ports[i] = v1.ServicePort{
+			NodePort: int32(30000 + i),
+			Port:     int32(8000 + i),
+			Protocol: v1.ProtocolTCP,
+		}
+	}
+
+	return HAProxyConfig{
+		Addresses:                     addresses,
+		Algorithm:                     "roundrobin",
+		Annotations:                   map[string]string{},
+		BindCiphers:                   "ECDH+AESGCM:DH+AESGCM:ECDH+AES256:DH+AES256:ECDH+AES128:DH+AES:RSA+AESGCM:RSA+AES:!aNULL:!MD5:!DSS",
+		BindOptions:                   "no-sslv3",
+		ClientTimeout:                 30,
+		ConnectionLimit:               1000,
+		DynamicCookieKey:              "k8s-load-balancer-test",
+		HealthCheckInterval:           5,
+		HealthCheckPath:               "/",
+		HealthCheckProtocol:           loadBalancerProtocolTCP,
+		HealthCheckStatus:             200,
+		HealthCheckThresholdHealthy:   2,
+		HealthCheckThresholdUnhealthy: 3,
+		HealthCheckTimeout:            5,
+		Ports:                         ports,
+		ProcessorCount:                1,
+		Protocol:                      loadBalancerProtocolTCP,
+		ServerTimeout:                 60,
+		Weights:                       map[string]int{},
+	}
+}
+
+func TestRenderHAProxyConfigGolden(t *testing.T) {
+	// The source ranges apply to the stats page as well as to the service ports.
+	statsConfig := newTestHAProxyConfig(3, 2)
+	statsConfig.SourceRanges = []string{"192.0.2.0/24"}
+	statsConfig.Stats = &HAProxyStats{
+		Password: "secret",
+		Port:     8404,
+		Username: "admin",
+	}
+
+	tlsConfig := newTestHAProxyConfig(3, 2)
+	tlsConfig.Protocol = loadBalancerProtocolHTTP
+	tlsConfig.HealthCheckProtocol = loadBalancerProtocolHTTP
+	tlsConfig.HealthCheckPath = "/healthz"
+	tlsConfig.StickySessions = true
+	tlsConfig.TLSCertificate = "/etc/haproxy/certs/k8s-load-balancer-test.pem"
+	tlsConfig.TLSPorts = []int32{8001}
+
+	// The TLS connections are forwarded to the nodes without being terminated, which leaves the client addresses to the PROXY protocol.
+	passthroughConfig := newTestHAProxyConfig(3, 2)
+	passthroughConfig.BackupAddresses = []string{"10.1.0.1"}
+	passthroughConfig.ProxyProtocol = true
+	passthroughConfig.ProxyProtocolPorts = []int32{8000}
+	passthroughConfig.TunnelTimeout = 3600
+	passthroughConfig.Weights = map[string]int{"10.0.0.2": 50}
+
+	tests := []struct {
+		name          string
+		haProxyConfig HAProxyConfig
+	}{
+		{"haproxy_default", newTestHAProxyConfig(3, 2)},
+		{"haproxy_stats", statsConfig},
+		{"haproxy_tls", tlsConfig},
+		{"haproxy_passthrough", passthroughConfig},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := tt.haProxyConfig.Render(haProxyDefaultTemplate)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assertGolden(t, tt.name, actual)
+		})
+	}
+}
+
+// benchmarkRenderHAProxyConfig measures the rendering of the default templates for a number of nodes and ports.
+func benchmarkRenderHAProxyConfig(b *testing.B, nodeCount int, portCount int) {
+	haProxyConfig := newTestHAProxyConfig(nodeCount, portCount)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, err := haProxyConfig.Render(haProxyDefaultTemplate)
+
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRenderHAProxyConfigSmall(b *testing.B) {
+	benchmarkRenderHAProxyConfig(b, 3, 2)
+}
+
+func BenchmarkRenderHAProxyConfigMedium(b *testing.B) {
+	benchmarkRenderHAProxyConfig(b, 50, 10)
+}
+
+func BenchmarkRenderHAProxyConfigLarge(b *testing.B) {
+	benchmarkRenderHAProxyConfig(b, 500, 50)
+}
@@ -0,0 +1,71 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/MakeNowJust/heredoc"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// pathHAProxyReloadConf specifies the path to the systemd drop-in, which enables seamless reloads for HAProxy deployed in host mode.
+	pathHAProxyReloadConf = "/etc/systemd/system/haproxy.service.d/reload.conf"
+)
+
+var (
+	// haProxyReloadConf runs HAProxy in master-worker mode, where the new workers retrieve the listening sockets of the old workers from the admin socket on reload.
+	// The sockets are thereby never closed, which prevents reloads from refusing new connections, while the old workers finish the established connections.
+	haProxyReloadConf = heredoc.Doc(`
+		[Service]
+		Environment="CONFIG=/etc/haproxy/haproxy.cfg" "PIDFILE=/run/haproxy.pid"
+		Type=notify
+		KillMode=mixed
+		ExecStart=
+		ExecStart=/usr/sbin/haproxy -Ws -f $CONFIG -p $PIDFILE -x /run/haproxy/admin.sock $EXTRAOPTS
+		ExecReload=
+		ExecReload=/usr/sbin/haproxy -Ws -f $CONFIG -c -q $EXTRAOPTS
+		ExecReload=/bin/kill -USR2 $MAINPID
+	`)
+)
+
+// ensureHAProxySeamlessReload updates the systemd units of a load balancer, which was provisioned before seamless reloads were introduced.
+// The changes to the start command only take effect, once HAProxy is restarted, while the reload command is replaced right away.
+func ensureHAProxySeamlessReload(server *CloudServer, sshClient *ssh.Client, sftpClient *sftp.Client, containerMode bool) error {
+	filePath := pathHAProxyReloadConf
+	fileContents := haProxyReloadConf
+
+	if containerMode {
+		filePath = pathHAProxyContainerService
+		fileContents = haProxyContainerService
+	}
+
+	output, err := server.RunCommand(sshClient, fmt.Sprintf("cat %s 2>/dev/null || true", filePath))
+
+	if err != nil {
+		return err
+	}
+
+	if string(output) == fileContents {
+		return nil
+	}
+
+	err = server.UploadFile(sshClient, sftpClient, filePath, bytes.NewBufferString(fileContents), fileModeConfig, 0, 0)
+
+	if err != nil {
+		return err
+	}
+
+	output, err = server.RunCommand(sshClient, "systemctl daemon-reload")
+
+	if err != nil {
+		return fmt.Errorf("Failed to reload the systemd units - Output: %s - Error: %s", string(output), err.Error())
+	}
+
+	return nil
+}
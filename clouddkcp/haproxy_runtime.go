@@ -0,0 +1,251 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// pathHAProxyRuntimeState specifies the path to the state, which the running HAProxy process has loaded.
+	pathHAProxyRuntimeState = "/etc/haproxy/haproxy.cfg.applied"
+)
+
+var (
+	// haProxyDynamicServersVersion specifies the first HAProxy version, which supports adding and deleting servers through the runtime API.
+	haProxyDynamicServersVersion = []int{2, 5}
+
+	// haProxyRuntimeResponses contains the responses of the runtime API, which indicate that a command succeeded.
+	haProxyRuntimeResponses = map[string]bool{
+		"":                       true,
+		"New server registered.": true,
+		"Server deleted.":        true,
+	}
+
+	// reHAProxyRuntimeVersion matches the version reported by the 'show info' command of the runtime API.
+	reHAProxyRuntimeVersion = regexp.MustCompile(`(?m)^Version: ([0-9]+)\.([0-9]+)`)
+)
+
+// haProxyRuntimeChanges describes the backend servers, which must be added or removed in order to apply a configuration through the runtime API.
+type haProxyRuntimeChanges struct {
+	Added   []haProxyServer
+	Removed []haProxyServer
+}
+
+// haProxyServer describes a 'server' line of the HAProxy configuration file.
+type haProxyServer struct {
+	Address string
+	Backend string
+	Name    string
+	Options []string
+}
+
+// Key returns the identifier of the server, which is used by the runtime API.
+func (s haProxyServer) Key() string {
+	return s.Backend + "/" + s.Name
+}
+
+// getHAProxyRuntimeChanges determines the backend servers, which differ between the state of the running HAProxy process and a new state.
+// The returned bool is false, if the states contain other differences, which require HAProxy to be reloaded.
+func getHAProxyRuntimeChanges(current string, desired string) (*haProxyRuntimeChanges, bool) {
+	currentRemainder, currentServers := parseHAProxyServers(current)
+	desiredRemainder, desiredServers := parseHAProxyServers(desired)
+
+	if current == "" || currentRemainder != desiredRemainder {
+		return nil, false
+	}
+
+	changes := &haProxyRuntimeChanges{}
+
+	for key, s := range currentServers {
+		d, ok := desiredServers[key]
+
+		if !ok {
+			changes.Removed = append(changes.Removed, s)
+		} else if d.Address != s.Address || strings.Join(d.Options, " ") != strings.Join(s.Options, " ") {
+			return nil, false
+		}
+	}
+
+	for key, d := range desiredServers {
+		if _, ok := currentServers[key]; !ok {
+			changes.Added = append(changes.Added, d)
+		}
+	}
+
+	sort.Slice(changes.Added, func(i, j int) bool { return changes.Added[i].Key() < changes.Added[j].Key() })
+	sort.Slice(changes.Removed, func(i, j int) bool { return changes.Removed[i].Key() < changes.Removed[j].Key() })
+
+	return changes, true
+}
+
+// getHAProxyRuntimeState generates the state of a load balancer, which is compared with the state of the running HAProxy process.
+// The values, which are deployed next to the configuration file, are appended as comments, which forces a reload, when they change.
+func getHAProxyRuntimeState(configFileContents string, tlsCertificate string, haProxyImage string, haProxyVersion string) string {
+	return configFileContents + fmt.Sprintf(
+		"\n# certificate %s\n# image %s\n# version %s\n",
+		getConfigHash(tlsCertificate),
+		haProxyImage,
+		haProxyVersion,
+	)
+}
+
+// parseHAProxyServers extracts the 'server' lines of the backends from an HAProxy configuration file.
+// The configuration file is returned without the extracted lines.
+func parseHAProxyServers(contents string) (string, map[string]haProxyServer) {
+	backend := ""
+	remainder := strings.Builder{}
+	servers := map[string]haProxyServer{}
+
+	for _, line := range strings.Split(contents, "\n") {
+		fields := strings.Fields(line)
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		if line[0] != ' ' && line[0] != '\t' {
+			backend = ""
+
+			if (fields[0] == "backend" || fields[0] == "listen") && len(fields) > 1 {
+				backend = fields[1]
+			}
+		} else if backend != "" && fields[0] == "server" && len(fields) > 2 {
+			s := haProxyServer{
+				Address: fields[2],
+				Backend: backend,
+				Name:    fields[1],
+				Options: fields[3:],
+			}
+
+			servers[s.Key()] = s
+
+			continue
+		}
+
+		remainder.WriteString(line)
+		remainder.WriteString("\n")
+	}
+
+	return remainder.String(), servers
+}
+
+// applyHAProxyRuntimeChanges adds and removes backend servers through the runtime API of a load balancer.
+// The returned bool is false, if the changes cannot be applied without a reload, as the HAProxy version does not support adding servers.
+// Removed servers are put into maintenance, before they are deleted, which leaves the active connections of older versions intact.
+func applyHAProxyRuntimeChanges(server *CloudServer, sshClient *ssh.Client, changes *haProxyRuntimeChanges) (bool, error) {
+	info, err := runHAProxyRuntimeCommand(server, sshClient, "show info")
+
+	if err != nil {
+		return false, err
+	}
+
+	matches := reHAProxyRuntimeVersion.FindStringSubmatch(info)
+
+	if matches == nil {
+		return false, fmt.Errorf("Failed to determine the HAProxy version through the runtime API")
+	}
+
+	major, _ := strconv.Atoi(matches[1])
+	minor, _ := strconv.Atoi(matches[2])
+	dynamicServers := major > haProxyDynamicServersVersion[0] || (major == haProxyDynamicServersVersion[0] && minor >= haProxyDynamicServersVersion[1])
+
+	if len(changes.Added) > 0 && !dynamicServers {
+		return false, nil
+	}
+
+	for _, s := range changes.Added {
+		commands := []string{fmt.Sprintf("add server %s %s %s", s.Key(), s.Address, strings.Join(s.Options, " "))}
+
+		for _, option := range s.Options {
+			if option == "check" {
+				commands = append(commands, fmt.Sprintf("enable health %s", s.Key()))
+			}
+		}
+
+		commands = append(commands, fmt.Sprintf("enable server %s", s.Key()))
+
+		for _, command := range commands {
+			err = runHAProxyRuntimeMutation(server, sshClient, command)
+
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+
+	for _, s := range changes.Removed {
+		err = runHAProxyRuntimeMutation(server, sshClient, fmt.Sprintf("set server %s state maint", s.Key()))
+
+		if err != nil {
+			return false, err
+		}
+
+		if !dynamicServers {
+			continue
+		}
+
+		// A server with active connections cannot be deleted, which is why it remains in maintenance until the next reload.
+		err = runHAProxyRuntimeMutation(server, sshClient, fmt.Sprintf("del server %s", s.Key()))
+
+		if err != nil {
+			debugCloudAction(rtLoadBalancers, "Failed to delete server '%s' through the runtime API - Error: %s", s.Key(), err.Error())
+		}
+	}
+
+	return true, nil
+}
+
+// getHAProxyRuntimeStateByServer retrieves the state of the running HAProxy process on a load balancer.
+// An empty string is returned, if the state has not been recorded.
+func getHAProxyRuntimeStateByServer(server *CloudServer, sshClient *ssh.Client) (string, error) {
+	output, err := server.RunCommand(sshClient, fmt.Sprintf("cat %s 2>/dev/null || true", pathHAProxyRuntimeState))
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(output), nil
+}
+
+// runHAProxyRuntimeCommand executes a command through the runtime API of a load balancer and returns the response.
+func runHAProxyRuntimeCommand(server *CloudServer, sshClient *ssh.Client, command string) (string, error) {
+	output, err := server.RunCommand(sshClient, fmt.Sprintf("echo %s | socat stdio UNIX-CONNECT:%s", shellQuote(command), pathHAProxyAdminSocket))
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to execute the runtime command '%s' - Output: %s - Error: %s", command, string(output), err.Error())
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+// runHAProxyRuntimeMutation executes a command, which modifies the running HAProxy process, through the runtime API of a load balancer.
+// Responses other than the known confirmations are treated as errors.
+func runHAProxyRuntimeMutation(server *CloudServer, sshClient *ssh.Client, command string) error {
+	response, err := runHAProxyRuntimeCommand(server, sshClient, command)
+
+	if err != nil {
+		return err
+	}
+
+	if !haProxyRuntimeResponses[response] {
+		return fmt.Errorf("Failed to execute the runtime command '%s' - Output: %s", command, response)
+	}
+
+	return nil
+}
+
+// setHAProxyRuntimeStateByServer records the state, which the running HAProxy process on a load balancer has loaded.
+func setHAProxyRuntimeStateByServer(server *CloudServer, sshClient *ssh.Client, sftpClient *sftp.Client, state string) error {
+	return server.UploadFile(sshClient, sftpClient, pathHAProxyRuntimeState, bytes.NewBufferString(state), fileModeConfig, 0, 0)
+}
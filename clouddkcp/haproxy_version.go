@@ -0,0 +1,149 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/MakeNowJust/heredoc"
+	"golang.org/x/crypto/ssh"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// annoLoadBalancerHAProxyVersion is the annotation specifying the HAProxy version of the form <major>.<minor> for Load Balancers deployed in host mode.
+	// Changing the value upgrades or downgrades HAProxy in place on the next update.
+	// Defaults to the value of CLOUDDK_HAPROXY_VERSION, which is only installed on new Load Balancers.
+	annoLoadBalancerHAProxyVersion = "kubernetes.cloud.dk/load-balancer-haproxy-version"
+
+	pathHAProxyUpgradeScript = "/usr/local/sbin/clouddk-upgrade-haproxy"
+)
+
+var (
+	// reInstalledHAProxyVersion matches the <major>.<minor> prefix of the version of an installed HAProxy package.
+	reInstalledHAProxyVersion = regexp.MustCompile(`^(?:[0-9]+:)?([0-9]+\.[0-9]+)`)
+
+	// haProxyUpgradeScript installs a different HAProxy version, once the current configuration file has been validated with the new binary.
+	// The package is extracted to a temporary directory for the validation, as installing it restarts HAProxy.
+	haProxyUpgradeScript = heredoc.Doc(`
+		#!/bin/bash
+		set -e
+
+		export DEBIAN_FRONTEND=noninteractive
+
+		REPOSITORY="$1"
+		VERSION="$2"
+		STAGING="$(mktemp -d)"
+
+		trap 'rm -rf "$STAGING"' EXIT
+
+		add-apt-repository -y "$REPOSITORY"
+		apt-get -qq update
+
+		cd "$STAGING"
+		apt-get -qq download "haproxy=${VERSION}.*"
+		dpkg-deb -x haproxy_*.deb root
+		root/usr/sbin/haproxy -c -q -f /etc/haproxy/haproxy.cfg
+
+		apt-get -qq install -y --allow-downgrades -o Dpkg::Options::=--force-confold "haproxy=${VERSION}.*"
+	`)
+)
+
+// getInstalledHAProxyVersion retrieves the <major>.<minor> version of the HAProxy package on a load balancer.
+// An empty string is returned, if the package has not been installed, e.g. because the load balancer has been deployed in container mode.
+func getInstalledHAProxyVersion(server *CloudServer, sshClient *ssh.Client) (string, error) {
+	output, err := server.RunCommand(sshClient, "dpkg-query -W -f '${Status} ${Version}' haproxy 2>/dev/null || true")
+
+	if err != nil {
+		return "", err
+	}
+
+	fields := strings.Fields(string(output))
+
+	if len(fields) < 4 || fields[2] != "installed" {
+		return "", nil
+	}
+
+	matches := reInstalledHAProxyVersion.FindStringSubmatch(fields[3])
+
+	if matches == nil {
+		return "", fmt.Errorf("Failed to parse the HAProxy version '%s'", fields[3])
+	}
+
+	return matches[1], nil
+}
+
+// getRequestedHAProxyVersion retrieves the HAProxy version, which a service requests for its load balancer.
+// An empty string is returned, if the service does not request a specific version.
+func getRequestedHAProxyVersion(service *v1.Service) (string, error) {
+	version := service.Annotations[annoLoadBalancerHAProxyVersion]
+
+	if version == "" {
+		return "", nil
+	}
+
+	err := validateHAProxyVersion(version)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", annoLoadBalancerHAProxyVersion, getLoadBalancerNameByService(service))
+
+		return "", newConfigurationError(err)
+	}
+
+	return version, nil
+}
+
+// upgradeLoadBalancerHAProxy replaces the HAProxy package on the server of a load balancer with the version requested by the service.
+func upgradeLoadBalancerHAProxy(c *CloudConfiguration, server *CloudServer, sshClient *ssh.Client, service *v1.Service, installedVersion string) error {
+	loadBalancerName := getLoadBalancerNameByService(service)
+	repository, version, err := getHAProxyPackageSource(c, server, service)
+
+	if err != nil {
+		return err
+	}
+
+	debugCloudAction(rtLoadBalancers, "Changing the HAProxy version from %s to %s (name: %s)", installedVersion, version, loadBalancerName)
+
+	timeUpgrade := time.Now()
+	err = upgradeHAProxy(server, sshClient, repository, version)
+
+	c.AuditLog.RecordMutation(server, mutationActionHAProxyUpgrade, map[string]string{
+		"from_version": installedVersion,
+		"service":      fmt.Sprintf("%s/%s", service.Namespace, service.Name),
+		"to_version":   version,
+	}, err, timeUpgrade)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to change the HAProxy version (name: %s) - Error: %s", loadBalancerName, err.Error())
+
+		return err
+	}
+
+	recordServiceEvent(c, service, v1.EventTypeNormal, eventReasonLoadBalancerHAProxyUpgraded, "Changed the HAProxy version from %s to %s on server '%s'", installedVersion, version, server.Information.Identifier)
+
+	return nil
+}
+
+// upgradeHAProxy replaces the HAProxy package on a load balancer with the specified version.
+// The current configuration file is validated with the new version, before the package is installed.
+func upgradeHAProxy(server *CloudServer, sshClient *ssh.Client, repository string, version string) error {
+	err := server.UploadFile(sshClient, nil, pathHAProxyUpgradeScript, bytes.NewBufferString(haProxyUpgradeScript), fileModeScript, 0, 0)
+
+	if err != nil {
+		return err
+	}
+
+	output, err := server.RunCommand(sshClient, fmt.Sprintf("/bin/bash %s %s %s", pathHAProxyUpgradeScript, shellQuote(repository), version))
+
+	if err != nil {
+		return fmt.Errorf("Failed to install HAProxy %s - Output: %s - Error: %s", version, string(output), err.Error())
+	}
+
+	return nil
+}
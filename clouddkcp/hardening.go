@@ -0,0 +1,155 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"github.com/MakeNowJust/heredoc"
+)
+
+const (
+	pathCISHardeningScript     = "/tmp/clouddk_cis_hardening.sh"
+	pathHAProxyAppArmorProfile = "/etc/apparmor.d/usr.sbin.haproxy"
+	pathHAProxySandboxConf     = "/etc/systemd/system/haproxy.service.d/sandbox.conf"
+)
+
+var (
+	// cisHardeningScript applies a subset of the CIS Ubuntu Linux benchmark, which does not interfere with the load balancer.
+	// IP forwarding is left untouched, as the container deployment relies on it.
+	cisHardeningScript = heredoc.Doc(`
+		#!/bin/bash
+		set -e
+
+		export DEBIAN_FRONTEND=noninteractive
+
+		# Apply the network and kernel security settings.
+		cat > /etc/sysctl.d/60-clouddk-cis.conf <<'EOF'
+		fs.suid_dumpable = 0
+		kernel.dmesg_restrict = 1
+		kernel.kptr_restrict = 2
+		kernel.randomize_va_space = 2
+		net.ipv4.conf.all.accept_redirects = 0
+		net.ipv4.conf.all.accept_source_route = 0
+		net.ipv4.conf.all.log_martians = 1
+		net.ipv4.conf.all.rp_filter = 1
+		net.ipv4.conf.all.secure_redirects = 0
+		net.ipv4.conf.all.send_redirects = 0
+		net.ipv4.conf.default.accept_redirects = 0
+		net.ipv4.conf.default.accept_source_route = 0
+		net.ipv4.conf.default.log_martians = 1
+		net.ipv4.conf.default.rp_filter = 1
+		net.ipv4.conf.default.secure_redirects = 0
+		net.ipv4.conf.default.send_redirects = 0
+		net.ipv4.icmp_echo_ignore_broadcasts = 1
+		net.ipv4.icmp_ignore_bogus_error_responses = 1
+		net.ipv4.tcp_syncookies = 1
+		net.ipv6.conf.all.accept_ra = 0
+		net.ipv6.conf.all.accept_redirects = 0
+		net.ipv6.conf.default.accept_ra = 0
+		net.ipv6.conf.default.accept_redirects = 0
+		EOF
+
+		sysctl -q -p /etc/sysctl.d/60-clouddk-cis.conf
+
+		# Disable core dumps as well as unused filesystems and network protocols.
+		echo "* hard core 0" > /etc/security/limits.d/60-clouddk-cis.conf
+
+		for module in cramfs freevxfs jffs2 hfs hfsplus udf dccp sctp rds tipc; do
+			echo "install ${module} /bin/true" >> /etc/modprobe.d/60-clouddk-cis.conf
+		done
+
+		# Enforce the password policy and a restrictive umask for new files.
+		apt-get -qq install -y libpam-pwquality
+
+		sed -i 's/^#\?\s*minlen.*/minlen = 14/' /etc/security/pwquality.conf
+		sed -i 's/^PASS_MAX_DAYS.*/PASS_MAX_DAYS	365/' /etc/login.defs
+		sed -i 's/^PASS_MIN_DAYS.*/PASS_MIN_DAYS	1/' /etc/login.defs
+		sed -i 's/^PASS_WARN_AGE.*/PASS_WARN_AGE	7/' /etc/login.defs
+		sed -i 's/^UMASK.*/UMASK		027/' /etc/login.defs
+
+		echo "umask 027" > /etc/profile.d/clouddk-umask.sh
+
+		# Audit changes to identities, privileges, the SSH configuration, the time and kernel modules.
+		apt-get -qq install -y auditd audispd-plugins
+
+		cat > /etc/audit/rules.d/60-clouddk-cis.rules <<'EOF'
+		-w /etc/group -p wa -k identity
+		-w /etc/passwd -p wa -k identity
+		-w /etc/gshadow -p wa -k identity
+		-w /etc/shadow -p wa -k identity
+		-w /etc/sudoers -p wa -k scope
+		-w /etc/sudoers.d/ -p wa -k scope
+		-w /etc/ssh/sshd_config -p wa -k sshd
+		-a always,exit -F arch=b64 -S adjtimex -S settimeofday -S clock_settime -k time-change
+		-a always,exit -F arch=b64 -S init_module -S delete_module -k modules
+		-w /sbin/insmod -p x -k modules
+		-w /sbin/rmmod -p x -k modules
+		-w /sbin/modprobe -p x -k modules
+		-e 2
+		EOF
+
+		systemctl enable --quiet auditd
+		augenrules --load || true
+
+		# Disable the services, which are not used by a load balancer.
+		for service in atd avahi-daemon cups iscsid lxcfs open-iscsi rpcbind snapd; do
+			systemctl disable --now "$service" >/dev/null 2>&1 || true
+		done
+	`)
+
+	// haProxyAppArmorProfile confines the HAProxy binary to the files and capabilities required by the generated configuration.
+	haProxyAppArmorProfile = heredoc.Doc(`
+		#include <tunables/global>
+
+		/usr/sbin/haproxy flags=(attach_disconnected) {
+			#include <abstractions/base>
+			#include <abstractions/nameservice>
+
+			capability chown,
+			capability kill,
+			capability net_bind_service,
+			capability setgid,
+			capability setuid,
+			capability sys_chroot,
+			capability sys_resource,
+
+			network inet stream,
+			network inet6 stream,
+			network inet dgram,
+			network inet6 dgram,
+			network unix stream,
+			network unix dgram,
+
+			/etc/haproxy/ r,
+			/etc/haproxy/** r,
+			/etc/ssl/** r,
+			/usr/sbin/haproxy rmix,
+			/run/haproxy.pid rw,
+			/run/haproxy/ rw,
+			/run/haproxy/** rwk,
+			/var/lib/haproxy/ r,
+			/var/lib/haproxy/** rw,
+		}
+	`)
+
+	// haProxySandboxConf restricts the HAProxy service in addition to the chroot configured in haproxy.cfg.
+	// It is only applied to the host deployment, as the container deployment is isolated by the container runtime.
+	haProxySandboxConf = heredoc.Doc(`
+		[Service]
+		NoNewPrivileges=true
+		ProtectSystem=strict
+		ProtectHome=true
+		ProtectKernelTunables=true
+		ProtectKernelModules=true
+		ProtectControlGroups=true
+		PrivateTmp=true
+		ReadWritePaths=/run /var/lib/haproxy
+		RestrictAddressFamilies=AF_UNIX AF_INET AF_INET6
+		RestrictNamespaces=true
+		RestrictRealtime=true
+		LockPersonality=true
+		SystemCallArchitectures=native
+		CapabilityBoundingSet=CAP_CHOWN CAP_KILL CAP_NET_BIND_SERVICE CAP_SETGID CAP_SETUID CAP_SYS_CHROOT CAP_SYS_RESOURCE
+	`)
+)
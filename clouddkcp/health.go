@@ -0,0 +1,134 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"k8s.io/apiserver/pkg/server/healthz"
+)
+
+const (
+	// healthCheckCacheDuration specifies how long the result of a Cloud.dk API health check is reused.
+	healthCheckCacheDuration = 30 * time.Second
+
+	// healthCheckTimeout specifies the maximum time to wait for a response from the Cloud.dk API.
+	healthCheckTimeout = 10 * time.Second
+)
+
+// apiHealthCheck verifies that the Cloud.dk API accepts the configured credentials.
+type apiHealthCheck struct {
+	config    *CloudConfiguration
+	lastCheck time.Time
+	lastError error
+	mutex     sync.Mutex
+}
+
+// Name returns the name of the health check.
+func (h *apiHealthCheck) Name() string {
+	return "clouddk-api"
+}
+
+// Check performs an authenticated API request, unless a recent result is available.
+func (h *apiHealthCheck) Check(_ *http.Request) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if time.Now().Sub(h.lastCheck) < healthCheckCacheDuration {
+		return h.lastError
+	}
+
+	result := make(chan error, 1)
+
+	go func() {
+		res, err := doUncachedClientRequest(h.config, "GET", "locations", new(bytes.Buffer), []int{200}, 1, 1)
+
+		if err == nil {
+			res.Body.Close()
+		}
+
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		h.lastError = err
+	case <-time.After(healthCheckTimeout):
+		h.lastError = fmt.Errorf("The API did not respond within %s", healthCheckTimeout.String())
+	}
+
+	h.lastCheck = time.Now()
+
+	if h.lastError != nil {
+		debugCloudAction(rtCloud, "Health check '%s' failed - Error: %s", h.Name(), h.lastError.Error())
+	}
+
+	return h.lastError
+}
+
+// checkSSHKeys verifies that the configured SSH keys can be parsed.
+func checkSSHKeys(c *CloudConfiguration) error {
+	privateKey, publicKey := c.getStaticSSHKeys()
+
+	if privateKey == "" && publicKey == "" && c.SSHCertificateAuthority != nil {
+		return nil
+	}
+
+	_, err := ssh.ParsePrivateKey([]byte(privateKey))
+
+	if err != nil {
+		return fmt.Errorf("The SSH private key is invalid - Error: %s", err.Error())
+	}
+
+	_, _, _, _, err = ssh.ParseAuthorizedKey([]byte(publicKey))
+
+	if err != nil {
+		return fmt.Errorf("The SSH public key is invalid - Error: %s", err.Error())
+	}
+
+	return nil
+}
+
+// startHealthServer serves the /healthz and /readyz endpoints until the stop channel closes.
+// Liveness only reflects the local configuration, while readiness also requires the Cloud.dk API to accept the credentials.
+func startHealthServer(c *CloudConfiguration, stop <-chan struct{}) {
+	if c.HealthBindAddress == "0" {
+		return
+	}
+
+	sshKeyCheck := healthz.NamedCheck("ssh-keys", func(_ *http.Request) error {
+		return checkSSHKeys(c)
+	})
+
+	mux := http.NewServeMux()
+
+	healthz.InstallHandler(mux, healthz.PingHealthz, sshKeyCheck)
+	healthz.InstallPathHandler(mux, "/readyz", healthz.PingHealthz, sshKeyCheck, &apiHealthCheck{config: c})
+
+	server := &http.Server{
+		Addr:    c.HealthBindAddress,
+		Handler: mux,
+	}
+
+	go func() {
+		debugCloudAction(rtCloud, "Serving health checks on '%s'", c.HealthBindAddress)
+
+		err := server.ListenAndServe()
+
+		if err != nil && err != http.ErrServerClosed {
+			debugCloudAction(rtCloud, "Failed to serve health checks on '%s' - Error: %s", c.HealthBindAddress, err.Error())
+		}
+	}()
+
+	go func() {
+		<-stop
+		server.Close()
+	}()
+}
@@ -0,0 +1,303 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// hostKeyPolicyInsecure specifies that host keys are not verified.
+	hostKeyPolicyInsecure = "insecure"
+
+	// hostKeyPolicyStrict specifies that connections to servers without recorded host keys are refused.
+	hostKeyPolicyStrict = "strict"
+
+	// hostKeyPolicyTOFU specifies that the host keys of servers without recorded host keys are recorded on first use.
+	hostKeyPolicyTOFU = "tofu"
+
+	// knownHostsConfigMapName specifies the name of the ConfigMap containing the recorded host keys.
+	knownHostsConfigMapName = "clouddk-ssh-known-hosts"
+
+	// knownHostsNamespace specifies the namespace of the ConfigMap containing the recorded host keys.
+	knownHostsNamespace = "kube-system"
+)
+
+// KnownHostsStore records the SSH host keys of managed servers by server id.
+// The keys are persisted in a ConfigMap, when a Kubernetes client is available outside minimal-permission mode, and cached in memory.
+type KnownHostsStore struct {
+	cache  map[string]string
+	config *CloudConfiguration
+	mutex  sync.Mutex
+}
+
+// newKnownHostsStore initializes a new KnownHostsStore object.
+func newKnownHostsStore(c *CloudConfiguration) *KnownHostsStore {
+	return &KnownHostsStore{
+		cache:  map[string]string{},
+		config: c,
+	}
+}
+
+// Get retrieves the recorded host keys for a server in authorized_keys format.
+func (k *KnownHostsStore) Get(serverID string) (string, bool, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if keys, ok := k.cache[serverID]; ok {
+		return keys, true, nil
+	}
+
+	if k.config.KubeClient == nil || k.config.MinimalPermissions {
+		return "", false, nil
+	}
+
+	configMap, err := k.config.KubeClient.CoreV1().ConfigMaps(knownHostsNamespace).Get(context.Background(), knownHostsConfigMapName, metav1.GetOptions{})
+
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", false, nil
+		}
+
+		return "", false, err
+	}
+
+	for id, keys := range configMap.Data {
+		k.cache[id] = keys
+	}
+
+	keys, ok := k.cache[serverID]
+
+	return keys, ok, nil
+}
+
+// Remove removes the recorded host keys for a server.
+func (k *KnownHostsStore) Remove(serverID string) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	delete(k.cache, serverID)
+
+	return k.update(func(data map[string]string) {
+		delete(data, serverID)
+	})
+}
+
+// Set records the host keys for a server in authorized_keys format.
+func (k *KnownHostsStore) Set(serverID string, keys string) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	k.cache[serverID] = keys
+
+	return k.update(func(data map[string]string) {
+		data[serverID] = keys
+	})
+}
+
+// update modifies the ConfigMap containing the recorded host keys.
+func (k *KnownHostsStore) update(modify func(data map[string]string)) error {
+	if k.config.KubeClient == nil || k.config.MinimalPermissions {
+		return nil
+	}
+
+	configMaps := k.config.KubeClient.CoreV1().ConfigMaps(knownHostsNamespace)
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMap, err := configMaps.Get(context.Background(), knownHostsConfigMapName, metav1.GetOptions{})
+
+		if err != nil {
+			if !apierrors.IsNotFound(err) {
+				return err
+			}
+
+			configMap = &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      knownHostsConfigMapName,
+					Namespace: knownHostsNamespace,
+				},
+				Data: map[string]string{},
+			}
+
+			modify(configMap.Data)
+
+			if len(configMap.Data) == 0 {
+				return nil
+			}
+
+			_, err = configMaps.Create(context.Background(), configMap, metav1.CreateOptions{})
+
+			return err
+		}
+
+		if configMap.Data == nil {
+			configMap.Data = map[string]string{}
+		}
+
+		modify(configMap.Data)
+
+		_, err = configMaps.Update(context.Background(), configMap, metav1.UpdateOptions{})
+
+		return err
+	})
+}
+
+// getHostKeyCallback retrieves the callback, which verifies the host key of a server against the recorded host keys.
+func (s *CloudServer) getHostKeyCallback() ssh.HostKeyCallback {
+	if s.CloudConfiguration.SSHHostKeyPolicy == hostKeyPolicyInsecure {
+		return ssh.InsecureIgnoreHostKey()
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		knownKeys, ok, err := s.CloudConfiguration.KnownHosts.Get(s.Information.Identifier)
+
+		if err != nil {
+			return fmt.Errorf("Failed to retrieve the recorded host keys for server '%s' - Error: %s", s.Information.Identifier, err.Error())
+		}
+
+		if !ok {
+			if s.CloudConfiguration.SSHHostKeyPolicy == hostKeyPolicyStrict {
+				return fmt.Errorf("No host keys have been recorded for server '%s'", s.Information.Identifier)
+			}
+
+			debugCloudAction(rtServers, "WARNING: Recording host key '%s' on first use (hostname: %s)", ssh.FingerprintSHA256(key), s.Information.Hostname)
+
+			return s.CloudConfiguration.KnownHosts.Set(s.Information.Identifier, string(ssh.MarshalAuthorizedKey(key)))
+		}
+
+		if !containsHostKey(knownKeys, key) {
+			return fmt.Errorf("The host key '%s' does not match the recorded host keys for server '%s'", ssh.FingerprintSHA256(key), s.Information.Identifier)
+		}
+
+		return nil
+	}
+}
+
+// pinHostKeys records the host keys of a server, which was not created by the controller.
+// The key presented during the initial connection must match the fingerprint, unless the host key policy allows the keys to be recorded on first use.
+func (s *CloudServer) pinHostKeys(user string, fingerprint string) error {
+	_, ok, err := s.CloudConfiguration.KnownHosts.Get(s.Information.Identifier)
+
+	if err != nil {
+		return err
+	}
+
+	if ok {
+		return nil
+	}
+
+	if fingerprint == "" {
+		if s.CloudConfiguration.SSHHostKeyPolicy == hostKeyPolicyStrict {
+			return fmt.Errorf("The host key fingerprint of server '%s' must be specified with the host key policy '%s'", s.Information.Identifier, hostKeyPolicyStrict)
+		}
+
+		return nil
+	}
+
+	privateKey, _ := s.CloudConfiguration.getStaticSSHKeys()
+	sshSigners, err := getSSHSigners(s.CloudConfiguration, user, privateKey)
+
+	if err != nil {
+		return err
+	}
+
+	var initialHostKey ssh.PublicKey
+
+	sshConfig := &ssh.ClientConfig{
+		User: user,
+		Auth: []ssh.AuthMethod{ssh.PublicKeys(sshSigners...)},
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if ssh.FingerprintSHA256(key) != fingerprint {
+				return fmt.Errorf("The host key '%s' does not match the fingerprint '%s'", ssh.FingerprintSHA256(key), fingerprint)
+			}
+
+			initialHostKey = key
+
+			return nil
+		},
+		Timeout: s.CloudConfiguration.SSHDialTimeout,
+	}
+
+	applySSHCryptoPolicy(s.CloudConfiguration, sshConfig)
+
+	sshAddress, err := s.GetSSHAddress()
+
+	if err != nil {
+		return err
+	}
+
+	sshClient, err := s.dialSSH(sshAddress, sshConfig)
+
+	if err != nil {
+		return err
+	}
+
+	defer sshClient.Close()
+
+	return s.recordHostKeys(sshClient, initialHostKey)
+}
+
+// recordHostKeys records all the host keys of a new server.
+// The key presented during the initial connection must be among them, which prevents a different host from injecting its keys.
+func (s *CloudServer) recordHostKeys(sshClient *ssh.Client, initialKey ssh.PublicKey) error {
+	sshSession, err := sshClient.NewSession()
+
+	if err != nil {
+		return err
+	}
+
+	defer sshSession.Close()
+
+	output, err := sshSession.Output("cat /etc/ssh/ssh_host_*_key.pub")
+
+	if err != nil {
+		return err
+	}
+
+	keys := new(bytes.Buffer)
+	rest := output
+
+	for len(bytes.TrimSpace(rest)) > 0 {
+		key, _, _, remaining, err := ssh.ParseAuthorizedKey(rest)
+
+		if err != nil {
+			return err
+		}
+
+		keys.Write(ssh.MarshalAuthorizedKey(key))
+		rest = remaining
+	}
+
+	if initialKey == nil || !containsHostKey(keys.String(), initialKey) {
+		return errors.New("The host key presented during the initial connection is not among the host keys of the server")
+	}
+
+	return s.CloudConfiguration.KnownHosts.Set(s.Information.Identifier, keys.String())
+}
+
+// containsHostKey determines whether a list of keys in authorized_keys format contains a key.
+func containsHostKey(knownKeys string, key ssh.PublicKey) bool {
+	marshaledKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+
+	for _, line := range strings.Split(knownKeys, "\n") {
+		if strings.TrimSpace(line) == marshaledKey {
+			return true
+		}
+	}
+
+	return false
+}
@@ -5,16 +5,12 @@
 package clouddkcp
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
-	"fmt"
 
 	v1 "k8s.io/api/core/v1"
 	cloudprovider "k8s.io/cloud-provider"
 
-	"github.com/danitso/terraform-provider-clouddk/clouddk"
 	"k8s.io/apimachinery/pkg/types"
 )
 
@@ -38,9 +34,11 @@ func (i Instances) NodeAddresses(ctx context.Context, name types.NodeName) ([]v1
 
 	server := CloudServer{
 		CloudConfiguration: i.config,
+		ReadOnly:           true,
 	}
 
-	_, err := server.InitializeByHostname(string(name))
+	notFound, err := server.InitializeByHostname(string(name))
+	setNodeInstanceCondition(i.config, string(name), "", notFound, err)
 
 	if err != nil {
 		return nodeAddresses, err
@@ -74,11 +72,17 @@ func (i Instances) NodeAddressesByProviderID(ctx context.Context, providerID str
 
 	server := CloudServer{
 		CloudConfiguration: i.config,
+		ReadOnly:           true,
 	}
 
-	_, err := server.InitializeByID(trimmedProviderID)
+	notFound, err := server.InitializeByID(trimmedProviderID)
+	setNodeInstanceCondition(i.config, "", providerID, notFound, err)
 
 	if err != nil {
+		if notFound {
+			return nodeAddresses, cloudprovider.InstanceNotFound
+		}
+
 		return nodeAddresses, err
 	}
 
@@ -105,9 +109,11 @@ func (i Instances) InstanceID(ctx context.Context, nodeName types.NodeName) (str
 
 	server := CloudServer{
 		CloudConfiguration: i.config,
+		ReadOnly:           true,
 	}
 
 	notFound, err := server.InitializeByHostname(string(nodeName))
+	setNodeInstanceCondition(i.config, string(nodeName), "", notFound, err)
 
 	if err != nil {
 		if notFound {
@@ -128,6 +134,7 @@ func (i Instances) InstanceType(ctx context.Context, name types.NodeName) (strin
 
 	server := CloudServer{
 		CloudConfiguration: i.config,
+		ReadOnly:           true,
 	}
 
 	_, err := server.InitializeByHostname(string(name))
@@ -143,9 +150,14 @@ func (i Instances) InstanceTypeByProviderID(ctx context.Context, providerID stri
 
 	server := CloudServer{
 		CloudConfiguration: i.config,
+		ReadOnly:           true,
 	}
 
-	_, err := server.InitializeByID(trimmedProviderID)
+	notFound, err := server.InitializeByID(trimmedProviderID)
+
+	if notFound {
+		return "", cloudprovider.InstanceNotFound
+	}
 
 	return server.Information.Package.Identifier, err
 }
@@ -175,18 +187,26 @@ func (i Instances) InstanceExistsByProviderID(ctx context.Context, providerID st
 
 	server := CloudServer{
 		CloudConfiguration: i.config,
+		ReadOnly:           true,
 	}
 
 	notFound, err := server.InitializeByID(trimmedProviderID)
-	exists := err == nil || notFound == false
+	setNodeInstanceCondition(i.config, "", providerID, notFound, err)
 
-	if exists {
-		debugCloudAction(rtInstances, "Node instance exists (id: %s)", trimmedProviderID)
-	} else {
+	if notFound {
 		debugCloudAction(rtInstances, "Node instance does not exist (id: %s)", trimmedProviderID)
+		recordNodeEvent(i.config, providerID, v1.EventTypeWarning, eventReasonInstanceVanished, "Server '%s' no longer exists", trimmedProviderID)
+
+		return false, nil
+	}
+
+	if err != nil {
+		return true, err
 	}
 
-	return exists, err
+	debugCloudAction(rtInstances, "Node instance exists (id: %s)", trimmedProviderID)
+
+	return true, nil
 }
 
 // InstanceShutdownByProviderID returns true if the instance is shutdown in cloudprovider.
@@ -197,34 +217,22 @@ func (i Instances) InstanceShutdownByProviderID(ctx context.Context, providerID
 
 	server := CloudServer{
 		CloudConfiguration: i.config,
+		ReadOnly:           true,
 	}
 
-	_, err := server.InitializeByID(trimmedProviderID)
+	notFound, err := server.InitializeByID(trimmedProviderID)
 
 	if err != nil {
 		debugCloudAction(rtInstances, "Node instance is not powered off (id: %s)", trimmedProviderID)
 
-		return false, err
-	}
-
-	res, err := clouddk.DoClientRequest(
-		i.config.ClientSettings,
-		"GET",
-		fmt.Sprintf("cloudservers/%s/logs", server.Information.Identifier),
-		new(bytes.Buffer),
-		[]int{200},
-		1,
-		1,
-	)
-
-	if err != nil {
-		debugCloudAction(rtInstances, "Node instance is not powered off (id: %s)", trimmedProviderID)
+		if notFound {
+			return false, cloudprovider.InstanceNotFound
+		}
 
 		return false, err
 	}
 
-	logsList := clouddk.LogsListBody{}
-	err = json.NewDecoder(res.Body).Decode(&logsList)
+	pendingActions, err := server.GetPendingActions()
 
 	if err != nil {
 		debugCloudAction(rtInstances, "Node instance is not powered off (id: %s)", trimmedProviderID)
@@ -232,10 +240,8 @@ func (i Instances) InstanceShutdownByProviderID(ctx context.Context, providerID
 		return false, err
 	}
 
-	for _, v := range logsList {
-		if v.Status == "pending" || v.Status == "running" {
-			return false, nil
-		}
+	if len(pendingActions) > 0 {
+		return false, nil
 	}
 
 	poweredOff := (server.Information.Booted == false)
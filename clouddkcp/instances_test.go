@@ -0,0 +1,273 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/danitso/clouddk-cloud-controller-manager/clouddkcp/mock"
+	"github.com/danitso/terraform-provider-clouddk/clouddk"
+	"golang.org/x/crypto/ssh"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	cloudprovider "k8s.io/cloud-provider"
+)
+
+// testAPIKey contains the API key, which the mock API requires.
+const testAPIKey = "test-api-key"
+
+// newTestCloudConfiguration creates a configuration, which sends every request to the mock API.
+// The response cache and the server list are disabled, which makes every lookup reach the mock API.
+func newTestCloudConfiguration(t *testing.T, api *mock.API) *CloudConfiguration {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalECPrivateKey(key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicKey, err := ssh.NewPublicKey(&key.PublicKey)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{
+		envAPICacheTTL:        "0",
+		envAPIEndpoint:        api.Endpoint(),
+		envAPIKey:             testAPIKey,
+		envAPIRateLimit:       "0",
+		envServerListInterval: "0",
+		envSSHPrivateKey:      base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})),
+		envSSHPublicKey:       base64.StdEncoding.EncodeToString(ssh.MarshalAuthorizedKey(publicKey)),
+	}
+
+	for k, v := range env {
+		os.Setenv(k, v)
+	}
+
+	config, err := newCloudConfiguration()
+
+	for k := range env {
+		os.Unsetenv(k)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return config
+}
+
+// newTestNodeServer adds a server, which backs a node, to the mock API.
+func newTestNodeServer(api *mock.API, hostname string, address string) clouddk.ServerBody {
+	return api.AddServer(clouddk.ServerBody{
+		Hostname: hostname,
+		Label:    hostname,
+		Booted:   true,
+		NetworkInterfaces: clouddk.NetworkInterfaceListBody{
+			{
+				Primary: true,
+				IPAddresses: clouddk.IPAddressListBody{
+					{Address: address},
+				},
+			},
+		},
+		Location: clouddk.LocationBody{Identifier: "dk1", Name: "Copenhagen"},
+		Package:  clouddk.PackageBody{Identifier: "e991abd8ef15c7", Name: "Medium"},
+	})
+}
+
+func TestInstancesByName(t *testing.T) {
+	api := mock.NewAPI(testAPIKey)
+	defer api.Close()
+
+	node := newTestNodeServer(api, "k8s-node-1", "192.0.2.10")
+	instances := newInstances(newTestCloudConfiguration(t, api))
+	ctx := context.Background()
+
+	addresses, err := instances.NodeAddresses(ctx, types.NodeName("k8s-node-1"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedAddresses := []v1.NodeAddress{
+		{Type: "ExternalIP", Address: "192.0.2.10"},
+		{Type: "InternalIP", Address: "192.0.2.10"},
+	}
+
+	if !reflect.DeepEqual(addresses, expectedAddresses) {
+		t.Errorf("NodeAddresses returned %v, expected %v", addresses, expectedAddresses)
+	}
+
+	id, err := instances.InstanceID(ctx, types.NodeName("k8s-node-1"))
+
+	if err != nil {
+		t.Fatal(err)
+	} else if id != node.Identifier {
+		t.Errorf("InstanceID returned %q, expected %q", id, node.Identifier)
+	}
+
+	instanceType, err := instances.InstanceType(ctx, types.NodeName("k8s-node-1"))
+
+	if err != nil {
+		t.Fatal(err)
+	} else if instanceType != "e991abd8ef15c7" {
+		t.Errorf("InstanceType returned %q, expected %q", instanceType, "e991abd8ef15c7")
+	}
+
+	_, err = instances.InstanceID(ctx, types.NodeName("k8s-node-2"))
+
+	if err != cloudprovider.InstanceNotFound {
+		t.Errorf("InstanceID returned %v for a missing node, expected %v", err, cloudprovider.InstanceNotFound)
+	}
+
+	for _, r := range api.Requests() {
+		if r.Method != "GET" || r.Path != "cloudservers" {
+			t.Errorf("Unexpected request %s /%s", r.Method, r.Path)
+		}
+	}
+}
+
+func TestInstancesByProviderID(t *testing.T) {
+	api := mock.NewAPI(testAPIKey)
+	defer api.Close()
+
+	node := newTestNodeServer(api, "k8s-node-1", "192.0.2.10")
+	providerID := "clouddk://" + node.Identifier
+	instances := newInstances(newTestCloudConfiguration(t, api))
+	ctx := context.Background()
+
+	addresses, err := instances.NodeAddressesByProviderID(ctx, providerID)
+
+	if err != nil {
+		t.Fatal(err)
+	} else if len(addresses) != 2 || addresses[0].Address != "192.0.2.10" {
+		t.Errorf("NodeAddressesByProviderID returned %v, expected the address 192.0.2.10", addresses)
+	}
+
+	instanceType, err := instances.InstanceTypeByProviderID(ctx, providerID)
+
+	if err != nil {
+		t.Fatal(err)
+	} else if instanceType != "e991abd8ef15c7" {
+		t.Errorf("InstanceTypeByProviderID returned %q, expected %q", instanceType, "e991abd8ef15c7")
+	}
+
+	exists, err := instances.InstanceExistsByProviderID(ctx, providerID)
+
+	if err != nil {
+		t.Fatal(err)
+	} else if !exists {
+		t.Error("InstanceExistsByProviderID returned false for an existing server")
+	}
+
+	shutdown, err := instances.InstanceShutdownByProviderID(ctx, providerID)
+
+	if err != nil {
+		t.Fatal(err)
+	} else if shutdown {
+		t.Error("InstanceShutdownByProviderID returned true for a booted server")
+	}
+
+	expectedPaths := []string{
+		"cloudservers/" + node.Identifier,
+		"cloudservers/" + node.Identifier,
+		"cloudservers/" + node.Identifier,
+		"cloudservers/" + node.Identifier,
+		"cloudservers/" + node.Identifier + "/logs",
+	}
+
+	paths := []string{}
+
+	for _, r := range api.Requests() {
+		paths = append(paths, r.Path)
+	}
+
+	if !reflect.DeepEqual(paths, expectedPaths) {
+		t.Errorf("The mock API received the requests %v, expected %v", paths, expectedPaths)
+	}
+}
+
+func TestInstancesPoweredOff(t *testing.T) {
+	api := mock.NewAPI(testAPIKey)
+	defer api.Close()
+
+	node := newTestNodeServer(api, "k8s-node-1", "192.0.2.10")
+	node.Booted = false
+	api.AddServer(node)
+
+	instances := newInstances(newTestCloudConfiguration(t, api))
+	shutdown, err := instances.InstanceShutdownByProviderID(context.Background(), "clouddk://"+node.Identifier)
+
+	if err != nil {
+		t.Fatal(err)
+	} else if !shutdown {
+		t.Error("InstanceShutdownByProviderID returned false for a server, which is powered off")
+	}
+}
+
+func TestInstancesVanished(t *testing.T) {
+	api := mock.NewAPI(testAPIKey)
+	defer api.Close()
+
+	instances := newInstances(newTestCloudConfiguration(t, api))
+	ctx := context.Background()
+
+	exists, err := instances.InstanceExistsByProviderID(ctx, "clouddk://000000000abc")
+
+	if err != nil {
+		t.Fatal(err)
+	} else if exists {
+		t.Error("InstanceExistsByProviderID returned true for a missing server")
+	}
+
+	_, err = instances.InstanceTypeByProviderID(ctx, "clouddk://000000000abc")
+
+	if err != cloudprovider.InstanceNotFound {
+		t.Errorf("InstanceTypeByProviderID returned %v for a missing server, expected %v", err, cloudprovider.InstanceNotFound)
+	}
+
+	_, err = instances.InstanceShutdownByProviderID(ctx, "clouddk://000000000abc")
+
+	if err != cloudprovider.InstanceNotFound {
+		t.Errorf("InstanceShutdownByProviderID returned %v for a missing server, expected %v", err, cloudprovider.InstanceNotFound)
+	}
+}
+
+func TestInstancesAPIFailure(t *testing.T) {
+	api := mock.NewAPI(testAPIKey)
+	defer api.Close()
+
+	node := newTestNodeServer(api, "k8s-node-1", "192.0.2.10")
+	api.Fail("GET", "cloudservers/"+node.Identifier, http.StatusInternalServerError, 1)
+
+	instances := newInstances(newTestCloudConfiguration(t, api))
+	exists, err := instances.InstanceExistsByProviderID(context.Background(), "clouddk://"+node.Identifier)
+
+	if err == nil {
+		t.Fatal("InstanceExistsByProviderID did not return the error of the API")
+	} else if !exists {
+		t.Error("InstanceExistsByProviderID returned false for a server, which could not be retrieved")
+	}
+}
@@ -0,0 +1,484 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/danitso/terraform-provider-clouddk/clouddk"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// managedLoadBalancer describes a load balancer server together with the service, which it belongs to.
+type managedLoadBalancer struct {
+	Namespace   string
+	Server      clouddk.ServerBody
+	ServiceName string
+	Status      map[string]string
+}
+
+// NewLoadBalancerCommand creates the 'lb' command, which inspects the load balancers managed by the cloud controller manager.
+func NewLoadBalancerCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "lb",
+		Short: "Inspect, adopt, export and migrate load balancers managed by the cloud controller manager",
+	}
+
+	command.AddCommand(newLoadBalancerAdoptCommand())
+	command.AddCommand(newLoadBalancerDescribeCommand())
+	command.AddCommand(newLoadBalancerExportCommand())
+	command.AddCommand(newLoadBalancerListCommand())
+	command.AddCommand(newLoadBalancerMigrateCommand())
+
+	setCommandHelp(command)
+
+	return command
+}
+
+// newLoadBalancerAdoptCommand creates the 'lb adopt' command.
+func newLoadBalancerAdoptCommand() *cobra.Command {
+	var clusterName, hostKeyFingerprint, id, serviceKey, sshUser string
+
+	command := &cobra.Command{
+		Use:   "adopt",
+		Short: "Adopt an existing server as the load balancer of a service",
+		Long:  "Take over a server, which was not created by the controller, as the load balancer of a service without recreating it.\nThe server is renamed, HAProxy is installed and configured, and the service is annotated with the server id.\nThe configuration is read from the same environment variables as the controller.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if id == "" || serviceKey == "" {
+				return errors.New("The flags --id and --service must be specified")
+			}
+
+			c, err := newCommandConfiguration()
+
+			if err != nil {
+				return err
+			}
+
+			if c.KubeClient == nil {
+				return errors.New("The command must run inside the cluster")
+			}
+
+			namespace, name, err := parseNamespacedName(serviceKey)
+
+			if err != nil {
+				return err
+			}
+
+			service, err := c.KubeClient.CoreV1().Services(namespace).Get(context.Background(), name, metav1.GetOptions{})
+
+			if err != nil {
+				return err
+			}
+
+			server := &CloudServer{
+				CloudConfiguration: c,
+			}
+
+			_, err = server.InitializeByID(id)
+
+			if err != nil {
+				return err
+			}
+
+			err = adoptLoadBalancer(context.Background(), c, server, service, getLoadBalancerHostname(clusterName, getLoadBalancerNameByService(service)), sshUser, hostKeyFingerprint)
+
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Server '%s' is now the load balancer of service '%s' (hostname: %s)\n", id, serviceKey, server.Information.Hostname)
+
+			return nil
+		},
+	}
+
+	command.Flags().StringVar(&clusterName, "cluster-name", "kubernetes", "The name of the cluster as presented to the controller manager")
+	command.Flags().StringVar(&hostKeyFingerprint, "host-key-fingerprint", "", "The SHA256 fingerprint of the SSH host key of the server, which is required with strict host key checking")
+	command.Flags().StringVar(&id, "id", "", "The id of the server")
+	command.Flags().StringVar(&serviceKey, "service", "", "The service in the form <namespace>/<name>")
+	command.Flags().StringVar(&sshUser, "ssh-user", "root", "The user, which has authorized the SSH key of the controller on the server")
+
+	return command
+}
+
+// newLoadBalancerDescribeCommand creates the 'lb describe' command.
+func newLoadBalancerDescribeCommand() *cobra.Command {
+	var clusterName, hostname, id, service string
+	var showConfig bool
+
+	command := &cobra.Command{
+		Use:   "describe",
+		Short: "Describe a load balancer",
+		Long:  "Print the server, service and status of a load balancer together with its live HAProxy configuration.\nThe configuration is read from the same environment variables as the controller.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags := 0
+
+			for _, v := range []string{hostname, id, service} {
+				if v != "" {
+					flags++
+				}
+			}
+
+			if flags != 1 {
+				return errors.New("Exactly one of the flags --hostname, --id and --service must be specified")
+			}
+
+			c, err := newCommandConfiguration()
+
+			if err != nil {
+				return err
+			}
+
+			if service != "" {
+				hostname, err = getServiceLoadBalancerHostname(c, service, clusterName)
+
+				if err != nil {
+					return err
+				}
+			}
+
+			loadBalancers, err := getManagedLoadBalancers(c)
+
+			if err != nil {
+				return err
+			}
+
+			for _, lb := range loadBalancers {
+				if lb.Server.Identifier == id || lb.Server.Hostname == hostname {
+					return describeLoadBalancer(cmd.OutOrStdout(), c, lb, showConfig)
+				}
+			}
+
+			return errors.New("The load balancer does not exist")
+		},
+	}
+
+	command.Flags().StringVar(&clusterName, "cluster-name", "kubernetes", "The name of the cluster as presented to the controller manager, which is used with --service")
+	command.Flags().StringVar(&hostname, "hostname", "", "The hostname of the server")
+	command.Flags().StringVar(&id, "id", "", "The id of the server")
+	command.Flags().StringVar(&service, "service", "", "The service in the form <namespace>/<name>")
+	command.Flags().BoolVar(&showConfig, "show-config", true, "Whether to retrieve the live HAProxy configuration over SSH")
+
+	return command
+}
+
+// newLoadBalancerExportCommand creates the 'lb export' command.
+func newLoadBalancerExportCommand() *cobra.Command {
+	var format string
+
+	command := &cobra.Command{
+		Use:   "export",
+		Short: "Export the load balancers for Terraform",
+		Long:  "Print the servers managed as load balancers as import blocks and resources for the Terraform provider for Cloud.dk, or as 'terraform import' commands.\nThe resources ignore all changes, as the servers remain managed by the controller.\nThe configuration is read from the same environment variables as the controller.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != terraformExportFormatCommands && format != terraformExportFormatHCL {
+				return fmt.Errorf("Unsupported format '%s'", format)
+			}
+
+			c, err := newCommandConfiguration()
+
+			if err != nil {
+				return err
+			}
+
+			loadBalancers, err := getManagedLoadBalancers(c)
+
+			if err != nil {
+				return err
+			}
+
+			return writeTerraformExport(cmd.OutOrStdout(), loadBalancers, format)
+		},
+	}
+
+	command.Flags().StringVar(&format, "format", terraformExportFormatHCL, "The output format (commands or hcl)")
+
+	return command
+}
+
+// newLoadBalancerListCommand creates the 'lb list' command.
+func newLoadBalancerListCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "list",
+		Short: "List the load balancers",
+		Long:  "List the servers managed as load balancers together with their services, IP addresses, packages and health.\nThe configuration is read from the same environment variables as the controller.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newCommandConfiguration()
+
+			if err != nil {
+				return err
+			}
+
+			loadBalancers, err := getManagedLoadBalancers(c)
+
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 8, 2, ' ', 0)
+
+			fmt.Fprintln(w, "HOSTNAME\tID\tSERVICE\tIP ADDRESSES\tPACKAGE\tBOOTED\tSTATE")
+
+			for _, lb := range loadBalancers {
+				fmt.Fprintf(
+					w,
+					"%s\t%s\t%s\t%s\t%s\t%t\t%s\n",
+					lb.Server.Hostname,
+					lb.Server.Identifier,
+					lb.getServiceKey(),
+					getServerIPAddresses(&CloudServer{Information: lb.Server}),
+					lb.Server.Package.Identifier,
+					bool(lb.Server.Booted),
+					lb.getState(),
+				)
+			}
+
+			return w.Flush()
+		},
+	}
+
+	return command
+}
+
+// newLoadBalancerMigrateCommand creates the 'lb migrate' command.
+func newLoadBalancerMigrateCommand() *cobra.Command {
+	var serviceKey string
+
+	migration := LoadBalancerMigration{}
+
+	command := &cobra.Command{
+		Use:   "migrate",
+		Short: "Migrate a load balancer to a new server",
+		Long:  "Replace the server of a load balancer with a new server, which uses the specified OS template and the package of the service.\nThe replacement is provisioned, configured and verified, before the service is switched to it and the old server is destroyed.\nThe configuration is read from the same environment variables as the controller.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if serviceKey == "" {
+				return errors.New("The flag --service must be specified")
+			}
+
+			c, err := newCommandConfiguration()
+
+			if err != nil {
+				return err
+			}
+
+			if c.KubeClient == nil {
+				return errors.New("The command must run inside the cluster")
+			}
+
+			namespace, name, err := parseNamespacedName(serviceKey)
+
+			if err != nil {
+				return err
+			}
+
+			service, err := c.KubeClient.CoreV1().Services(namespace).Get(context.Background(), name, metav1.GetOptions{})
+
+			if err != nil {
+				return err
+			}
+
+			if service.Spec.Type != v1.ServiceTypeLoadBalancer {
+				return fmt.Errorf("The service '%s' is not of type '%s'", serviceKey, v1.ServiceTypeLoadBalancer)
+			}
+
+			return migrateLoadBalancer(context.Background(), c, service, migration, cmd.OutOrStdout())
+		},
+	}
+
+	command.Flags().StringVar(&migration.ClusterName, "cluster-name", "kubernetes", "The name of the cluster as presented to the controller manager")
+	command.Flags().DurationVar(&migration.DrainPeriod, "drain-period", 5*time.Minute, "How long to keep the old server running after the service has been switched to the replacement")
+	command.Flags().BoolVar(&migration.KeepOld, "keep-old", false, "Whether to keep the old server instead of destroying it")
+	command.Flags().StringVar(&serviceKey, "service", "", "The service in the form <namespace>/<name>")
+	command.Flags().StringVar(&migration.TemplateID, "template", "", "The OS template of the replacement server, which defaults to the OS template of new load balancers")
+
+	return command
+}
+
+// getServiceKey retrieves the namespace/name key for the service of a load balancer, or '-' if it is unknown.
+func (lb managedLoadBalancer) getServiceKey() string {
+	if lb.ServiceName == "" {
+		return "-"
+	}
+
+	return lb.Namespace + "/" + lb.ServiceName
+}
+
+// getState retrieves the health of a load balancer based on its status.
+func (lb managedLoadBalancer) getState() string {
+	if lb.Status == nil {
+		return "unknown"
+	}
+
+	if lb.Status[statusKeyLastError] != "" {
+		return "failing"
+	}
+
+	if state := lb.Status[statusKeyProvisioningState]; state != "" {
+		return state
+	}
+
+	return "unknown"
+}
+
+// describeLoadBalancer prints the details of a load balancer.
+func describeLoadBalancer(w io.Writer, c *CloudConfiguration, lb managedLoadBalancer, showConfig bool) error {
+	fmt.Fprintf(w, "Hostname:      %s\n", lb.Server.Hostname)
+	fmt.Fprintf(w, "ID:            %s\n", lb.Server.Identifier)
+	fmt.Fprintf(w, "Service:       %s\n", lb.getServiceKey())
+	fmt.Fprintf(w, "IP Addresses:  %s\n", getServerIPAddresses(&CloudServer{Information: lb.Server}))
+	fmt.Fprintf(w, "Location:      %s\n", lb.Server.Location.Identifier)
+	fmt.Fprintf(w, "Package:       %s\n", lb.Server.Package.Identifier)
+	fmt.Fprintf(w, "Template:      %s\n", lb.Server.Template.Identifier)
+	fmt.Fprintf(w, "Booted:        %t\n", bool(lb.Server.Booted))
+	fmt.Fprintf(w, "State:         %s\n", lb.getState())
+
+	if len(lb.Status) > 0 {
+		keys := make([]string, 0, len(lb.Status))
+
+		for k := range lb.Status {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+
+		fmt.Fprintf(w, "Status:\n")
+
+		for _, k := range keys {
+			fmt.Fprintf(w, "  %s: %s\n", k, lb.Status[k])
+		}
+	}
+
+	if !showConfig {
+		return nil
+	}
+
+	server := CloudServer{
+		CloudConfiguration: c,
+		Information:        lb.Server,
+	}
+
+	if lb.ServiceName != "" {
+		var err error
+
+		server.SSHKeyPair, err = getServiceSSHKeyPair(c, lb.Namespace, lb.ServiceName)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	sshClient, err := server.SSH()
+
+	if err != nil {
+		return err
+	}
+
+	defer sshClient.Close()
+
+	output, err := server.RunCommand(sshClient, fmt.Sprintf("cat %s", pathHAProxyConf))
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "HAProxy Configuration:\n%s", output)
+
+	return nil
+}
+
+// getManagedLoadBalancers retrieves the servers, which are managed as load balancers, sorted by hostname.
+// The services are resolved from the status ConfigMaps, which are only available when running inside the cluster.
+func getManagedLoadBalancers(c *CloudConfiguration) ([]managedLoadBalancer, error) {
+	res, err := doClientRequest(c, "GET", "cloudservers", new(bytes.Buffer), []int{200}, 1, 1)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	servers := make(clouddk.ServerListBody, 0)
+	err = json.NewDecoder(res.Body).Decode(&servers)
+
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := map[string]v1.ConfigMap{}
+
+	if c.KubeClient != nil {
+		configMaps, err := c.KubeClient.CoreV1().ConfigMaps("").List(context.Background(), metav1.ListOptions{
+			LabelSelector: labelLoadBalancerStatus + "=true",
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, v := range configMaps.Items {
+			if id := v.Data[statusKeyServerID]; id != "" {
+				statuses[id] = v
+			}
+		}
+	}
+
+	loadBalancers := []managedLoadBalancer{}
+
+	for _, server := range servers {
+		if !reLoadBalancerHostname.MatchString(server.Hostname) || server.Label != server.Hostname {
+			continue
+		}
+
+		lb := managedLoadBalancer{
+			Server: server,
+		}
+
+		if configMap, ok := statuses[server.Identifier]; ok {
+			lb.Namespace = configMap.Namespace
+			lb.ServiceName = strings.TrimPrefix(configMap.Name, fmt.Sprintf(fmtLoadBalancerStatusName, ""))
+			lb.Status = configMap.Data
+		}
+
+		loadBalancers = append(loadBalancers, lb)
+	}
+
+	sort.Slice(loadBalancers, func(i, j int) bool {
+		return loadBalancers[i].Server.Hostname < loadBalancers[j].Server.Hostname
+	})
+
+	return loadBalancers, nil
+}
+
+// getServiceLoadBalancerHostname retrieves the hostname of the load balancer for a service in the form <namespace>/<name>.
+func getServiceLoadBalancerHostname(c *CloudConfiguration, serviceKey string, clusterName string) (string, error) {
+	if c.KubeClient == nil {
+		return "", errors.New("The flag --service requires the command to run inside the cluster")
+	}
+
+	namespace, name, err := parseNamespacedName(serviceKey)
+
+	if err != nil {
+		return "", err
+	}
+
+	service, err := c.KubeClient.CoreV1().Services(namespace).Get(context.Background(), name, metav1.GetOptions{})
+
+	if err != nil {
+		return "", err
+	}
+
+	return getLoadBalancerHostnameByService(clusterName, service)
+}
@@ -0,0 +1,24 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// loadBalancerClassName specifies the value of spec.loadBalancerClass, which selects the cloud controller manager.
+	loadBalancerClassName = "clouddk"
+)
+
+// isLoadBalancerClassManaged determines whether the load balancer of a service is managed by the cloud controller manager.
+// Services with a different class belong to another implementation and must not be touched.
+func isLoadBalancerClassManaged(c *CloudConfiguration, service *v1.Service) bool {
+	if service.Spec.LoadBalancerClass == nil || *service.Spec.LoadBalancerClass == "" {
+		return !c.RequireExplicitClass
+	}
+
+	return *service.Spec.LoadBalancerClass == loadBalancerClassName
+}
@@ -0,0 +1,88 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"fmt"
+	"net"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// annoLoadBalancerIP is the annotation specifying the IP address, which the load balancer of a service must keep.
+	// The annotation takes precedence over spec.loadBalancerIP.
+	// Defaults to the value of spec.loadBalancerIP.
+	annoLoadBalancerIP = "kubernetes.cloud.dk/load-balancer-ip"
+)
+
+// getRequestedLoadBalancerIP retrieves the IP address, which the load balancer of a service must keep.
+// An empty string is returned, if the service does not request a specific address.
+func getRequestedLoadBalancerIP(service *v1.Service) (string, error) {
+	value := service.Annotations[annoLoadBalancerIP]
+
+	if value == "" {
+		value = service.Spec.LoadBalancerIP
+	}
+
+	if value == "" {
+		return "", nil
+	}
+
+	err := validateLoadBalancerIP(value)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", annoLoadBalancerIP, getLoadBalancerNameByService(service))
+
+		return "", newConfigurationError(err)
+	}
+
+	return net.ParseIP(value).String(), nil
+}
+
+// checkNewLoadBalancerIP rejects the creation of a server for a service, which requests a specific IP address, as a new server cannot receive an existing address.
+func checkNewLoadBalancerIP(service *v1.Service) error {
+	requestedIP, err := getRequestedLoadBalancerIP(service)
+
+	if err != nil || requestedIP == "" {
+		return err
+	}
+
+	return newConfigurationError(fmt.Errorf("Cannot create a server with the IP address %s, as Cloud.dk cannot assign existing IP addresses to new servers - Adopt the server, which owns the address, with 'lb adopt' instead", requestedIP))
+}
+
+// hasRequestedLoadBalancerIP determines whether a service requests a specific IP address for its load balancer.
+func hasRequestedLoadBalancerIP(service *v1.Service) bool {
+	return service.Annotations[annoLoadBalancerIP] != "" || service.Spec.LoadBalancerIP != ""
+}
+
+// validateLoadBalancerIP validates the IP address, which is requested for a load balancer.
+func validateLoadBalancerIP(value string) error {
+	if net.ParseIP(value) == nil {
+		return fmt.Errorf("The value '%s' is not a valid IP address", value)
+	}
+
+	return nil
+}
+
+// verifyLoadBalancerIP verifies that the server of a load balancer owns the IP address requested by the service.
+// Cloud.dk cannot assign an existing IP address to a different server, which is why the address can only be kept by keeping the server.
+func verifyLoadBalancerIP(server *CloudServer, service *v1.Service) error {
+	requestedIP, err := getRequestedLoadBalancerIP(service)
+
+	if err != nil || requestedIP == "" {
+		return err
+	}
+
+	for _, nic := range server.Information.NetworkInterfaces {
+		for _, ip := range nic.IPAddresses {
+			if parsedIP := net.ParseIP(ip.Address); parsedIP != nil && parsedIP.String() == requestedIP {
+				return nil
+			}
+		}
+	}
+
+	return newConfigurationError(fmt.Errorf("The IP address %s is not assigned to server '%s' and cannot be moved to it (addresses: %s)", requestedIP, server.Information.Identifier, getServerIPAddresses(server)))
+}
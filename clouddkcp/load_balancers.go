@@ -13,12 +13,12 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
 	cloudprovider "k8s.io/cloud-provider"
 
 	"github.com/MakeNowJust/heredoc"
-	"github.com/pkg/sftp"
 )
 
 const (
@@ -46,6 +46,20 @@ const (
 	// Defaults to 3.
 	annoLoadBalancerHealthCheckInterval = "kubernetes.cloud.dk/load-balancer-health-check-interval"
 
+	// annoLoadBalancerHealthCheckPath is the annotation specifying the path, which is requested by HTTP health checks.
+	// Defaults to /healthz.
+	annoLoadBalancerHealthCheckPath = "kubernetes.cloud.dk/load-balancer-health-check-path"
+
+	// annoLoadBalancerHealthCheckProtocol is the annotation specifying the protocol used for health checks.
+	// Options are http and tcp.
+	// Defaults to tcp.
+	annoLoadBalancerHealthCheckProtocol = "kubernetes.cloud.dk/load-balancer-health-check-protocol"
+
+	// annoLoadBalancerHealthCheckStatus is the annotation specifying the status code, which HTTP health checks expect.
+	// The value must be between 100 and 599.
+	// Defaults to 200.
+	annoLoadBalancerHealthCheckStatus = "kubernetes.cloud.dk/load-balancer-health-check-status"
+
 	// annoLoadBalancerHealthCheckThresholdHealthy is the annotation used to specify the number of times a health check must pass for a backend to be marked "healthy" for the given service and be re-added to the pool.
 	// The value must be between 2 and 10.
 	// Defaults to 5.
@@ -62,27 +76,116 @@ const (
 	annoLoadBalancerHealthCheckTimeout = "kubernetes.cloud.dk/load-balancer-health-check-timeout"
 
 	// annoLoadBalancerID is the annotation specifying the load balancer ID used to enable fast retrievals of load balancers from the API.
+	// The annotation is set by the controller, once the load balancer has been configured.
 	annoLoadBalancerID = "kubernetes.cloud.dk/load-balancer-id"
 
+	// annoLoadBalancerPackageID is the annotation specifying the Cloud.dk package of the Load Balancer.
+	// The annotation takes precedence over the package derived from annoLoadBalancerConnectionLimit.
+	// Defaults to the package derived from annoLoadBalancerConnectionLimit.
+	annoLoadBalancerPackageID = "kubernetes.cloud.dk/load-balancer-package-id"
+
+	// annoLoadBalancerProtocol is the annotation specifying the protocol, which the Load Balancer forwards.
+	// Options are http and tcp.
+	// Defaults to tcp.
+	annoLoadBalancerProtocol = "kubernetes.cloud.dk/load-balancer-protocol"
+
+	// annoLoadBalancerProxyProtocolPorts is the annotation specifying the ports, whose backend servers receive the PROXY protocol header.
+	// The value is a comma separated list of ports, which enables the PROXY protocol for these ports only.
+	// Defaults to every port, if annoLoadBalancerEnableProxyProtocol is true.
+	annoLoadBalancerProxyProtocolPorts = "kubernetes.cloud.dk/load-balancer-proxy-protocol-ports"
+
+	// annoLoadBalancerRateLimitConcurrentConnections is the annotation specifying the maximum number of concurrent connections per client IP address.
+	// The value must be between 1 and 100000.
+	// Defaults to no limit.
+	annoLoadBalancerRateLimitConcurrentConnections = "kubernetes.cloud.dk/load-balancer-rate-limit-concurrent-connections"
+
+	// annoLoadBalancerRateLimitConnectionsPerSecond is the annotation specifying the maximum number of new connections per second per client IP address.
+	// The value must be between 1 and 100000.
+	// Defaults to no limit.
+	annoLoadBalancerRateLimitConnectionsPerSecond = "kubernetes.cloud.dk/load-balancer-rate-limit-connections-per-second"
+
 	// annoLoadBalancerServerTimeout is the annotation used to specify the number of seconds the Load Balancer will allow a server to idle for.
 	// The value must be between 1 and 86400.
 	// Defaults to 60.
 	annoLoadBalancerServerTimeout = "kubernetes.cloud.dk/load-balancer-server-timeout"
 
+	// annoLoadBalancerStickySessions is the annotation specifying whether clients should be routed to the same backend server.
+	// Defaults to false.
+	annoLoadBalancerStickySessions = "kubernetes.cloud.dk/load-balancer-sticky-sessions"
+
+	// annoLoadBalancerTunnelTimeout is the annotation used to specify the number of seconds the Load Balancer will allow a tunnel, e.g. a WebSocket connection, to idle for.
+	// The value must be between 1 and 604800.
+	// Defaults to the client and server timeouts.
+	annoLoadBalancerTunnelTimeout = "kubernetes.cloud.dk/load-balancer-tunnel-timeout"
+
+	// loadBalancerProtocolHTTP specifies the protocol, which terminates HTTP and adds the X-Forwarded-For and X-Forwarded-Proto headers to requests.
+	loadBalancerProtocolHTTP = "http"
+
+	// loadBalancerProtocolTCP specifies the protocol, which forwards TCP connections without inspecting them.
+	loadBalancerProtocolTCP = "tcp"
+
 	// fmtLoadBalancerHostname specifies the format for load balancer hostnames.
 	fmtLoadBalancerHostname = "k8s-load-balancer-%s"
 
+	// hostnameSuffixReplacement specifies the suffix for the hostname of a load balancer, which is being provisioned as a replacement during a migration.
+	hostnameSuffixReplacement = "-replacement"
+
+	// hostnameSuffixRetired specifies the suffix for the hostname of a load balancer, which has been replaced during a migration.
+	hostnameSuffixRetired = "-retired"
+
+	pathHAProxyConf                 = "/etc/haproxy/haproxy.cfg"
+	pathHAProxyLogrotateConf        = "/etc/logrotate.d/haproxy"
 	pathHAProxyOverrideConf         = "/etc/systemd/system/haproxy.service.d/override.conf"
+	pathHAProxyRsyslogConf          = "/etc/rsyslog.d/49-haproxy.conf"
+	pathJournaldConf                = "/etc/systemd/journald.conf.d/clouddk.conf"
 	pathLoadBalancerProvisionScript = "/tmp/clouddk_load_balancer_provisioner.sh"
-	pathSecurityLimitsConf          = "/etc/security/limits.conf"
-	pathSysctlConf                  = "/etc/sysctl.d/20-maximum-performance.conf"
+	pathLogrotateCronScript         = "/etc/cron.hourly/logrotate-haproxy"
+	pathTimesyncdConf               = "/etc/systemd/timesyncd.conf.d/clouddk.conf"
 )
 
 var (
+	// reHealthCheckPath matches the paths, which are accepted for HTTP health checks.
+	// Whitespace, quotes, backslashes and hash signs are rejected, as the path is interpolated into the HAProxy configuration file.
+	reHealthCheckPath = regexp.MustCompile(`^/[-A-Za-z0-9._~!$&()*+,;=:@/%?]*$`)
+
+	// rePackageID matches the package identifiers, which are accepted by annoLoadBalancerPackageID.
+	rePackageID = regexp.MustCompile(`^[0-9A-Za-z]{1,64}$`)
+
+	// reLoadBalancerHostname matches the hostnames generated with fmtLoadBalancerHostname including the suffixes used during migrations.
+	reLoadBalancerHostname = regexp.MustCompile(`^k8s-load-balancer-[0-9a-f]{32}(-replacement|-retired)?$`)
+
+	haProxyLogrotateConf = heredoc.Doc(`
+		/var/log/haproxy.log {
+			daily
+			rotate 7
+			maxsize 50M
+			missingok
+			notifempty
+			compress
+			delaycompress
+			postrotate
+				[ ! -x /usr/lib/rsyslog/rsyslog-rotate ] || /usr/lib/rsyslog/rsyslog-rotate
+			endscript
+		}
+	`)
 	haProxyOverrideConf = heredoc.Doc(`
 		[Service]
 		LimitNOFILE=1048576
 	`)
+	haProxyRsyslogConf = heredoc.Doc(`
+		# Create an additional socket in the HAProxy chroot in order to allow logging via /dev/log.
+		$AddUnixListenSocket /var/lib/haproxy/dev/log
+
+		# Send HAProxy messages to a dedicated log file.
+		:programname, startswith, "haproxy" {
+			/var/log/haproxy.log
+			stop
+		}
+	`)
+	journaldConf = heredoc.Doc(`
+		[Journal]
+		SystemMaxUse=100M
+	`)
 	loadBalancerProvisionScript = heredoc.Doc(`
 		#!/bin/bash
 		set -e
@@ -93,61 +196,74 @@ var (
 		# Load the optimized kernel configuration.
 		sysctl --system
 
+		# Enable time synchronization using the configured NTP servers.
+		timedatectl set-ntp true
+		systemctl restart systemd-timesyncd
+
 		# Wait for APT processes to terminate before proceeding.
 		while ps aux | grep -q [a]pt || fuser /var/lib/apt/lists/lock >/dev/null 2>&1 || fuser /var/lib/dpkg/lock >/dev/null 2>&1; do
 			sleep 2
 		done
 
-		# Install an LTS version of HAProxy.
-		add-apt-repository -y ppa:vbernat/haproxy-2.0
-		apt-get -qq update
-		apt-get -qq install -y haproxy=2.0.\*
-	`)
-	securityLimitsConf = heredoc.Doc(`
-		* soft nproc 1048576
-		* hard nproc 1048576
-		* soft nofile 1048576
-		* hard nofile 1048576
-		* soft stack 1048576
-		* hard stack 1048576
-		* soft memlock unlimited
-		* hard memlock unlimited
-		haproxy soft nproc 1048576
-		haproxy hard nproc 1048576
-		haproxy soft nofile 1048576
-		haproxy hard nofile 1048576
-		haproxy soft stack 1048576
-		haproxy hard stack 1048576
-		haproxy soft memlock unlimited
-		haproxy hard memlock unlimited
+		# Install either nginx with the stream module or an LTS version of HAProxy as a regular package or as a container.
+		if [[ "$CLOUDDK_LOAD_BALANCER_ENGINE" == "nginx" ]]; then
+			apt-get -qq update
+			apt-get -qq install -y -o Dpkg::Options::=--force-confold nginx-core libnginx-mod-stream
+			systemctl enable nginx
+		elif [[ "$CLOUDDK_HAPROXY_DEPLOYMENT" == "container" ]]; then
+			apt-get -qq update
+			apt-get -qq install -y docker.io socat
+			systemctl enable --now docker
+
+			source /etc/default/haproxy-container
+			docker pull "$HAPROXY_IMAGE"
+
+			mkdir -p /etc/haproxy /run/haproxy /var/lib/haproxy
+			systemctl daemon-reload
+			systemctl enable haproxy
+		else
+			add-apt-repository -y "$CLOUDDK_HAPROXY_REPOSITORY"
+			apt-get -qq update
+			apt-get -qq install -y "haproxy=${CLOUDDK_HAPROXY_VERSION}.*" socat
+
+			# Confine HAProxy with the AppArmor profile, if one has been uploaded, and restart it in order to apply the profile.
+			if [[ -f /etc/apparmor.d/usr.sbin.haproxy ]]; then
+				apparmor_parser -r /etc/apparmor.d/usr.sbin.haproxy
+				systemctl restart haproxy
+			fi
+		fi
+
+		# Apply the logging configuration now that HAProxy has been installed.
+		mkdir -p /var/lib/haproxy/dev
+		systemctl restart rsyslog systemd-journald
+
+		# Install the log shipping agent, if a configuration has been uploaded.
+		if [[ -f /etc/fluent-bit/fluent-bit.conf ]]; then
+			wget -qO - https://packages.fluentbit.io/fluentbit.key | apt-key add -
+			echo "deb https://packages.fluentbit.io/ubuntu/$(lsb_release -cs) $(lsb_release -cs) main" > /etc/apt/sources.list.d/fluent-bit.list
+			apt-get -qq update
+			apt-get -qq install -y fluent-bit
+			mkdir -p /var/lib/fluent-bit
+			systemctl enable fluent-bit
+			systemctl restart fluent-bit
+		fi
+
+		# Install the Prometheus exporter, if a configuration has been uploaded.
+		if [[ -f /etc/default/prometheus-haproxy-exporter ]]; then
+			apt-get -qq install -y -o Dpkg::Options::=--force-confold prometheus-haproxy-exporter
+			systemctl enable prometheus-haproxy-exporter
+			systemctl restart prometheus-haproxy-exporter
+		fi
+
+		# Ensure that the kernel tuning is re-applied and verified after every reboot.
+		systemctl daemon-reload
+		systemctl enable clouddk-verify-tuning.service
+		/usr/local/sbin/clouddk-verify-tuning
 	`)
-	sysctlConf = heredoc.Doc(`
-		fs.file-max=1048576
-		fs.inotify.max_user_instances=1048576
-		fs.inotify.max_user_watches=1048576
-		fs.nr_open=1048576
-		net.core.netdev_max_backlog=1048576
-		net.core.rmem_max=16777216
-		net.core.somaxconn=65535
-		net.core.wmem_max=16777216
-		net.ipv4.tcp_congestion_control=htcp
-		net.ipv4.ip_local_port_range=32768 65535
-		net.ipv4.tcp_fin_timeout=5
-		net.ipv4.tcp_max_orphans=1048576
-		net.ipv4.tcp_max_syn_backlog=20480
-		net.ipv4.tcp_max_tw_buckets=400000
-		net.ipv4.tcp_no_metrics_save=1
-		net.ipv4.tcp_rmem=4096 87380 16777216
-		net.ipv4.tcp_synack_retries=2
-		net.ipv4.tcp_syn_retries=2
-		net.ipv4.tcp_tw_recycle=1
-		net.ipv4.tcp_tw_reuse=1
-		net.ipv4.tcp_wmem=4096 65535 16777216
-		vm.max_map_count=1048576
-		vm.min_free_kbytes=65535
-		vm.overcommit_memory=1
-		vm.swappiness=0
-		vm.vfs_cache_pressure=50
+	logrotateCronScript = heredoc.Doc(`
+		#!/bin/sh
+		# Rotate the HAProxy logs every hour in order to enforce the size limit on small disks.
+		/usr/sbin/logrotate /etc/logrotate.d/haproxy
 	`)
 )
 
@@ -157,27 +273,59 @@ type LoadBalancers struct {
 }
 
 // createLoadBalancer creates a new load balancer.
-func createLoadBalancer(c *CloudConfiguration, hostname string, service *v1.Service) (CloudServer, error) {
+func createLoadBalancer(ctx context.Context, c *CloudConfiguration, hostname string, service *v1.Service) (server CloudServer, err error) {
 	loadBalancerName := getLoadBalancerNameByService(service)
 
+	ctx, span := c.Tracer.Start(ctx, "create_load_balancer", "name", loadBalancerName)
+	defer func() { span.End(err) }()
+
 	debugCloudAction(rtLoadBalancers, "Creating new load balancer (name: %s)", loadBalancerName)
 
-	server := CloudServer{
+	server = CloudServer{
 		CloudConfiguration: c,
 	}
 
+	err = checkNewLoadBalancerIP(service)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Refusing to create a server without the requested IP address (name: %s)", loadBalancerName)
+
+		return server, err
+	}
+
+	server.SSHKeyPair, err = ensureServiceSSHKeyPair(c, service)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to retrieve SSH key pair (name: %s)", loadBalancerName)
+
+		return server, err
+	}
+
 	connectionLimit, err := parseIntAnnotation(service.Annotations[annoLoadBalancerConnectionLimit], 1000, 1, 20000)
 
 	if err != nil {
 		debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", annoLoadBalancerConnectionLimit, loadBalancerName)
 
-		return server, err
+		return server, newConfigurationError(err)
 	}
 
 	debugCloudAction(rtLoadBalancers, "Creating server (name: %s)", loadBalancerName)
 
-	packageID := getPackageIDByConnectionLimit(connectionLimit)
-	err = server.Create("dk1", packageID, hostname)
+	setProvisioningState(c, service, provisioningStateCreated)
+
+	packageID, err := getPackageIDByService(service, connectionLimit)
+
+	if err != nil {
+		return server, err
+	}
+
+	template, err := getServerTemplateByService(c, service)
+
+	if err != nil {
+		return server, err
+	}
+
+	err = server.Create(ctx, "dk1", packageID, template, hostname)
 
 	if err != nil {
 		debugCloudAction(rtLoadBalancers, "Failed to create server (name: %s)", loadBalancerName)
@@ -186,6 +334,38 @@ func createLoadBalancer(c *CloudConfiguration, hostname string, service *v1.Serv
 	}
 
 	debugCloudAction(rtLoadBalancers, "Successfully created server (name: %s)", loadBalancerName)
+	recordServiceEvent(c, service, v1.EventTypeNormal, eventReasonServerCreated, "Created server '%s' (hostname: %s)", server.Information.Identifier, hostname)
+
+	setProvisioningState(c, service, provisioningStateBooted)
+
+	err = configureLoadBalancer(ctx, c, &server, service)
+
+	if err != nil {
+		return server, err
+	}
+
+	setProvisioningState(c, service, provisioningStateConfigured)
+
+	return server, nil
+}
+
+// configureLoadBalancer installs and configures the load balancer software on a server, which has been created.
+// The server is destroyed, if it cannot be configured.
+func configureLoadBalancer(ctx context.Context, c *CloudConfiguration, server *CloudServer, service *v1.Service) error {
+	err := provisionLoadBalancer(ctx, c, server, service)
+
+	if err != nil {
+		server.Destroy()
+
+		return err
+	}
+
+	return nil
+}
+
+// provisionLoadBalancer installs and configures the load balancer software on a server.
+func provisionLoadBalancer(ctx context.Context, c *CloudConfiguration, server *CloudServer, service *v1.Service) (err error) {
+	loadBalancerName := getLoadBalancerNameByService(service)
 
 	// Establish an SSH connection to the server in order to configure it.
 	debugCloudAction(rtLoadBalancers, "Establishing SSH connection (name: %s)", loadBalancerName)
@@ -195,9 +375,7 @@ func createLoadBalancer(c *CloudConfiguration, hostname string, service *v1.Serv
 	if err != nil {
 		debugCloudAction(rtLoadBalancers, "Failed to establish SSH connection (name: %s)", loadBalancerName)
 
-		server.Destroy()
-
-		return server, err
+		return err
 	}
 
 	defer sshClient.Close()
@@ -210,91 +388,294 @@ func createLoadBalancer(c *CloudConfiguration, hostname string, service *v1.Serv
 	if err != nil {
 		debugCloudAction(rtLoadBalancers, "Failed to create new SFTP client (name: %s)", loadBalancerName)
 
-		server.Destroy()
-
-		return server, err
+		return err
 	}
 
 	defer sftpClient.Close()
 
-	// Upload the configuration files stored as heredoc variables at the top of this file.
-	debugCloudAction(rtLoadBalancers, "Configuring server (name: %s)", loadBalancerName)
-	debugCloudAction(rtLoadBalancers, "Uploading file to '%s' (name: %s)", pathHAProxyOverrideConf, loadBalancerName)
-
-	err = server.UploadFile(sftpClient, pathHAProxyOverrideConf, bytes.NewBufferString(haProxyOverrideConf))
+	// Render the kernel tuning for the selected profile and the kernel version of the server.
+	tuningProfile, err := parseStringAnnotation(
+		service.Annotations[annoLoadBalancerTuningProfile],
+		c.TuningProfile,
+		[]string{tuningProfileAggressive, tuningProfileCustom, tuningProfileDefault},
+	)
 
 	if err != nil {
-		debugCloudAction(rtLoadBalancers, "Failed to configure server because file '%s' could not be uploaded (name: %s)", pathHAProxyOverrideConf, loadBalancerName)
+		debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", annoLoadBalancerTuningProfile, loadBalancerName)
 
-		server.Destroy()
+		return newConfigurationError(err)
+	}
 
-		return server, err
+	kernelVersion, err := server.RunCommand(sshClient, "uname -r")
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to determine the kernel version (name: %s)", loadBalancerName)
+
+		return err
 	}
 
-	debugCloudAction(rtLoadBalancers, "Uploading file to '%s' (name: %s)", pathLoadBalancerProvisionScript, loadBalancerName)
+	sysctlConf, err := getSysctlConf(c, tuningProfile, string(kernelVersion))
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to render the kernel tuning profile '%s' (name: %s)", tuningProfile, loadBalancerName)
+
+		return newConfigurationError(err)
+	}
 
-	err = server.UploadFile(sftpClient, pathLoadBalancerProvisionScript, bytes.NewBufferString(loadBalancerProvisionScript))
+	haProxyDeployment, err := parseStringAnnotation(
+		service.Annotations[annoLoadBalancerHAProxyDeployment],
+		c.HAProxyDeployment,
+		[]string{haProxyDeploymentContainer, haProxyDeploymentHost},
+	)
 
 	if err != nil {
-		debugCloudAction(rtLoadBalancers, "Failed to configure server because file '%s' could not be uploaded (name: %s)", pathLoadBalancerProvisionScript, loadBalancerName)
+		debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", annoLoadBalancerHAProxyDeployment, loadBalancerName)
 
-		server.Destroy()
+		return newConfigurationError(err)
+	}
 
-		return server, err
+	engine, err := getLoadBalancerEngine(service)
+
+	if err != nil {
+		return err
 	}
 
-	debugCloudAction(rtLoadBalancers, "Uploading file to '%s' (name: %s)", pathSecurityLimitsConf, loadBalancerName)
+	haProxyImage := service.Annotations[annoLoadBalancerHAProxyImage]
 
-	err = server.UploadFile(sftpClient, pathSecurityLimitsConf, bytes.NewBufferString(securityLimitsConf))
+	if haProxyImage == "" {
+		haProxyImage = c.HAProxyImage
+	}
+
+	err = validateContainerImage(haProxyImage)
 
 	if err != nil {
-		debugCloudAction(rtLoadBalancers, "Failed to configure server because file '%s' could not be uploaded (name: %s)", pathSecurityLimitsConf, loadBalancerName)
+		debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", annoLoadBalancerHAProxyImage, loadBalancerName)
 
-		server.Destroy()
+		return newConfigurationError(err)
+	}
 
-		return server, err
+	logShippingEndpoint := service.Annotations[annoLoadBalancerLogShippingEndpoint]
+
+	if logShippingEndpoint == "" {
+		logShippingEndpoint = c.LogShippingEndpoint
 	}
 
-	debugCloudAction(rtLoadBalancers, "Uploading file to '%s' (name: %s)", pathSysctlConf, loadBalancerName)
+	fluentBitConf := ""
 
-	err = server.UploadFile(sftpClient, pathSysctlConf, bytes.NewBufferString(sysctlConf))
+	if logShippingEndpoint != "" {
+		fluentBitConf, err = getFluentBitConf(logShippingEndpoint)
 
-	if err != nil {
-		debugCloudAction(rtLoadBalancers, "Failed to configure server because file '%s' could not be created (name: %s)", pathSysctlConf, loadBalancerName)
+		if err != nil {
+			debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", annoLoadBalancerLogShippingEndpoint, loadBalancerName)
 
-		server.Destroy()
+			return newConfigurationError(err)
+		}
+	}
 
-		return server, err
+	// Upload the configuration files stored as heredoc variables at the top of this file.
+	debugCloudAction(rtLoadBalancers, "Configuring server (name: %s)", loadBalancerName)
+
+	files := []provisioningFile{
+		{Path: pathHAProxyOverrideConf, Contents: haProxyOverrideConf, Mode: fileModeConfig},
+		{Path: pathHAProxyLogrotateConf, Contents: haProxyLogrotateConf, Mode: fileModeConfig},
+		{Path: pathHAProxyRsyslogConf, Contents: haProxyRsyslogConf, Mode: fileModeConfig},
+		{Path: pathJournaldConf, Contents: journaldConf, Mode: fileModeConfig},
+		{Path: pathLoadBalancerProvisionScript, Contents: loadBalancerProvisionScript, Mode: fileModeScript},
+		{Path: pathLogrotateCronScript, Contents: logrotateCronScript, Mode: fileModeScript},
+		{Path: pathSecurityLimitsConf, Contents: securityLimitsConf, Mode: fileModeConfig},
+		{Path: pathSysctlConf, Contents: sysctlConf, Mode: fileModeConfig},
+		{Path: pathTimesyncdConf, Contents: getTimesyncdConf(c.NTPServers), Mode: fileModeConfig},
+		{Path: pathVerifyTuningScript, Contents: verifyTuningScript, Mode: fileModeScript},
+		{Path: pathVerifyTuningService, Contents: verifyTuningService, Mode: fileModeConfig},
 	}
 
-	// Configure the server.
-	debugCloudAction(rtLoadBalancers, "Creating new SSH session (name: %s)", loadBalancerName)
+	if haProxyDeployment == haProxyDeploymentContainer {
+		files = append(
+			files,
+			provisioningFile{Path: pathHAProxyContainerEnv, Contents: getHAProxyContainerEnv(haProxyImage), Mode: fileModeConfig},
+			provisioningFile{Path: pathHAProxyContainerService, Contents: haProxyContainerService, Mode: fileModeConfig},
+			provisioningFile{Path: pathHAProxyContainerWrapper, Contents: haProxyContainerWrapper, Mode: fileModeScript},
+		)
+	}
+
+	if haProxyDeployment == haProxyDeploymentHost && engine == loadBalancerEngineHAProxy {
+		files = append(
+			files,
+			provisioningFile{Path: pathHAProxyReloadConf, Contents: haProxyReloadConf, Mode: fileModeConfig},
+			provisioningFile{Path: pathHAProxySandboxConf, Contents: haProxySandboxConf, Mode: fileModeConfig},
+		)
+
+		if c.HAProxyAppArmor {
+			files = append(files, provisioningFile{Path: pathHAProxyAppArmorProfile, Contents: haProxyAppArmorProfile, Mode: fileModeConfig})
+		}
+	}
+
+	if fluentBitConf != "" {
+		files = append(files, provisioningFile{Path: pathFluentBitConf, Contents: fluentBitConf, Mode: fileModePrivate})
+	}
+
+	if isMetricsEnabled(service) {
+		files = append(files, provisioningFile{Path: pathHAProxyExporterDefaults, Contents: haProxyExporterDefaults, Mode: fileModeConfig})
+	}
+
+	_, uploadSpan := c.Tracer.Start(ctx, "upload_files", "count", strconv.Itoa(len(files)))
+
+	for _, f := range files {
+		debugCloudAction(rtLoadBalancers, "Uploading file to '%s' (name: %s)", f.Path, loadBalancerName)
+
+		err = server.UploadFile(sshClient, sftpClient, f.Path, bytes.NewBufferString(f.Contents), f.Mode, 0, 0)
 
-	sshSession, err := sshClient.NewSession()
+		if err != nil {
+			debugCloudAction(rtLoadBalancers, "Failed to configure server because file '%s' could not be uploaded (name: %s)", f.Path, loadBalancerName)
+
+			uploadSpan.End(err)
+
+			return err
+		}
+	}
+
+	uploadSpan.End(nil)
+
+	verifyCommand, err := server.signProvisioningFiles(sshClient, sftpClient, files)
 
 	if err != nil {
-		debugCloudAction(rtLoadBalancers, "Failed to configure server due to SSH session errors (name: %s)", loadBalancerName)
+		debugCloudAction(rtLoadBalancers, "Failed to configure server because the provisioning files could not be signed (name: %s)", loadBalancerName)
 
-		server.Destroy()
+		return err
+	}
 
-		return server, err
+	// Configure the server.
+	debugCloudAction(rtLoadBalancers, "Executing provisioning script (name: %s)", loadBalancerName)
+
+	haProxyRepository, haProxyVersion, err := getHAProxyPackageSource(c, server, service)
+
+	if err != nil {
+		return err
 	}
 
-	defer sshSession.Close()
+	provisionCommand := fmt.Sprintf(
+		"CLOUDDK_HAPROXY_DEPLOYMENT=%s CLOUDDK_HAPROXY_REPOSITORY=%s CLOUDDK_HAPROXY_VERSION=%s CLOUDDK_LOAD_BALANCER_ENGINE=%s /bin/bash %s",
+		haProxyDeployment,
+		shellQuote(haProxyRepository),
+		haProxyVersion,
+		engine,
+		pathLoadBalancerProvisionScript,
+	)
+
+	if verifyCommand != "" {
+		provisionCommand = verifyCommand + " && " + provisionCommand
+	}
 
-	output, err := sshSession.CombinedOutput("/bin/bash " + pathLoadBalancerProvisionScript)
+	_, provisionSpan := c.Tracer.Start(ctx, "provision_load_balancer", "deployment", haProxyDeployment)
+	output, err := server.RunCommand(sshClient, provisionCommand)
+	provisionSpan.End(err)
 
 	if err != nil {
 		debugCloudAction(rtLoadBalancers, "Failed to configure server due to shell errors (name: %s) - Output: %s - Error: %s", loadBalancerName, string(output), err.Error())
 
-		server.Destroy()
+		return err
+	}
 
-		return server, err
+	return nil
+}
+
+// ensureLoadBalancerServer retrieves the server of a load balancer and creates it, if it does not exist.
+// The load balancer is locked, while the server is being created, which prevents concurrent operations from creating duplicate servers.
+func ensureLoadBalancerServer(ctx context.Context, c *CloudConfiguration, hostname string, service *v1.Service) (CloudServer, error) {
+	unlock := c.LoadBalancerLocks.Lock(service)
+	defer unlock()
+
+	server := CloudServer{
+		CloudConfiguration: c,
+	}
+
+	notFound, err := initializeLoadBalancerServer(&server, hostname, service)
+
+	if notFound {
+		// The cached listings may predate a server created by an earlier attempt, which is why they are discarded, before a server is created.
+		c.ServerList.Invalidate()
+		c.ResponseCache.Invalidate("cloudservers")
+
+		server = CloudServer{
+			CloudConfiguration: c,
+		}
+
+		notFound, err = initializeLoadBalancerServer(&server, hostname, service)
+	}
+
+	if err != nil {
+		if !notFound {
+			return server, err
+		}
+	} else {
+		destroyed, err := recoverStuckServer(c, &server, service)
+
+		if err != nil {
+			return server, err
+		}
+
+		notFound = destroyed
+	}
+
+	if notFound {
+		adopted, err := adoptAnnotatedServer(ctx, c, hostname, service)
+
+		if err != nil {
+			reportLoadBalancerFailure(c, service, eventReasonLoadBalancerProvisionFailed, "Failed to adopt server (%s error): %s", getErrorClass(err), err.Error())
+
+			return server, err
+		}
+
+		if adopted != nil {
+			return *adopted, nil
+		}
+
+		server, err = createLoadBalancer(ctx, c, hostname, service)
+
+		if err != nil {
+			reportLoadBalancerFailure(c, service, eventReasonLoadBalancerProvisionFailed, "Failed to provision load balancer (%s error): %s", getErrorClass(err), err.Error())
+
+			return server, err
+		}
 	}
 
 	return server, nil
 }
 
+// initializeLoadBalancerServer initializes the server of a load balancer based on the id annotation of a service, which avoids searching for the hostname.
+// The hostname is searched for instead, if the annotation is missing or refers to a server, which no longer belongs to the load balancer.
+func initializeLoadBalancerServer(server *CloudServer, hostname string, service *v1.Service) (notFound bool, err error) {
+	if id := service.Annotations[annoLoadBalancerID]; id != "" {
+		notFound, err = server.InitializeByID(id)
+
+		if err != nil && !notFound {
+			return false, err
+		}
+
+		if err == nil && server.Information.Hostname == hostname {
+			return false, nil
+		}
+
+		debugCloudAction(rtLoadBalancers, "Ignoring load balancer id '%s', which refers to a server with a different hostname (name: %s)", id, getLoadBalancerNameByService(service))
+
+		*server = CloudServer{
+			CloudConfiguration: server.CloudConfiguration,
+		}
+	}
+
+	return server.InitializeByHostname(hostname)
+}
+
+// getHealthCheckNodePort retrieves the node port, which reports whether a node has local endpoints for a service.
+// Zero is returned, unless the service routes external traffic to local endpoints only.
+func getHealthCheckNodePort(service *v1.Service) int32 {
+	if service.Spec.ExternalTrafficPolicy != v1.ServiceExternalTrafficPolicyTypeLocal {
+		return 0
+	}
+
+	return service.Spec.HealthCheckNodePort
+}
+
 // getLoadBalancerHostname retrieves the hostname for a load balancer.
 func getLoadBalancerHostname(clusterName string, loadBalancerName string) string {
 	loadBalancerHash := md5.New()
@@ -327,6 +708,26 @@ func getPackageIDByConnectionLimit(limit int) string {
 	}
 }
 
+// getPackageIDByService retrieves the package id for the load balancer of a service.
+// The package is derived from the connection limit, unless the service specifies a package explicitly.
+func getPackageIDByService(service *v1.Service, connectionLimit int) (string, error) {
+	packageID := service.Annotations[annoLoadBalancerPackageID]
+
+	if packageID == "" {
+		return getPackageIDByConnectionLimit(connectionLimit), nil
+	}
+
+	err := validatePackageID(packageID)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", annoLoadBalancerPackageID, getLoadBalancerNameByService(service))
+
+		return "", newConfigurationError(err)
+	}
+
+	return packageID, nil
+}
+
 // getProcessorCountByConnectionLimit retrieves the package id based on a connection limit.
 func getProcessorCountByConnectionLimit(limit int) int {
 	if limit <= 1000 {
@@ -338,6 +739,11 @@ func getProcessorCountByConnectionLimit(limit int) int {
 	}
 }
 
+// getTimesyncdConf retrieves the systemd-timesyncd configuration for a list of NTP servers.
+func getTimesyncdConf(servers []string) string {
+	return fmt.Sprintf("[Time]\nNTP=%s\n", strings.Join(servers, " "))
+}
+
 // newLoadBalancers initializes a new LoadBalancers object.
 func newLoadBalancers(c *CloudConfiguration) cloudprovider.LoadBalancer {
 	return LoadBalancers{
@@ -377,6 +783,27 @@ func parseIntAnnotation(value string, defaultValue int, minValue int, maxValue i
 	return i, nil
 }
 
+// parsePortListAnnotation parses an annotation containing a comma separated list of ports.
+func parsePortListAnnotation(value string, defaultValue []int32) ([]int32, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	ports := []int32{}
+
+	for _, v := range strings.Split(value, ",") {
+		port, err := strconv.Atoi(strings.TrimSpace(v))
+
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("Invalid port '%s'", strings.TrimSpace(v))
+		}
+
+		ports = append(ports, int32(port))
+	}
+
+	return ports, nil
+}
+
 // parseStringAnnotation parses an annotation containing a string.
 func parseStringAnnotation(value string, defaultValue string, supportedValues []string) (string, error) {
 	if value == "" {
@@ -392,6 +819,24 @@ func parseStringAnnotation(value string, defaultValue string, supportedValues []
 	return value, fmt.Errorf("Unsupported value '%s'", value)
 }
 
+// validateHealthCheckPath validates the path, which is requested by HTTP health checks.
+func validateHealthCheckPath(value string) error {
+	if !reHealthCheckPath.MatchString(value) {
+		return fmt.Errorf("The path '%s' must begin with '/' and must not contain whitespace, quotes, backslashes or hash signs", value)
+	}
+
+	return nil
+}
+
+// validatePackageID validates the identifier of a Cloud.dk package.
+func validatePackageID(value string) error {
+	if !rePackageID.MatchString(value) {
+		return fmt.Errorf("The value '%s' is not a valid package identifier", value)
+	}
+
+	return nil
+}
+
 // sanitizeClusterName sanitizes a cluster name for use in hostnames.
 func sanitizeClusterName(clusterName string) string {
 	re := regexp.MustCompile(`[^a-z0-9-]`)
@@ -409,15 +854,37 @@ func sanitizeClusterName(clusterName string) string {
 // Parameter 'clusterName' is the name of the cluster as presented to kube-controller-manager
 func (l LoadBalancers) GetLoadBalancer(ctx context.Context, clusterName string, service *v1.Service) (status *v1.LoadBalancerStatus, exists bool, err error) {
 	loadBalancerName := getLoadBalancerNameByService(service)
-	hostname := getLoadBalancerHostname(clusterName, loadBalancerName)
+
+	if !isLoadBalancerClassManaged(l.config, service) {
+		return service.Status.LoadBalancer.DeepCopy(), false, nil
+	}
 
 	debugCloudAction(rtLoadBalancers, "Determining if load balancer exists (name: %s)", loadBalancerName)
 
+	passthrough, err := isNodePassthroughLoadBalancer(service)
+
+	if err != nil {
+		return &v1.LoadBalancerStatus{}, true, err
+	}
+
+	if passthrough {
+		status = service.Status.LoadBalancer.DeepCopy()
+
+		return status, len(status.Ingress) > 0, nil
+	}
+
+	hostname, err := getLoadBalancerHostnameByService(clusterName, service)
+
+	if err != nil {
+		return &v1.LoadBalancerStatus{}, true, err
+	}
+
 	server := CloudServer{
 		CloudConfiguration: l.config,
+		ReadOnly:           true,
 	}
 
-	notFound, err := server.InitializeByHostname(hostname)
+	notFound, err := initializeLoadBalancerServer(&server, hostname, service)
 
 	if err != nil {
 		if notFound {
@@ -427,17 +894,7 @@ func (l LoadBalancers) GetLoadBalancer(ctx context.Context, clusterName string,
 		return &v1.LoadBalancerStatus{}, true, err
 	}
 
-	ingresses := make([]v1.LoadBalancerIngress, 0)
-
-	for _, nic := range server.Information.NetworkInterfaces {
-		for _, ip := range nic.IPAddresses {
-			debugCloudAction(rtLoadBalancers, "Adding IP address '%s' to ingress (name: %s)", ip.Address, loadBalancerName)
-
-			ingresses = append(ingresses, v1.LoadBalancerIngress{
-				IP: ip.Address,
-			})
-		}
-	}
+	ingresses := getLoadBalancerIngresses(&server, service)
 
 	if len(ingresses) == 0 {
 		return &v1.LoadBalancerStatus{}, true, fmt.Errorf("No IP addresses available (name: %s)", loadBalancerName)
@@ -455,61 +912,141 @@ func (l LoadBalancers) GetLoadBalancerName(ctx context.Context, clusterName stri
 // EnsureLoadBalancer creates a new load balancer 'name', or updates the existing one. Returns the status of the balancer.
 // Implementations must treat the *v1.Service and *v1.Node parameters as read-only and not modify them.
 // Parameter 'clusterName' is the name of the cluster as presented to kube-controller-manager
-func (l LoadBalancers) EnsureLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
-	loadBalancerName := getLoadBalancerNameByService(service)
-	hostname := getLoadBalancerHostname(clusterName, loadBalancerName)
+func (l LoadBalancers) EnsureLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) (status *v1.LoadBalancerStatus, err error) {
+	// The status is returned unchanged, as the service controller would otherwise overwrite the status written by the other implementation.
+	if !isLoadBalancerClassManaged(l.config, service) {
+		debugCloudAction(rtLoadBalancers, "Skipping load balancer with a different class (name: %s)", getLoadBalancerNameByService(service))
 
-	debugCloudAction(rtLoadBalancers, "Ensuring that load balancer exists (name: %s)", loadBalancerName)
+		return service.Status.LoadBalancer.DeepCopy(), nil
+	}
 
-	server := CloudServer{
-		CloudConfiguration: l.config,
+	defer observeLoadBalancerOperation(operationEnsure, service, time.Now(), &err)
+
+	ctx, span := l.config.Tracer.Start(ctx, "EnsureLoadBalancer", "namespace", service.Namespace, "service", service.Name)
+	defer func() { span.End(err) }()
+	defer func() {
+		l.config.TerminalErrors.Update(service, err)
+
+		if err != nil {
+			recordLoadBalancerError(l.config, service, err)
+		} else {
+			resetLoadBalancerFailures(l.config, service)
+		}
+	}()
+
+	err = l.config.TerminalErrors.Get(service)
+
+	if err != nil {
+		reportLoadBalancerFailure(l.config, service, eventReasonLoadBalancerUpdateFailed, "Failed to update load balancer (%s error): %s", getErrorClass(err), err.Error())
+
+		return nil, err
 	}
 
-	notFound, err := server.InitializeByHostname(hostname)
+	passthrough, err := isNodePassthroughLoadBalancer(service)
 
 	if err != nil {
-		if !notFound {
-			return nil, err
-		}
+		return nil, err
+	}
 
-		server, err = createLoadBalancer(l.config, hostname, service)
+	if passthrough {
+		return l.ensureNodePassthroughLoadBalancer(ctx, clusterName, service, nodes)
+	}
 
-		if err != nil {
-			return nil, err
-		}
+	if l.config.CircuitBreaker.IsOpen() {
+		return nil, deferLoadBalancerMutation(l.config, service)
 	}
 
-	err = l.UpdateLoadBalancer(ctx, clusterName, service, nodes)
+	loadBalancerName := getLoadBalancerNameByService(service)
+	hostname, err := getLoadBalancerHostnameByService(clusterName, service)
 
 	if err != nil {
 		return nil, err
 	}
 
-	ingresses := make([]v1.LoadBalancerIngress, 0)
+	debugCloudAction(rtLoadBalancers, "Ensuring that load balancer exists (name: %s)", loadBalancerName)
 
-	for _, nic := range server.Information.NetworkInterfaces {
-		for _, ip := range nic.IPAddresses {
-			debugCloudAction(rtLoadBalancers, "Adding IP address '%s' to ingress (name: %s)", ip.Address, loadBalancerName)
+	server, err := ensureLoadBalancerServer(ctx, l.config, hostname, service)
 
-			ingresses = append(ingresses, v1.LoadBalancerIngress{
-				IP: ip.Address,
-			})
-		}
+	if err != nil {
+		return nil, err
+	}
+
+	err = l.UpdateLoadBalancer(ctx, clusterName, service, nodes)
+
+	if err != nil {
+		return nil, err
 	}
 
+	ingresses := getLoadBalancerIngresses(&server, service)
+
 	if len(ingresses) == 0 {
 		return &v1.LoadBalancerStatus{}, fmt.Errorf("No IP addresses available (name: %s)", loadBalancerName)
 	}
 
+	if record := getExternalDNSOwnershipRecord(l.config, service); record != "" {
+		updateLoadBalancerStatus(l.config, service, map[string]string{
+			statusKeyExternalDNSOwnership: record,
+		})
+	}
+
 	return &v1.LoadBalancerStatus{Ingress: ingresses}, nil
 }
 
 // UpdateLoadBalancer updates hosts under the specified load balancer.
 // Implementations must treat the *v1.Service and *v1.Node parameters as read-only and not modify them.
 // Parameter 'clusterName' is the name of the cluster as presented to kube-controller-manager.
-func (l LoadBalancers) UpdateLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) error {
+func (l LoadBalancers) UpdateLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) (err error) {
+	if !isLoadBalancerClassManaged(l.config, service) {
+		return nil
+	}
+
+	defer observeLoadBalancerOperation(operationUpdate, service, time.Now(), &err)
+
+	unlock := l.config.LoadBalancerLocks.Lock(service)
+	defer unlock()
+
+	ctx, span := l.config.Tracer.Start(ctx, "UpdateLoadBalancer", "namespace", service.Namespace, "service", service.Name)
+	defer func() { span.End(err) }()
+	defer func() {
+		l.config.TerminalErrors.Update(service, err)
+
+		if err != nil {
+			if !isCircuitOpenError(err) {
+				reportLoadBalancerFailure(l.config, service, eventReasonLoadBalancerUpdateFailed, "Failed to update load balancer (%s error): %s", getErrorClass(err), err.Error())
+			}
+
+			recordLoadBalancerError(l.config, service, err)
+		} else {
+			resetLoadBalancerFailures(l.config, service)
+		}
+	}()
+
+	err = l.config.TerminalErrors.Get(service)
+
+	if err != nil {
+		return err
+	}
+
+	passthrough, err := isNodePassthroughLoadBalancer(service)
+
+	if err != nil {
+		return err
+	}
+
+	if passthrough {
+		return l.updateNodePassthroughLoadBalancer(service, nodes)
+	}
+
+	if l.config.CircuitBreaker.IsOpen() {
+		return deferLoadBalancerMutation(l.config, service)
+	}
+
 	loadBalancerName := getLoadBalancerNameByService(service)
-	hostname := getLoadBalancerHostname(clusterName, loadBalancerName)
+	hostname, err := getLoadBalancerHostnameByService(clusterName, service)
+
+	if err != nil {
+		return err
+	}
 
 	debugCloudAction(rtLoadBalancers, "Updating load balancer (name: %s)", loadBalancerName)
 
@@ -517,7 +1054,7 @@ func (l LoadBalancers) UpdateLoadBalancer(ctx context.Context, clusterName strin
 		CloudConfiguration: l.config,
 	}
 
-	_, err := server.InitializeByHostname(hostname)
+	_, err = initializeLoadBalancerServer(&server, hostname, service)
 
 	if err != nil {
 		debugCloudAction(rtLoadBalancers, "Failed to initialize server instance (name: %s)", loadBalancerName)
@@ -525,12 +1062,188 @@ func (l LoadBalancers) UpdateLoadBalancer(ctx context.Context, clusterName strin
 		return err
 	}
 
+	server.SSHKeyPair, err = getServiceSSHKeyPair(l.config, service.Namespace, service.Name)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to retrieve SSH key pair (name: %s)", loadBalancerName)
+
+		return err
+	}
+
+	_, recoverSpan := l.config.Tracer.Start(ctx, "recover_stuck_server")
+	destroyed, err := recoverStuckServer(l.config, &server, service)
+	recoverSpan.End(err)
+
+	if err != nil {
+		return err
+	}
+
+	if !destroyed {
+		destroyed, err = resumeLoadBalancerProvisioning(ctx, l.config, &server, service)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if destroyed {
+		server, err = createLoadBalancer(ctx, l.config, hostname, service)
+
+		if err != nil {
+			reportLoadBalancerFailure(l.config, service, eventReasonLoadBalancerProvisionFailed, "Failed to provision load balancer (%s error): %s", getErrorClass(err), err.Error())
+
+			return err
+		}
+	}
+
 	if len(server.Information.NetworkInterfaces) == 0 {
 		debugCloudAction(rtLoadBalancers, "Failed to find any network interfaces (name: %s)", loadBalancerName)
 
 		return fmt.Errorf("Cannot update load balancer due to lack of IP addresses (name: %s)", loadBalancerName)
 	}
 
+	err = verifyLoadBalancerIP(&server, service)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to verify the requested IP address (name: %s) - Error: %s", loadBalancerName, err.Error())
+
+		return err
+	}
+
+	configFileContents, haProxyVersion, err := l.configureLoadBalancerServer(ctx, clusterName, &server, service, nodes)
+
+	if err != nil {
+		return err
+	}
+
+	err = ensureLoadBalancerMetricsService(l.config, &server, service)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to point Prometheus at the exporter (name: %s) - Error: %s", loadBalancerName, err.Error())
+
+		return err
+	}
+
+	recordLoadBalancerSuccess(l.config, service, &server, getConfigHash(configFileContents), haProxyVersion)
+
+	// The id is only an optimization for later lookups, which is why a failure to store it does not fail the update.
+	if l.config.KubeClient != nil && service.Annotations[annoLoadBalancerID] != server.Information.Identifier {
+		err = setLoadBalancerIDAnnotation(l.config, service, server.Information.Identifier)
+
+		if err != nil {
+			debugCloudAction(rtLoadBalancers, "Failed to store the load balancer id (name: %s) - Error: %s", loadBalancerName, err.Error())
+		}
+	}
+
+	l.config.LoadBalancerRegistry.Add(service, clusterName, hostname)
+
+	return nil
+}
+
+// EnsureLoadBalancerDeleted deletes the specified load balancer if it exists, returning nil if the load balancer specified either didn't exist or was successfully deleted.
+// This construction is useful because many cloud providers' load balancers have multiple underlying components, meaning a Get could say that the LB doesn't exist even if some part of it is still laying around.
+// Implementations must treat the *v1.Service parameter as read-only and not modify it.
+// Parameter 'clusterName' is the name of the cluster as presented to kube-controller-manager.
+func (l LoadBalancers) EnsureLoadBalancerDeleted(ctx context.Context, clusterName string, service *v1.Service) (err error) {
+	if !isLoadBalancerClassManaged(l.config, service) {
+		return nil
+	}
+
+	defer observeLoadBalancerOperation(operationEnsureDeleted, service, time.Now(), &err)
+
+	unlock := l.config.LoadBalancerLocks.Lock(service)
+	defer unlock()
+
+	if l.config.CircuitBreaker.IsOpen() {
+		return deferLoadBalancerMutation(l.config, service)
+	}
+
+	loadBalancerName := getLoadBalancerNameByService(service)
+	hostname, err := getLoadBalancerHostnameByService(clusterName, service)
+
+	if err != nil {
+		return err
+	}
+
+	debugCloudAction(rtLoadBalancers, "Ensuring that load balancer has been deleted (name: %s)", loadBalancerName)
+
+	server := CloudServer{
+		CloudConfiguration: l.config,
+	}
+
+	notFound, err := initializeLoadBalancerServer(&server, hostname, service)
+
+	if err != nil {
+		if notFound {
+			l.config.LoadBalancerRegistry.Remove(service)
+			l.config.MaintenanceQueue.Remove(service)
+			deleteLoadBalancerMetrics(service)
+			deleteLoadBalancerSSHKeyPair(l.config, service)
+			deleteServiceStatsCredentials(l.config, service)
+			deleteLoadBalancerMetricsService(l.config, service)
+
+			return nil
+		}
+
+		debugCloudAction(rtLoadBalancers, "Failed to determine if load balancer exists (name: %s)", loadBalancerName)
+
+		return err
+	}
+
+	// The server of a shared load balancer is only destroyed, once no other cluster has a fragment on it.
+	if isSharedLoadBalancer(service) {
+		remaining, err := removeSharedLoadBalancerFragment(&server, clusterName, service)
+
+		if err != nil {
+			debugCloudAction(rtLoadBalancers, "Failed to remove the fragment from the shared load balancer (name: %s) - Error: %s", loadBalancerName, err.Error())
+			recordServiceEvent(l.config, service, v1.EventTypeWarning, eventReasonLoadBalancerDeleteFailed, "Failed to remove configuration fragment from shared server '%s': %s", server.Information.Identifier, err.Error())
+
+			return err
+		}
+
+		if remaining > 0 {
+			debugCloudAction(rtLoadBalancers, "Keeping shared load balancer, which is still used by %d services (name: %s)", remaining, loadBalancerName)
+
+			l.config.LoadBalancerRegistry.Remove(service)
+			l.config.MaintenanceQueue.Remove(service)
+			deleteLoadBalancerMetrics(service)
+
+			return nil
+		}
+	}
+
+	serverID := server.Information.Identifier
+	err = server.Destroy()
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to destroy load balancer (name: %s)", loadBalancerName)
+		recordServiceEvent(l.config, service, v1.EventTypeWarning, eventReasonLoadBalancerDeleteFailed, "Failed to destroy server '%s': %s", serverID, err.Error())
+
+		return err
+	}
+
+	recordServiceEvent(l.config, service, v1.EventTypeNormal, eventReasonLoadBalancerDeleted, "Destroyed server '%s'", serverID)
+
+	l.config.LoadBalancerRegistry.Remove(service)
+	l.config.MaintenanceQueue.Remove(service)
+	deleteLoadBalancerMetrics(service)
+	deleteLoadBalancerSSHKeyPair(l.config, service)
+	deleteServiceStatsCredentials(l.config, service)
+	deleteLoadBalancerMetricsService(l.config, service)
+
+	return nil
+}
+
+// configureLoadBalancerServer renders the HAProxy configuration of a service, uploads it to a server together with the firewall rules and reloads HAProxy.
+// The rendered configuration and the HAProxy version are returned in order to record the success of the operation.
+func (l LoadBalancers) configureLoadBalancerServer(ctx context.Context, clusterName string, server *CloudServer, service *v1.Service, nodes []*v1.Node) (configFileContents string, haProxyVersion string, err error) {
+	loadBalancerName := getLoadBalancerNameByService(service)
+	engine, err := getLoadBalancerEngine(service)
+
+	if err != nil {
+		return "", "", err
+	}
+
 	// Retrieve the configuration values stored as annotations.
 	algorithm, err := parseStringAnnotation(
 		service.Annotations[annoLoadBalancerAlgorithm],
@@ -541,7 +1254,7 @@ func (l LoadBalancers) UpdateLoadBalancer(ctx context.Context, clusterName strin
 	if err != nil {
 		debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", annoLoadBalancerAlgorithm)
 
-		return err
+		return "", "", newConfigurationError(err)
 	}
 
 	clientTimeout, err := parseIntAnnotation(service.Annotations[annoLoadBalancerClientTimeout], 30, 1, 86400)
@@ -549,7 +1262,7 @@ func (l LoadBalancers) UpdateLoadBalancer(ctx context.Context, clusterName strin
 	if err != nil {
 		debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", annoLoadBalancerClientTimeout)
 
-		return err
+		return "", "", newConfigurationError(err)
 	}
 
 	connectionLimit, err := parseIntAnnotation(service.Annotations[annoLoadBalancerConnectionLimit], 1000, 1, 20000)
@@ -557,16 +1270,103 @@ func (l LoadBalancers) UpdateLoadBalancer(ctx context.Context, clusterName strin
 	if err != nil {
 		debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", annoLoadBalancerConnectionLimit)
 
-		return err
+		return "", "", newConfigurationError(err)
+	}
+
+	// Resize the server, if the connection limit requires a different package, and the maintenance window allows it.
+	deferredActions := []string{}
+	packageID, err := getPackageIDByService(service, connectionLimit)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	if server.Information.Package.Identifier != packageID {
+		allowed, err := allowMaintenance(l.config, service, maintenanceActionResize)
+
+		if err != nil {
+			return "", "", err
+		}
+
+		if !allowed {
+			deferredActions = append(deferredActions, maintenanceActionResize)
+			packageID = server.Information.Package.Identifier
+		}
+	}
+
+	_, resizeSpan := l.config.Tracer.Start(ctx, "resize_server")
+	// The engines are installed as systemd units of the same name, which is also the case for HAProxy deployed in container mode.
+	err = server.Resize(packageID, engine)
+	resizeSpan.End(err)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to resize the server (name: %s) - Error: %s", loadBalancerName, err.Error())
+
+		return "", "", err
 	}
 
 	enableProxyProtocol, _ := parseBoolAnnotation(service.Annotations[annoLoadBalancerEnableProxyProtocol], false)
+	proxyProtocolPorts, err := parsePortListAnnotation(service.Annotations[annoLoadBalancerProxyProtocolPorts], nil)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", annoLoadBalancerProxyProtocolPorts, loadBalancerName)
+
+		return "", "", newConfigurationError(err)
+	}
+
+	if len(proxyProtocolPorts) > 0 {
+		enableProxyProtocol = true
+	}
+
 	healthCheckInterval, err := parseIntAnnotation(service.Annotations[annoLoadBalancerHealthCheckInterval], 3, 3, 300)
 
 	if err != nil {
 		debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", annoLoadBalancerHealthCheckInterval)
 
-		return err
+		return "", "", newConfigurationError(err)
+	}
+
+	healthCheckPath := service.Annotations[annoLoadBalancerHealthCheckPath]
+
+	if healthCheckPath == "" {
+		healthCheckPath = "/healthz"
+	}
+
+	err = validateHealthCheckPath(healthCheckPath)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", annoLoadBalancerHealthCheckPath, loadBalancerName)
+
+		return "", "", newConfigurationError(err)
+	}
+
+	healthCheckProtocol, err := parseStringAnnotation(
+		service.Annotations[annoLoadBalancerHealthCheckProtocol],
+		loadBalancerProtocolTCP,
+		[]string{loadBalancerProtocolHTTP, loadBalancerProtocolTCP},
+	)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", annoLoadBalancerHealthCheckProtocol, loadBalancerName)
+
+		return "", "", newConfigurationError(err)
+	}
+
+	healthCheckStatus, err := parseIntAnnotation(service.Annotations[annoLoadBalancerHealthCheckStatus], 200, 100, 599)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", annoLoadBalancerHealthCheckStatus, loadBalancerName)
+
+		return "", "", newConfigurationError(err)
+	}
+
+	// Nodes without local endpoints must be taken out of rotation, when the service routes external traffic to local endpoints only.
+	// kube-proxy reports this on the health check node port, which responds with 200 for nodes with local endpoints and 503 for other nodes.
+	healthCheckPort := getHealthCheckNodePort(service)
+
+	if healthCheckPort != 0 {
+		healthCheckProtocol = loadBalancerProtocolHTTP
+		healthCheckStatus = 200
 	}
 
 	healthCheckThresholdHealthy, err := parseIntAnnotation(service.Annotations[annoLoadBalancerHealthCheckThresholdHealthy], 5, 2, 10)
@@ -574,7 +1374,7 @@ func (l LoadBalancers) UpdateLoadBalancer(ctx context.Context, clusterName strin
 	if err != nil {
 		debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", annoLoadBalancerHealthCheckThresholdHealthy)
 
-		return err
+		return "", "", newConfigurationError(err)
 	}
 
 	healthCheckThresholdUnhealthy, err := parseIntAnnotation(service.Annotations[annoLoadBalancerHealthCheckThresholdUnhealthy], 3, 2, 10)
@@ -582,7 +1382,7 @@ func (l LoadBalancers) UpdateLoadBalancer(ctx context.Context, clusterName strin
 	if err != nil {
 		debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", healthCheckThresholdUnhealthy)
 
-		return err
+		return "", "", newConfigurationError(err)
 	}
 
 	healthCheckTimeout, err := parseIntAnnotation(service.Annotations[annoLoadBalancerHealthCheckTimeout], 5, 3, 300)
@@ -590,7 +1390,35 @@ func (l LoadBalancers) UpdateLoadBalancer(ctx context.Context, clusterName strin
 	if err != nil {
 		debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", healthCheckTimeout)
 
-		return err
+		return "", "", newConfigurationError(err)
+	}
+
+	protocol, err := parseStringAnnotation(
+		service.Annotations[annoLoadBalancerProtocol],
+		loadBalancerProtocolTCP,
+		[]string{loadBalancerProtocolHTTP, loadBalancerProtocolTCP},
+	)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", annoLoadBalancerProtocol, loadBalancerName)
+
+		return "", "", newConfigurationError(err)
+	}
+
+	rateLimitConcurrent, err := parseIntAnnotation(service.Annotations[annoLoadBalancerRateLimitConcurrentConnections], 0, 1, 100000)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", annoLoadBalancerRateLimitConcurrentConnections, loadBalancerName)
+
+		return "", "", newConfigurationError(err)
+	}
+
+	rateLimitPerSecond, err := parseIntAnnotation(service.Annotations[annoLoadBalancerRateLimitConnectionsPerSecond], 0, 1, 100000)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", annoLoadBalancerRateLimitConnectionsPerSecond, loadBalancerName)
+
+		return "", "", newConfigurationError(err)
 	}
 
 	serverTimeout, err := parseIntAnnotation(service.Annotations[annoLoadBalancerServerTimeout], 60, 1, 86400)
@@ -598,108 +1426,187 @@ func (l LoadBalancers) UpdateLoadBalancer(ctx context.Context, clusterName strin
 	if err != nil {
 		debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", annoLoadBalancerServerTimeout)
 
-		return err
+		return "", "", newConfigurationError(err)
+	}
+
+	stickySessions, _ := parseBoolAnnotation(service.Annotations[annoLoadBalancerStickySessions], false)
+
+	// The source ranges are enforced by HAProxy in addition to the host firewall, which is not updated for shared load balancers.
+	sourceRanges, err := getServiceSourceRanges(service)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to parse the source ranges (name: %s) - Error: %s", loadBalancerName, err.Error())
+
+		return "", "", newConfigurationError(err)
+	}
+
+	stats, err := getServiceStats(l.config, service)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to configure the stats page (name: %s) - Error: %s", loadBalancerName, err.Error())
+
+		return "", "", err
+	}
+
+	tlsPorts, err := parsePortListAnnotation(service.Annotations[annoLoadBalancerTLSPorts], []int32{443})
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", annoLoadBalancerTLSPorts, loadBalancerName)
+
+		return "", "", newConfigurationError(err)
+	}
+
+	tlsCertificate, err := getServiceTLSCertificate(l.config, service)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to retrieve the TLS certificate (name: %s) - Error: %s", loadBalancerName, err.Error())
+
+		return "", "", err
+	}
+
+	tlsCertificatePath := ""
+
+	if tlsCertificate != "" {
+		tlsCertificatePath = getTLSCertificatePath(service)
+	}
+
+	tunnelTimeout, err := parseIntAnnotation(service.Annotations[annoLoadBalancerTunnelTimeout], 0, 1, 604800)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", annoLoadBalancerTunnelTimeout, loadBalancerName)
+
+		return "", "", newConfigurationError(err)
 	}
 
 	// Generate a new HAProxy configuration file.
 	debugCloudAction(rtLoadBalancers, "Generating new configuration file (name: %s)", loadBalancerName)
 
-	processorCount := getProcessorCountByConnectionLimit(connectionLimit)
-	configFileContents := strings.TrimSpace(fmt.Sprintf(
-		`
-global
-	log /dev/log local0 info alert
-	log /dev/log local1 notice alert
-
-	chroot /var/lib/haproxy
-
-	stats socket /run/haproxy/admin.sock mode 660 level admin expose-fd listeners
-	stats timeout 30s
-
-	user haproxy
-	group haproxy
-
-	ca-base /etc/ssl/certs
-	crt-base /etc/ssl/private
-
-	ssl-default-bind-ciphers ECDH+AESGCM:DH+AESGCM:ECDH+AES256:DH+AES256:ECDH+AES128:DH+AES:RSA+AESGCM:RSA+AES:!aNULL:!MD5:!DSS
-	ssl-default-bind-options no-sslv3
-
-	nbproc %d
-	nbthread 2
-		`,
-		processorCount,
-	))
-
-	configFileContents = configFileContents + "\n\n"
-
-	for i := 1; i <= processorCount; i++ {
-		configFileContents = configFileContents + fmt.Sprintf("\tcpu-map %d %d\n", i, i)
-	}
-
-	configFileContents = configFileContents + "\n"
-	configFileContents = configFileContents + strings.TrimSpace(fmt.Sprintf(
-		`
-defaults
-	balance %s
-	log global
-	maxconn %d
-	mode tcp
-
-	timeout check %ds
-	timeout client %ds
-	timeout connect 5s
-	timeout server %ds
-		`,
-		algorithm,
-		int(connectionLimit/processorCount),
-		healthCheckTimeout,
-		clientTimeout,
-		serverTimeout,
-	))
-
-	configFileContents = configFileContents + "\n\n"
-	serverLineFormat := "\tserver %s:%d %s:%d maxconn %d check inter %d fall %d rise %d"
-
-	if enableProxyProtocol {
-		serverLineFormat = serverLineFormat + " send-proxy"
-	}
-
-	serverLineFormat = serverLineFormat + "\n"
-
-	for _, port := range service.Spec.Ports {
-		configFileContents = configFileContents + strings.TrimSpace(fmt.Sprintf(
-			`
-listen %d
-	bind 0.0.0.0:%d
-
-	option tcp-check
-			`,
-			port.Port,
-			port.Port,
-		))
-
-		configFileContents = configFileContents + "\n\n"
-
-		for _, node := range nodes {
-			for _, address := range node.Status.Addresses {
-				if address.Type != "ExternalIP" {
-					continue
-				}
-
-				configFileContents = configFileContents + fmt.Sprintf(
-					serverLineFormat,
-					address.Address,
-					port.NodePort,
-					address.Address,
-					port.NodePort,
-					int(connectionLimit/processorCount),
-					healthCheckInterval,
-					healthCheckThresholdUnhealthy,
-					healthCheckThresholdHealthy,
-				)
-			}
+	bindCiphers := "ECDH+AESGCM:DH+AESGCM:ECDH+AES256:DH+AES256:ECDH+AES128:DH+AES:RSA+AESGCM:RSA+AES:!aNULL:!MD5:!DSS"
+	bindOptions := "no-sslv3"
+
+	if l.config.StrictCrypto {
+		bindCiphers = haProxyStrictBindCiphers
+		bindOptions = haProxyStrictBindOptions
+	}
+
+	// Cordoned and NotReady nodes are removed from the pool right away instead of waiting for the health checks to fail, which is slow during rolling node replacements.
+	// Nodes in other zones only receive traffic, when every node in the zone of the load balancer is down, if the service requests topology aware routing.
+	backendNodes := filterLoadBalancerNodes(nodes)
+	localNodes, remoteNodes := getTopologyAwareNodes(service, backendNodes, server.Information.Location.Identifier)
+
+	haProxyConfig := HAProxyConfig{
+		Addresses:                     getNodeAddresses(localNodes, loadBalancerName),
+		Algorithm:                     algorithm,
+		Annotations:                   service.Annotations,
+		BackupAddresses:               getNodeAddresses(remoteNodes, loadBalancerName),
+		BindCiphers:                   bindCiphers,
+		BindOptions:                   bindOptions,
+		ClientTimeout:                 clientTimeout,
+		ConnectionLimit:               connectionLimit,
+		DynamicCookieKey:              loadBalancerName,
+		HealthCheckInterval:           healthCheckInterval,
+		HealthCheckPath:               healthCheckPath,
+		HealthCheckPort:               healthCheckPort,
+		HealthCheckProtocol:           healthCheckProtocol,
+		HealthCheckStatus:             healthCheckStatus,
+		HealthCheckThresholdHealthy:   healthCheckThresholdHealthy,
+		HealthCheckThresholdUnhealthy: healthCheckThresholdUnhealthy,
+		HealthCheckTimeout:            healthCheckTimeout,
+		Metrics:                       isMetricsEnabled(service),
+		Ports:                         service.Spec.Ports,
+		ProcessorCount:                getProcessorCountByConnectionLimit(connectionLimit),
+		Protocol:                      protocol,
+		ProxyProtocol:                 enableProxyProtocol,
+		ProxyProtocolPorts:            proxyProtocolPorts,
+		RateLimitConcurrent:           rateLimitConcurrent,
+		RateLimitPerSecond:            rateLimitPerSecond,
+		ServerTimeout:                 serverTimeout,
+		SourceRanges:                  getSortedCIDRs(sourceRanges),
+		Stats:                         stats,
+		StickySessions:                stickySessions,
+		TLSCertificate:                tlsCertificatePath,
+		TLSPorts:                      tlsPorts,
+		TunnelTimeout:                 tunnelTimeout,
+		Weights:                       getNodeWeights(backendNodes, loadBalancerName),
+	}
+
+	if engine == loadBalancerEngineNginx {
+		return l.configureNginxServer(ctx, server, service, nodes, haProxyConfig, deferredActions)
+	}
+
+	haProxyTemplate, err := getHAProxyTemplate(l.config, service)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to retrieve the HAProxy templates (name: %s) - Error: %s", loadBalancerName, err.Error())
+
+		return "", "", newConfigurationError(err)
+	}
+
+	var fragments map[string]string
+
+	if isSharedLoadBalancer(service) {
+		fragments, err = renderHAProxyFragments(haProxyTemplate, haProxyConfig, clusterName, service)
+
+		if err == nil {
+			configFileContents = fragments[haProxyFragmentBase] + fragments[getHAProxyListenFragmentName(clusterName, service)]
 		}
+	} else {
+		configFileContents, err = haProxyConfig.Render(haProxyTemplate)
+	}
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to render the HAProxy configuration (name: %s) - Error: %s", loadBalancerName, err.Error())
+
+		return "", "", newConfigurationError(err)
+	}
+
+	requestedVersion, err := getRequestedHAProxyVersion(service)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	haProxyImage := service.Annotations[annoLoadBalancerHAProxyImage]
+
+	if haProxyImage == "" {
+		haProxyImage = l.config.HAProxyImage
+	}
+
+	err = validateContainerImage(haProxyImage)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", annoLoadBalancerHAProxyImage, loadBalancerName)
+
+		return "", "", newConfigurationError(err)
+	}
+
+	// The server is left untouched, if nothing has changed since the last deployment, as every reload may drop long-lived connections.
+	firewallFingerprint := ""
+
+	if !isSharedLoadBalancer(service) {
+		firewallFingerprint, err = getFirewallFingerprint(l.config, service, nodes)
+
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	deploymentHash := getDeploymentHash(server, configFileContents, tlsCertificate, firewallFingerprint, requestedVersion, haProxyImage, haProxyReloadConf, haProxyContainerService)
+	status := getLoadBalancerStatus(l.config, service)
+
+	if len(deferredActions) == 0 && status[statusKeyDeploymentHash] == deploymentHash {
+		debugCloudAction(rtLoadBalancers, "Skipping the configuration update, as it has already been deployed (name: %s)", loadBalancerName)
+
+		queueMaintenance(l.config, service, deferredActions)
+
+		return configFileContents, status[statusKeyHAProxyVersion], nil
+	}
+
+	// The recorded deployment is invalidated first, as a partially applied deployment must not be mistaken for it later.
+	if status[statusKeyDeploymentHash] != "" {
+		updateLoadBalancerStatus(l.config, service, map[string]string{
+			statusKeyDeploymentHash: "",
+		})
 	}
 
 	// Upload the new configuration file to the server using SFTP.
@@ -710,100 +1617,239 @@ listen %d
 	if err != nil {
 		debugCloudAction(rtLoadBalancers, "Failed to establish SSH connection (name: %s)", loadBalancerName)
 
-		return err
+		return "", "", err
 	}
 
 	defer sshClient.Close()
 
 	debugCloudAction(rtLoadBalancers, "Creating new SFTP client (name: %s)", loadBalancerName)
 
-	sftp, err := sftp.NewClient(sshClient)
+	sftpClient, err := server.SFTP(sshClient)
 
 	if err != nil {
 		debugCloudAction(rtLoadBalancers, "Failed to create new SFTP client (name: %s)", loadBalancerName)
 
-		return err
+		return "", "", err
 	}
 
-	defer sftp.Close()
+	defer sftpClient.Close()
+
+	// The firewall rules of a shared load balancer are left untouched, as the rules of a single cluster would lock out the controllers of the other clusters.
+	if !isSharedLoadBalancer(service) {
+		debugCloudAction(rtLoadBalancers, "Updating the firewall rules (name: %s)", loadBalancerName)
 
-	debugCloudAction(rtLoadBalancers, "Uploading new configuration file (name: %s)", loadBalancerName)
+		_, firewallSpan := l.config.Tracer.Start(ctx, "update_firewall")
+		err = updateFirewall(server, sshClient, sftpClient, service, nodes)
+		firewallSpan.End(err)
 
-	cfgFile, err := sftp.Create("/etc/haproxy/haproxy.cfg")
+		if err != nil {
+			debugCloudAction(rtLoadBalancers, "Failed to update the firewall rules (name: %s) - Error: %s", loadBalancerName, err.Error())
 
-	if err != nil {
-		debugCloudAction(rtLoadBalancers, "Failed to upload the new configuration file (name: %s)", loadBalancerName)
+			return "", "", err
+		}
+	}
 
-		return err
+	if tlsCertificate != "" {
+		debugCloudAction(rtLoadBalancers, "Uploading the TLS certificate (name: %s)", loadBalancerName)
+
+		err = uploadTLSCertificate(server, sshClient, sftpClient, service, tlsCertificate)
+
+		if err != nil {
+			debugCloudAction(rtLoadBalancers, "Failed to upload the TLS certificate (name: %s) - Error: %s", loadBalancerName, err.Error())
+
+			return "", "", err
+		}
 	}
 
-	_, err = cfgFile.Write(bytes.NewBufferString(configFileContents).Bytes())
+	// Load balancers deployed in host mode are upgraded in place, before the new configuration file is validated with the requested version.
+	installedVersion, err := getInstalledHAProxyVersion(server, sshClient)
 
 	if err != nil {
-		cfgFile.Close()
+		debugCloudAction(rtLoadBalancers, "Failed to determine the installed HAProxy version (name: %s) - Error: %s", loadBalancerName, err.Error())
 
-		debugCloudAction(rtLoadBalancers, "Failed to upload the new configuration file (name: %s)", loadBalancerName)
+		return "", "", err
+	}
 
-		return err
+	if requestedVersion != "" && installedVersion != "" && requestedVersion != installedVersion {
+		allowed, err := allowMaintenance(l.config, service, maintenanceActionVersionChange)
+
+		if err != nil {
+			return "", "", err
+		}
+
+		if allowed {
+			err = upgradeLoadBalancerHAProxy(l.config, server, sshClient, service, installedVersion)
+
+			if err != nil {
+				return "", "", err
+			}
+		} else {
+			deferredActions = append(deferredActions, maintenanceActionVersionChange)
+		}
 	}
 
-	cfgFile.Close()
+	// Changes to the backend servers are applied through the runtime API, if they are the only changes, as node autoscaling would otherwise reload HAProxy repeatedly.
+	// The runtime API of a single process cannot update the other processes, which is why HAProxy is reloaded, when it runs multiple processes.
+	runtimeState := getHAProxyRuntimeState(configFileContents, tlsCertificate, haProxyImage, requestedVersion)
+	var runtimeChanges *haProxyRuntimeChanges
 
-	// Reload the HAProxy service now that the configuration file has been updated.
-	debugCloudAction(rtLoadBalancers, "Creating new SSH session (name: %s)", loadBalancerName)
+	if fragments == nil && haProxyConfig.ProcessorCount == 1 {
+		currentState, err := getHAProxyRuntimeStateByServer(server, sshClient)
 
-	sshSession, err := sshClient.NewSession()
+		if err != nil {
+			debugCloudAction(rtLoadBalancers, "Failed to retrieve the state of the running HAProxy process (name: %s) - Error: %s", loadBalancerName, err.Error())
+		} else {
+			runtimeChanges, _ = getHAProxyRuntimeChanges(currentState, runtimeState)
+		}
+	}
+
+	debugCloudAction(rtLoadBalancers, "Uploading new configuration file (name: %s)", loadBalancerName)
+
+	_, uploadSpan := l.config.Tracer.Start(ctx, "upload_config")
+
+	if fragments != nil {
+		name := getHAProxyListenFragmentName(clusterName, service)
+		_, err = assembleHAProxyFragments(server, sshClient, sftpClient, getHAProxyStagingPath(name), fragments, nil)
+	} else {
+		err = server.ReplaceFile(sshClient, sftpClient, pathHAProxyConf, bytes.NewBufferString(configFileContents), fileModeConfig, 0, 0, "haproxy -c -f %s")
+	}
+
+	uploadSpan.End(err)
 
 	if err != nil {
-		debugCloudAction(rtLoadBalancers, "Failed to create new SSH session (name: %s)", loadBalancerName)
+		debugCloudAction(rtLoadBalancers, "Failed to upload the new configuration file (name: %s) - Error: %s", loadBalancerName, err.Error())
 
-		return err
+		return "", "", err
 	}
 
-	defer sshSession.Close()
+	// Reload the HAProxy service now that the configuration file has been updated.
+	debugCloudAction(rtLoadBalancers, "Reloading the HAProxy service (name: %s)", loadBalancerName)
+
+	// Load balancers deployed in container mode are upgraded or rolled back by replacing the image, which requires a restart.
+	_, reloadSpan := l.config.Tracer.Start(ctx, "reload_haproxy")
+	defer func() { reloadSpan.End(err) }()
 
-	_, err = sshSession.CombinedOutput("systemctl reload haproxy")
+	// Replacing the image restarts HAProxy, which drops the active connections, which is why it is deferred until the maintenance window opens.
+	currentImage, err := getHAProxyContainerImage(server, sshClient)
 
 	if err != nil {
-		debugCloudAction(rtLoadBalancers, "Failed to load the new configuration file (name: %s)", loadBalancerName)
+		debugCloudAction(rtLoadBalancers, "Failed to determine the HAProxy container image (name: %s) - Error: %s", loadBalancerName, err.Error())
+
+		return "", "", err
 	}
 
-	return err
-}
+	if currentImage != "" && currentImage != haProxyImage {
+		allowed, err := allowMaintenance(l.config, service, maintenanceActionImageChange)
 
-// EnsureLoadBalancerDeleted deletes the specified load balancer if it exists, returning nil if the load balancer specified either didn't exist or was successfully deleted.
-// This construction is useful because many cloud providers' load balancers have multiple underlying components, meaning a Get could say that the LB doesn't exist even if some part of it is still laying around.
-// Implementations must treat the *v1.Service parameter as read-only and not modify it.
-// Parameter 'clusterName' is the name of the cluster as presented to kube-controller-manager.
-func (l LoadBalancers) EnsureLoadBalancerDeleted(ctx context.Context, clusterName string, service *v1.Service) error {
-	loadBalancerName := getLoadBalancerNameByService(service)
-	hostname := getLoadBalancerHostname(clusterName, loadBalancerName)
+		if err != nil {
+			return "", "", err
+		}
 
-	debugCloudAction(rtLoadBalancers, "Ensuring that load balancer has been deleted (name: %s)", loadBalancerName)
+		if !allowed {
+			deferredActions = append(deferredActions, maintenanceActionImageChange)
+			haProxyImage = currentImage
+		}
+	}
 
-	server := CloudServer{
-		CloudConfiguration: l.config,
+	err = ensureHAProxySeamlessReload(server, sshClient, sftpClient, currentImage != "")
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to enable seamless reloads (name: %s) - Error: %s", loadBalancerName, err.Error())
+
+		return "", "", err
 	}
 
-	notFound, err := server.InitializeByHostname(hostname)
+	imageChanged, err := updateHAProxyContainerImage(server, sshClient, haProxyImage)
 
 	if err != nil {
-		if notFound {
-			return nil
+		debugCloudAction(rtLoadBalancers, "Failed to update the HAProxy container image (name: %s) - Error: %s", loadBalancerName, err.Error())
+
+		return "", "", err
+	}
+
+	timeReload := time.Now()
+	runtimeApplied := false
+
+	if imageChanged {
+		debugCloudAction(rtLoadBalancers, "Restarting the HAProxy service with image '%s' (name: %s)", haProxyImage, loadBalancerName)
+
+		_, err = server.RunCommand(sshClient, "systemctl restart haproxy")
+	} else {
+		if runtimeChanges != nil {
+			debugCloudAction(rtLoadBalancers, "Updating the backend servers through the runtime API (name: %s, added: %d, removed: %d)", loadBalancerName, len(runtimeChanges.Added), len(runtimeChanges.Removed))
+
+			runtimeApplied, err = applyHAProxyRuntimeChanges(server, sshClient, runtimeChanges)
+
+			if err != nil {
+				debugCloudAction(rtLoadBalancers, "Failed to update the backend servers through the runtime API - Reloading instead (name: %s) - Error: %s", loadBalancerName, err.Error())
+
+				runtimeApplied = false
+			}
 		}
 
-		debugCloudAction(rtLoadBalancers, "Failed to determine if load balancer exists (name: %s)", loadBalancerName)
+		if runtimeApplied {
+			err = nil
+		} else {
+			_, err = server.RunCommand(sshClient, "systemctl reload-or-restart haproxy")
+		}
+	}
 
-		return err
+	if runtimeApplied {
+		if len(runtimeChanges.Added) > 0 || len(runtimeChanges.Removed) > 0 {
+			l.config.AuditLog.RecordMutation(server, mutationActionRuntimeUpdate, map[string]string{
+				"added":       strconv.Itoa(len(runtimeChanges.Added)),
+				"config_hash": getConfigHash(configFileContents),
+				"removed":     strconv.Itoa(len(runtimeChanges.Removed)),
+				"service":     fmt.Sprintf("%s/%s", service.Namespace, service.Name),
+			}, nil, timeReload)
+
+			recordServiceEvent(l.config, service, v1.EventTypeNormal, eventReasonLoadBalancerServersUpdated, "Updated the backend servers on server '%s' without a reload (added: %d, removed: %d)", server.Information.Identifier, len(runtimeChanges.Added), len(runtimeChanges.Removed))
+		}
+	} else {
+		l.config.AuditLog.RecordMutation(server, mutationActionConfigReload, map[string]string{
+			"config_hash": getConfigHash(configFileContents),
+			"image":       haProxyImage,
+			"restart":     strconv.FormatBool(imageChanged),
+			"service":     fmt.Sprintf("%s/%s", service.Namespace, service.Name),
+		}, err, timeReload)
+
+		if err != nil {
+			debugCloudAction(rtLoadBalancers, "Failed to load the new configuration file (name: %s)", loadBalancerName)
+
+			return "", "", err
+		}
+
+		recordServiceEvent(l.config, service, v1.EventTypeNormal, eventReasonLoadBalancerConfigReloaded, "Loaded new HAProxy configuration on server '%s'", server.Information.Identifier)
 	}
 
-	err = server.Destroy()
+	// The state is only recorded once it has been loaded, as it determines which changes can be applied through the runtime API.
+	if fragments == nil {
+		stateErr := setHAProxyRuntimeStateByServer(server, sshClient, sftpClient, runtimeState)
 
-	if err != nil {
-		debugCloudAction(rtLoadBalancers, "Failed to destroy load balancer (name: %s)", loadBalancerName)
+		if stateErr != nil {
+			debugCloudAction(rtLoadBalancers, "Failed to record the state of the running HAProxy process (name: %s) - Error: %s", loadBalancerName, stateErr.Error())
+		}
+	}
 
-		return err
+	queueMaintenance(l.config, service, deferredActions)
+
+	// The deployment is not recorded, while actions are deferred, as they must be applied, once the maintenance window opens.
+	if len(deferredActions) > 0 {
+		deploymentHash = ""
 	}
 
-	return nil
+	updateLoadBalancerStatus(l.config, service, map[string]string{
+		statusKeyDeploymentHash: deploymentHash,
+	})
+	verifyKernelTuning(server, sshClient, service)
+
+	version, versionErr := server.RunCommand(sshClient, "haproxy -v | head -n 1")
+
+	if versionErr != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to determine the HAProxy version (name: %s) - Error: %s", loadBalancerName, versionErr.Error())
+
+		version = nil
+	}
+
+	return configFileContents, strings.TrimSpace(string(version)), nil
 }
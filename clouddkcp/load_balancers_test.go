@@ -0,0 +1,374 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/danitso/clouddk-cloud-controller-manager/clouddkcp/mock"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// testClusterName contains the name of the cluster, which is passed to the load balancer methods.
+const testClusterName = "test"
+
+// testLoadBalancerEnvironment contains the test doubles, which a load balancer is deployed to.
+type testLoadBalancerEnvironment struct {
+	API           *mock.API
+	LoadBalancers LoadBalancers
+	SSHServer     *mock.SSHServer
+}
+
+// Close shuts down the test doubles.
+func (e *testLoadBalancerEnvironment) Close() {
+	e.SSHServer.Close()
+	e.API.Close()
+}
+
+// newTestLoadBalancerEnvironment starts the mock API and an SSH server, which every server of the mock API connects to.
+// The SSH server reports the state of the running HAProxy process from the last uploaded state file and supports the runtime API of HAProxy 2.6.
+func newTestLoadBalancerEnvironment(t *testing.T) *testLoadBalancerEnvironment {
+	t.Helper()
+
+	api := mock.NewAPI(testAPIKey)
+	sshServer, err := mock.NewSSHServer()
+
+	if err != nil {
+		api.Close()
+		t.Fatal(err)
+	}
+
+	sshServer.Handle("SSH_CLIENT", func(string) (string, int) {
+		return "127.0.0.1\n", 0
+	})
+	sshServer.Handle("cat "+pathHAProxyRuntimeState, func(string) (string, int) {
+		return getTestUploadedFile(sshServer, path.Base(pathHAProxyRuntimeState)), 0
+	})
+	sshServer.Handle("show info", func(string) (string, int) {
+		return "Name: HAProxy\nVersion: 2.6.16\n", 0
+	})
+	sshServer.Handle("haproxy -v", func(string) (string, int) {
+		return "HAProxy version 2.6.16 2023/10/25\n", 0
+	})
+
+	config := newTestCloudConfiguration(t, api)
+	config.SSHDial = sshServer.Dial
+
+	return &testLoadBalancerEnvironment{
+		API:           api,
+		LoadBalancers: newLoadBalancers(config).(LoadBalancers),
+		SSHServer:     sshServer,
+	}
+}
+
+// newTestNode creates a ready node with an external IP address.
+func newTestNode(name string, address string) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{
+				{Type: "ExternalIP", Address: address},
+			},
+			Conditions: []v1.NodeCondition{
+				{Type: v1.NodeReady, Status: v1.ConditionTrue},
+			},
+		},
+	}
+}
+
+// newTestService creates a service of type LoadBalancer, which exposes a single TCP port.
+func newTestService() *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "default",
+			UID:       types.UID("8f0b9e3c-5b8a-4a4e-9a35-0d8e4b1f2c11"),
+		},
+		Spec: v1.ServiceSpec{
+			Type: v1.ServiceTypeLoadBalancer,
+			Ports: []v1.ServicePort{
+				{Port: 80, NodePort: 30080, Protocol: v1.ProtocolTCP},
+			},
+		},
+	}
+}
+
+// countTestCommands counts the commands executed on the SSH server, which contain the specified string.
+func countTestCommands(commands []string, substring string) int {
+	count := 0
+
+	for _, c := range commands {
+		if strings.Contains(c, substring) {
+			count++
+		}
+	}
+
+	return count
+}
+
+// getTestUploadedFile retrieves the contents of the last file with the specified name, which has been uploaded to the SSH server.
+// Files are uploaded to temporary paths of the form /tmp/.<name>.<random>.tmp, before they are moved into place.
+func getTestUploadedFile(sshServer *mock.SSHServer, name string) string {
+	contents := ""
+
+	for _, f := range sshServer.Files() {
+		base := strings.TrimSuffix(strings.TrimPrefix(path.Base(f.Path), "."), ".tmp")
+
+		if i := strings.LastIndex(base, "."); i > 0 && base[:i] == name {
+			contents = f.Contents
+		}
+	}
+
+	return contents
+}
+
+func TestEnsureLoadBalancer(t *testing.T) {
+	env := newTestLoadBalancerEnvironment(t)
+	defer env.Close()
+
+	service := newTestService()
+	nodes := []*v1.Node{newTestNode("k8s-node-1", "192.0.2.10")}
+
+	status, err := env.LoadBalancers.EnsureLoadBalancer(context.Background(), testClusterName, service, nodes)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(status.Ingress) != 1 || status.Ingress[0].IP != env.API.ServerAddress {
+		t.Errorf("EnsureLoadBalancer returned the ingress points %v, expected %s", status.Ingress, env.API.ServerAddress)
+	}
+
+	hostname := getLoadBalancerHostname(testClusterName, getLoadBalancerNameByService(service))
+	servers := env.API.Servers()
+
+	if len(servers) != 1 || servers[0].Hostname != hostname {
+		t.Fatalf("The mock API contains the servers %v, expected a single server with hostname '%s'", servers, hostname)
+	}
+
+	creates := 0
+
+	for _, r := range env.API.Requests() {
+		if r.Method == "POST" && r.Path == "cloudservers" {
+			creates++
+
+			if !strings.Contains(r.Body, hostname) {
+				t.Errorf("The server was created without the hostname '%s' - Body: %s", hostname, r.Body)
+			}
+		} else if r.Method != "GET" {
+			t.Errorf("Unexpected request %s /%s", r.Method, r.Path)
+		}
+	}
+
+	if creates != 1 {
+		t.Errorf("The server was created %d times, expected once", creates)
+	}
+
+	configFileContents := getTestUploadedFile(env.SSHServer, path.Base(pathHAProxyConf))
+
+	for _, line := range []string{"listen 80", "bind 0.0.0.0:80", "server 192.0.2.10:30080 192.0.2.10:30080"} {
+		if !strings.Contains(configFileContents, line) {
+			t.Errorf("The HAProxy configuration does not contain '%s'\n%s", line, configFileContents)
+		}
+	}
+
+	commands := env.SSHServer.Commands()
+
+	for _, command := range []string{
+		"/bin/bash /tmp/clouddk_server_provisioner.sh",
+		"/bin/bash /tmp/clouddk_load_balancer_provisioner.sh",
+		"/usr/local/sbin/clouddk-firewall",
+		"haproxy -c -f",
+		"systemctl reload-or-restart haproxy",
+	} {
+		if countTestCommands(commands, command) == 0 {
+			t.Errorf("The command '%s' was not executed", command)
+		}
+	}
+
+	if getTestUploadedFile(env.SSHServer, path.Base(pathHAProxyRuntimeState)) == "" {
+		t.Error("The state of the running HAProxy process was not recorded")
+	}
+}
+
+func TestEnsureLoadBalancerExisting(t *testing.T) {
+	env := newTestLoadBalancerEnvironment(t)
+	defer env.Close()
+
+	service := newTestService()
+	nodes := []*v1.Node{newTestNode("k8s-node-1", "192.0.2.10")}
+
+	_, err := env.LoadBalancers.EnsureLoadBalancer(context.Background(), testClusterName, service, nodes)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = env.LoadBalancers.EnsureLoadBalancer(context.Background(), testClusterName, service, nodes)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if servers := env.API.Servers(); len(servers) != 1 {
+		t.Errorf("The mock API contains %d servers, expected the existing load balancer to be reused", len(servers))
+	}
+
+	if count := countTestCommands(env.SSHServer.Commands(), "/bin/bash /tmp/clouddk_load_balancer_provisioner.sh"); count != 1 {
+		t.Errorf("The load balancer was provisioned %d times, expected once", count)
+	}
+}
+
+func TestEnsureLoadBalancerAPIFailure(t *testing.T) {
+	env := newTestLoadBalancerEnvironment(t)
+	defer env.Close()
+
+	env.API.Fail("POST", "cloudservers", http.StatusUnprocessableEntity, 1)
+
+	_, err := env.LoadBalancers.EnsureLoadBalancer(context.Background(), testClusterName, newTestService(), []*v1.Node{newTestNode("k8s-node-1", "192.0.2.10")})
+
+	if err == nil {
+		t.Fatal("EnsureLoadBalancer did not return the error of the API")
+	}
+
+	if servers := env.API.Servers(); len(servers) != 0 {
+		t.Errorf("The mock API contains the servers %v, expected none", servers)
+	}
+
+	if commands := env.SSHServer.Commands(); len(commands) != 0 {
+		t.Errorf("The commands %v were executed, although the server was not created", commands)
+	}
+}
+
+func TestUpdateLoadBalancer(t *testing.T) {
+	env := newTestLoadBalancerEnvironment(t)
+	defer env.Close()
+
+	service := newTestService()
+
+	_, err := env.LoadBalancers.EnsureLoadBalancer(context.Background(), testClusterName, service, []*v1.Node{newTestNode("k8s-node-1", "192.0.2.10")})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloads := countTestCommands(env.SSHServer.Commands(), "systemctl reload-or-restart haproxy")
+	nodes := []*v1.Node{
+		newTestNode("k8s-node-1", "192.0.2.10"),
+		newTestNode("k8s-node-2", "192.0.2.11"),
+	}
+
+	err = env.LoadBalancers.UpdateLoadBalancer(context.Background(), testClusterName, service, nodes)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commands := env.SSHServer.Commands()
+
+	if countTestCommands(commands, "add server 80/192.0.2.11:30080 192.0.2.11:30080") != 1 {
+		t.Errorf("The new node was not added through the runtime API - Commands: %v", commands)
+	}
+
+	if countTestCommands(commands, "systemctl reload-or-restart haproxy") != reloads {
+		t.Error("HAProxy was reloaded, although only the backend servers changed")
+	}
+
+	configFileContents := getTestUploadedFile(env.SSHServer, path.Base(pathHAProxyConf))
+
+	if !strings.Contains(configFileContents, "server 192.0.2.11:30080 192.0.2.11:30080") {
+		t.Errorf("The HAProxy configuration does not contain the new node\n%s", configFileContents)
+	}
+
+	if !strings.Contains(getTestUploadedFile(env.SSHServer, path.Base(pathHAProxyRuntimeState)), "192.0.2.11:30080") {
+		t.Error("The state of the running HAProxy process does not contain the new node")
+	}
+
+	// Ports are not part of the runtime API, which is why HAProxy must be reloaded, when they change.
+	service.Spec.Ports = append(service.Spec.Ports, v1.ServicePort{Port: 443, NodePort: 30443, Protocol: v1.ProtocolTCP})
+
+	err = env.LoadBalancers.UpdateLoadBalancer(context.Background(), testClusterName, service, nodes)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if countTestCommands(env.SSHServer.Commands(), "systemctl reload-or-restart haproxy") != reloads+1 {
+		t.Error("HAProxy was not reloaded after adding a port")
+	}
+
+	if servers := env.API.Servers(); len(servers) != 1 {
+		t.Errorf("The mock API contains %d servers, expected the load balancer to be updated in place", len(servers))
+	}
+}
+
+func TestEnsureLoadBalancerDeleted(t *testing.T) {
+	env := newTestLoadBalancerEnvironment(t)
+	defer env.Close()
+
+	node := newTestNodeServer(env.API, "k8s-node-1", "192.0.2.10")
+	service := newTestService()
+
+	_, err := env.LoadBalancers.EnsureLoadBalancer(context.Background(), testClusterName, service, []*v1.Node{newTestNode("k8s-node-1", "192.0.2.10")})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	servers := env.API.Servers()
+
+	if len(servers) != 2 {
+		t.Fatalf("The mock API contains %d servers, expected the node and the load balancer", len(servers))
+	}
+
+	loadBalancerID := servers[1].Identifier
+
+	err = env.LoadBalancers.EnsureLoadBalancerDeleted(context.Background(), testClusterName, service)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := env.API.Server(loadBalancerID); ok {
+		t.Error("The server of the load balancer was not destroyed")
+	}
+
+	if _, ok := env.API.Server(node.Identifier); !ok {
+		t.Error("The server of the node was destroyed")
+	}
+
+	deletes := []string{}
+
+	for _, r := range env.API.Requests() {
+		if r.Method == "DELETE" {
+			deletes = append(deletes, r.Path)
+		}
+	}
+
+	if len(deletes) != 1 || deletes[0] != "cloudservers/"+loadBalancerID {
+		t.Errorf("The mock API received the deletions %v, expected only cloudservers/%s", deletes, loadBalancerID)
+	}
+
+	// Deleting a load balancer, which no longer exists, must succeed without further deletions.
+	err = env.LoadBalancers.EnsureLoadBalancerDeleted(context.Background(), testClusterName, service)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range env.API.Requests() {
+		if r.Method == "DELETE" && r.Path != deletes[0] {
+			t.Errorf("Unexpected request %s /%s", r.Method, r.Path)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// LoadBalancerLocks serializes the operations on each load balancer, while operations on different load balancers run concurrently.
+// This prevents the node syncer and the service controller from pushing configurations to the same load balancer at the same time.
+type LoadBalancerLocks struct {
+	locks map[string]*loadBalancerLock
+	mutex sync.Mutex
+}
+
+// loadBalancerLock describes the lock of a single load balancer.
+type loadBalancerLock struct {
+	mutex sync.Mutex
+	refs  int
+}
+
+// newLoadBalancerLocks initializes a new LoadBalancerLocks object.
+func newLoadBalancerLocks() *LoadBalancerLocks {
+	return &LoadBalancerLocks{
+		locks: map[string]*loadBalancerLock{},
+	}
+}
+
+// Lock acquires the lock of the load balancer for a service and returns a function, which releases it.
+func (l *LoadBalancerLocks) Lock(service *v1.Service) func() {
+	if l == nil {
+		return func() {}
+	}
+
+	key := getLoadBalancerNameByService(service)
+
+	l.mutex.Lock()
+
+	lock, ok := l.locks[key]
+
+	if !ok {
+		lock = &loadBalancerLock{}
+		l.locks[key] = lock
+	}
+
+	lock.refs++
+	l.mutex.Unlock()
+
+	lock.mutex.Lock()
+
+	return func() {
+		lock.mutex.Unlock()
+
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+
+		lock.refs--
+
+		if lock.refs == 0 {
+			delete(l.locks, key)
+		}
+	}
+}
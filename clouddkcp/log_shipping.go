@@ -0,0 +1,118 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/MakeNowJust/heredoc"
+)
+
+const (
+	// annoLoadBalancerLogShippingEndpoint is the annotation specifying the endpoint, which receives the HAProxy and system logs of a load balancer.
+	// The endpoint must be a URL with the scheme forward, http, https or syslog.
+	// Defaults to the value of the environment variable CLOUDDK_LOG_SHIPPING_ENDPOINT.
+	annoLoadBalancerLogShippingEndpoint = "kubernetes.cloud.dk/load-balancer-log-shipping-endpoint"
+
+	pathFluentBitConf = "/etc/fluent-bit/fluent-bit.conf"
+)
+
+var (
+	fluentBitInputs = heredoc.Doc(`
+		[SERVICE]
+		    Flush        5
+		    Log_Level    warn
+
+		[INPUT]
+		    Name         tail
+		    Path         /var/log/haproxy.log
+		    Tag          haproxy
+		    DB           /var/lib/fluent-bit/haproxy.db
+
+		[INPUT]
+		    Name         systemd
+		    Tag          system
+		    DB           /var/lib/fluent-bit/systemd.db
+	`)
+)
+
+// getFluentBitConf retrieves the Fluent Bit configuration, which forwards logs to the specified endpoint.
+func getFluentBitConf(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+
+	if err != nil {
+		return "", fmt.Errorf("The log shipping endpoint '%s' is invalid - Error: %s", endpoint, err.Error())
+	}
+
+	host := u.Hostname()
+	port := u.Port()
+
+	if host == "" {
+		return "", fmt.Errorf("The log shipping endpoint '%s' does not specify a host", endpoint)
+	}
+
+	err = validateConfigToken(host)
+
+	if err != nil {
+		return "", fmt.Errorf("The log shipping endpoint '%s' is invalid - Error: %s", endpoint, err.Error())
+	}
+
+	var output strings.Builder
+
+	output.WriteString("[OUTPUT]\n")
+	output.WriteString("    Name         ")
+
+	switch u.Scheme {
+	case "forward":
+		if port == "" {
+			port = "24224"
+		}
+
+		output.WriteString("forward\n")
+	case "http", "https":
+		if port == "" {
+			port = "80"
+
+			if u.Scheme == "https" {
+				port = "443"
+			}
+		}
+
+		uri := u.RequestURI()
+		err = validateConfigToken(uri)
+
+		if err != nil {
+			return "", fmt.Errorf("The log shipping endpoint '%s' is invalid - Error: %s", endpoint, err.Error())
+		}
+
+		output.WriteString("http\n")
+		output.WriteString("    Format       json\n")
+		output.WriteString(fmt.Sprintf("    URI          %s\n", uri))
+
+		if u.Scheme == "https" {
+			output.WriteString("    tls          On\n")
+			output.WriteString("    tls.verify   On\n")
+		}
+	case "syslog":
+		if port == "" {
+			port = "514"
+		}
+
+		output.WriteString("syslog\n")
+		output.WriteString("    Mode         tcp\n")
+		output.WriteString("    Syslog_Format rfc5424\n")
+		output.WriteString("    Syslog_Message_Key log\n")
+	default:
+		return "", fmt.Errorf("The log shipping endpoint '%s' uses an unsupported scheme '%s'", endpoint, u.Scheme)
+	}
+
+	output.WriteString("    Match        *\n")
+	output.WriteString(fmt.Sprintf("    Host         %s\n", host))
+	output.WriteString(fmt.Sprintf("    Port         %s\n", port))
+
+	return fluentBitInputs + "\n" + output.String(), nil
+}
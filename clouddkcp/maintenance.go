@@ -0,0 +1,362 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// annoLoadBalancerMaintenanceWindow is the annotation specifying the maintenance window for disruptive changes to a load balancer.
+	// The value is a cron expression with the fields minute, hour, day of month, month and day of week, which matches every minute of the window in UTC.
+	// Defaults to no maintenance window, which allows disruptive changes at any time.
+	annoLoadBalancerMaintenanceWindow = "kubernetes.cloud.dk/load-balancer-maintenance-window"
+
+	// maintenanceActionImageChange specifies the disruptive action, which restarts HAProxy with a different container image.
+	maintenanceActionImageChange = "HAProxy image change"
+
+	// maintenanceActionResize specifies the disruptive action, which resizes the server of a load balancer.
+	maintenanceActionResize = "server resize"
+
+	// maintenanceActionVersionChange specifies the disruptive action, which restarts HAProxy with a different package version.
+	maintenanceActionVersionChange = "HAProxy version change"
+
+	// maintenanceQueueInterval specifies the interval between checks for queued changes, whose maintenance window has opened.
+	maintenanceQueueInterval = time.Minute
+
+	// maintenanceWindowSearchLimit specifies how far into the future the start of a maintenance window is searched for.
+	maintenanceWindowSearchLimit = 366 * 24 * time.Hour
+)
+
+// MaintenanceQueue keeps track of the load balancers with disruptive changes, which have been deferred until their maintenance window opens.
+type MaintenanceQueue struct {
+	entries map[string]MaintenanceQueueEntry
+	mutex   sync.Mutex
+}
+
+// MaintenanceQueueEntry describes the deferred changes to a load balancer.
+type MaintenanceQueueEntry struct {
+	Actions []string
+	Opens   time.Time
+}
+
+// MaintenanceWindow describes the minutes, during which disruptive changes are allowed.
+type MaintenanceWindow struct {
+	daysOfMonth map[int]bool
+	daysOfWeek  map[int]bool
+	hours       map[int]bool
+	minutes     map[int]bool
+	months      map[int]bool
+}
+
+// newMaintenanceQueue initializes a new MaintenanceQueue object.
+func newMaintenanceQueue() *MaintenanceQueue {
+	return &MaintenanceQueue{
+		entries: map[string]MaintenanceQueueEntry{},
+	}
+}
+
+// Add queues the deferred changes to the load balancer of a service and returns whether they differ from the queued changes.
+func (q *MaintenanceQueue) Add(service *v1.Service, actions []string, opens time.Time) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	key := getServiceKey(service)
+	entry, ok := q.entries[key]
+
+	q.entries[key] = MaintenanceQueueEntry{
+		Actions: actions,
+		Opens:   opens,
+	}
+
+	return !ok || strings.Join(entry.Actions, ",") != strings.Join(actions, ",")
+}
+
+// Due retrieves the keys of the services, whose maintenance window has opened.
+func (q *MaintenanceQueue) Due(now time.Time) []string {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	keys := []string{}
+
+	for key, entry := range q.entries {
+		if !now.Before(entry.Opens) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Remove removes the deferred changes to the load balancer of a service.
+func (q *MaintenanceQueue) Remove(service *v1.Service) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	delete(q.entries, getServiceKey(service))
+}
+
+// Contains determines whether a point in time is inside the maintenance window.
+func (w *MaintenanceWindow) Contains(t time.Time) bool {
+	t = t.UTC()
+
+	return w.minutes[t.Minute()] &&
+		w.hours[t.Hour()] &&
+		w.daysOfMonth[t.Day()] &&
+		w.months[int(t.Month())] &&
+		w.daysOfWeek[int(t.Weekday())]
+}
+
+// Next retrieves the start of the next minute inside the maintenance window.
+// The zero time is returned, if the window does not open within a year.
+func (w *MaintenanceWindow) Next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute)
+
+	for limit := t.Add(maintenanceWindowSearchLimit); t.Before(limit); t = t.Add(time.Minute) {
+		if !w.months[int(t.Month())] || !w.daysOfMonth[t.Day()] || !w.daysOfWeek[int(t.Weekday())] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), 23, 59, 0, 0, time.UTC)
+
+			continue
+		}
+
+		if w.Contains(t) {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// allowMaintenance determines whether a disruptive change to the load balancer of a service is allowed at this point in time.
+// Disallowed changes must be queued with queueMaintenance in order to be applied, once the maintenance window opens.
+func allowMaintenance(c *CloudConfiguration, service *v1.Service, action string) (bool, error) {
+	window, err := getMaintenanceWindow(service)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", annoLoadBalancerMaintenanceWindow, getLoadBalancerNameByService(service))
+
+		return false, newConfigurationError(err)
+	}
+
+	if window == nil || window.Contains(time.Now()) {
+		return true, nil
+	}
+
+	debugCloudAction(rtLoadBalancers, "Deferring %s until the maintenance window opens (name: %s)", action, getLoadBalancerNameByService(service))
+
+	return false, nil
+}
+
+// getMaintenanceWindow retrieves the maintenance window of a service.
+// A nil object is returned, if the service does not specify a maintenance window.
+func getMaintenanceWindow(service *v1.Service) (*MaintenanceWindow, error) {
+	value := strings.TrimSpace(service.Annotations[annoLoadBalancerMaintenanceWindow])
+
+	if value == "" {
+		return nil, nil
+	}
+
+	return parseMaintenanceWindow(value)
+}
+
+// parseMaintenanceWindow parses a cron expression with the fields minute, hour, day of month, month and day of week.
+// Every field accepts wildcards, values, ranges and steps separated by commas, e.g. "* 2-4 * * 0,6" for 02:00 to 04:59 on weekends.
+func parseMaintenanceWindow(value string) (*MaintenanceWindow, error) {
+	fields := strings.Fields(value)
+
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("Expected 5 fields but found %d in maintenance window '%s'", len(fields), value)
+	}
+
+	minutes, err := parseMaintenanceWindowField(fields[0], 0, 59)
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid minute field '%s' - Error: %s", fields[0], err.Error())
+	}
+
+	hours, err := parseMaintenanceWindowField(fields[1], 0, 23)
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid hour field '%s' - Error: %s", fields[1], err.Error())
+	}
+
+	daysOfMonth, err := parseMaintenanceWindowField(fields[2], 1, 31)
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid day of month field '%s' - Error: %s", fields[2], err.Error())
+	}
+
+	months, err := parseMaintenanceWindowField(fields[3], 1, 12)
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid month field '%s' - Error: %s", fields[3], err.Error())
+	}
+
+	daysOfWeek, err := parseMaintenanceWindowField(fields[4], 0, 7)
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid day of week field '%s' - Error: %s", fields[4], err.Error())
+	}
+
+	// Sunday may be specified as either 0 or 7.
+	if daysOfWeek[7] {
+		daysOfWeek[0] = true
+	}
+
+	return &MaintenanceWindow{
+		daysOfMonth: daysOfMonth,
+		daysOfWeek:  daysOfWeek,
+		hours:       hours,
+		minutes:     minutes,
+		months:      months,
+	}, nil
+}
+
+// parseMaintenanceWindowField parses a single field of a cron expression.
+func parseMaintenanceWindowField(field string, minValue int, maxValue int) (map[int]bool, error) {
+	values := map[int]bool{}
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		rangeValue := part
+
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+
+			if err != nil || s < 1 {
+				return nil, fmt.Errorf("Invalid step '%s'", part[i+1:])
+			}
+
+			step = s
+			rangeValue = part[:i]
+		}
+
+		first := minValue
+		last := maxValue
+
+		if rangeValue != "*" {
+			bounds := strings.SplitN(rangeValue, "-", 2)
+			v, err := strconv.Atoi(bounds[0])
+
+			if err != nil {
+				return nil, fmt.Errorf("Invalid value '%s'", bounds[0])
+			}
+
+			first = v
+			last = v
+
+			if len(bounds) == 2 {
+				v, err = strconv.Atoi(bounds[1])
+
+				if err != nil {
+					return nil, fmt.Errorf("Invalid value '%s'", bounds[1])
+				}
+
+				last = v
+			} else if step > 1 {
+				last = maxValue
+			}
+		}
+
+		if first < minValue || last > maxValue || first > last {
+			return nil, fmt.Errorf("Invalid range %d-%d - Expected values between %d and %d", first, last, minValue, maxValue)
+		}
+
+		for v := first; v <= last; v += step {
+			values[v] = true
+		}
+	}
+
+	return values, nil
+}
+
+// queueMaintenance queues the deferred changes to the load balancer of a service and emits an event, when the changes differ from the queued changes.
+// The queue is cleared, when the load balancer has been updated without deferring any changes.
+func queueMaintenance(c *CloudConfiguration, service *v1.Service, actions []string) {
+	if c.MaintenanceQueue == nil {
+		return
+	}
+
+	if len(actions) == 0 {
+		c.MaintenanceQueue.Remove(service)
+
+		return
+	}
+
+	window, err := getMaintenanceWindow(service)
+
+	if err != nil || window == nil {
+		return
+	}
+
+	opens := window.Next(time.Now())
+
+	if opens.IsZero() {
+		debugCloudAction(rtLoadBalancers, "The maintenance window does not open within a year (name: %s)", getLoadBalancerNameByService(service))
+
+		return
+	}
+
+	if c.MaintenanceQueue.Add(service, actions, opens) {
+		recordServiceEvent(c, service, v1.EventTypeNormal, eventReasonLoadBalancerMaintenancePending, "Deferred %s until the maintenance window opens at %s", strings.Join(actions, " and "), opens.Format(time.RFC3339))
+	}
+}
+
+// startMaintenanceQueue updates the load balancers with deferred changes, once their maintenance window opens, until the stop channel closes.
+func startMaintenanceQueue(c *CloudConfiguration, syncer *NodeSyncer, stop <-chan struct{}) {
+	if c.MaintenanceQueue == nil || syncer == nil {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(maintenanceQueueInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				keys := c.MaintenanceQueue.Due(now)
+
+				if len(keys) == 0 {
+					continue
+				}
+
+				nodes, err := syncer.getNodes()
+
+				if err != nil {
+					debugCloudAction(rtLoadBalancers, "Failed to list nodes - Error: %s", err.Error())
+
+					continue
+				}
+
+				due := map[string]bool{}
+
+				for _, key := range keys {
+					due[key] = true
+				}
+
+				for _, entry := range c.LoadBalancerRegistry.List() {
+					if !due[entry.Namespace+"/"+entry.ServiceName] {
+						continue
+					}
+
+					debugCloudAction(rtLoadBalancers, "Applying deferred changes in the maintenance window (name: %s)", entry.LoadBalancerName)
+					syncer.update(entry, nodes)
+				}
+			}
+		}
+	}()
+}
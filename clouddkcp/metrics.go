@@ -0,0 +1,201 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// metricsNamespace specifies the namespace used for all metrics exported by the cloud provider.
+	metricsNamespace = "clouddk"
+
+	// operationEnsure specifies the operation label value for EnsureLoadBalancer.
+	operationEnsure = "ensure"
+
+	// operationEnsureDeleted specifies the operation label value for EnsureLoadBalancerDeleted.
+	operationEnsureDeleted = "ensure_deleted"
+
+	// operationUpdate specifies the operation label value for UpdateLoadBalancer.
+	operationUpdate = "update"
+
+	// resultFailure specifies the result label value for failed operations.
+	resultFailure = "failure"
+
+	// resultSuccess specifies the result label value for successful operations.
+	resultSuccess = "success"
+)
+
+var (
+	apiCircuitBreakerOpen = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "api",
+			Name:      "circuit_breaker_open",
+			Help:      "Whether requests to the Cloud.dk API have been suspended by the circuit breaker.",
+		},
+	)
+
+	apiRateLimit = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "api",
+			Name:      "rate_limit",
+			Help:      "Number of requests per second, which the adaptive rate limiter currently allows against the Cloud.dk API.",
+		},
+	)
+
+	haProxyServerUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "haproxy",
+			Name:      "server_up",
+			Help:      "Whether a server in an HAProxy proxy on a load balancer passes its health checks.",
+		},
+		[]string{"namespace", "service", "proxy", "server"},
+	)
+
+	haProxyStatsProbeDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "stats_monitor",
+			Name:      "probe_duration_seconds",
+			Help:      "Duration of the HAProxy stats probes performed by the background monitor.",
+			Buckets:   prometheus.ExponentialBuckets(0.25, 2, 8),
+		},
+		[]string{"result"},
+	)
+
+	haProxyStatsProbesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "stats_monitor",
+			Name:      "probes_total",
+			Help:      "Number of HAProxy stats probes performed by the background monitor.",
+		},
+		[]string{"result"},
+	)
+
+	haProxyStatsRunsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "stats_monitor",
+			Name:      "runs_total",
+			Help:      "Number of completed runs of the background monitor.",
+		},
+	)
+
+	loadBalancerErrorsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "load_balancer",
+			Name:      "errors_total",
+			Help:      "Number of failed load balancer operations by error class.",
+		},
+		[]string{"operation", "class"},
+	)
+
+	loadBalancerOperationDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "load_balancer",
+			Name:      "operation_duration_seconds",
+			Help:      "Duration of load balancer operations per service.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+		},
+		[]string{"operation", "namespace", "service", "result"},
+	)
+
+	loadBalancerOperationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "load_balancer",
+			Name:      "operations_total",
+			Help:      "Number of load balancer operations per service.",
+		},
+		[]string{"operation", "namespace", "service", "result"},
+	)
+
+	loadBalancersRegistered = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "load_balancer",
+			Name:      "registered",
+			Help:      "Number of load balancers reconciled by this controller and watched by the background monitor.",
+		},
+	)
+
+	loadBalancerTimeToReady = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "load_balancer",
+			Name:      "time_to_ready_seconds",
+			Help:      "Time from service creation until the load balancer was first reported as ready.",
+		},
+		[]string{"namespace", "service"},
+	)
+
+	traceSpanDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "trace",
+			Name:      "span_duration_seconds",
+			Help:      "Duration of the traced steps of the provisioning pipeline.",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 2, 14),
+		},
+		[]string{"span", "result"},
+	)
+)
+
+// init registers the metrics with the default registry, which is served by the controller manager.
+func init() {
+	prometheus.MustRegister(
+		apiCircuitBreakerOpen,
+		apiRateLimit,
+		haProxyServerUp,
+		haProxyStatsProbeDuration,
+		haProxyStatsProbesTotal,
+		haProxyStatsRunsTotal,
+		loadBalancerErrorsTotal,
+		loadBalancerOperationDuration,
+		loadBalancerOperationsTotal,
+		loadBalancerTimeToReady,
+		loadBalancersRegistered,
+		traceSpanDuration,
+	)
+}
+
+// deleteLoadBalancerMetrics removes the provisioning metrics for a service which no longer has a load balancer.
+func deleteLoadBalancerMetrics(service *v1.Service) {
+	for _, operation := range []string{operationEnsure, operationUpdate} {
+		for _, result := range []string{resultFailure, resultSuccess} {
+			loadBalancerOperationDuration.DeleteLabelValues(operation, service.Namespace, service.Name, result)
+			loadBalancerOperationsTotal.DeleteLabelValues(operation, service.Namespace, service.Name, result)
+		}
+	}
+
+	loadBalancerTimeToReady.DeleteLabelValues(service.Namespace, service.Name)
+}
+
+// observeLoadBalancerOperation records the duration and outcome of a load balancer operation.
+func observeLoadBalancerOperation(operation string, service *v1.Service, timeStart time.Time, err *error) {
+	result := resultSuccess
+
+	if *err != nil {
+		result = resultFailure
+
+		loadBalancerErrorsTotal.WithLabelValues(operation, getErrorClass(*err)).Inc()
+	}
+
+	loadBalancerOperationDuration.WithLabelValues(operation, service.Namespace, service.Name, result).Observe(time.Since(timeStart).Seconds())
+	loadBalancerOperationsTotal.WithLabelValues(operation, service.Namespace, service.Name, result).Inc()
+
+	if operation == operationEnsure && *err == nil && len(service.Status.LoadBalancer.Ingress) == 0 && !service.CreationTimestamp.IsZero() {
+		loadBalancerTimeToReady.WithLabelValues(service.Namespace, service.Name).Set(time.Since(service.CreationTimestamp.Time).Seconds())
+	}
+}
@@ -0,0 +1,238 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// LoadBalancerMigration describes the migration of a load balancer to a new server.
+type LoadBalancerMigration struct {
+	ClusterName string
+	DrainPeriod time.Duration
+	KeepOld     bool
+	TemplateID  string
+}
+
+// migrateLoadBalancer replaces the server of a load balancer with a new server, which uses the OS template of the migration and the package of the service.
+// The replacement is provisioned and configured under a temporary hostname and verified, before the hostnames are swapped and the old server is destroyed.
+// Cloud.dk cannot move IP addresses between servers, which is why the ingress status of the service is updated to the addresses of the replacement.
+func migrateLoadBalancer(ctx context.Context, c *CloudConfiguration, service *v1.Service, m LoadBalancerMigration, w io.Writer) error {
+	if isSharedLoadBalancer(service) {
+		return errors.New("Shared load balancers cannot be migrated, as the server is co-managed by other clusters")
+	}
+
+	if hasRequestedLoadBalancerIP(service) {
+		return errors.New("Load balancers with a requested IP address cannot be migrated, as Cloud.dk cannot move IP addresses between servers")
+	}
+
+	loadBalancerName := getLoadBalancerNameByService(service)
+	hostname := getLoadBalancerHostname(m.ClusterName, loadBalancerName)
+
+	oldServer := &CloudServer{
+		CloudConfiguration: c,
+	}
+
+	_, err := oldServer.InitializeByHostname(hostname)
+
+	if err != nil {
+		return fmt.Errorf("Failed to retrieve the load balancer of service '%s/%s' - Error: %s", service.Namespace, service.Name, err.Error())
+	}
+
+	connectionLimit, err := parseIntAnnotation(service.Annotations[annoLoadBalancerConnectionLimit], 1000, 1, 20000)
+
+	if err != nil {
+		return newConfigurationError(err)
+	}
+
+	nodes, err := getLoadBalancerNodes(c)
+
+	if err != nil {
+		return err
+	}
+
+	// Provision the replacement under a temporary hostname, which prevents the controller from managing it before it is ready.
+	newServer := &CloudServer{
+		CloudConfiguration: c,
+	}
+
+	newServer.SSHKeyPair, err = ensureServiceSSHKeyPair(c, service)
+
+	if err != nil {
+		return err
+	}
+
+	packageID, err := getPackageIDByService(service, connectionLimit)
+
+	if err != nil {
+		return err
+	}
+
+	templateID := m.TemplateID
+
+	if templateID == "" {
+		templateID, err = getServerTemplateByService(c, service)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(w, "Creating replacement server (package: %s, template: %s)\n", packageID, templateID)
+
+	err = newServer.Create(ctx, oldServer.Information.Location.Identifier, packageID, templateID, hostname+hostnameSuffixReplacement)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create the replacement server - Error: %s", err.Error())
+	}
+
+	fmt.Fprintf(w, "Provisioning replacement server '%s'\n", newServer.Information.Identifier)
+
+	err = configureLoadBalancer(ctx, c, newServer, service)
+
+	if err != nil {
+		return fmt.Errorf("Failed to provision the replacement server - Error: %s", err.Error())
+	}
+
+	_, _, err = LoadBalancers{config: c}.configureLoadBalancerServer(ctx, m.ClusterName, newServer, service, nodes)
+
+	if err == nil {
+		err = verifyLoadBalancerHealth(newServer, service)
+	}
+
+	if err != nil {
+		newServer.Destroy()
+
+		return fmt.Errorf("Failed to configure the replacement server - Error: %s", err.Error())
+	}
+
+	// Swap the hostnames, which causes the controller to manage the replacement from now on.
+	fmt.Fprintf(w, "Swapping server '%s' for server '%s'\n", oldServer.Information.Identifier, newServer.Information.Identifier)
+
+	err = oldServer.Rename(hostname + hostnameSuffixRetired)
+
+	if err != nil {
+		newServer.Destroy()
+
+		return err
+	}
+
+	err = newServer.Rename(hostname)
+
+	if err != nil {
+		oldServer.Rename(hostname)
+		newServer.Destroy()
+
+		return err
+	}
+
+	c.ServerList.Invalidate()
+	c.ResponseCache.Invalidate("cloudservers")
+
+	updateLoadBalancerStatus(c, service, map[string]string{
+		statusKeyIPAddresses: getServerIPAddresses(newServer),
+		statusKeyServerID:    newServer.Information.Identifier,
+	})
+
+	err = updateLoadBalancerIngress(c, service, getLoadBalancerIngresses(newServer, service))
+
+	if err != nil {
+		return fmt.Errorf("Failed to update the ingress status of the service - Error: %s", err.Error())
+	}
+
+	if service.Annotations[annoLoadBalancerID] != "" {
+		err = setLoadBalancerIDAnnotation(c, service, newServer.Information.Identifier)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(w, "Service '%s/%s' now uses the IP addresses %s\n", service.Namespace, service.Name, getServerIPAddresses(newServer))
+
+	if m.KeepOld {
+		fmt.Fprintf(w, "Keeping server '%s' (hostname: %s)\n", oldServer.Information.Identifier, oldServer.Information.Hostname)
+
+		return nil
+	}
+
+	// Allow clients to pick up the new addresses, before the old server stops accepting connections.
+	if m.DrainPeriod > 0 {
+		fmt.Fprintf(w, "Draining server '%s' for %s\n", oldServer.Information.Identifier, m.DrainPeriod)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(m.DrainPeriod):
+		}
+	}
+
+	fmt.Fprintf(w, "Destroying server '%s'\n", oldServer.Information.Identifier)
+
+	return oldServer.Destroy()
+}
+
+// updateLoadBalancerIngress replaces the ingress status of a service.
+func updateLoadBalancerIngress(c *CloudConfiguration, service *v1.Service, ingresses []v1.LoadBalancerIngress) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current, err := c.KubeClient.CoreV1().Services(service.Namespace).Get(context.Background(), service.Name, metav1.GetOptions{})
+
+		if err != nil {
+			return err
+		}
+
+		current.Status.LoadBalancer.Ingress = ingresses
+
+		_, err = c.KubeClient.CoreV1().Services(service.Namespace).UpdateStatus(context.Background(), current, metav1.UpdateOptions{})
+
+		return err
+	})
+}
+
+// verifyLoadBalancerHealth verifies that the engine of a service is running on a server and listening on every port of the service.
+func verifyLoadBalancerHealth(server *CloudServer, service *v1.Service) error {
+	engine, err := getLoadBalancerEngine(service)
+
+	if err != nil {
+		return err
+	}
+
+	sshClient, err := server.SSH()
+
+	if err != nil {
+		return err
+	}
+
+	defer sshClient.Close()
+
+	output, err := server.RunCommand(sshClient, fmt.Sprintf("systemctl is-active %s", engine))
+
+	if err != nil {
+		return fmt.Errorf("The unit '%s' is not active on server '%s' - Output: %s - Error: %s", engine, server.Information.Identifier, strings.TrimSpace(string(output)), err.Error())
+	}
+
+	for _, port := range service.Spec.Ports {
+		if port.Protocol != "" && port.Protocol != v1.ProtocolTCP {
+			continue
+		}
+
+		_, err = server.RunCommand(sshClient, fmt.Sprintf("ss -ltn | grep -q ':%d '", port.Port))
+
+		if err != nil {
+			return fmt.Errorf("HAProxy is not listening on port %d on server '%s'", port.Port, server.Information.Identifier)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,401 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+// Package mock provides test doubles for the Cloud.dk API and the SSH servers managed by the cloud controller manager.
+package mock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danitso/terraform-provider-clouddk/clouddk"
+)
+
+// API is an in-memory mock of the Cloud.dk API endpoints used by the cloud controller manager.
+// It supports the server, log, location and package endpoints as well as injected failures.
+type API struct {
+	// Key specifies the API key, which must be sent with every request.
+	Key string
+
+	// Locations contains the response for the location endpoint.
+	Locations clouddk.LocationListBody
+
+	// Packages contains the response for the package endpoint.
+	Packages clouddk.PackageeListBody
+
+	// ServerAddress specifies the IP address assigned to new servers.
+	ServerAddress string
+
+	failures []APIFailure
+	logs     map[string]clouddk.LogsListBody
+	mutex    sync.Mutex
+	next     int
+	requests []APIRequest
+	server   *httptest.Server
+	servers  map[string]clouddk.ServerBody
+}
+
+// APIFailure describes a failure, which is injected into the responses of the mock API.
+type APIFailure struct {
+	// Count specifies the number of requests, which will fail.
+	Count int
+
+	// Method specifies the HTTP method of the requests, which will fail.
+	Method string
+
+	// Path specifies the path of the requests, which will fail, without a leading slash.
+	Path string
+
+	// StatusCode specifies the HTTP status code of the failed responses.
+	StatusCode int
+}
+
+// APIRequest describes a request received by the mock API.
+type APIRequest struct {
+	Body   string
+	Method string
+	Path   string
+}
+
+// NewAPI starts a new mock API, which requires the specified API key.
+// The endpoint must be passed to the cloud controller manager with the environment variable CLOUDDK_API_ENDPOINT.
+func NewAPI(key string) *API {
+	a := &API{
+		Key: key,
+		Locations: clouddk.LocationListBody{
+			{Identifier: "dk1", Name: "Copenhagen"},
+		},
+		Packages: clouddk.PackageeListBody{
+			{Identifier: "89833c1dfa7010", Name: "Small"},
+			{Identifier: "e991abd8ef15c7", Name: "Medium"},
+			{Identifier: "9559dbb4b71c45", Name: "Large"},
+		},
+		ServerAddress: "127.0.0.1",
+		logs:          map[string]clouddk.LogsListBody{},
+		servers:       map[string]clouddk.ServerBody{},
+	}
+
+	a.server = httptest.NewServer(http.HandlerFunc(a.serveHTTP))
+
+	return a
+}
+
+// AddServer adds an existing server to the mock API, such as the server backing a node, and returns it with an identifier.
+func (a *API) AddServer(server clouddk.ServerBody) clouddk.ServerBody {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if server.Identifier == "" {
+		a.next++
+		server.Identifier = fmt.Sprintf("%012x", a.next)
+	}
+
+	a.servers[server.Identifier] = server
+
+	return server
+}
+
+// Close shuts down the mock API.
+func (a *API) Close() {
+	a.server.Close()
+}
+
+// Endpoint returns the endpoint of the mock API.
+func (a *API) Endpoint() string {
+	return a.server.URL
+}
+
+// Fail causes the next requests matching the method and path to fail with the specified status code.
+func (a *API) Fail(method string, path string, statusCode int, count int) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.failures = append(a.failures, APIFailure{
+		Count:      count,
+		Method:     method,
+		Path:       path,
+		StatusCode: statusCode,
+	})
+}
+
+// Requests returns the requests received by the mock API in order.
+func (a *API) Requests() []APIRequest {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return append([]APIRequest{}, a.requests...)
+}
+
+// Server returns the server with the specified identifier.
+func (a *API) Server(id string) (clouddk.ServerBody, bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	server, ok := a.servers[id]
+
+	return server, ok
+}
+
+// Servers returns the servers sorted by identifier.
+func (a *API) Servers() clouddk.ServerListBody {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return a.getServers("")
+}
+
+// SetLogs replaces the logs of a server, which makes it possible to simulate pending actions.
+func (a *API) SetLogs(id string, logs clouddk.LogsListBody) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.logs[id] = logs
+}
+
+// createServer handles a request to create a new server.
+func (a *API) createServer(w http.ResponseWriter, body []byte) {
+	createBody := clouddk.ServerCreateBody{}
+	err := json.Unmarshal(body, &createBody)
+
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	a.next++
+
+	id := fmt.Sprintf("%012x", a.next)
+	server := clouddk.ServerBody{
+		Identifier: id,
+		Hostname:   createBody.Hostname,
+		Label:      createBody.Label,
+		CPUs:       1,
+		Memory:     1024,
+		Booted:     true,
+		NetworkInterfaces: clouddk.NetworkInterfaceListBody{
+			{
+				Identifier: id + "-nic",
+				Primary:    true,
+				IPAddresses: clouddk.IPAddressListBody{
+					{Address: a.ServerAddress, NetworkInterfaceIdentifier: id + "-nic"},
+				},
+			},
+		},
+		Template: clouddk.TemplateBody{Identifier: createBody.Template, Name: createBody.Template},
+		Location: a.getLocation(createBody.Location),
+		Package:  a.getPackage(createBody.Package),
+	}
+
+	a.servers[id] = server
+	a.logs[id] = append(a.logs[id], newLogEntry(len(a.logs[id])+1, "create"))
+
+	writeJSON(w, http.StatusOK, server)
+}
+
+// getFailure retrieves the status code of an injected failure for a request, or zero if the request must not fail.
+func (a *API) getFailure(method string, path string) int {
+	for i, f := range a.failures {
+		if f.Count <= 0 || f.Method != method || f.Path != path {
+			continue
+		}
+
+		a.failures[i].Count--
+
+		return f.StatusCode
+	}
+
+	return 0
+}
+
+// getLocation retrieves the location with the specified identifier.
+func (a *API) getLocation(id string) clouddk.LocationBody {
+	for _, v := range a.Locations {
+		if v.Identifier == id {
+			return v
+		}
+	}
+
+	return clouddk.LocationBody{Identifier: id, Name: id}
+}
+
+// getPackage retrieves the package with the specified identifier.
+func (a *API) getPackage(id string) clouddk.PackageBody {
+	for _, v := range a.Packages {
+		if v.Identifier == id {
+			return v
+		}
+	}
+
+	return clouddk.PackageBody{Identifier: id, Name: id}
+}
+
+// getServers retrieves the servers sorted by identifier, optionally filtered by hostname.
+func (a *API) getServers(hostname string) clouddk.ServerListBody {
+	servers := clouddk.ServerListBody{}
+
+	for _, v := range a.servers {
+		if hostname == "" || v.Hostname == hostname {
+			servers = append(servers, v)
+		}
+	}
+
+	sort.Slice(servers, func(i, j int) bool {
+		return servers[i].Identifier < servers[j].Identifier
+	})
+
+	return servers
+}
+
+// serveHTTP dispatches a request to the handler of the endpoint.
+func (a *API) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	path := strings.Trim(r.URL.Path, "/")
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.requests = append(a.requests, APIRequest{
+		Body:   string(body),
+		Method: r.Method,
+		Path:   path,
+	})
+
+	if r.Header.Get("X-Api-Key") != a.Key {
+		writeError(w, http.StatusUnauthorized, "Invalid API key")
+
+		return
+	}
+
+	if statusCode := a.getFailure(r.Method, path); statusCode != 0 {
+		writeError(w, statusCode, fmt.Sprintf("Injected failure (HTTP %d)", statusCode))
+
+		return
+	}
+
+	segments := strings.Split(path, "/")
+
+	switch {
+	case path == "locations" && r.Method == "GET":
+		writeJSON(w, http.StatusOK, a.Locations)
+	case path == "packages" && r.Method == "GET":
+		writeJSON(w, http.StatusOK, a.Packages)
+	case path == "cloudservers" && r.Method == "GET":
+		writeJSON(w, http.StatusOK, a.getServers(r.URL.Query().Get("hostname")))
+	case path == "cloudservers" && r.Method == "POST":
+		a.createServer(w, body)
+	case segments[0] == "cloudservers" && len(segments) == 2:
+		a.serveServer(w, r.Method, segments[1], body)
+	case segments[0] == "cloudservers" && len(segments) == 3 && segments[2] == "logs" && r.Method == "GET":
+		if _, ok := a.servers[segments[1]]; !ok {
+			writeError(w, http.StatusNotFound, "CloudServer not found")
+
+			return
+		}
+
+		writeJSON(w, http.StatusOK, append(clouddk.LogsListBody{}, a.logs[segments[1]]...))
+	case segments[0] == "cloudservers" && len(segments) == 3 && segments[2] == "upgrade" && r.Method == "POST":
+		a.upgradeServer(w, segments[1], body)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("No route for %s /%s", r.Method, path))
+	}
+}
+
+// serveServer handles a request for a single server.
+func (a *API) serveServer(w http.ResponseWriter, method string, id string, body []byte) {
+	server, ok := a.servers[id]
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "CloudServer not found")
+
+		return
+	}
+
+	switch method {
+	case "DELETE":
+		delete(a.logs, id)
+		delete(a.servers, id)
+
+		writeJSON(w, http.StatusOK, server)
+	case "GET":
+		writeJSON(w, http.StatusOK, server)
+	case "PUT":
+		updateBody := clouddk.ServerUpdateBody{}
+		err := json.Unmarshal(body, &updateBody)
+
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+
+			return
+		}
+
+		server.Hostname = updateBody.Hostname
+		server.Label = updateBody.Label
+		a.servers[id] = server
+
+		writeJSON(w, http.StatusOK, server)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Sprintf("Method %s is not allowed", method))
+	}
+}
+
+// upgradeServer handles a request to change the package of a server.
+func (a *API) upgradeServer(w http.ResponseWriter, id string, body []byte) {
+	server, ok := a.servers[id]
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "CloudServer not found")
+
+		return
+	}
+
+	upgradeBody := clouddk.ServerUpgradeBody{}
+	err := json.Unmarshal(body, &upgradeBody)
+
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	server.Package = a.getPackage(upgradeBody.Package)
+	a.servers[id] = server
+	a.logs[id] = append(a.logs[id], newLogEntry(len(a.logs[id])+1, "upgrade"))
+
+	writeJSON(w, http.StatusOK, server)
+}
+
+// newLogEntry creates a log entry for a completed action.
+func newLogEntry(id int, action string) clouddk.LogsBody {
+	return clouddk.LogsBody{
+		Identifier: clouddk.CustomInt(id),
+		Action:     action,
+		Status:     "completed",
+		TargetType: "cloudserver",
+		CreatedAt:  time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// writeError writes an error response in the format used by the Cloud.dk API.
+func writeError(w http.ResponseWriter, statusCode int, message string) {
+	writeJSON(w, statusCode, clouddk.ErrorBody{
+		Message: message,
+		Status:  clouddk.CustomInt(statusCode),
+	})
+}
+
+// writeJSON writes a JSON response.
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	json.NewEncoder(w).Encode(v)
+}
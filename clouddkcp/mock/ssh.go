@@ -0,0 +1,308 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package mock
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHCommandHandler produces the output and exit status of a command executed on the SSH server.
+type SSHCommandHandler func(command string) (output string, exitStatus int)
+
+// SSHFile describes a file, which has been written over SFTP.
+type SSHFile struct {
+	Contents string
+	Path     string
+}
+
+// SSHServer is an SSH and SFTP test double, which accepts every client and records the commands and file uploads.
+// Commands succeed without output, unless a handler has been registered for them, and files are stored in memory.
+//
+// The SFTP server of the vendored SFTP package does not support the posix-rename extension, which is why the cloud
+// controller manager must connect as an unprivileged user, which moves uploaded files into place with commands.
+type SSHServer struct {
+	// HostKey contains the host key of the server.
+	HostKey ssh.Signer
+
+	commands []string
+	files    []SSHFile
+	handlers map[string]SSHCommandHandler
+	listener net.Listener
+	mutex    sync.Mutex
+}
+
+// sftpRecorder records the files written over SFTP.
+type sftpRecorder struct {
+	sftp.FileWriter
+
+	server *SSHServer
+}
+
+// sftpRecordingWriter captures the contents written to a file.
+type sftpRecordingWriter struct {
+	io.WriterAt
+
+	contents []byte
+	mutex    sync.Mutex
+	path     string
+	server   *SSHServer
+}
+
+// NewSSHServer starts a new SSH server on a random port on the loopback interface.
+func NewSSHServer() (*SSHServer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	hostKey, err := ssh.NewSignerFromKey(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SSHServer{
+		HostKey:  hostKey,
+		handlers: map[string]SSHCommandHandler{},
+		listener: listener,
+	}
+
+	// The host keys are read by the cloud controller manager in order to verify the key presented during the initial connection.
+	s.Handle("/etc/ssh/ssh_host_", func(command string) (string, int) {
+		return string(ssh.MarshalAuthorizedKey(hostKey.PublicKey())), 0
+	})
+
+	go s.serve()
+
+	return s, nil
+}
+
+// Address returns the address of the SSH server.
+func (s *SSHServer) Address() string {
+	return s.listener.Addr().String()
+}
+
+// Close shuts down the SSH server.
+func (s *SSHServer) Close() error {
+	return s.listener.Close()
+}
+
+// Commands returns the commands executed on the SSH server in order.
+func (s *SSHServer) Commands() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]string{}, s.commands...)
+}
+
+// Dial connects to the SSH server regardless of the address, which makes it possible to use the server for every server of the mock API.
+// It must be assigned to CloudConfiguration.SSHDial.
+func (s *SSHServer) Dial(network string, address string) (net.Conn, error) {
+	return net.Dial("tcp", s.Address())
+}
+
+// Files returns the files written over SFTP in order.
+func (s *SSHServer) Files() []SSHFile {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]SSHFile{}, s.files...)
+}
+
+// Handle registers a handler for the commands, which contain the specified string.
+func (s *SSHServer) Handle(substring string, handler SSHCommandHandler) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.handlers[substring] = handler
+}
+
+// execute runs a command with the matching handler and records it.
+func (s *SSHServer) execute(command string) (string, int) {
+	s.mutex.Lock()
+	s.commands = append(s.commands, command)
+
+	var handler SSHCommandHandler
+
+	for k, v := range s.handlers {
+		if strings.Contains(command, k) {
+			handler = v
+
+			break
+		}
+	}
+
+	s.mutex.Unlock()
+
+	if handler == nil {
+		return "", 0
+	}
+
+	return handler(command)
+}
+
+// handleChannel serves a session channel.
+func (s *SSHServer) handleChannel(newChannel ssh.NewChannel) {
+	if newChannel.ChannelType() != "session" {
+		newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+
+		return
+	}
+
+	channel, requests, err := newChannel.Accept()
+
+	if err != nil {
+		return
+	}
+
+	defer channel.Close()
+
+	for req := range requests {
+		switch req.Type {
+		case "exec":
+			command := ""
+
+			if len(req.Payload) >= 4 {
+				length := binary.BigEndian.Uint32(req.Payload)
+
+				if int(length) <= len(req.Payload)-4 {
+					command = string(req.Payload[4 : 4+length])
+				}
+			}
+
+			req.Reply(true, nil)
+
+			output, exitStatus := s.execute(command)
+
+			io.WriteString(channel, output)
+
+			status := make([]byte, 4)
+			binary.BigEndian.PutUint32(status, uint32(exitStatus))
+
+			channel.SendRequest("exit-status", false, status)
+
+			return
+		case "subsystem":
+			if len(req.Payload) < 4 || string(req.Payload[4:]) != "sftp" {
+				req.Reply(false, nil)
+
+				continue
+			}
+
+			req.Reply(true, nil)
+
+			handlers := sftp.InMemHandler()
+			handlers.FileCmd.Filecmd(sftp.NewRequest("Mkdir", "/tmp"))
+			handlers.FilePut = sftpRecorder{handlers.FilePut, s}
+
+			sftp.NewRequestServer(channel, handlers).Serve()
+
+			return
+		default:
+			if req.WantReply {
+				req.Reply(req.Type == "env" || req.Type == "pty-req", nil)
+			}
+		}
+	}
+}
+
+// serve accepts connections until the listener is closed.
+func (s *SSHServer) serve() {
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+
+	config.AddHostKey(s.HostKey)
+
+	for {
+		conn, err := s.listener.Accept()
+
+		if err != nil {
+			return
+		}
+
+		go func() {
+			serverConn, channels, requests, err := ssh.NewServerConn(conn, config)
+
+			if err != nil {
+				conn.Close()
+
+				return
+			}
+
+			defer serverConn.Close()
+
+			go ssh.DiscardRequests(requests)
+
+			for newChannel := range channels {
+				go s.handleChannel(newChannel)
+			}
+		}()
+	}
+}
+
+// Filewrite returns a writer, which records the contents of the file, once it is closed.
+func (r sftpRecorder) Filewrite(req *sftp.Request) (io.WriterAt, error) {
+	w, err := r.FileWriter.Filewrite(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &sftpRecordingWriter{WriterAt: w, path: req.Filepath, server: r.server}, nil
+}
+
+// Close records the contents of the file.
+func (w *sftpRecordingWriter) Close() error {
+	w.mutex.Lock()
+	contents := string(w.contents)
+	w.mutex.Unlock()
+
+	w.server.mutex.Lock()
+	w.server.files = append(w.server.files, SSHFile{Contents: contents, Path: w.path})
+	w.server.mutex.Unlock()
+
+	if c, ok := w.WriterAt.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+// WriteAt writes to the file and the recorded contents.
+func (w *sftpRecordingWriter) WriteAt(p []byte, off int64) (int, error) {
+	w.mutex.Lock()
+
+	if end := int(off) + len(p); end > len(w.contents) {
+		w.contents = append(w.contents, make([]byte, end-len(w.contents))...)
+	}
+
+	copy(w.contents[off:], p)
+	w.mutex.Unlock()
+
+	return w.WriterAt.WriteAt(p, off)
+}
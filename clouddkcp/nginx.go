@@ -0,0 +1,324 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/MakeNowJust/heredoc"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// annoLoadBalancerEngine is the annotation specifying the software, which forwards the traffic on the Load Balancer.
+	// Options are haproxy and nginx.
+	// Defaults to haproxy.
+	annoLoadBalancerEngine = "kubernetes.cloud.dk/load-balancer-engine"
+
+	// loadBalancerEngineHAProxy specifies that HAProxy forwards the traffic.
+	loadBalancerEngineHAProxy = "haproxy"
+
+	// loadBalancerEngineNginx specifies that the stream module of nginx forwards the traffic, which also supports UDP.
+	loadBalancerEngineNginx = "nginx"
+
+	pathNginxConf = "/etc/nginx/nginx.conf"
+)
+
+var (
+	// nginxConfigTemplate renders the nginx configuration file with an NginxConfig object as context.
+	nginxConfigTemplate = template.Must(template.New("nginx.conf").Parse(heredoc.Doc(`
+		include /etc/nginx/modules-enabled/*.conf;
+
+		user www-data;
+		pid /run/nginx.pid;
+		worker_processes {{ .ProcessorCount }};
+		worker_rlimit_nofile 1048576;
+
+		error_log /var/log/nginx/error.log warn;
+
+		events {
+			worker_connections {{ .WorkerConnections }};
+		}
+
+		stream {
+			proxy_connect_timeout 5s;
+			proxy_timeout {{ .ProxyTimeout }}s;
+		{{ range $server := .Servers }}
+			upstream {{ .Name }} {
+		{{- with $.Algorithm }}
+				{{ . }};
+		{{- end }}
+		{{- range .Addresses }}
+				server {{ . }}:{{ $server.NodePort }}{{ $server.ServerOptions }}{{ with index $server.Weights . }} weight={{ . }}{{ end }};
+		{{- end }}
+		{{- range .BackupAddresses }}
+				server {{ . }}:{{ $server.NodePort }}{{ $server.ServerOptions }}{{ with index $server.Weights . }} weight={{ . }}{{ end }} backup;
+		{{- end }}
+			}
+
+			server {
+				listen {{ .Port }}{{ if .UDP }} udp{{ end }};
+		{{- range $.SourceRanges }}
+				allow {{ . }};
+		{{- end }}
+		{{- if $.SourceRanges }}
+				deny all;
+		{{- end }}
+				proxy_pass {{ .Name }};
+		{{- if .ProxyProtocol }}
+				proxy_protocol on;
+		{{- end }}
+			}
+		{{ end -}}
+		}
+	`)))
+
+	// nginxUnsupportedAnnotations lists the annotations, which rely on features of HAProxy, that the stream module of nginx does not provide.
+	nginxUnsupportedAnnotations = []string{
+		annoLoadBalancerEnableMetrics,
+		annoLoadBalancerEnableStats,
+		annoLoadBalancerRateLimitConcurrentConnections,
+		annoLoadBalancerRateLimitConnectionsPerSecond,
+		annoLoadBalancerSharedName,
+		annoLoadBalancerStickySessions,
+		annoLoadBalancerTemplate,
+		annoLoadBalancerTLSSecret,
+	}
+)
+
+// NginxConfig describes the values, which are rendered into the nginx configuration file of a load balancer.
+type NginxConfig struct {
+	Algorithm         string
+	ProcessorCount    int
+	ProxyTimeout      int
+	Servers           []NginxServer
+	SourceRanges      []string
+	WorkerConnections int
+}
+
+// NginxServer describes a 'server' block and its upstream, which forward a port of the service to the nodes.
+type NginxServer struct {
+	Addresses       []string
+	BackupAddresses []string
+	FailTimeout     int
+	MaxFails        int
+	Name            string
+	NodePort        int32
+	Port            int32
+	ProxyProtocol   bool
+	UDP             bool
+	Weights         map[string]int
+}
+
+// ServerOptions returns the options, which are identical for every backend server of the upstream.
+// The stream module only supports passive health checks, which is why a server is considered unavailable for the time, which HAProxy would need to consider it healthy again.
+func (s NginxServer) ServerOptions() string {
+	return fmt.Sprintf(" max_fails=%d fail_timeout=%ds", s.MaxFails, s.FailTimeout)
+}
+
+// getLoadBalancerEngine retrieves the software, which forwards the traffic on the load balancer of a service.
+// Annotations, which rely on features of HAProxy, are rejected for the other engines.
+func getLoadBalancerEngine(service *v1.Service) (string, error) {
+	engine, err := parseStringAnnotation(
+		service.Annotations[annoLoadBalancerEngine],
+		loadBalancerEngineHAProxy,
+		[]string{loadBalancerEngineHAProxy, loadBalancerEngineNginx},
+	)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", annoLoadBalancerEngine, getLoadBalancerNameByService(service))
+
+		return "", newConfigurationError(err)
+	}
+
+	if engine != loadBalancerEngineNginx {
+		return engine, nil
+	}
+
+	for _, anno := range nginxUnsupportedAnnotations {
+		if value := service.Annotations[anno]; value != "" && value != "false" {
+			return "", newConfigurationError(fmt.Errorf("The annotation '%s' is not supported by the engine '%s'", anno, engine))
+		}
+	}
+
+	if service.Annotations[annoLoadBalancerHAProxyDeployment] == haProxyDeploymentContainer {
+		return "", newConfigurationError(fmt.Errorf("The engine '%s' does not support the deployment mode '%s'", engine, haProxyDeploymentContainer))
+	}
+
+	if service.Annotations[annoLoadBalancerProtocol] == loadBalancerProtocolHTTP || service.Annotations[annoLoadBalancerHealthCheckProtocol] == loadBalancerProtocolHTTP {
+		return "", newConfigurationError(fmt.Errorf("The engine '%s' only supports the protocol '%s'", engine, loadBalancerProtocolTCP))
+	}
+
+	return engine, nil
+}
+
+// newNginxConfig converts the settings of a load balancer to an nginx configuration.
+func newNginxConfig(h HAProxyConfig) NginxConfig {
+	config := NginxConfig{
+		ProcessorCount:    h.ProcessorCount,
+		ProxyTimeout:      h.ServerTimeout,
+		SourceRanges:      h.SourceRanges,
+		WorkerConnections: h.MaxConn() * 2,
+	}
+
+	if h.ClientTimeout > config.ProxyTimeout {
+		config.ProxyTimeout = h.ClientTimeout
+	}
+
+	if h.TunnelTimeout > 0 {
+		config.ProxyTimeout = h.TunnelTimeout
+	}
+
+	addresses := h.Addresses
+	backupAddresses := h.BackupAddresses
+
+	switch h.Algorithm {
+	case "leastconn":
+		config.Algorithm = "least_conn"
+	case "source":
+		// Backup servers cannot be combined with hashing, which is why they receive traffic like the other servers.
+		config.Algorithm = "hash $remote_addr consistent"
+		addresses = append(append([]string{}, addresses...), backupAddresses...)
+		backupAddresses = nil
+	}
+
+	for _, port := range h.Ports {
+		udp := port.Protocol == v1.ProtocolUDP
+
+		config.Servers = append(config.Servers, NginxServer{
+			Addresses:       addresses,
+			BackupAddresses: backupAddresses,
+			FailTimeout:     h.HealthCheckInterval * h.HealthCheckThresholdHealthy,
+			MaxFails:        h.HealthCheckThresholdUnhealthy,
+			Name:            fmt.Sprintf("port_%d_%s", port.Port, strings.ToLower(string(port.Protocol))),
+			NodePort:        port.NodePort,
+			Port:            port.Port,
+			ProxyProtocol:   !udp && h.ProxyProtocol && (len(h.ProxyProtocolPorts) == 0 || containsPort(h.ProxyProtocolPorts, port.Port)),
+			UDP:             udp,
+			Weights:         h.Weights,
+		})
+	}
+
+	return config
+}
+
+// Render generates the contents of the nginx configuration file.
+func (n NginxConfig) Render() (string, error) {
+	b := strings.Builder{}
+	err := nginxConfigTemplate.Execute(&b, n)
+
+	if err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// configureNginxServer renders the nginx configuration of a service, uploads it to a server together with the firewall rules and reloads nginx.
+// The rendered configuration and the nginx version are returned in order to record the success of the operation.
+func (l LoadBalancers) configureNginxServer(ctx context.Context, server *CloudServer, service *v1.Service, nodes []*v1.Node, haProxyConfig HAProxyConfig, deferredActions []string) (configFileContents string, nginxVersion string, err error) {
+	loadBalancerName := getLoadBalancerNameByService(service)
+	configFileContents, err = newNginxConfig(haProxyConfig).Render()
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to render the nginx configuration (name: %s) - Error: %s", loadBalancerName, err.Error())
+
+		return "", "", newConfigurationError(err)
+	}
+
+	debugCloudAction(rtLoadBalancers, "Establishing SSH connection (name: %s)", loadBalancerName)
+
+	sshClient, err := server.SSH()
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to establish SSH connection (name: %s)", loadBalancerName)
+
+		return "", "", err
+	}
+
+	defer sshClient.Close()
+
+	debugCloudAction(rtLoadBalancers, "Creating new SFTP client (name: %s)", loadBalancerName)
+
+	sftpClient, err := server.SFTP(sshClient)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to create new SFTP client (name: %s)", loadBalancerName)
+
+		return "", "", err
+	}
+
+	defer sftpClient.Close()
+
+	// The engine is selected, when the server is provisioned, which is why a server running HAProxy must be replaced.
+	_, err = server.RunCommand(sshClient, "test -x /usr/sbin/nginx")
+
+	if err != nil {
+		return "", "", newConfigurationError(fmt.Errorf("The engine '%s' has not been installed on server '%s' - Replace the server with 'lb migrate' in order to change the engine", loadBalancerEngineNginx, server.Information.Identifier))
+	}
+
+	debugCloudAction(rtLoadBalancers, "Updating the firewall rules (name: %s)", loadBalancerName)
+
+	_, firewallSpan := l.config.Tracer.Start(ctx, "update_firewall")
+	err = updateFirewall(server, sshClient, sftpClient, service, nodes)
+	firewallSpan.End(err)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to update the firewall rules (name: %s) - Error: %s", loadBalancerName, err.Error())
+
+		return "", "", err
+	}
+
+	debugCloudAction(rtLoadBalancers, "Uploading new configuration file (name: %s)", loadBalancerName)
+
+	_, uploadSpan := l.config.Tracer.Start(ctx, "upload_config")
+	err = server.ReplaceFile(sshClient, sftpClient, pathNginxConf, bytes.NewBufferString(configFileContents), fileModeConfig, 0, 0, "nginx -q -t -c %s")
+	uploadSpan.End(err)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to upload the new configuration file (name: %s) - Error: %s", loadBalancerName, err.Error())
+
+		return "", "", err
+	}
+
+	debugCloudAction(rtLoadBalancers, "Reloading the nginx service (name: %s)", loadBalancerName)
+
+	_, reloadSpan := l.config.Tracer.Start(ctx, "reload_nginx")
+	timeReload := time.Now()
+	_, err = server.RunCommand(sshClient, "systemctl reload-or-restart nginx")
+	reloadSpan.End(err)
+
+	l.config.AuditLog.RecordMutation(server, mutationActionConfigReload, map[string]string{
+		"config_hash": getConfigHash(configFileContents),
+		"engine":      loadBalancerEngineNginx,
+		"restart":     "false",
+		"service":     fmt.Sprintf("%s/%s", service.Namespace, service.Name),
+	}, err, timeReload)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to load the new configuration file (name: %s)", loadBalancerName)
+
+		return "", "", err
+	}
+
+	recordServiceEvent(l.config, service, v1.EventTypeNormal, eventReasonLoadBalancerConfigReloaded, "Loaded new nginx configuration on server '%s'", server.Information.Identifier)
+	queueMaintenance(l.config, service, deferredActions)
+	verifyKernelTuning(server, sshClient, service)
+
+	version, versionErr := server.RunCommand(sshClient, "nginx -v 2>&1")
+
+	if versionErr != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to determine the nginx version (name: %s) - Error: %s", loadBalancerName, versionErr.Error())
+
+		version = nil
+	}
+
+	return configFileContents, strings.TrimPrefix(strings.TrimSpace(string(version)), "nginx version: "), nil
+}
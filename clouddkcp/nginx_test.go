@@ -0,0 +1,32 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestRenderNginxConfigGolden(t *testing.T) {
+	haProxyConfig := newTestHAProxyConfig(3, 2)
+	haProxyConfig.Algorithm = "leastconn"
+	haProxyConfig.BackupAddresses = []string{"10.1.0.1"}
+	haProxyConfig.ProxyProtocol = true
+	haProxyConfig.SourceRanges = []string{"192.0.2.0/24"}
+	haProxyConfig.Ports = append(haProxyConfig.Ports, v1.ServicePort{
+		NodePort: 30053,
+		Port:     53,
+		Protocol: v1.ProtocolUDP,
+	})
+
+	actual, err := newNginxConfig(haProxyConfig).Render()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertGolden(t, "nginx_stream", actual)
+}
@@ -0,0 +1,315 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	cloudprovider "k8s.io/cloud-provider"
+)
+
+const (
+	// labelNodeExcludeBalancer is the label, which excludes a node from load balancers.
+	labelNodeExcludeBalancer = "alpha.service-controller.kubernetes.io/exclude-balancer"
+
+	// labelNodeLoadBalancerWeight is the label specifying the weight of a node relative to the other backend servers.
+	// The value must be between 1 and 256.
+	// Defaults to 1.
+	labelNodeLoadBalancerWeight = "kubernetes.cloud.dk/load-balancer-weight"
+
+	// labelNodeRoleMaster is the label, which identifies master nodes.
+	labelNodeRoleMaster = "node-role.kubernetes.io/master"
+)
+
+// NodeSyncer updates the load balancers, when nodes are added or removed, or when their addresses or readiness change.
+// Bursts of node events are batched into a single update of every load balancer, once no events have been received for the delay.
+type NodeSyncer struct {
+	client        kubernetes.Interface
+	config        *CloudConfiguration
+	fingerprint   string
+	lister        corelisters.NodeLister
+	loadBalancers cloudprovider.LoadBalancer
+	trigger       chan struct{}
+}
+
+// startNodeSync starts watching the nodes until the stop channel closes.
+// A nil object is returned, if the watch has been disabled.
+func startNodeSync(c *CloudConfiguration, client kubernetes.Interface, loadBalancers cloudprovider.LoadBalancer, stop <-chan struct{}) *NodeSyncer {
+	if c.NodeSyncDelay == 0 {
+		return nil
+	}
+
+	factory := informers.NewSharedInformerFactory(client, 0)
+	informer := factory.Core().V1().Nodes()
+
+	s := &NodeSyncer{
+		client:        client,
+		config:        c,
+		lister:        informer.Lister(),
+		loadBalancers: loadBalancers,
+		trigger:       make(chan struct{}, 1),
+	}
+
+	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			s.Trigger()
+		},
+		UpdateFunc: func(oldObj interface{}, newObj interface{}) {
+			oldNode, oldOK := oldObj.(*v1.Node)
+			newNode, newOK := newObj.(*v1.Node)
+
+			if !oldOK || !newOK || getNodeFingerprint(oldNode) != getNodeFingerprint(newNode) {
+				s.Trigger()
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			s.Trigger()
+		},
+	})
+
+	factory.Start(stop)
+
+	go s.run(informer.Informer().HasSynced, stop)
+
+	return s
+}
+
+// Ensure recreates the load balancer of a registry entry, whose server has disappeared.
+func (s *NodeSyncer) Ensure(entry LoadBalancerRegistryEntry, service *v1.Service) {
+	nodes, err := s.getNodes()
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to list nodes - Error: %s", err.Error())
+
+		return
+	}
+
+	_, err = s.loadBalancers.EnsureLoadBalancer(context.Background(), entry.ClusterName, service, nodes)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to ensure load balancer after cloud event (name: %s) - Error: %s", entry.LoadBalancerName, err.Error())
+	}
+}
+
+// Trigger schedules an update of the load balancers.
+func (s *NodeSyncer) Trigger() {
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// run waits for node events and updates the load balancers, once the events have settled.
+func (s *NodeSyncer) run(hasSynced cache.InformerSynced, stop <-chan struct{}) {
+	if !cache.WaitForCacheSync(stop, hasSynced) {
+		return
+	}
+
+	// The service controller ensures every load balancer on startup, which is why the initial set of nodes does not require an update.
+	nodes, err := s.getNodes()
+
+	if err == nil {
+		s.fingerprint = getNodeSetFingerprint(nodes)
+	}
+
+	var timer <-chan time.Time
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-s.trigger:
+			timer = time.After(s.config.NodeSyncDelay)
+		case <-timer:
+			timer = nil
+
+			s.sync()
+		}
+	}
+}
+
+// getNodes retrieves the nodes, which are eligible as backend servers.
+func (s *NodeSyncer) getNodes() ([]*v1.Node, error) {
+	nodes, err := s.lister.List(labels.Everything())
+
+	if err != nil {
+		return nil, err
+	}
+
+	eligibleNodes := filterLoadBalancerNodes(nodes)
+
+	sort.Slice(eligibleNodes, func(i, j int) bool {
+		return eligibleNodes[i].Name < eligibleNodes[j].Name
+	})
+
+	return eligibleNodes, nil
+}
+
+// sync updates every registered load balancer, if the set of eligible nodes has changed since the last update.
+// The load balancers are updated concurrently by a bounded number of workers, as each update requires an SSH session.
+func (s *NodeSyncer) sync() {
+	nodes, err := s.getNodes()
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to list nodes - Error: %s", err.Error())
+
+		return
+	}
+
+	fingerprint := getNodeSetFingerprint(nodes)
+
+	if fingerprint == s.fingerprint {
+		return
+	}
+
+	entries := s.config.LoadBalancerRegistry.List()
+	failed := int32(0)
+	queue := make(chan LoadBalancerRegistryEntry)
+	wg := sync.WaitGroup{}
+
+	debugCloudAction(rtLoadBalancers, "Nodes have changed - Updating %d load balancers (nodes: %d, workers: %d)", len(entries), len(nodes), s.config.NodeSyncWorkers)
+
+	for i := 0; i < s.config.NodeSyncWorkers && i < len(entries); i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for entry := range queue {
+				if !s.update(entry, nodes) {
+					atomic.StoreInt32(&failed, 1)
+				}
+			}
+		}()
+	}
+
+	for _, entry := range entries {
+		queue <- entry
+	}
+
+	close(queue)
+	wg.Wait()
+
+	// The fingerprint is only recorded after a complete update, which causes failed updates to be retried on the next node event.
+	if failed == 0 {
+		s.fingerprint = fingerprint
+	}
+}
+
+// update updates the load balancer of a registry entry and returns whether the update succeeded.
+func (s *NodeSyncer) update(entry LoadBalancerRegistryEntry, nodes []*v1.Node) bool {
+	service, err := s.client.CoreV1().Services(entry.Namespace).Get(context.Background(), entry.ServiceName, metav1.GetOptions{})
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to retrieve service for node update (name: %s) - Error: %s", entry.LoadBalancerName, err.Error())
+
+		return false
+	}
+
+	if service.Spec.Type != v1.ServiceTypeLoadBalancer {
+		return true
+	}
+
+	err = s.loadBalancers.UpdateLoadBalancer(context.Background(), entry.ClusterName, service, nodes)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to update load balancer after node change (name: %s) - Error: %s", entry.LoadBalancerName, err.Error())
+
+		return false
+	}
+
+	return true
+}
+
+// getLoadBalancerNodes retrieves the nodes, which are eligible as backend servers.
+func getLoadBalancerNodes(c *CloudConfiguration) ([]*v1.Node, error) {
+	list, err := c.KubeClient.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{})
+
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make([]*v1.Node, 0, len(list.Items))
+
+	for i := range list.Items {
+		if isLoadBalancerNode(&list.Items[i]) {
+			nodes = append(nodes, &list.Items[i])
+		}
+	}
+
+	return nodes, nil
+}
+
+// getNodeFingerprint retrieves a string, which changes whenever a change to a node affects the load balancers.
+func getNodeFingerprint(node *v1.Node) string {
+	addresses := []string{}
+
+	for _, address := range node.Status.Addresses {
+		addresses = append(addresses, string(address.Type)+"="+address.Address)
+	}
+
+	return fmt.Sprintf("%s:%t:%s:%s", node.Name, isLoadBalancerNode(node), strings.Join(addresses, ","), node.Labels[labelNodeLoadBalancerWeight])
+}
+
+// getNodeSetFingerprint retrieves a hash of the fingerprints of a set of nodes.
+func getNodeSetFingerprint(nodes []*v1.Node) string {
+	fingerprints := make([]string, len(nodes))
+
+	for i, node := range nodes {
+		fingerprints[i] = getNodeFingerprint(node)
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(strings.Join(fingerprints, "\n"))))
+}
+
+// filterLoadBalancerNodes retrieves the nodes, which are eligible as backend servers, from a list of nodes.
+func filterLoadBalancerNodes(nodes []*v1.Node) []*v1.Node {
+	eligibleNodes := make([]*v1.Node, 0, len(nodes))
+
+	for _, node := range nodes {
+		if isLoadBalancerNode(node) {
+			eligibleNodes = append(eligibleNodes, node)
+		}
+	}
+
+	return eligibleNodes
+}
+
+// isLoadBalancerNode determines whether a node is eligible as a backend server by using the same rules as the service controller.
+func isLoadBalancerNode(node *v1.Node) bool {
+	if _, ok := node.Labels[labelNodeRoleMaster]; ok {
+		return false
+	}
+
+	if _, ok := node.Labels[labelNodeExcludeBalancer]; ok {
+		return false
+	}
+
+	if node.Spec.Unschedulable {
+		return false
+	}
+
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1.NodeReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+
+	return false
+}
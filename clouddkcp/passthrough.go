@@ -0,0 +1,133 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// annoLoadBalancerMode is the annotation specifying how a load balancer is implemented.
+	// Options are node-passthrough and server.
+	// Defaults to server.
+	annoLoadBalancerMode = "kubernetes.cloud.dk/load-balancer-mode"
+
+	// loadBalancerModeNodePassthrough specifies the mode, which publishes the external IP addresses of the nodes instead of creating a server.
+	loadBalancerModeNodePassthrough = "node-passthrough"
+
+	// loadBalancerModeServer specifies the mode, which creates a server running HAProxy.
+	loadBalancerModeServer = "server"
+)
+
+// ensureNodePassthroughLoadBalancer publishes the external IP addresses of the nodes as the ingress points of a service.
+// The server of a load balancer, which has previously been running in server mode, is destroyed.
+func (l LoadBalancers) ensureNodePassthroughLoadBalancer(ctx context.Context, clusterName string, service *v1.Service, nodes []*v1.Node) (*v1.LoadBalancerStatus, error) {
+	loadBalancerName := getLoadBalancerNameByService(service)
+
+	debugCloudAction(rtLoadBalancers, "Ensuring that node passthrough load balancer exists (name: %s)", loadBalancerName)
+
+	server := CloudServer{
+		CloudConfiguration: l.config,
+	}
+
+	notFound, err := server.InitializeByHostname(getLoadBalancerHostname(clusterName, loadBalancerName))
+
+	if err != nil && !notFound {
+		return nil, err
+	}
+
+	if !notFound {
+		err = l.EnsureLoadBalancerDeleted(ctx, clusterName, service)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ingresses := getNodePassthroughIngresses(service, nodes)
+
+	if len(ingresses) == 0 {
+		return &v1.LoadBalancerStatus{}, fmt.Errorf("No node external IP addresses available (name: %s)", loadBalancerName)
+	}
+
+	// The registry entry does not contain a hostname, as there is no server, but it allows node changes to update the ingress points.
+	l.config.LoadBalancerRegistry.Add(service, clusterName, "")
+	updateLoadBalancerStatus(l.config, service, getLoadBalancerReadyCondition(nil))
+
+	return &v1.LoadBalancerStatus{Ingress: ingresses}, nil
+}
+
+// updateNodePassthroughLoadBalancer updates the ingress points of a service, if the external IP addresses of the nodes have changed.
+// The service controller only updates the status after ensuring a load balancer, which is why node changes are written to the service directly.
+func (l LoadBalancers) updateNodePassthroughLoadBalancer(service *v1.Service, nodes []*v1.Node) error {
+	loadBalancerName := getLoadBalancerNameByService(service)
+	ingresses := getNodePassthroughIngresses(service, nodes)
+
+	if len(ingresses) == 0 {
+		return fmt.Errorf("No node external IP addresses available (name: %s)", loadBalancerName)
+	}
+
+	if l.config.KubeClient == nil || reflect.DeepEqual(ingresses, service.Status.LoadBalancer.Ingress) {
+		return nil
+	}
+
+	debugCloudAction(rtLoadBalancers, "Updating ingress points of node passthrough load balancer (name: %s)", loadBalancerName)
+
+	return updateLoadBalancerIngress(l.config, service, ingresses)
+}
+
+// getNodePassthroughIngresses retrieves the external IP addresses of the healthy nodes as ingress points sorted by address.
+// The hostname from the external-dns annotation is published alongside every IP address.
+func getNodePassthroughIngresses(service *v1.Service, nodes []*v1.Node) []v1.LoadBalancerIngress {
+	addresses := map[string]bool{}
+	hostname := getExternalDNSHostname(service)
+	ingresses := make([]v1.LoadBalancerIngress, 0)
+
+	for _, node := range nodes {
+		if !isLoadBalancerNode(node) {
+			continue
+		}
+
+		for _, address := range node.Status.Addresses {
+			if address.Type != v1.NodeExternalIP || addresses[address.Address] {
+				continue
+			}
+
+			addresses[address.Address] = true
+			ingresses = append(ingresses, v1.LoadBalancerIngress{
+				Hostname: hostname,
+				IP:       address.Address,
+			})
+		}
+	}
+
+	sort.Slice(ingresses, func(i, j int) bool {
+		return ingresses[i].IP < ingresses[j].IP
+	})
+
+	return ingresses
+}
+
+// isNodePassthroughLoadBalancer determines whether a service uses the node passthrough mode.
+func isNodePassthroughLoadBalancer(service *v1.Service) (bool, error) {
+	mode, err := parseStringAnnotation(
+		service.Annotations[annoLoadBalancerMode],
+		loadBalancerModeServer,
+		[]string{loadBalancerModeNodePassthrough, loadBalancerModeServer},
+	)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", annoLoadBalancerMode, getLoadBalancerNameByService(service))
+
+		return false, newConfigurationError(err)
+	}
+
+	return mode == loadBalancerModeNodePassthrough, nil
+}
@@ -0,0 +1,71 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// provisioningStateBooted indicates that the server has been created and accepts SSH connections.
+	provisioningStateBooted = "booted"
+
+	// provisioningStateConfigured indicates that the load balancer software has been installed and configured.
+	provisioningStateConfigured = "configured"
+
+	// provisioningStateCreated indicates that the server has been requested, but may not have finished booting.
+	provisioningStateCreated = "created"
+
+	// provisioningStateReady indicates that the load balancer has loaded its configuration and serves traffic.
+	provisioningStateReady = "ready"
+)
+
+// getProvisioningState retrieves the provisioning state of a load balancer from its status.
+// An empty string is returned, if the state is unknown, which is the case for load balancers created by older versions.
+func getProvisioningState(c *CloudConfiguration, service *v1.Service) string {
+	return getLoadBalancerStatus(c, service)[statusKeyProvisioningState]
+}
+
+// setProvisioningState stores the provisioning state of a load balancer in its status.
+func setProvisioningState(c *CloudConfiguration, service *v1.Service, state string) {
+	debugCloudAction(rtLoadBalancers, "Load balancer reached provisioning state '%s' (name: %s)", state, getLoadBalancerNameByService(service))
+
+	updateLoadBalancerStatus(c, service, map[string]string{
+		statusKeyProvisioningState: state,
+	})
+}
+
+// resumeLoadBalancerProvisioning completes the provisioning of a load balancer, which was interrupted by a restart of the controller.
+// A server, which may not have finished booting, is destroyed in order to be recreated, as it cannot be trusted to have been set up correctly.
+func resumeLoadBalancerProvisioning(ctx context.Context, c *CloudConfiguration, server *CloudServer, service *v1.Service) (bool, error) {
+	loadBalancerName := getLoadBalancerNameByService(service)
+
+	switch getProvisioningState(c, service) {
+	case provisioningStateCreated:
+		debugCloudAction(rtLoadBalancers, "Destroying partially created server (name: %s)", loadBalancerName)
+
+		err := server.Destroy()
+
+		if err != nil {
+			return false, err
+		}
+
+		return true, nil
+	case provisioningStateBooted:
+		debugCloudAction(rtLoadBalancers, "Resuming interrupted provisioning (name: %s)", loadBalancerName)
+
+		err := configureLoadBalancer(ctx, c, server, service)
+
+		if err != nil {
+			return false, err
+		}
+
+		setProvisioningState(c, service, provisioningStateConfigured)
+	}
+
+	return false, nil
+}
@@ -0,0 +1,117 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/danitso/terraform-provider-clouddk/clouddk"
+	v1 "k8s.io/api/core/v1"
+	cloudproviderapi "k8s.io/cloud-provider/api"
+)
+
+const (
+	// stuckActionRetryMin specifies the minimum delay before a load balancer with pending actions is reconciled again.
+	stuckActionRetryMin = 5 * time.Second
+
+	// stuckActionRetryMax specifies the maximum delay before a load balancer with pending actions is reconciled again.
+	stuckActionRetryMax = time.Minute
+)
+
+var (
+	// actionTimeLayouts contains the supported timestamp layouts for actions returned by the logs endpoint.
+	actionTimeLayouts = []string{
+		time.RFC3339,
+		"2006-01-02 15:04:05",
+		"2006-01-02T15:04:05",
+	}
+)
+
+// getOldestActionAge retrieves the age of the oldest action in a list.
+func getOldestActionAge(actions clouddk.LogsListBody) time.Duration {
+	oldest := time.Duration(0)
+
+	for _, a := range actions {
+		for _, layout := range actionTimeLayouts {
+			createdAt, err := time.Parse(layout, a.CreatedAt)
+
+			if err != nil {
+				continue
+			}
+
+			age := time.Now().Sub(createdAt)
+
+			if age > oldest {
+				oldest = age
+			}
+
+			break
+		}
+	}
+
+	return oldest
+}
+
+// getStuckActionRetryDelay retrieves the delay before a load balancer with pending actions is reconciled again.
+// The delay grows with the age of the oldest action, which backs off from servers, whose actions take a long time to complete.
+func getStuckActionRetryDelay(age time.Duration) time.Duration {
+	if age < stuckActionRetryMin {
+		return stuckActionRetryMin
+	} else if age > stuckActionRetryMax {
+		return stuckActionRetryMax
+	}
+
+	return age
+}
+
+// recoverStuckServer checks whether a server has pending actions, which prevent the load balancer from being reconciled.
+// Actions, which are still pending after the configured deadline, are escalated by destroying the server, if automatic recreation is enabled.
+// Otherwise, a RetryError is returned, which requeues the service with a backoff instead of blocking the worker and the lock of the load balancer, while the actions complete.
+// The returned value is true, if the server was destroyed and must be recreated.
+func recoverStuckServer(c *CloudConfiguration, server *CloudServer, service *v1.Service) (bool, error) {
+	loadBalancerName := getLoadBalancerNameByService(service)
+	pendingActions, err := server.GetPendingActions()
+
+	if err != nil {
+		return false, err
+	}
+
+	if len(pendingActions) == 0 {
+		return false, nil
+	}
+
+	age := getOldestActionAge(pendingActions)
+
+	if age < c.StuckActionDeadline {
+		delay := getStuckActionRetryDelay(age)
+
+		debugCloudAction(rtLoadBalancers, "Retrying in %s, as %d pending actions have not completed (name: %s)", delay.String(), len(pendingActions), loadBalancerName)
+
+		return false, cloudproviderapi.NewRetryError(fmt.Sprintf("The server '%s' still has %d pending actions (name: %s)", server.Information.Identifier, len(pendingActions), loadBalancerName), delay)
+	}
+
+	// The server of a service, which requests a specific IP address, is never recreated, as the address would be lost.
+	if !c.StuckActionRecreate || hasRequestedLoadBalancerIP(service) {
+		debugCloudAction(rtLoadBalancers, "WARNING: Server has been stuck with %d pending actions for %s (name: %s)", len(pendingActions), age.String(), loadBalancerName)
+		recordServiceEvent(c, service, v1.EventTypeWarning, eventReasonServerStuck, "Server '%s' has been stuck with %d pending actions for %s", server.Information.Identifier, len(pendingActions), age.String())
+
+		return false, fmt.Errorf("The server '%s' has been stuck with pending actions for %s (name: %s)", server.Information.Identifier, age.String(), loadBalancerName)
+	}
+
+	debugCloudAction(rtLoadBalancers, "WARNING: Destroying server, which has been stuck with %d pending actions for %s (name: %s)", len(pendingActions), age.String(), loadBalancerName)
+	recordServiceEvent(c, service, v1.EventTypeWarning, eventReasonServerStuck, "Destroying server '%s', which has been stuck with %d pending actions for %s", server.Information.Identifier, len(pendingActions), age.String())
+
+	serverID := server.Information.Identifier
+	err = server.Destroy()
+
+	if err != nil {
+		return false, err
+	}
+
+	recordServiceEvent(c, service, v1.EventTypeNormal, eventReasonServerDestroyed, "Destroyed server '%s'", serverID)
+
+	return true, nil
+}
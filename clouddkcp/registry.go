@@ -0,0 +1,115 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"sort"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// LoadBalancerRegistry keeps track of the load balancers reconciled by this controller.
+// The service controller ensures every load balancer on startup, which is why the registry is repopulated after a restart.
+type LoadBalancerRegistry struct {
+	entries map[string]LoadBalancerRegistryEntry
+	mutex   sync.RWMutex
+}
+
+// LoadBalancerRegistryEntry describes a load balancer in the registry.
+// The hostname is empty for load balancers without a server, such as node passthrough load balancers.
+type LoadBalancerRegistryEntry struct {
+	ClusterName      string
+	Engine           string
+	Hostname         string
+	LoadBalancerName string
+	Namespace        string
+	ServiceName      string
+	StatsSecretName  string
+}
+
+// newLoadBalancerRegistry initializes a new LoadBalancerRegistry object.
+func newLoadBalancerRegistry() *LoadBalancerRegistry {
+	return &LoadBalancerRegistry{
+		entries: map[string]LoadBalancerRegistryEntry{},
+	}
+}
+
+// Add adds or updates the load balancer for a service.
+// Load balancers of other kinds of objects, such as gateways, are skipped, as they are reconciled by their own controllers.
+func (r *LoadBalancerRegistry) Add(service *v1.Service, clusterName string, hostname string) {
+	if service.Kind != "" && service.Kind != "Service" {
+		return
+	}
+
+	// Invalid engines are rejected by the reconciliation, which is why the default is recorded in the meantime.
+	engine, err := getLoadBalancerEngine(service)
+
+	if err != nil {
+		engine = loadBalancerEngineHAProxy
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.entries[getServiceKey(service)] = LoadBalancerRegistryEntry{
+		ClusterName:      clusterName,
+		Engine:           engine,
+		Hostname:         hostname,
+		LoadBalancerName: getLoadBalancerNameByService(service),
+		Namespace:        service.Namespace,
+		ServiceName:      service.Name,
+		StatsSecretName:  getServiceStatsSecretName(service),
+	}
+}
+
+// GetByHostname retrieves the load balancer with the specified hostname.
+func (r *LoadBalancerRegistry) GetByHostname(hostname string) (LoadBalancerRegistryEntry, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, v := range r.entries {
+		if v.Hostname != "" && v.Hostname == hostname {
+			return v, true
+		}
+	}
+
+	return LoadBalancerRegistryEntry{}, false
+}
+
+// List returns the load balancers sorted by service key.
+func (r *LoadBalancerRegistry) List() []LoadBalancerRegistryEntry {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	keys := make([]string, 0, len(r.entries))
+
+	for k := range r.entries {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	entries := make([]LoadBalancerRegistryEntry, 0, len(keys))
+
+	for _, k := range keys {
+		entries = append(entries, r.entries[k])
+	}
+
+	return entries
+}
+
+// Remove removes the load balancer for a service.
+func (r *LoadBalancerRegistry) Remove(service *v1.Service) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.entries, getServiceKey(service))
+}
+
+// getServiceKey retrieves the namespace/name key for a service.
+func getServiceKey(service *v1.Service) string {
+	return service.Namespace + "/" + service.Name
+}
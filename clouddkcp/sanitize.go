@@ -0,0 +1,37 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var (
+	// reConfigToken matches values, which can safely be interpolated into a configuration file as a single token.
+	// Whitespace, quotes, backslashes and comment characters are rejected, as they could be used to inject additional directives.
+	reConfigToken = regexp.MustCompile(`^[A-Za-z0-9._:/@%+=,~-]+$`)
+
+	// reContainerImage matches container image references of the form [registry[:port]/]repository[:tag][@digest].
+	reContainerImage = regexp.MustCompile(`^[a-z0-9]+([._-][a-z0-9]+)*(:[0-9]+)?(/[a-z0-9]+([._-][a-z0-9]+)*)*(:[A-Za-z0-9_][A-Za-z0-9._-]{0,127})?(@sha256:[a-f0-9]{64})?$`)
+)
+
+// validateConfigToken verifies that a value can be interpolated into a configuration file as a single token.
+func validateConfigToken(value string) error {
+	if !reConfigToken.MatchString(value) {
+		return fmt.Errorf("The value %q contains characters, which are not allowed in the configuration", value)
+	}
+
+	return nil
+}
+
+// validateContainerImage verifies that a value is a valid container image reference.
+func validateContainerImage(image string) error {
+	if len(image) > 255 || !reContainerImage.MatchString(image) {
+		return fmt.Errorf("The value %q is not a valid container image reference", image)
+	}
+
+	return nil
+}
@@ -0,0 +1,309 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/danitso/terraform-provider-clouddk/clouddk"
+)
+
+const (
+	credentialKeyAPIKey          = "api_key"
+	credentialKeySSHCAPrivateKey = "ssh_ca_private_key"
+	credentialKeySSHPrivateKey   = "ssh_private_key"
+	credentialKeySSHPublicKey    = "ssh_public_key"
+
+	// pathServiceAccountToken specifies the path to the token of the service account, which the pod is running as.
+	pathServiceAccountToken = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+	secretProviderEnv   = "env"
+	secretProviderVault = "vault"
+)
+
+// SecretProvider retrieves credentials from an external secret store.
+// The returned map is keyed by credential names such as 'api_key', and missing credentials fall back to the environment variables.
+type SecretProvider interface {
+	GetSecrets() (map[string]string, error)
+	Name() string
+}
+
+// vaultSecretProvider retrieves credentials from a HashiCorp Vault KV secrets engine.
+type vaultSecretProvider struct {
+	address    string
+	authPath   string
+	client     *http.Client
+	role       string
+	secretPath string
+	token      string
+}
+
+// newSecretProvider initializes the secret provider with the specified name.
+// A nil object is returned for the 'env' provider, as the credentials are then read directly from the environment variables.
+func newSecretProvider(name string, strictCrypto bool) (SecretProvider, error) {
+	switch name {
+	case "", secretProviderEnv:
+		return nil, nil
+	case secretProviderVault:
+		provider := &vaultSecretProvider{
+			address:    strings.TrimRight(os.Getenv(envVaultAddress), "/"),
+			authPath:   strings.Trim(os.Getenv(envVaultAuthPath), "/"),
+			role:       os.Getenv(envVaultRole),
+			secretPath: strings.Trim(os.Getenv(envVaultSecretPath), "/"),
+			token:      os.Getenv(envVaultToken),
+		}
+
+		if provider.address == "" {
+			return nil, fmt.Errorf("The environment variable '%s' is empty", envVaultAddress)
+		}
+
+		if provider.secretPath == "" {
+			return nil, fmt.Errorf("The environment variable '%s' is empty", envVaultSecretPath)
+		}
+
+		if provider.token == "" && provider.role == "" {
+			return nil, fmt.Errorf("One of the environment variables '%s' and '%s' must be specified", envVaultRole, envVaultToken)
+		}
+
+		if provider.authPath == "" {
+			provider.authPath = "kubernetes"
+		}
+
+		client, err := newAPIClient(os.Getenv(envVaultCABundle), "1.2", nil, strictCrypto)
+
+		if err != nil {
+			return nil, err
+		}
+
+		provider.client = client
+
+		return provider, nil
+	default:
+		return nil, fmt.Errorf("Unsupported secret provider '%s'", name)
+	}
+}
+
+// GetSecrets retrieves the credentials from Vault.
+func (p *vaultSecretProvider) GetSecrets() (map[string]string, error) {
+	token, err := p.getToken()
+
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequest("GET", fmt.Sprintf("%s/v1/%s", p.address, p.secretPath), nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("X-Vault-Token", token)
+
+	body := struct {
+		Data map[string]interface{} `json:"data"`
+	}{}
+
+	err = p.do(request, &body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Version 2 of the KV secrets engine nests the values and adds metadata, while version 1 returns the values directly.
+	values := body.Data
+
+	if nested, ok := values["data"].(map[string]interface{}); ok {
+		values = nested
+	}
+
+	secrets := map[string]string{}
+
+	for k, v := range values {
+		if s, ok := v.(string); ok {
+			secrets[k] = s
+		}
+	}
+
+	return secrets, nil
+}
+
+// Name returns the name of the secret provider.
+func (p *vaultSecretProvider) Name() string {
+	return secretProviderVault
+}
+
+// do performs a request against the Vault API and decodes the JSON response.
+func (p *vaultSecretProvider) do(request *http.Request, v interface{}) error {
+	response, err := p.client.Do(request)
+
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	responseBody, err := ioutil.ReadAll(response.Body)
+
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("Vault responded with status code %d - Body: %s", response.StatusCode, strings.TrimSpace(string(responseBody)))
+	}
+
+	return json.Unmarshal(responseBody, v)
+}
+
+// getToken retrieves a Vault token.
+// A new token is requested on every call with the Kubernetes auth method, which avoids having to track the lease of the token.
+func (p *vaultSecretProvider) getToken() (string, error) {
+	if p.token != "" {
+		return p.token, nil
+	}
+
+	jwt, err := ioutil.ReadFile(pathServiceAccountToken)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to read the service account token - Error: %s", err.Error())
+	}
+
+	requestBody, err := json.Marshal(map[string]string{
+		"jwt":  strings.TrimSpace(string(jwt)),
+		"role": p.role,
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	request, err := http.NewRequest("POST", fmt.Sprintf("%s/v1/auth/%s/login", p.address, p.authPath), bytes.NewReader(requestBody))
+
+	if err != nil {
+		return "", err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+
+	body := struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}{}
+
+	err = p.do(request, &body)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to authenticate with Vault - Error: %s", err.Error())
+	}
+
+	if body.Auth.ClientToken == "" {
+		return "", errors.New("Vault did not return a client token")
+	}
+
+	return body.Auth.ClientToken, nil
+}
+
+// getCredential retrieves a credential from the secrets and falls back to the specified value, if it is missing.
+func getCredential(secrets map[string]string, key string, fallback string) string {
+	if secrets[key] != "" {
+		return secrets[key]
+	}
+
+	return fallback
+}
+
+// getClientSettings retrieves a copy of the API client settings, which is safe to use while the credentials are rotated.
+func (c *CloudConfiguration) getClientSettings() *clouddk.ClientSettings {
+	c.credentialsMutex.RLock()
+	defer c.credentialsMutex.RUnlock()
+
+	settings := *c.ClientSettings
+
+	return &settings
+}
+
+// getStaticSSHKeys retrieves the static SSH private and public key.
+func (c *CloudConfiguration) getStaticSSHKeys() (string, string) {
+	c.credentialsMutex.RLock()
+	defer c.credentialsMutex.RUnlock()
+
+	return c.PrivateKey, c.PublicKey
+}
+
+// refreshCredentials retrieves the credentials from the secret provider and replaces the ones, which have changed.
+// The certificate authority is not rotated, as servers only trust the key, which was present when they were created.
+func (c *CloudConfiguration) refreshCredentials() error {
+	secrets, err := c.SecretProvider.GetSecrets()
+
+	if err != nil {
+		return err
+	}
+
+	c.credentialsMutex.Lock()
+	defer c.credentialsMutex.Unlock()
+
+	apiKey := getCredential(secrets, credentialKeyAPIKey, c.ClientSettings.Key)
+	privateKey := getCredential(secrets, credentialKeySSHPrivateKey, c.PrivateKey)
+	publicKey := getCredential(secrets, credentialKeySSHPublicKey, c.PublicKey)
+
+	if c.StrictCrypto {
+		err = validateStrictSSHKey(privateKey)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if apiKey != c.ClientSettings.Key {
+		debugCloudAction(rtCloud, "Rotated the API key (provider: %s)", c.SecretProvider.Name())
+	}
+
+	if privateKey != c.PrivateKey || publicKey != c.PublicKey {
+		debugCloudAction(rtCloud, "Rotated the SSH key pair (provider: %s)", c.SecretProvider.Name())
+	}
+
+	settings := *c.ClientSettings
+	settings.Key = apiKey
+
+	c.ClientSettings = &settings
+	c.PrivateKey = privateKey
+	c.PublicKey = publicKey
+
+	return nil
+}
+
+// startSecretRefresh periodically refreshes the credentials from the secret provider until the stop channel closes.
+func startSecretRefresh(c *CloudConfiguration, stop <-chan struct{}) {
+	if c.SecretProvider == nil || c.SecretRefreshInterval == 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(c.SecretRefreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				err := c.refreshCredentials()
+
+				if err != nil {
+					debugCloudAction(rtCloud, "Failed to refresh the credentials (provider: %s) - Error: %s", c.SecretProvider.Name(), err.Error())
+				}
+			}
+		}
+	}()
+}
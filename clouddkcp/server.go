@@ -6,11 +6,15 @@ package clouddkcp
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"math/rand"
+	"math/big"
+	"net"
 	"net/url"
+	"os"
 	"path/filepath"
 	"strings"
 	"time"
@@ -22,9 +26,29 @@ import (
 )
 
 const (
-	pathAPTAutoConf           = "/etc/apt/apt.conf.d/00auto-conf"
-	pathPublicKeyController   = "/root/.ssh/id_rsa_controller.pub"
-	pathServerProvisionScript = "/tmp/clouddk_server_provisioner.sh"
+	// fileModeConfig specifies the permissions for regular configuration files.
+	fileModeConfig = 0644
+
+	// fileModePrivate specifies the permissions for key material and other sensitive files.
+	fileModePrivate = 0600
+
+	// fileModeScript specifies the permissions for scripts.
+	fileModeScript = 0755
+
+	pathAPTAutoConf               = "/etc/apt/apt.conf.d/00auto-conf"
+	pathAPTAutoUpgradesConf       = "/etc/apt/apt.conf.d/20auto-upgrades"
+	pathAPTUnattendedUpgradesConf = "/etc/apt/apt.conf.d/51clouddk-unattended-upgrades"
+	pathPublicKeyController       = "/root/.ssh/id_rsa_controller.pub"
+	pathServerProvisionScript     = "/tmp/clouddk_server_provisioner.sh"
+
+	// serverAdoptionInterval specifies the delay between lookups of a server, which may have been created by a failed request.
+	serverAdoptionInterval = 5 * time.Second
+
+	// serverAdoptionTimeout specifies how long to look for a server, which may have been created by a failed request.
+	serverAdoptionTimeout = 60 * time.Second
+
+	// serverTemplateDefault specifies the OS template for new servers, unless CLOUDDK_SERVER_TEMPLATE has been set.
+	serverTemplateDefault = "ubuntu-18.04-x64"
 )
 
 var (
@@ -34,19 +58,70 @@ var (
 			"--force-confold";
 		}
 	`)
-	serverProvisionScript = heredoc.Doc(`
+	aptAutoUpgradesConf = heredoc.Doc(`
+		APT::Periodic::Update-Package-Lists "1";
+		APT::Periodic::Unattended-Upgrade "1";
+	`)
+	aptUnattendedUpgradesConf = heredoc.Doc(`
+		// Only install updates from the security pocket.
+		#clear Unattended-Upgrade::Allowed-Origins;
+		Unattended-Upgrade::Allowed-Origins {
+			"${distro_id}:${distro_codename}-security";
+		};
+
+		// HAProxy is upgraded by replacing the load balancer, which keeps the version consistent across the fleet.
+		Unattended-Upgrade::Package-Blacklist {
+			"haproxy";
+		};
+
+		Unattended-Upgrade::Automatic-Reboot "false";
+	`)
+	serverDisableRootLoginCommand = "sed -i 's/^#\\?PermitRootLogin.*/PermitRootLogin no/' /etc/ssh/sshd_config && systemctl reload ssh"
+	serverLockdownCommand         = "sed -i -e 's/^#\\?PasswordAuthentication.*/PasswordAuthentication no/' -e 's/^#\\?PermitRootLogin.*/PermitRootLogin prohibit-password/' /etc/ssh/sshd_config && systemctl reload ssh"
+	serverProvisionScript         = heredoc.Doc(`
 		#!/bin/bash
 		set -e
 
 		# Specify the required environment variables.
 		export DEBIAN_FRONTEND=noninteractive
 
-		# Authorize the SSH key and disable password authentication.
+		# Authorize the SSH key for root, unless the controller uses a dedicated user, and disable password authentication.
 		if [[ ! -f /root/.ssh/authorized_keys ]]; then
 			touch /root/.ssh/authorized_keys
 		fi
 
-		cat /root/.ssh/id_rsa_controller.pub >> /root/.ssh/authorized_keys
+		if [[ -f /root/.ssh/id_rsa_controller.pub && ( -z "$CLOUDDK_SSH_USER" || "$CLOUDDK_SSH_USER" == "root" ) ]]; then
+			cat /root/.ssh/id_rsa_controller.pub >> /root/.ssh/authorized_keys
+		fi
+
+		# Create the non-root user used by the controller and grant it passwordless sudo access.
+		if [[ -n "$CLOUDDK_SSH_USER" && "$CLOUDDK_SSH_USER" != "root" ]]; then
+			if ! id -u "$CLOUDDK_SSH_USER" >/dev/null 2>&1; then
+				useradd -m -s /bin/bash "$CLOUDDK_SSH_USER"
+			fi
+
+			SSH_USER_HOME="$(getent passwd "$CLOUDDK_SSH_USER" | cut -d: -f6)"
+
+			mkdir -p "${SSH_USER_HOME}/.ssh"
+			touch "${SSH_USER_HOME}/.ssh/authorized_keys"
+
+			if [[ -f /root/.ssh/id_rsa_controller.pub ]]; then
+				cat /root/.ssh/id_rsa_controller.pub >> "${SSH_USER_HOME}/.ssh/authorized_keys"
+			fi
+
+			chmod 700 "${SSH_USER_HOME}/.ssh"
+			chmod 600 "${SSH_USER_HOME}/.ssh/authorized_keys"
+			chown -R "${CLOUDDK_SSH_USER}:" "${SSH_USER_HOME}/.ssh"
+
+			echo "${CLOUDDK_SSH_USER} ALL=(ALL) NOPASSWD:ALL" > /etc/sudoers.d/90-clouddk
+			chmod 440 /etc/sudoers.d/90-clouddk
+		fi
+
+		# Trust the user certificates signed by the certificate authority of the controller.
+		if [[ -f /etc/ssh/clouddk_ca.pub ]]; then
+			echo "TrustedUserCAKeys /etc/ssh/clouddk_ca.pub" >> /etc/ssh/sshd_config
+		fi
+
 		sed -i 's/#\?PasswordAuthentication.*/PasswordAuthentication no/' /etc/ssh/sshd_config
 		systemctl restart ssh
 
@@ -67,23 +142,55 @@ var (
 		apt-get -qq upgrade -y
 		apt-get -qq dist-upgrade -y
 		apt-get -qq install -y apt-transport-https ca-certificates software-properties-common
+
+		# Install the security updates automatically, if unattended upgrades have been configured.
+		if [[ -f /etc/apt/apt.conf.d/51clouddk-unattended-upgrades ]]; then
+			apt-get -qq install -y unattended-upgrades
+		fi
+
+		# Apply the CIS hardening, if it has been enabled.
+		if [[ -f /tmp/clouddk_cis_hardening.sh ]]; then
+			/bin/bash /tmp/clouddk_cis_hardening.sh
+			rm -f /tmp/clouddk_cis_hardening.sh
+		fi
 	`)
 )
 
+// provisioningFile describes a file, which is uploaded to a server during provisioning.
+type provisioningFile struct {
+	Path     string
+	Contents string
+	Mode     os.FileMode
+}
+
 // CloudServer manages a Cloud.dk server.
+// Servers, which are only inspected, set ReadOnly, which allows them to be initialized from the last known state, while the circuit breaker is open.
 type CloudServer struct {
 	CloudConfiguration *CloudConfiguration
 	Information        clouddk.ServerBody
+	ReadOnly           bool
+	SSHKeyPair         *SSHKeyPair
 }
 
 // Create creates a new Cloud.dk server.
-func (s *CloudServer) Create(locationID string, packageID string, hostname string) error {
+func (s *CloudServer) Create(ctx context.Context, locationID string, packageID string, templateID string, hostname string) (err error) {
 	if s.Information.Identifier != "" {
 		return errors.New("The server has already been initialized")
 	}
 
 	debugCloudAction(rtServers, "Creating server (hostname: %s)", hostname)
 
+	timeCreate := time.Now()
+
+	defer func() {
+		s.CloudConfiguration.AuditLog.RecordMutation(s, mutationActionServerCreate, map[string]string{
+			"hostname": hostname,
+			"location": locationID,
+			"package":  packageID,
+			"template": templateID,
+		}, err, timeCreate)
+	}()
+
 	rootPassword := "p" + s.GetRandomPassword(63)
 
 	body := clouddk.ServerCreateBody{
@@ -91,29 +198,47 @@ func (s *CloudServer) Create(locationID string, packageID string, hostname strin
 		Label:               hostname,
 		InitialRootPassword: rootPassword,
 		Package:             packageID,
-		Template:            "ubuntu-18.04-x64",
+		Template:            templateID,
 		Location:            locationID,
 	}
 
 	reqBody := new(bytes.Buffer)
-	err := json.NewEncoder(reqBody).Encode(body)
+	err = json.NewEncoder(reqBody).Encode(body)
 
 	if err != nil {
 		return err
 	}
 
-	res, err := clouddk.DoClientRequest(s.CloudConfiguration.ClientSettings, "POST", "cloudservers", reqBody, []int{200}, 1, 1)
+	_, apiSpan := s.CloudConfiguration.Tracer.Start(ctx, "api_create_server")
+	res, err := doClientRequest(s.CloudConfiguration, "POST", "cloudservers", reqBody, []int{200}, 1, 1)
+	apiSpan.End(err)
 
-	if err != nil {
-		debugCloudAction(rtServers, "Failed to create server (hostname: %s)", hostname)
+	if err == nil {
+		s.Information = clouddk.ServerBody{}
+		err = json.NewDecoder(res.Body).Decode(&s.Information)
 
-		return err
-	}
+		if err != nil {
+			return err
+		}
+	} else if isIndeterminateError(err) {
+		// The server may have been created, even though the request failed, which is why it must be adopted instead of being created again.
+		debugCloudAction(rtServers, "Looking for a server created by a failed request (hostname: %s) - Error: %s", hostname, err.Error())
 
-	s.Information = clouddk.ServerBody{}
-	err = json.NewDecoder(res.Body).Decode(&s.Information)
+		information, found := s.findCreatedServer(hostname)
+
+		if !found {
+			debugCloudAction(rtServers, "Failed to create server (hostname: %s)", hostname)
+
+			return err
+		}
+
+		debugCloudAction(rtServers, "Adopting server '%s' created by a failed request (hostname: %s)", information.Identifier, hostname)
+
+		s.Information = information
+		err = nil
+	} else {
+		debugCloudAction(rtServers, "Failed to create server (hostname: %s)", hostname)
 
-	if err != nil {
 		return err
 	}
 
@@ -127,18 +252,39 @@ func (s *CloudServer) Create(locationID string, packageID string, hostname strin
 		return err
 	}
 
+	sshAddress, err := s.GetSSHAddress()
+
+	if err != nil {
+		debugCloudAction(rtServers, "Failed to create server due to lack of usable IP addresses (hostname: %s)", hostname)
+
+		s.Destroy()
+
+		return err
+	}
+
 	// Wait for the server to become ready by testing SSH connectivity.
-	debugCloudAction(rtServers, "Waiting for server to accept SSH connections (hostname: %s)", hostname)
+	debugCloudAction(rtServers, "Waiting for server to accept SSH connections on '%s' (hostname: %s)", sshAddress, hostname)
 
 	var sshClient *ssh.Client
+	var initialHostKey ssh.PublicKey
 
+	// The host key is captured during the initial password session and verified against the keys on disk afterwards.
 	sshConfig := &ssh.ClientConfig{
-		User:            "root",
-		Auth:            []ssh.AuthMethod{ssh.Password(rootPassword)},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		User: "root",
+		Auth: []ssh.AuthMethod{ssh.Password(rootPassword)},
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			initialHostKey = key
+
+			return nil
+		},
+		Timeout: s.CloudConfiguration.SSHDialTimeout,
 	}
 
-	timeDelay := int64(10)
+	applySSHCryptoPolicy(s.CloudConfiguration, sshConfig)
+
+	_, waitSpan := s.CloudConfiguration.Tracer.Start(ctx, "wait_for_ssh", "address", sshAddress)
+
+	timeDelay := int64(2)
 	timeMax := float64(300)
 	timeStart := time.Now()
 	timeElapsed := timeStart.Sub(timeStart)
@@ -147,7 +293,7 @@ func (s *CloudServer) Create(locationID string, packageID string, hostname strin
 
 	for timeElapsed.Seconds() < timeMax {
 		if int64(timeElapsed.Seconds())%timeDelay == 0 {
-			sshClient, err = ssh.Dial("tcp", s.Information.NetworkInterfaces[0].IPAddresses[0].Address+":22", sshConfig)
+			sshClient, err = s.dialSSH(sshAddress, sshConfig)
 
 			if err == nil {
 				break
@@ -161,6 +307,8 @@ func (s *CloudServer) Create(locationID string, packageID string, hostname strin
 		timeElapsed = time.Now().Sub(timeStart)
 	}
 
+	waitSpan.End(err)
+
 	if err != nil {
 		debugCloudAction(rtServers, "Failed to create server due to SSH timeout (hostname: %s)", hostname)
 
@@ -173,6 +321,35 @@ func (s *CloudServer) Create(locationID string, packageID string, hostname strin
 
 	s.Information.Booted = true
 
+	// Disable password authentication before doing anything else, as the server accepts root logins with the initial password until then.
+	// Established sessions are unaffected by the reload, which allows provisioning to continue over the current connection.
+	debugCloudAction(rtServers, "Disabling password authentication (hostname: %s)", hostname)
+
+	err = s.lockDown(sshClient)
+
+	if err != nil {
+		debugCloudAction(rtServers, "Failed to create server because password authentication could not be disabled (hostname: %s) - Error: %s", hostname, err.Error())
+
+		s.Destroy()
+
+		return err
+	}
+
+	debugCloudAction(rtServers, "Recording host keys (hostname: %s)", hostname)
+
+	err = s.recordHostKeys(sshClient, initialHostKey)
+
+	if err != nil {
+		debugCloudAction(rtServers, "Failed to create server because the host keys could not be recorded (hostname: %s) - Error: %s", hostname, err.Error())
+
+		s.Destroy()
+
+		return err
+	}
+
+	_, provisionSpan := s.CloudConfiguration.Tracer.Start(ctx, "provision_server")
+	defer func() { provisionSpan.End(err) }()
+
 	// Configure the package manager for unattended upgrades.
 	debugCloudAction(rtServers, "Creating new SFTP client (hostname: %s)", hostname)
 
@@ -190,7 +367,7 @@ func (s *CloudServer) Create(locationID string, packageID string, hostname strin
 
 	debugCloudAction(rtServers, "Uploading file to '%s' (hostname: %s)", pathAPTAutoConf, hostname)
 
-	err = s.UploadFile(sftpClient, pathAPTAutoConf, bytes.NewBufferString(strings.ReplaceAll(aptAutoConf, "\r", "")))
+	err = s.UploadFile(sshClient, sftpClient, pathAPTAutoConf, bytes.NewBufferString(strings.ReplaceAll(aptAutoConf, "\r", "")), fileModeConfig, 0, 0)
 
 	if err != nil {
 		debugCloudAction(rtServers, "Failed to create server because file '%s' could not be uploaded (hostname: %s)", pathAPTAutoConf, hostname)
@@ -200,24 +377,54 @@ func (s *CloudServer) Create(locationID string, packageID string, hostname strin
 		return err
 	}
 
-	debugCloudAction(rtServers, "Uploading file to '%s' (hostname: %s)", pathPublicKeyController, hostname)
+	files := []provisioningFile{}
+	_, publicKey := s.CloudConfiguration.getStaticSSHKeys()
 
-	err = s.UploadFile(sftpClient, pathPublicKeyController, bytes.NewBufferString(strings.ReplaceAll(s.CloudConfiguration.PublicKey, "\r", "")))
+	if s.SSHKeyPair != nil {
+		publicKey = s.SSHKeyPair.PublicKey
+	}
 
-	if err != nil {
-		debugCloudAction(rtServers, "Failed to create server because file '%s' could not be uploaded (hostname: %s)", pathPublicKeyController, hostname)
+	if publicKey != "" {
+		files = append(files, provisioningFile{Path: pathPublicKeyController, Contents: publicKey, Mode: fileModePrivate})
+	}
 
-		s.Destroy()
+	if s.CloudConfiguration.SSHCertificateAuthority != nil {
+		files = append(files, provisioningFile{Path: pathSSHCAPublicKey, Contents: s.CloudConfiguration.SSHCertificateAuthority.PublicKey(), Mode: fileModeConfig})
+	}
 
-		return err
+	if s.CloudConfiguration.SecurityUpgrades {
+		files = append(
+			files,
+			provisioningFile{Path: pathAPTAutoUpgradesConf, Contents: aptAutoUpgradesConf, Mode: fileModeConfig},
+			provisioningFile{Path: pathAPTUnattendedUpgradesConf, Contents: aptUnattendedUpgradesConf, Mode: fileModeConfig},
+		)
+	}
+
+	if s.CloudConfiguration.CISHardening {
+		files = append(files, provisioningFile{Path: pathCISHardeningScript, Contents: cisHardeningScript, Mode: fileModeScript})
 	}
 
-	debugCloudAction(rtServers, "Uploading file to '%s' (hostname: %s)", pathServerProvisionScript, hostname)
+	files = append(files, provisioningFile{Path: pathServerProvisionScript, Contents: serverProvisionScript, Mode: fileModeScript})
+
+	for i, f := range files {
+		debugCloudAction(rtServers, "Uploading file to '%s' (hostname: %s)", f.Path, hostname)
+
+		files[i].Contents = strings.ReplaceAll(f.Contents, "\r", "")
+		err = s.UploadFile(sshClient, sftpClient, f.Path, bytes.NewBufferString(files[i].Contents), f.Mode, 0, 0)
+
+		if err != nil {
+			debugCloudAction(rtServers, "Failed to create server because file '%s' could not be uploaded (hostname: %s)", f.Path, hostname)
+
+			s.Destroy()
 
-	err = s.UploadFile(sftpClient, pathServerProvisionScript, bytes.NewBufferString(strings.ReplaceAll(serverProvisionScript, "\r", "")))
+			return err
+		}
+	}
+
+	verifyCommand, err := s.signProvisioningFiles(sshClient, sftpClient, files)
 
 	if err != nil {
-		debugCloudAction(rtServers, "Failed to create server because file '%s' could not be uploaded (hostname: %s)", pathServerProvisionScript, hostname)
+		debugCloudAction(rtServers, "Failed to create server because the provisioning files could not be signed (hostname: %s) - Error: %s", hostname, err.Error())
 
 		s.Destroy()
 
@@ -241,7 +448,15 @@ func (s *CloudServer) Create(locationID string, packageID string, hostname strin
 
 	debugCloudAction(rtServers, "Upgrading and configuring the operating system (hostname: %s)", hostname)
 
-	output, err := sshSession.CombinedOutput("/bin/bash " + pathServerProvisionScript)
+	provisionCommand := fmt.Sprintf("CLOUDDK_SSH_USER=%s /bin/bash %s", shellQuote(s.CloudConfiguration.SSHUser), pathServerProvisionScript)
+
+	if verifyCommand != "" {
+		provisionCommand = verifyCommand + " && " + provisionCommand
+	}
+	timeStart = time.Now()
+	output, err := sshSession.CombinedOutput(provisionCommand)
+
+	s.CloudConfiguration.AuditLog.RecordCommand(s, provisionCommand, output, err, timeStart)
 
 	if err != nil {
 		debugCloudAction(rtServers, "Failed to create server due to shell errors (hostname: %s) - Output: %s - Error: %s", hostname, string(output), err.Error())
@@ -251,6 +466,48 @@ func (s *CloudServer) Create(locationID string, packageID string, hostname strin
 		return err
 	}
 
+	// Lock the initial root password once the controller has confirmed that it can authenticate without it.
+	debugCloudAction(rtServers, "Verifying key-based authentication (hostname: %s)", hostname)
+
+	keySSHClient, err := s.SSH()
+
+	if err != nil {
+		debugCloudAction(rtServers, "Failed to create server because key-based authentication failed (hostname: %s) - Error: %s", hostname, err.Error())
+
+		s.Destroy()
+
+		return err
+	}
+
+	defer keySSHClient.Close()
+
+	debugCloudAction(rtServers, "Locking the root password (hostname: %s)", hostname)
+
+	output, err = s.RunCommand(keySSHClient, "passwd -l root")
+
+	if err != nil {
+		debugCloudAction(rtServers, "Failed to create server because the root password could not be locked (hostname: %s) - Output: %s - Error: %s", hostname, string(output), err.Error())
+
+		s.Destroy()
+
+		return err
+	}
+
+	// Disable root logins entirely, once the controller has confirmed that it can authenticate as the dedicated user.
+	if !s.isPrivileged() {
+		debugCloudAction(rtServers, "Disabling root logins (hostname: %s)", hostname)
+
+		output, err = s.RunCommand(keySSHClient, serverDisableRootLoginCommand)
+
+		if err != nil {
+			debugCloudAction(rtServers, "Failed to create server because root logins could not be disabled (hostname: %s) - Output: %s - Error: %s", hostname, string(output), err.Error())
+
+			s.Destroy()
+
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -260,10 +517,19 @@ func (s *CloudServer) Destroy() error {
 		return errors.New("The server has not been initialized")
 	}
 
+	err := s.verifyOwnership()
+
+	if err != nil {
+		debugCloudAction(rtServers, "WARNING: Refusing to destroy server '%s' (hostname: %s) - Error: %s", s.Information.Identifier, s.Information.Hostname, err.Error())
+
+		return err
+	}
+
 	debugCloudAction(rtServers, "Destroying server (hostname: %s)", s.Information.Hostname)
 
-	_, err := clouddk.DoClientRequest(
-		s.CloudConfiguration.ClientSettings,
+	timeStart := time.Now()
+	_, err = doClientRequest(
+		s.CloudConfiguration,
 		"DELETE",
 		fmt.Sprintf("cloudservers/%s", s.Information.Identifier),
 		new(bytes.Buffer),
@@ -272,30 +538,130 @@ func (s *CloudServer) Destroy() error {
 		10,
 	)
 
+	s.CloudConfiguration.AuditLog.RecordMutation(s, mutationActionServerDestroy, nil, err, timeStart)
+
 	if err != nil {
 		debugCloudAction(rtServers, "Failed to destroy server (hostname: %s)", s.Information.Hostname)
 
 		return err
 	}
 
+	err = s.CloudConfiguration.KnownHosts.Remove(s.Information.Identifier)
+
+	if err != nil {
+		debugCloudAction(rtServers, "WARNING: Failed to remove the recorded host keys (hostname: %s) - Error: %s", s.Information.Hostname, err.Error())
+	}
+
 	s.Information = clouddk.ServerBody{}
 
 	return nil
 }
 
+// GetPendingActions retrieves the actions, which are still pending or running on the server.
+func (s *CloudServer) GetPendingActions() (clouddk.LogsListBody, error) {
+	if s.Information.Identifier == "" {
+		return nil, errors.New("The server has not been initialized")
+	}
+
+	res, err := doClientRequest(
+		s.CloudConfiguration,
+		"GET",
+		fmt.Sprintf("cloudservers/%s/logs", s.Information.Identifier),
+		new(bytes.Buffer),
+		[]int{200},
+		1,
+		1,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	logsList := clouddk.LogsListBody{}
+	err = json.NewDecoder(res.Body).Decode(&logsList)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pendingActions := clouddk.LogsListBody{}
+
+	for _, v := range logsList {
+		if v.Status == "pending" || v.Status == "running" {
+			pendingActions = append(pendingActions, v)
+		}
+	}
+
+	return pendingActions, nil
+}
+
 // GetRandomPassword generates a random password of a fixed length.
+// The characters are chosen with a cryptographically secure generator, as the password is used for the initial root login.
 func (s *CloudServer) GetRandomPassword(length int) string {
 	var b strings.Builder
 
 	chars := []rune("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789")
+	max := big.NewInt(int64(len(chars)))
 
 	for i := 0; i < length; i++ {
-		b.WriteRune(chars[rand.Intn(len(chars))])
+		n, err := rand.Int(rand.Reader, max)
+
+		if err != nil {
+			panic(err)
+		}
+
+		b.WriteRune(chars[n.Int64()])
 	}
 
 	return b.String()
 }
 
+// GetSSHAddress retrieves the address used for SSH connections to the server.
+// IPv4 addresses are preferred unless a specific address family has been configured.
+func (s *CloudServer) GetSSHAddress() (string, error) {
+	ipv4Address := ""
+	ipv6Address := ""
+
+	for _, nic := range s.Information.NetworkInterfaces {
+		for _, ip := range nic.IPAddresses {
+			parsedIP := net.ParseIP(ip.Address)
+
+			if parsedIP == nil {
+				continue
+			}
+
+			if parsedIP.To4() != nil {
+				if ipv4Address == "" {
+					ipv4Address = parsedIP.String()
+				}
+			} else if ipv6Address == "" {
+				ipv6Address = parsedIP.String()
+			}
+		}
+	}
+
+	address := ""
+
+	switch s.CloudConfiguration.SSHAddressFamily {
+	case addressFamilyIPv4:
+		address = ipv4Address
+	case addressFamilyIPv6:
+		address = ipv6Address
+	default:
+		address = ipv4Address
+
+		if address == "" {
+			address = ipv6Address
+		}
+	}
+
+	if address == "" {
+		return "", fmt.Errorf("No usable IP addresses available for server '%s' (family: %s)", s.Information.Identifier, s.CloudConfiguration.SSHAddressFamily)
+	}
+
+	return net.JoinHostPort(address, "22"), nil
+}
+
 // InitializeByHostname initializes a CloudServer based on a hostname.
 func (s *CloudServer) InitializeByHostname(hostname string) (notFound bool, e error) {
 	if s.Information.Identifier != "" {
@@ -306,8 +672,15 @@ func (s *CloudServer) InitializeByHostname(hostname string) (notFound bool, e er
 		return false, errors.New("Cannot retrieve a server without a hostname")
 	}
 
-	res, err := clouddk.DoClientRequest(
-		s.CloudConfiguration.ClientSettings,
+	if information, ok := s.CloudConfiguration.ServerList.Find(s.CloudConfiguration, func(v clouddk.ServerBody) bool { return v.Hostname == hostname }); ok {
+		s.Information = information
+		s.CloudConfiguration.CircuitBreaker.PutServer(information)
+
+		return false, nil
+	}
+
+	res, err := doClientRequest(
+		s.CloudConfiguration,
 		"GET",
 		fmt.Sprintf("cloudservers?hostname=%s", url.QueryEscape(hostname)),
 		new(bytes.Buffer),
@@ -316,6 +689,15 @@ func (s *CloudServer) InitializeByHostname(hostname string) (notFound bool, e er
 		1,
 	)
 
+	// Changes must not be based on the last known state, which is why they fail, until the API is available again.
+	if isCircuitOpenError(err) && s.ReadOnly {
+		if information, ok := s.CloudConfiguration.CircuitBreaker.GetServer("hostname/" + hostname); ok {
+			s.Information = information
+
+			return false, nil
+		}
+	}
+
 	if err != nil {
 		return false, err
 	}
@@ -330,6 +712,7 @@ func (s *CloudServer) InitializeByHostname(hostname string) (notFound bool, e er
 	for _, v := range servers {
 		if v.Hostname == hostname {
 			s.Information = v
+			s.CloudConfiguration.CircuitBreaker.PutServer(v)
 
 			return false, nil
 		}
@@ -348,8 +731,22 @@ func (s *CloudServer) InitializeByID(id string) (notFound bool, e error) {
 		return false, errors.New("Cannot retrieve a server without an identifier")
 	}
 
-	res, err := clouddk.DoClientRequest(
-		s.CloudConfiguration.ClientSettings,
+	// The cached listing and responses may predate changes to the server, which is why servers, which are going to be changed, are always retrieved from the API.
+	request := doUncachedClientRequest
+
+	if s.ReadOnly {
+		if information, ok := s.CloudConfiguration.ServerList.Find(s.CloudConfiguration, func(v clouddk.ServerBody) bool { return v.Identifier == id }); ok {
+			s.Information = information
+			s.CloudConfiguration.CircuitBreaker.PutServer(information)
+
+			return false, nil
+		}
+
+		request = doClientRequest
+	}
+
+	res, err := request(
+		s.CloudConfiguration,
 		"GET",
 		fmt.Sprintf("cloudservers/%s", id),
 		new(bytes.Buffer),
@@ -358,16 +755,28 @@ func (s *CloudServer) InitializeByID(id string) (notFound bool, e error) {
 		1,
 	)
 
+	if isCircuitOpenError(err) && s.ReadOnly {
+		if information, ok := s.CloudConfiguration.CircuitBreaker.GetServer("id/" + id); ok {
+			s.Information = information
+
+			return false, nil
+		}
+	}
+
 	if err != nil {
-		return (res.StatusCode == 404), err
+		return isNotFoundError(err), err
 	}
 
+	defer res.Body.Close()
+
 	err = json.NewDecoder(res.Body).Decode(&s.Information)
 
 	if err != nil {
 		return false, err
 	}
 
+	s.CloudConfiguration.CircuitBreaker.PutServer(s.Information)
+
 	return false, nil
 }
 
@@ -400,20 +809,48 @@ func (s *CloudServer) SSH() (*ssh.Client, error) {
 		return nil, errors.New("The server has not been initialized")
 	}
 
-	sshPrivateKeyBuffer := bytes.NewBufferString(s.CloudConfiguration.PrivateKey)
-	sshPrivateKeySigner, err := ssh.ParsePrivateKey(sshPrivateKeyBuffer.Bytes())
+	sshClient, err := s.sshAsUser(s.CloudConfiguration.SSHUser)
+
+	// Servers provisioned before the dedicated user was introduced only authorize the key for root.
+	if err != nil && !s.isPrivileged() && strings.Contains(err.Error(), "unable to authenticate") {
+		debugCloudAction(rtServers, "WARNING: Falling back to root, as the server does not accept the user '%s' (hostname: %s)", s.CloudConfiguration.SSHUser, s.Information.Hostname)
+
+		return s.sshAsUser("root")
+	}
+
+	return sshClient, err
+}
+
+// sshAsUser establishes a new SSH connection to a Cloud.dk server as the specified user.
+func (s *CloudServer) sshAsUser(user string) (*ssh.Client, error) {
+	privateKey, _ := s.CloudConfiguration.getStaticSSHKeys()
+
+	if s.SSHKeyPair != nil {
+		privateKey = s.SSHKeyPair.PrivateKey
+	}
+
+	sshSigners, err := getSSHSigners(s.CloudConfiguration, user, privateKey)
 
 	if err != nil {
 		return nil, err
 	}
 
 	sshConfig := &ssh.ClientConfig{
-		User:            "root",
-		Auth:            []ssh.AuthMethod{ssh.PublicKeys(sshPrivateKeySigner)},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(sshSigners...)},
+		HostKeyCallback: s.getHostKeyCallback(),
+		Timeout:         s.CloudConfiguration.SSHDialTimeout,
+	}
+
+	applySSHCryptoPolicy(s.CloudConfiguration, sshConfig)
+
+	sshAddress, err := s.GetSSHAddress()
+
+	if err != nil {
+		return nil, err
 	}
 
-	sshClient, err := ssh.Dial("tcp", s.Information.NetworkInterfaces[0].IPAddresses[0].Address+":22", sshConfig)
+	sshClient, err := s.dialSSH(sshAddress, sshConfig)
 
 	if err != nil {
 		return nil, err
@@ -422,20 +859,176 @@ func (s *CloudServer) SSH() (*ssh.Client, error) {
 	return sshClient, nil
 }
 
-// UploadFile uploads a file to the server.
-func (s *CloudServer) UploadFile(sftpClient *sftp.Client, filePath string, fileContents *bytes.Buffer) error {
-	newSFTPClient := sftpClient
+// Rename changes the hostname and the label of the server.
+func (s *CloudServer) Rename(hostname string) error {
+	if s.Information.Identifier == "" {
+		return errors.New("The server has not been initialized")
+	}
 
-	if newSFTPClient == nil {
-		sshClient, err := s.SSH()
+	if s.Information.Hostname == hostname && s.Information.Label == hostname {
+		return nil
+	}
+
+	debugCloudAction(rtServers, "Renaming server '%s' to '%s'", s.Information.Hostname, hostname)
+
+	body := clouddk.ServerUpdateBody{
+		Hostname: hostname,
+		Label:    hostname,
+	}
+
+	reqBody := new(bytes.Buffer)
+	err := json.NewEncoder(reqBody).Encode(body)
+
+	if err != nil {
+		return err
+	}
+
+	timeStart := time.Now()
+	res, err := doClientRequest(
+		s.CloudConfiguration,
+		"PUT",
+		fmt.Sprintf("cloudservers/%s", s.Information.Identifier),
+		reqBody,
+		[]int{200},
+		1,
+		1,
+	)
+
+	s.CloudConfiguration.AuditLog.RecordMutation(s, mutationActionServerRename, map[string]string{
+		"from_hostname": s.Information.Hostname,
+		"to_hostname":   hostname,
+	}, err, timeStart)
+
+	if err != nil {
+		debugCloudAction(rtServers, "Failed to rename server (hostname: %s)", s.Information.Hostname)
+
+		return err
+	}
+
+	defer res.Body.Close()
+
+	s.Information.Hostname = hostname
+	s.Information.Label = hostname
+
+	return nil
+}
+
+// Resize changes the package of the server, waits for the change to complete and verifies that the systemd unit of the load balancer software is active afterwards.
+func (s *CloudServer) Resize(packageID string, unit string) error {
+	if s.Information.Identifier == "" {
+		return errors.New("The server has not been initialized")
+	}
+
+	if s.Information.Package.Identifier == packageID {
+		return nil
+	}
+
+	debugCloudAction(rtServers, "Resizing server from package '%s' to '%s' (hostname: %s)", s.Information.Package.Identifier, packageID, s.Information.Hostname)
+
+	body := clouddk.ServerUpgradeBody{
+		Package:     packageID,
+		UpgradeDisk: false,
+	}
+
+	reqBody := new(bytes.Buffer)
+	err := json.NewEncoder(reqBody).Encode(body)
+
+	if err != nil {
+		return err
+	}
+
+	timeStart := time.Now()
+	_, err = doClientRequest(
+		s.CloudConfiguration,
+		"POST",
+		fmt.Sprintf("cloudservers/%s/upgrade", s.Information.Identifier),
+		reqBody,
+		[]int{200},
+		1,
+		1,
+	)
+
+	s.CloudConfiguration.AuditLog.RecordMutation(s, mutationActionServerResize, map[string]string{
+		"from_package": s.Information.Package.Identifier,
+		"to_package":   packageID,
+	}, err, timeStart)
+
+	if err != nil {
+		debugCloudAction(rtServers, "Failed to resize server (hostname: %s)", s.Information.Hostname)
+
+		return err
+	}
+
+	err = s.WaitForActions(10 * time.Minute)
+
+	if err != nil {
+		debugCloudAction(rtServers, "Failed to resize server due to unfinished actions (hostname: %s)", s.Information.Hostname)
+
+		return err
+	}
+
+	// Refresh the server information in order to verify that the package was changed.
+	id := s.Information.Identifier
+	s.Information = clouddk.ServerBody{}
+
+	_, err = s.InitializeByID(id)
+
+	if err != nil {
+		return err
+	}
+
+	if s.Information.Package.Identifier != packageID {
+		return fmt.Errorf("The server '%s' still uses package '%s' after being resized to package '%s'", id, s.Information.Package.Identifier, packageID)
+	}
+
+	sshClient, err := s.WaitForSSH(5 * time.Minute)
+
+	if err != nil {
+		debugCloudAction(rtServers, "Failed to resize server due to SSH timeout (hostname: %s)", s.Information.Hostname)
+
+		return err
+	}
+
+	defer sshClient.Close()
+
+	output, err := s.RunCommand(sshClient, fmt.Sprintf("systemctl is-active %s", unit))
+
+	if err != nil {
+		return fmt.Errorf("The unit '%s' is not active after resizing server '%s' - Output: %s - Error: %s", unit, id, strings.TrimSpace(string(output)), err.Error())
+	}
+
+	debugCloudAction(rtServers, "Successfully resized server (hostname: %s)", s.Information.Hostname)
+
+	return nil
+}
+
+// ReplaceFile uploads a file to a temporary path, optionally validates it and moves it into place with a remote rename.
+// The permissions and ownership are applied before the file is moved into place.
+// The validation command must contain a single '%s' verb, which is replaced with the temporary path.
+func (s *CloudServer) ReplaceFile(sshClient *ssh.Client, sftpClient *sftp.Client, filePath string, fileContents *bytes.Buffer, mode os.FileMode, uid int, gid int, validationCommand string) (err error) {
+	fileSize := fileContents.Len()
+	timeStart := time.Now()
+
+	defer func() {
+		s.CloudConfiguration.AuditLog.RecordUpload(s, filePath, mode, fileSize, err, timeStart)
+	}()
+
+	newSSHClient := sshClient
+
+	if newSSHClient == nil {
+		newSSHClient, err = s.SSH()
 
 		if err != nil {
 			return err
 		}
 
-		defer sshClient.Close()
+		defer newSSHClient.Close()
+	}
+
+	newSFTPClient := sftpClient
 
-		newSFTPClient, err = s.SFTP(sshClient)
+	if newSFTPClient == nil {
+		newSFTPClient, err = s.SFTP(newSSHClient)
 
 		if err != nil {
 			return err
@@ -445,25 +1038,393 @@ func (s *CloudServer) UploadFile(sftpClient *sftp.Client, filePath string, fileC
 	}
 
 	dir := filepath.Dir(filePath)
-	err := newSFTPClient.MkdirAll(dir)
+	tempFilePath := filepath.Join(dir, fmt.Sprintf(".%s.tmp", filepath.Base(filePath)))
+	uploadFilePath := tempFilePath
+
+	// Unprivileged users cannot write to most of the configuration directories, which is why the file is staged
+	// in the temporary directory and copied into place with sudo.
+	if s.isPrivileged() {
+		err = newSFTPClient.MkdirAll(dir)
+
+		if err != nil {
+			return err
+		}
+	} else {
+		uploadFilePath = fmt.Sprintf("/tmp/.%s.%s.tmp", filepath.Base(filePath), s.GetRandomPassword(8))
+	}
+
+	remoteFile, err := newSFTPClient.Create(uploadFilePath)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = remoteFile.ReadFrom(fileContents)
 
 	if err != nil {
+		remoteFile.Close()
+		newSFTPClient.Remove(uploadFilePath)
+
 		return err
 	}
 
-	remoteFile, err := newSFTPClient.Create(filePath)
+	err = remoteFile.Close()
 
 	if err != nil {
+		newSFTPClient.Remove(uploadFilePath)
+
 		return err
 	}
 
-	defer remoteFile.Close()
+	removeTempFile := func() {
+		if s.isPrivileged() {
+			newSFTPClient.Remove(tempFilePath)
+		} else {
+			s.RunCommand(newSSHClient, fmt.Sprintf("rm -f %s", shellQuote(tempFilePath)))
+		}
+	}
+
+	if s.isPrivileged() {
+		err = newSFTPClient.Chmod(tempFilePath, mode)
 
-	_, err = remoteFile.ReadFrom(fileContents)
+		if err != nil {
+			removeTempFile()
+
+			return err
+		}
+
+		err = newSFTPClient.Chown(tempFilePath, uid, gid)
+
+		if err != nil {
+			removeTempFile()
+
+			return err
+		}
+	} else {
+		output, err := s.RunCommand(newSSHClient, fmt.Sprintf(
+			"mkdir -p %s && install -m %o -o %d -g %d %s %s",
+			shellQuote(dir),
+			mode,
+			uid,
+			gid,
+			shellQuote(uploadFilePath),
+			shellQuote(tempFilePath),
+		))
+
+		newSFTPClient.Remove(uploadFilePath)
+
+		if err != nil {
+			removeTempFile()
+
+			return fmt.Errorf("Failed to stage file '%s' - Output: %s - Error: %s", filePath, string(output), err.Error())
+		}
+	}
+
+	if validationCommand != "" {
+		output, err := s.RunCommand(newSSHClient, fmt.Sprintf(validationCommand, tempFilePath))
+
+		if err != nil {
+			removeTempFile()
+
+			return newConfigurationError(fmt.Errorf("Validation of file '%s' failed - Output: %s - Error: %s", filePath, string(output), err.Error()))
+		}
+	}
+
+	if s.isPrivileged() {
+		err = newSFTPClient.PosixRename(tempFilePath, filePath)
+	} else {
+		_, err = s.RunCommand(newSSHClient, fmt.Sprintf("mv -f %s %s", shellQuote(tempFilePath), shellQuote(filePath)))
+	}
 
 	if err != nil {
+		removeTempFile()
+
 		return err
 	}
 
 	return nil
 }
+
+// RunCommand executes a command on the server and returns the combined output.
+// The command is executed through sudo, if the SSH user is not root.
+func (s *CloudServer) RunCommand(sshClient *ssh.Client, command string) ([]byte, error) {
+	sshSession, err := sshClient.NewSession()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer sshSession.Close()
+
+	if !s.isPrivileged() {
+		command = fmt.Sprintf("sudo -n -- /bin/bash -c %s", shellQuote(command))
+	}
+
+	timeStart := time.Now()
+	output, err := sshSession.CombinedOutput(command)
+
+	s.CloudConfiguration.AuditLog.RecordCommand(s, command, output, err, timeStart)
+
+	return output, err
+}
+
+// UploadFile uploads a file to the server and applies the specified permissions and ownership.
+// The file is written to a temporary path and moved into place once the upload has completed.
+func (s *CloudServer) UploadFile(sshClient *ssh.Client, sftpClient *sftp.Client, filePath string, fileContents *bytes.Buffer, mode os.FileMode, uid int, gid int) error {
+	return s.ReplaceFile(sshClient, sftpClient, filePath, fileContents, mode, uid, gid, "")
+}
+
+// WaitForActions waits for pending and running actions on the server to complete.
+func (s *CloudServer) WaitForActions(timeout time.Duration) error {
+	timeStart := time.Now()
+
+	for {
+		pendingActions, err := s.GetPendingActions()
+
+		if err != nil {
+			return err
+		}
+
+		if len(pendingActions) == 0 {
+			return nil
+		}
+
+		if time.Now().Sub(timeStart) > timeout {
+			return fmt.Errorf("Timed out waiting for %d actions to complete on server '%s'", len(pendingActions), s.Information.Identifier)
+		}
+
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// WaitForSSH waits for the server to accept SSH connections.
+func (s *CloudServer) WaitForSSH(timeout time.Duration) (*ssh.Client, error) {
+	timeStart := time.Now()
+
+	for {
+		sshClient, err := s.SSH()
+
+		if err == nil {
+			return sshClient, nil
+		}
+
+		if time.Now().Sub(timeStart) > timeout {
+			return nil, err
+		}
+
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// dialSSH establishes a new SSH connection and keeps it alive until it is closed or stops responding.
+// The connection is established with the dial function of the configuration, if one has been specified, which allows the SSH server to be replaced by a test double.
+func (s *CloudServer) dialSSH(address string, sshConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	if s.CloudConfiguration.SSHDial == nil {
+		sshClient, err := ssh.Dial("tcp", address, sshConfig)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return s.keepAliveSSH(address, sshClient), nil
+	}
+
+	conn, err := s.CloudConfiguration.SSHDial("tcp", address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, address, sshConfig)
+
+	if err != nil {
+		conn.Close()
+
+		return nil, err
+	}
+
+	return s.keepAliveSSH(address, ssh.NewClient(clientConn, chans, reqs)), nil
+}
+
+// keepAliveSSH sends keep-alive requests over an SSH connection and closes it, once the server stops responding.
+func (s *CloudServer) keepAliveSSH(address string, sshClient *ssh.Client) *ssh.Client {
+
+	closed := make(chan struct{})
+
+	go func() {
+		sshClient.Wait()
+		close(closed)
+	}()
+
+	go func() {
+		failures := 0
+		ticker := time.NewTicker(s.CloudConfiguration.SSHKeepAliveInterval)
+
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-closed:
+				return
+			case <-ticker.C:
+				// The request is sent asynchronously as it blocks until a reply arrives, which never happens for black-holed hosts.
+				reply := make(chan error, 1)
+
+				go func() {
+					_, _, err := sshClient.SendRequest("keepalive@openssh.com", true, nil)
+					reply <- err
+				}()
+
+				select {
+				case <-closed:
+					return
+				case err := <-reply:
+					if err != nil {
+						failures++
+					} else {
+						failures = 0
+					}
+				case <-time.After(s.CloudConfiguration.SSHKeepAliveInterval):
+					failures++
+				}
+
+				if failures >= s.CloudConfiguration.SSHKeepAliveCountMax {
+					debugCloudAction(rtServers, "Closing unresponsive SSH connection to '%s' (hostname: %s)", address, s.Information.Hostname)
+
+					sshClient.Close()
+
+					return
+				}
+			}
+		}
+	}()
+
+	return sshClient
+}
+
+// findCreatedServer looks for a server, which was created by a request that failed with an indeterminate error.
+// The server may not be listed right away, which is why the lookup is repeated until serverAdoptionTimeout has elapsed. Only servers carrying the ownership label are adopted.
+func (s *CloudServer) findCreatedServer(hostname string) (clouddk.ServerBody, bool) {
+	timeStart := time.Now()
+
+	for {
+		// The response cache is bypassed, as it may still contain a listing from before the server was created.
+		res, err := doUncachedClientRequest(
+			s.CloudConfiguration,
+			"GET",
+			fmt.Sprintf("cloudservers?hostname=%s", url.QueryEscape(hostname)),
+			new(bytes.Buffer),
+			[]int{200},
+			1,
+			1,
+		)
+
+		if err == nil {
+			servers := make(clouddk.ServerListBody, 0)
+			err = json.NewDecoder(res.Body).Decode(&servers)
+			res.Body.Close()
+
+			if err == nil {
+				for _, v := range servers {
+					if v.Hostname == hostname && v.Label == hostname {
+						return v, true
+					}
+				}
+			}
+		}
+
+		if err != nil {
+			debugCloudAction(rtServers, "Failed to look for a created server (hostname: %s) - Error: %s", hostname, err.Error())
+		}
+
+		if time.Now().Sub(timeStart)+serverAdoptionInterval >= serverAdoptionTimeout {
+			return clouddk.ServerBody{}, false
+		}
+
+		time.Sleep(serverAdoptionInterval)
+	}
+}
+
+// verifyOwnership re-fetches the server and verifies that it is still the server, which the controller expects to manage.
+// This guards against hash collisions and stale identifiers, which would otherwise cause the wrong server to be destroyed.
+// The server is always retrieved from the API, as neither the cached listings nor the last known state of the circuit breaker prove the current ownership.
+func (s *CloudServer) verifyOwnership() error {
+	if s.Information.Identifier == "" {
+		return errors.New("Cannot verify the ownership of a server without an identifier")
+	}
+
+	res, err := doUncachedClientRequest(
+		s.CloudConfiguration,
+		"GET",
+		fmt.Sprintf("cloudservers/%s", s.Information.Identifier),
+		new(bytes.Buffer),
+		[]int{200},
+		1,
+		1,
+	)
+
+	if err != nil {
+		if isNotFoundError(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	defer res.Body.Close()
+
+	current := CloudServer{
+		CloudConfiguration: s.CloudConfiguration,
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&current.Information)
+
+	if err != nil {
+		return err
+	}
+
+	if current.Information.Identifier != s.Information.Identifier {
+		return fmt.Errorf("The identifier '%s' does not match the expected identifier '%s'", current.Information.Identifier, s.Information.Identifier)
+	}
+
+	if current.Information.Hostname != s.Information.Hostname {
+		return fmt.Errorf("The hostname '%s' does not match the expected hostname '%s'", current.Information.Hostname, s.Information.Hostname)
+	}
+
+	if !reLoadBalancerHostname.MatchString(current.Information.Hostname) {
+		return fmt.Errorf("The hostname '%s' does not match the load balancer hostname pattern", current.Information.Hostname)
+	}
+
+	if current.Information.Label != current.Information.Hostname {
+		return fmt.Errorf("The label '%s' does not match the ownership label '%s'", current.Information.Label, current.Information.Hostname)
+	}
+
+	return nil
+}
+
+// lockDown disables password authentication on a new server.
+func (s *CloudServer) lockDown(sshClient *ssh.Client) error {
+	sshSession, err := sshClient.NewSession()
+
+	if err != nil {
+		return err
+	}
+
+	defer sshSession.Close()
+
+	timeStart := time.Now()
+	output, err := sshSession.CombinedOutput(serverLockdownCommand)
+
+	s.CloudConfiguration.AuditLog.RecordCommand(s, serverLockdownCommand, output, err, timeStart)
+
+	if err != nil {
+		return fmt.Errorf("%s - Output: %s", err.Error(), string(output))
+	}
+
+	return nil
+}
+
+// isPrivileged returns whether the SSH user is root.
+func (s *CloudServer) isPrivileged() bool {
+	return s.CloudConfiguration.SSHUser == "" || s.CloudConfiguration.SSHUser == "root"
+}
@@ -0,0 +1,96 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/danitso/terraform-provider-clouddk/clouddk"
+)
+
+// ServerList holds a listing of all servers, which is shared by the instances, zones and load balancer controllers.
+// The listing is retrieved at most once per interval, which replaces the per-node and per-service queries in large clusters.
+type ServerList struct {
+	fetched  time.Time
+	interval time.Duration
+	mutex    sync.Mutex
+	servers  clouddk.ServerListBody
+}
+
+// newServerList initializes a new ServerList object. The listing is never retrieved, if the interval is zero.
+func newServerList(interval time.Duration) *ServerList {
+	return &ServerList{
+		interval: interval,
+	}
+}
+
+// Find retrieves the first server in the listing, which matches the function.
+// Servers missing from the listing must be looked up individually, as they may have been created since it was retrieved.
+func (l *ServerList) Find(c *CloudConfiguration, match func(server clouddk.ServerBody) bool) (clouddk.ServerBody, bool) {
+	if l == nil || l.interval == 0 {
+		return clouddk.ServerBody{}, false
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.servers == nil || time.Now().Sub(l.fetched) >= l.interval {
+		err := l.refresh(c)
+
+		if err != nil {
+			debugCloudAction(rtCloud, "Failed to retrieve the server list - Error: %s", err.Error())
+
+			return clouddk.ServerBody{}, false
+		}
+	}
+
+	for _, v := range l.servers {
+		if match(v) {
+			return v, true
+		}
+	}
+
+	return clouddk.ServerBody{}, false
+}
+
+// Invalidate discards the listing, which causes it to be retrieved again on the next lookup.
+func (l *ServerList) Invalidate() {
+	if l == nil || l.interval == 0 {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.servers = nil
+}
+
+// refresh retrieves the listing from the API.
+func (l *ServerList) refresh(c *CloudConfiguration) error {
+	res, err := doClientRequest(c, "GET", "cloudservers", new(bytes.Buffer), []int{200}, 1, 1)
+
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	servers := make(clouddk.ServerListBody, 0)
+	err = json.NewDecoder(res.Body).Decode(&servers)
+
+	if err != nil {
+		return err
+	}
+
+	debugCloudAction(rtCloud, "Retrieved server list (count: %d)", len(servers))
+
+	l.fetched = time.Now()
+	l.servers = servers
+
+	return nil
+}
@@ -0,0 +1,107 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"fmt"
+	"regexp"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// annoLoadBalancerOSTemplate is the annotation specifying the OS template of the server, which is created for the Load Balancer.
+	// The annotation has no effect on existing servers.
+	// Defaults to the value of CLOUDDK_SERVER_TEMPLATE.
+	annoLoadBalancerOSTemplate = "kubernetes.cloud.dk/load-balancer-os-template"
+
+	// fmtHAProxyRepository specifies the format for the APT repository, which provides a specific HAProxy version.
+	fmtHAProxyRepository = "ppa:vbernat/haproxy-%s"
+
+	// haProxyVersionDefault specifies the HAProxy version for OS templates, which do not have a built-in version.
+	haProxyVersionDefault = "2.0"
+)
+
+var (
+	// reHAProxyVersion matches the HAProxy versions, which can be installed from the APT repository.
+	reHAProxyVersion = regexp.MustCompile(`^[0-9]+\.[0-9]+$`)
+
+	// reServerTemplate matches the identifiers of the OS templates.
+	reServerTemplate = regexp.MustCompile(`^[0-9a-z][0-9a-z._-]{0,63}$`)
+
+	// serverTemplateHAProxyVersions specifies the HAProxy versions for the supported OS templates, as the repository does not provide every version for every release.
+	serverTemplateHAProxyVersions = map[string]string{
+		"ubuntu-18.04-x64": "2.0",
+		"ubuntu-20.04-x64": "2.4",
+		"ubuntu-22.04-x64": "2.4",
+	}
+)
+
+// getHAProxyPackageSource retrieves the APT repository and the version of the HAProxy package, which is installed on the server of a load balancer.
+// The version is derived from the OS template of the server, unless a version has been requested or configured.
+func getHAProxyPackageSource(c *CloudConfiguration, server *CloudServer, service *v1.Service) (repository string, version string, err error) {
+	version, err = getRequestedHAProxyVersion(service)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	if version == "" {
+		version = c.HAProxyVersion
+	}
+
+	if version == "" {
+		version = serverTemplateHAProxyVersions[server.Information.Template.Identifier]
+	}
+
+	if version == "" {
+		version = haProxyVersionDefault
+	}
+
+	repository = c.HAProxyRepository
+
+	if repository == "" {
+		repository = fmt.Sprintf(fmtHAProxyRepository, version)
+	}
+
+	return repository, version, nil
+}
+
+// getServerTemplateByService retrieves the OS template for the server of a load balancer.
+func getServerTemplateByService(c *CloudConfiguration, service *v1.Service) (string, error) {
+	template := service.Annotations[annoLoadBalancerOSTemplate]
+
+	if template == "" {
+		return c.ServerTemplate, nil
+	}
+
+	err := validateServerTemplate(template)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", annoLoadBalancerOSTemplate, getLoadBalancerNameByService(service))
+
+		return "", newConfigurationError(err)
+	}
+
+	return template, nil
+}
+
+// validateHAProxyVersion validates a HAProxy version of the form <major>.<minor>.
+func validateHAProxyVersion(value string) error {
+	if !reHAProxyVersion.MatchString(value) {
+		return fmt.Errorf("The value '%s' is not a HAProxy version of the form <major>.<minor>", value)
+	}
+
+	return nil
+}
+
+// validateServerTemplate validates the identifier of an OS template.
+func validateServerTemplate(value string) error {
+	if !reServerTemplate.MatchString(value) {
+		return fmt.Errorf("The value '%s' is not a valid OS template", value)
+	}
+
+	return nil
+}
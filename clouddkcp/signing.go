@@ -0,0 +1,171 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	pathProvisioningManifest  = "/var/lib/clouddk/provisioning.manifest"
+	pathProvisioningPublicKey = "/etc/clouddk/provisioning.pub"
+	pathProvisioningSignature = "/var/lib/clouddk/provisioning.manifest.sig"
+)
+
+// ProvisioningSigner signs the files, which are uploaded to servers before they are executed or applied.
+type ProvisioningSigner struct {
+	privateKey *ecdsa.PrivateKey
+	publicKey  string
+}
+
+// newProvisioningSigner initializes a new ProvisioningSigner object from a PEM encoded ECDSA private key.
+// A nil object is returned, if no private key has been specified.
+func newProvisioningSigner(privateKey string) (*ProvisioningSigner, error) {
+	if privateKey == "" {
+		return nil, nil
+	}
+
+	block, _ := pem.Decode([]byte(privateKey))
+
+	if block == nil {
+		return nil, errors.New("The private key is not PEM encoded")
+	}
+
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+
+	if err != nil {
+		pkcs8Key, pkcs8Err := x509.ParsePKCS8PrivateKey(block.Bytes)
+
+		if pkcs8Err != nil {
+			return nil, err
+		}
+
+		ecdsaKey, ok := pkcs8Key.(*ecdsa.PrivateKey)
+
+		if !ok {
+			return nil, errors.New("The private key is not an ECDSA key")
+		}
+
+		key = ecdsaKey
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProvisioningSigner{
+		privateKey: key,
+		publicKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+	}, nil
+}
+
+// Sign creates an ASN.1 encoded ECDSA signature of the SHA-256 digest of the contents, which can be verified with OpenSSL.
+func (p *ProvisioningSigner) Sign(contents string) ([]byte, error) {
+	digest := sha256.Sum256([]byte(contents))
+
+	return p.privateKey.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// getProvisioningManifest generates a manifest in 'sha256sum' format, which is preceded by a line containing the nonce.
+func getProvisioningManifest(nonce string, files []provisioningFile) string {
+	manifest := fmt.Sprintf("nonce %s\n", nonce)
+
+	for _, f := range files {
+		manifest = manifest + fmt.Sprintf("%x  %s\n", sha256.Sum256([]byte(f.Contents)), f.Path)
+	}
+
+	return manifest
+}
+
+// signProvisioningFiles uploads a signed manifest of the files and returns the command, which verifies them on the server.
+// The nonce prevents a stale manifest from being accepted, and the verification fails, if any of the files have been modified.
+// An empty command is returned, if no signing key has been configured.
+func (s *CloudServer) signProvisioningFiles(sshClient *ssh.Client, sftpClient *sftp.Client, files []provisioningFile) (string, error) {
+	signer := s.CloudConfiguration.ProvisioningSigner
+
+	if signer == nil {
+		return "", nil
+	}
+
+	err := s.pinProvisioningPublicKey(sshClient, sftpClient)
+
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, 16)
+	_, err = rand.Read(nonce)
+
+	if err != nil {
+		return "", err
+	}
+
+	manifest := getProvisioningManifest(hex.EncodeToString(nonce), files)
+	signature, err := signer.Sign(manifest)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to sign the provisioning manifest - Error: %s", err.Error())
+	}
+
+	err = s.UploadFile(sshClient, sftpClient, pathProvisioningManifest, bytes.NewBufferString(manifest), fileModeConfig, 0, 0)
+
+	if err != nil {
+		return "", err
+	}
+
+	err = s.UploadFile(sshClient, sftpClient, pathProvisioningSignature, bytes.NewBuffer(signature), fileModeConfig, 0, 0)
+
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(
+		"openssl dgst -sha256 -verify %s -signature %s %s >/dev/null && [[ \"$(head -n 1 %s)\" == %s ]] && tail -n +2 %s | sha256sum --quiet --strict -c -",
+		pathProvisioningPublicKey,
+		pathProvisioningSignature,
+		pathProvisioningManifest,
+		pathProvisioningManifest,
+		shellQuote(fmt.Sprintf("nonce %s", hex.EncodeToString(nonce))),
+		pathProvisioningManifest,
+	), nil
+}
+
+// pinProvisioningPublicKey installs the public key of the signer on a server, unless a key has already been pinned.
+// A server, which has pinned a different key, is rejected, as it would otherwise be possible to replace the key along with the files.
+func (s *CloudServer) pinProvisioningPublicKey(sshClient *ssh.Client, sftpClient *sftp.Client) error {
+	publicKey := s.CloudConfiguration.ProvisioningSigner.publicKey
+	output, err := s.RunCommand(sshClient, fmt.Sprintf("cat %s 2>/dev/null || true", pathProvisioningPublicKey))
+
+	if err != nil {
+		return fmt.Errorf("Failed to retrieve the provisioning public key - Output: %s - Error: %s", string(output), err.Error())
+	}
+
+	pinnedKey := strings.TrimSpace(string(output))
+
+	if pinnedKey == strings.TrimSpace(publicKey) {
+		return nil
+	} else if pinnedKey != "" {
+		return fmt.Errorf("The server '%s' has pinned a different provisioning public key", s.Information.Identifier)
+	}
+
+	debugCloudAction(rtServers, "Pinning provisioning public key (hostname: %s)", s.Information.Hostname)
+
+	return s.UploadFile(sshClient, sftpClient, pathProvisioningPublicKey, bytes.NewBufferString(publicKey), fileModeConfig, 0, 0)
+}
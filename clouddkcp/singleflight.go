@@ -0,0 +1,97 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// RequestGroup coalesces concurrent identical requests into a single request.
+// This avoids a burst of identical lookups, when several controllers resolve the same node at the same time.
+type RequestGroup struct {
+	calls map[string]*requestGroupCall
+	mutex sync.Mutex
+}
+
+// requestGroupCall describes a request, which is in flight or has completed.
+type requestGroupCall struct {
+	body       []byte
+	err        error
+	header     http.Header
+	status     string
+	statusCode int
+	wg         sync.WaitGroup
+}
+
+// newRequestGroup initializes a new RequestGroup object.
+func newRequestGroup() *RequestGroup {
+	return &RequestGroup{
+		calls: map[string]*requestGroupCall{},
+	}
+}
+
+// Do performs the request, unless an identical request is already in flight, in which case its result is shared.
+// Every caller receives its own copy of the response body.
+func (g *RequestGroup) Do(key string, fn func() (*http.Response, error)) (*http.Response, error) {
+	if g == nil {
+		return fn()
+	}
+
+	g.mutex.Lock()
+
+	if call, ok := g.calls[key]; ok {
+		g.mutex.Unlock()
+		call.wg.Wait()
+
+		debugCloudAction(rtCloud, "Coalesced identical API request (path: %s)", key)
+
+		return call.newResponse(), call.err
+	}
+
+	call := &requestGroupCall{}
+	call.wg.Add(1)
+	g.calls[key] = call
+	g.mutex.Unlock()
+
+	response, err := fn()
+
+	call.err = err
+
+	if response != nil {
+		call.header = response.Header
+		call.status = response.Status
+		call.statusCode = response.StatusCode
+
+		if err == nil {
+			call.body, call.err = ioutil.ReadAll(response.Body)
+			response.Body.Close()
+		}
+	}
+
+	call.wg.Done()
+
+	g.mutex.Lock()
+	delete(g.calls, key)
+	g.mutex.Unlock()
+
+	return call.newResponse(), call.err
+}
+
+// newResponse creates a response, which contains a copy of the shared result.
+func (c *requestGroupCall) newResponse() *http.Response {
+	if c.statusCode == 0 {
+		return nil
+	}
+
+	return &http.Response{
+		Body:       ioutil.NopCloser(bytes.NewReader(c.body)),
+		Header:     c.header,
+		Status:     c.status,
+		StatusCode: c.statusCode,
+	}
+}
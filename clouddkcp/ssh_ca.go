@@ -0,0 +1,126 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// pathSSHCAPublicKey specifies the path to the public key of the certificate authority on a server.
+	pathSSHCAPublicKey = "/etc/ssh/clouddk_ca.pub"
+)
+
+// SSHCertificateAuthority signs short-lived user certificates for the SSH connections made by the controller.
+type SSHCertificateAuthority struct {
+	signer   ssh.Signer
+	validity time.Duration
+}
+
+// newSSHCertificateAuthority initializes a new SSHCertificateAuthority object.
+// A nil object is returned, if no private key has been specified.
+func newSSHCertificateAuthority(privateKey string, validity time.Duration) (*SSHCertificateAuthority, error) {
+	if privateKey == "" {
+		return nil, nil
+	}
+
+	signer, err := ssh.ParsePrivateKey([]byte(privateKey))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &SSHCertificateAuthority{
+		signer:   signer,
+		validity: validity,
+	}, nil
+}
+
+// PublicKey retrieves the public key of the certificate authority in authorized_keys format.
+func (a *SSHCertificateAuthority) PublicKey() string {
+	return string(ssh.MarshalAuthorizedKey(a.signer.PublicKey()))
+}
+
+// Sign generates an ephemeral key and signs a certificate for it, which is valid for the specified user.
+func (a *SSHCertificateAuthority) Sign(user string) (ssh.Signer, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := ssh.NewSignerFromKey(privateKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	serial := make([]byte, 8)
+	_, err = rand.Read(serial)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The certificate is backdated slightly in order to tolerate clock skew between the controller and the servers.
+	timeNow := time.Now()
+	certificate := &ssh.Certificate{
+		CertType:        ssh.UserCert,
+		Key:             signer.PublicKey(),
+		KeyId:           fmt.Sprintf("%s-%d", componentName, timeNow.Unix()),
+		Serial:          binary.BigEndian.Uint64(serial),
+		ValidAfter:      uint64(timeNow.Add(-1 * time.Minute).Unix()),
+		ValidBefore:     uint64(timeNow.Add(a.validity).Unix()),
+		ValidPrincipals: []string{user},
+	}
+
+	err = certificate.SignCert(rand.Reader, a.signer)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ssh.NewCertSigner(certificate, signer)
+}
+
+// getSSHSigners retrieves the signers used to authenticate SSH connections.
+// A certificate is preferred when a certificate authority has been configured, while the static key remains a fallback for servers provisioned without it.
+func getSSHSigners(c *CloudConfiguration, user string, privateKey string) ([]ssh.Signer, error) {
+	signers := []ssh.Signer{}
+
+	if c.SSHCertificateAuthority != nil {
+		signer, err := c.SSHCertificateAuthority.Sign(user)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to sign an SSH certificate - Error: %s", err.Error())
+		}
+
+		signers = append(signers, signer)
+	}
+
+	if privateKey != "" {
+		signer, err := ssh.ParsePrivateKey([]byte(privateKey))
+
+		if err != nil {
+			return nil, err
+		}
+
+		signers = append(signers, signer)
+	}
+
+	if len(signers) == 0 {
+		return nil, errors.New("No SSH keys have been configured")
+	}
+
+	return signers, nil
+}
@@ -0,0 +1,168 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// fmtLoadBalancerSSHKeyName specifies the name format for the Secret containing the SSH key pair of a load balancer.
+	fmtLoadBalancerSSHKeyName = "clouddk-load-balancer-%s-ssh"
+
+	// labelLoadBalancerSSHKey is the label identifying Secrets which contain the SSH key pair of a load balancer.
+	labelLoadBalancerSSHKey = "kubernetes.cloud.dk/load-balancer-ssh-key"
+
+	secretKeySSHPublicKey = "ssh-publickey"
+)
+
+// SSHKeyPair stores a dedicated SSH key pair for a single load balancer.
+type SSHKeyPair struct {
+	PrivateKey string
+	PublicKey  string
+}
+
+// generateSSHKeyPair generates a new ECDSA key pair.
+func generateSSHKeyPair() (*SSHKeyPair, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalECPrivateKey(privateKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := ssh.NewPublicKey(&privateKey.PublicKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &SSHKeyPair{
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})),
+		PublicKey:  string(ssh.MarshalAuthorizedKey(publicKey)),
+	}, nil
+}
+
+// getServiceSSHKeyPair retrieves the dedicated SSH key pair of a load balancer.
+// A nil object is returned, if the load balancer does not have a dedicated key pair, in which case the global key applies.
+func getServiceSSHKeyPair(c *CloudConfiguration, namespace string, serviceName string) (*SSHKeyPair, error) {
+	if c.KubeClient == nil || c.MinimalPermissions {
+		return nil, nil
+	}
+
+	secret, err := c.KubeClient.CoreV1().Secrets(namespace).Get(context.Background(), fmt.Sprintf(fmtLoadBalancerSSHKeyName, serviceName), metav1.GetOptions{})
+
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("Failed to retrieve the SSH key pair - Error: %s", err.Error())
+	}
+
+	keyPair := &SSHKeyPair{
+		PrivateKey: string(secret.Data[v1.SSHAuthPrivateKey]),
+		PublicKey:  string(secret.Data[secretKeySSHPublicKey]),
+	}
+
+	if keyPair.PrivateKey == "" || keyPair.PublicKey == "" {
+		return nil, fmt.Errorf("The Secret '%s/%s' does not contain a complete SSH key pair", namespace, secret.Name)
+	}
+
+	return keyPair, nil
+}
+
+// ensureServiceSSHKeyPair retrieves the dedicated SSH key pair of a load balancer and generates it, if it does not exist.
+// The Secret is owned by the service, which is why it is garbage collected together with the service.
+// A nil object is returned, if dedicated key pairs have not been enabled, or if the load balancer is shared with other clusters, which cannot access the Secret.
+func ensureServiceSSHKeyPair(c *CloudConfiguration, service *v1.Service) (*SSHKeyPair, error) {
+	if !c.SSHPerServiceKeys || isSharedLoadBalancer(service) {
+		return nil, nil
+	}
+
+	if c.KubeClient == nil {
+		return nil, errors.New("Dedicated SSH key pairs require a Kubernetes client")
+	}
+
+	keyPair, err := getServiceSSHKeyPair(c, service.Namespace, service.Name)
+
+	if err != nil || keyPair != nil {
+		return keyPair, err
+	}
+
+	keyPair, err = generateSSHKeyPair()
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to generate an SSH key pair - Error: %s", err.Error())
+	}
+
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf(fmtLoadBalancerSSHKeyName, service.Name),
+			Namespace: service.Namespace,
+			Labels: map[string]string{
+				labelLoadBalancerSSHKey: "true",
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion: "v1",
+					Kind:       "Service",
+					Name:       service.Name,
+					UID:        service.UID,
+				},
+			},
+		},
+		Type: v1.SecretTypeSSHAuth,
+		Data: map[string][]byte{
+			v1.SSHAuthPrivateKey:  []byte(keyPair.PrivateKey),
+			secretKeySSHPublicKey: []byte(keyPair.PublicKey),
+		},
+	}
+
+	_, err = c.KubeClient.CoreV1().Secrets(service.Namespace).Create(context.Background(), secret, metav1.CreateOptions{})
+
+	if err != nil {
+		// Another reconciliation may have created the Secret in the meantime, in which case its key pair takes precedence.
+		if apierrors.IsAlreadyExists(err) {
+			return getServiceSSHKeyPair(c, service.Namespace, service.Name)
+		}
+
+		return nil, fmt.Errorf("Failed to store the SSH key pair - Error: %s", err.Error())
+	}
+
+	debugCloudAction(rtLoadBalancers, "Generated dedicated SSH key pair (name: %s)", getLoadBalancerNameByService(service))
+
+	return keyPair, nil
+}
+
+// deleteLoadBalancerSSHKeyPair deletes the dedicated SSH key pair of a load balancer, if it exists.
+func deleteLoadBalancerSSHKeyPair(c *CloudConfiguration, service *v1.Service) {
+	if c.KubeClient == nil || c.MinimalPermissions {
+		return
+	}
+
+	err := c.KubeClient.CoreV1().Secrets(service.Namespace).Delete(context.Background(), fmt.Sprintf(fmtLoadBalancerSSHKeyName, service.Name), metav1.DeleteOptions{})
+
+	if err != nil && !apierrors.IsNotFound(err) {
+		debugCloudAction(rtLoadBalancers, "Failed to delete SSH key pair (name: %s) - Error: %s", getLoadBalancerNameByService(service), err.Error())
+	}
+}
@@ -0,0 +1,204 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// pathHAProxyAdminSocket specifies the path to the HAProxy stats socket on a load balancer.
+	pathHAProxyAdminSocket = "/run/haproxy/admin.sock"
+)
+
+// haProxyServerStatus describes the status of a server in an HAProxy proxy.
+type haProxyServerStatus struct {
+	Proxy  string
+	Server string
+	Up     bool
+}
+
+// collectHAProxyStats queries the stats socket of a load balancer and updates the backend metrics.
+// The returned map contains the label values of the series, which were set.
+func collectHAProxyStats(c *CloudConfiguration, entry LoadBalancerRegistryEntry) (map[string][]string, error) {
+	server := CloudServer{
+		CloudConfiguration: c,
+		ReadOnly:           true,
+	}
+
+	_, err := server.InitializeByHostname(entry.Hostname)
+
+	if err != nil {
+		return nil, err
+	}
+
+	server.SSHKeyPair, err = getServiceSSHKeyPair(c, entry.Namespace, entry.ServiceName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sshClient, err := server.SSH()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer sshClient.Close()
+
+	output, err := server.RunCommand(sshClient, fmt.Sprintf("echo 'show stat' | socat stdio UNIX-CONNECT:%s", pathHAProxyAdminSocket))
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query the stats socket - Output: %s - Error: %s", string(output), err.Error())
+	}
+
+	statuses, err := parseHAProxyStats(string(output))
+
+	if err != nil {
+		return nil, err
+	}
+
+	series := map[string][]string{}
+
+	for _, s := range statuses {
+		labels := []string{entry.Namespace, entry.ServiceName, s.Proxy, s.Server}
+		value := float64(0)
+
+		if s.Up {
+			value = 1
+		}
+
+		haProxyServerUp.WithLabelValues(labels...).Set(value)
+		series[strings.Join(labels, "/")] = labels
+	}
+
+	return series, nil
+}
+
+// parseHAProxyStats parses the CSV output of the 'show stat' command.
+// Only the status of servers is returned, as the aggregated frontend and backend rows are redundant.
+func parseHAProxyStats(output string) ([]haProxyServerStatus, error) {
+	output = strings.TrimPrefix(strings.TrimSpace(output), "# ")
+	reader := csv.NewReader(strings.NewReader(output))
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	columns := map[string]int{}
+
+	for i, name := range records[0] {
+		columns[name] = i
+	}
+
+	proxyColumn, ok1 := columns["pxname"]
+	serverColumn, ok2 := columns["svname"]
+	statusColumn, ok3 := columns["status"]
+
+	if !ok1 || !ok2 || !ok3 {
+		return nil, fmt.Errorf("The stats output is missing one or more required columns")
+	}
+
+	statuses := []haProxyServerStatus{}
+
+	for _, r := range records[1:] {
+		if len(r) <= statusColumn || len(r) <= proxyColumn || len(r) <= serverColumn {
+			continue
+		}
+
+		if r[serverColumn] == "FRONTEND" || r[serverColumn] == "BACKEND" {
+			continue
+		}
+
+		statuses = append(statuses, haProxyServerStatus{
+			Proxy:  r[proxyColumn],
+			Server: r[serverColumn],
+			Up:     strings.HasPrefix(r[statusColumn], "UP") || r[statusColumn] == "no check",
+		})
+	}
+
+	return statuses, nil
+}
+
+// startHAProxyStatsMonitor periodically collects the backend state of every registered load balancer until the stop channel closes.
+func startHAProxyStatsMonitor(c *CloudConfiguration, stop <-chan struct{}) {
+	if c.StatsInterval == 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(c.StatsInterval)
+		defer ticker.Stop()
+
+		previous := map[string]map[string][]string{}
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			current := map[string]map[string][]string{}
+			entries := c.LoadBalancerRegistry.List()
+
+			loadBalancersRegistered.Set(float64(len(entries)))
+
+			for _, entry := range entries {
+				// Load balancers without a server, such as node passthrough load balancers, do not run HAProxy.
+				if entry.Hostname == "" {
+					continue
+				}
+
+				// The stream module of nginx does not expose statistics, which are comparable to those of HAProxy.
+				if entry.Engine == loadBalancerEngineNginx {
+					continue
+				}
+
+				timeStart := time.Now()
+				series, err := collectHAProxyStats(c, entry)
+				result := resultSuccess
+
+				if err != nil {
+					result = resultFailure
+				}
+
+				haProxyStatsProbeDuration.WithLabelValues(result).Observe(time.Now().Sub(timeStart).Seconds())
+				haProxyStatsProbesTotal.WithLabelValues(result).Inc()
+
+				if err != nil {
+					debugCloudAction(rtLoadBalancers, "Failed to collect HAProxy stats (name: %s) - Error: %s", entry.LoadBalancerName, err.Error())
+
+					continue
+				}
+
+				current[entry.Hostname] = series
+			}
+
+			// Remove the series for servers, which no longer exist, in order to avoid reporting stale state.
+			for hostname, series := range previous {
+				for key, labels := range series {
+					if _, ok := current[hostname][key]; !ok {
+						haProxyServerUp.DeleteLabelValues(labels...)
+					}
+				}
+			}
+
+			previous = current
+
+			haProxyStatsRunsTotal.Inc()
+		}
+	}()
+}
@@ -0,0 +1,263 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// annoLoadBalancerEnableStats is the annotation specifying whether the HAProxy stats page should be enabled with generated credentials.
+	// The credentials are stored in a Secret in the namespace of the service.
+	// Defaults to false.
+	annoLoadBalancerEnableStats = "kubernetes.cloud.dk/load-balancer-enable-stats"
+
+	// annoLoadBalancerStatsPort is the annotation specifying the port, which serves the HAProxy stats page.
+	// The value must be between 1 and 65535.
+	// Defaults to 8404.
+	annoLoadBalancerStatsPort = "kubernetes.cloud.dk/load-balancer-stats-port"
+
+	// annoLoadBalancerStatsSecret is the annotation specifying the name of a Secret of type kubernetes.io/basic-auth in the namespace of the service.
+	// The credentials protect the HAProxy stats page, which is enabled by the annotation.
+	// Defaults to no stats page.
+	annoLoadBalancerStatsSecret = "kubernetes.cloud.dk/load-balancer-stats-secret"
+
+	// fmtLoadBalancerStatsName specifies the name format for the Secret containing the generated credentials for the stats page of a load balancer.
+	fmtLoadBalancerStatsName = "clouddk-load-balancer-%s-stats"
+
+	// labelLoadBalancerStats is the label identifying Secrets which contain the credentials for the stats page of a load balancer.
+	// Changes to the credentials are only pushed to the load balancers, if the Secret has the label.
+	labelLoadBalancerStats = "kubernetes.cloud.dk/load-balancer-stats"
+
+	// statsPagePort specifies the default port, which serves the HAProxy stats page.
+	statsPagePort = 8404
+
+	// statsPageUsername specifies the username of the generated credentials.
+	statsPageUsername = "admin"
+)
+
+var (
+	// reStatsCredential matches the usernames and passwords, which are accepted for the stats page, as they are interpolated into the HAProxy configuration file.
+	reStatsCredential = regexp.MustCompile(`^[0-9A-Za-z]+$`)
+)
+
+// HAProxyStats describes the stats page of a load balancer.
+type HAProxyStats struct {
+	Password string
+	Port     int
+	Username string
+}
+
+// generateStatsPassword generates a random password, which only consists of hexadecimal characters, as it is interpolated into the HAProxy configuration file.
+func generateStatsPassword() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// getServiceStatsSecretName retrieves the name of the Secret containing the credentials for the stats page of a load balancer.
+// An empty string is returned, if the stats page has not been enabled.
+func getServiceStatsSecretName(service *v1.Service) string {
+	if name := service.Annotations[annoLoadBalancerStatsSecret]; name != "" {
+		return name
+	}
+
+	enabled, _ := parseBoolAnnotation(service.Annotations[annoLoadBalancerEnableStats], false)
+
+	if enabled {
+		return fmt.Sprintf(fmtLoadBalancerStatsName, service.Name)
+	}
+
+	return ""
+}
+
+// getServiceStatsCredentials retrieves the credentials for the stats page of a load balancer from a Secret in the namespace of the service.
+// A nil object is returned, if the Secret does not exist.
+func getServiceStatsCredentials(c *CloudConfiguration, service *v1.Service, name string) (*HAProxyStats, error) {
+	secret, err := c.KubeClient.CoreV1().Secrets(service.Namespace).Get(context.Background(), name, metav1.GetOptions{})
+
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("Failed to retrieve the stats Secret '%s/%s' - Error: %s", service.Namespace, name, err.Error())
+	}
+
+	stats := &HAProxyStats{
+		Password: string(secret.Data[v1.BasicAuthPasswordKey]),
+		Username: string(secret.Data[v1.BasicAuthUsernameKey]),
+	}
+
+	if !reStatsCredential.MatchString(stats.Username) || !reStatsCredential.MatchString(stats.Password) {
+		return nil, newConfigurationError(fmt.Errorf("The Secret '%s/%s' must contain a username and a password consisting of alphanumeric characters", service.Namespace, name))
+	}
+
+	return stats, nil
+}
+
+// getServiceStats retrieves the stats page of a load balancer.
+// The credentials are generated, if the stats page has been enabled without specifying a Secret and they do not exist yet.
+// A nil object is returned, if the stats page has not been enabled.
+func getServiceStats(c *CloudConfiguration, service *v1.Service) (*HAProxyStats, error) {
+	enabled, err := parseBoolAnnotation(service.Annotations[annoLoadBalancerEnableStats], false)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", annoLoadBalancerEnableStats, getLoadBalancerNameByService(service))
+
+		return nil, newConfigurationError(err)
+	}
+
+	name := service.Annotations[annoLoadBalancerStatsSecret]
+	annotation := annoLoadBalancerStatsSecret
+
+	if name == "" {
+		if !enabled {
+			return nil, nil
+		}
+
+		annotation = annoLoadBalancerEnableStats
+	}
+
+	port, err := parseIntAnnotation(service.Annotations[annoLoadBalancerStatsPort], statsPagePort, 1, 65535)
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to parse annotation '%s' (name: %s)", annoLoadBalancerStatsPort, getLoadBalancerNameByService(service))
+
+		return nil, newConfigurationError(err)
+	}
+
+	for _, p := range service.Spec.Ports {
+		if int(p.Port) == port {
+			return nil, newConfigurationError(fmt.Errorf("The stats port %d is already used by the service", port))
+		}
+	}
+
+	if isSharedLoadBalancer(service) {
+		return nil, newConfigurationError(fmt.Errorf("The annotation '%s' is not supported for shared load balancers", annotation))
+	}
+
+	if c.MinimalPermissions {
+		return nil, newConfigurationError(fmt.Errorf("The annotation '%s' requires access to Secrets, which is not available with %s", annotation, envMinimalPermissions))
+	}
+
+	if c.KubeClient == nil {
+		return nil, errors.New("The stats page requires a Kubernetes client")
+	}
+
+	var stats *HAProxyStats
+
+	if name != "" {
+		stats, err = getServiceStatsCredentials(c, service, name)
+
+		if err == nil && stats == nil {
+			return nil, fmt.Errorf("The stats Secret '%s/%s' does not exist", service.Namespace, name)
+		}
+	} else {
+		stats, err = getServiceStatsCredentials(c, service, fmt.Sprintf(fmtLoadBalancerStatsName, service.Name))
+
+		if err == nil && stats == nil {
+			stats, err = createServiceStatsCredentials(c, service)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if stats == nil {
+		return nil, fmt.Errorf("The stats credentials were deleted during the update (name: %s)", getLoadBalancerNameByService(service))
+	}
+
+	stats.Port = port
+
+	return stats, nil
+}
+
+// createServiceStatsCredentials generates the credentials for the stats page of a load balancer and stores them in a Secret.
+// The Secret is owned by the service, which is why it is garbage collected together with the service.
+func createServiceStatsCredentials(c *CloudConfiguration, service *v1.Service) (*HAProxyStats, error) {
+	password, err := generateStatsPassword()
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to generate the stats credentials - Error: %s", err.Error())
+	}
+
+	name := fmt.Sprintf(fmtLoadBalancerStatsName, service.Name)
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: service.Namespace,
+			Labels: map[string]string{
+				labelLoadBalancerStats: "true",
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion: "v1",
+					Kind:       "Service",
+					Name:       service.Name,
+					UID:        service.UID,
+				},
+			},
+		},
+		Type: v1.SecretTypeBasicAuth,
+		Data: map[string][]byte{
+			v1.BasicAuthPasswordKey: []byte(password),
+			v1.BasicAuthUsernameKey: []byte(statsPageUsername),
+		},
+	}
+
+	_, err = c.KubeClient.CoreV1().Secrets(service.Namespace).Create(context.Background(), secret, metav1.CreateOptions{})
+
+	if err != nil {
+		// Another reconciliation may have created the Secret in the meantime, in which case its credentials take precedence.
+		if apierrors.IsAlreadyExists(err) {
+			return getServiceStatsCredentials(c, service, name)
+		}
+
+		return nil, fmt.Errorf("Failed to store the stats credentials - Error: %s", err.Error())
+	}
+
+	debugCloudAction(rtLoadBalancers, "Generated stats credentials (name: %s)", getLoadBalancerNameByService(service))
+
+	return &HAProxyStats{
+		Password: password,
+		Username: statsPageUsername,
+	}, nil
+}
+
+// deleteServiceStatsCredentials deletes the generated credentials for the stats page of a load balancer, if they exist.
+func deleteServiceStatsCredentials(c *CloudConfiguration, service *v1.Service) {
+	if c.KubeClient == nil || c.MinimalPermissions {
+		return
+	}
+
+	name := fmt.Sprintf(fmtLoadBalancerStatsName, service.Name)
+
+	// Secrets specified by the annotation are managed by the user, which is why they are left untouched.
+	if service.Annotations[annoLoadBalancerStatsSecret] == name {
+		return
+	}
+
+	err := c.KubeClient.CoreV1().Secrets(service.Namespace).Delete(context.Background(), name, metav1.DeleteOptions{})
+
+	if err != nil && !apierrors.IsNotFound(err) {
+		debugCloudAction(rtLoadBalancers, "Failed to delete stats credentials (name: %s) - Error: %s", getLoadBalancerNameByService(service), err.Error())
+	}
+}
@@ -0,0 +1,137 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"context"
+	"reflect"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	cloudprovider "k8s.io/cloud-provider"
+)
+
+const (
+	// statsCredentialsSyncRetries specifies how many times the update of a load balancer is retried, after its stats credentials have changed.
+	statsCredentialsSyncRetries = 5
+)
+
+// startStatsCredentialsSync watches the Secrets containing the credentials for the stats pages and updates the load balancers, which reference a Secret, when its credentials change, until the stop channel closes.
+func startStatsCredentialsSync(c *CloudConfiguration, client kubernetes.Interface, loadBalancers cloudprovider.LoadBalancer, stop <-chan struct{}) {
+	if c.MinimalPermissions {
+		return
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0, informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+		options.LabelSelector = labelLoadBalancerStats + "=true"
+	}))
+	informer := factory.Core().V1().Secrets().Informer()
+	queue := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "stats-credentials")
+
+	enqueue := func(obj interface{}) {
+		key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+
+		if err == nil {
+			queue.Add(key)
+		}
+	}
+
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj interface{}, newObj interface{}) {
+			oldSecret, oldOK := oldObj.(*v1.Secret)
+			newSecret, newOK := newObj.(*v1.Secret)
+
+			if oldOK && newOK && reflect.DeepEqual(oldSecret.Data, newSecret.Data) {
+				return
+			}
+
+			enqueue(newObj)
+		},
+		DeleteFunc: enqueue,
+	})
+
+	factory.Start(stop)
+
+	go func() {
+		<-stop
+		queue.ShutDown()
+	}()
+
+	go func() {
+		if !cache.WaitForCacheSync(stop, informer.HasSynced) {
+			return
+		}
+
+		for {
+			item, shutdown := queue.Get()
+
+			if shutdown {
+				return
+			}
+
+			key := item.(string)
+
+			if syncStatsCredentials(c, client, loadBalancers, key) || queue.NumRequeues(key) >= statsCredentialsSyncRetries {
+				queue.Forget(key)
+			} else {
+				queue.AddRateLimited(key)
+			}
+
+			queue.Done(key)
+		}
+	}()
+}
+
+// syncStatsCredentials updates the load balancers, which reference a Secret with changed stats credentials, and returns whether every update succeeded.
+// Services without a registered load balancer are skipped, as the credentials are applied, when the load balancer is created.
+func syncStatsCredentials(c *CloudConfiguration, client kubernetes.Interface, loadBalancers cloudprovider.LoadBalancer, key string) bool {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+
+	if err != nil {
+		return true
+	}
+
+	succeeded := true
+
+	for _, entry := range c.LoadBalancerRegistry.List() {
+		if entry.Namespace != namespace || entry.StatsSecretName != name {
+			continue
+		}
+
+		debugCloudAction(rtLoadBalancers, "Stats credentials have changed - Updating load balancer (name: %s)", entry.LoadBalancerName)
+
+		service, err := client.CoreV1().Services(entry.Namespace).Get(context.Background(), entry.ServiceName, metav1.GetOptions{})
+
+		if err != nil {
+			debugCloudAction(rtLoadBalancers, "Failed to retrieve service for stats update (name: %s) - Error: %s", entry.LoadBalancerName, err.Error())
+
+			succeeded = false
+
+			continue
+		}
+
+		nodes, err := getLoadBalancerNodes(c)
+
+		if err != nil {
+			debugCloudAction(rtLoadBalancers, "Failed to list nodes - Error: %s", err.Error())
+
+			return false
+		}
+
+		err = loadBalancers.UpdateLoadBalancer(context.Background(), entry.ClusterName, service, nodes)
+
+		if err != nil {
+			debugCloudAction(rtLoadBalancers, "Failed to apply the new stats credentials (name: %s) - Error: %s", entry.LoadBalancerName, err.Error())
+
+			succeeded = false
+		}
+	}
+
+	return succeeded
+}
@@ -0,0 +1,182 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// fmtLoadBalancerStatusName specifies the name format for the ConfigMap containing the status of a load balancer.
+	fmtLoadBalancerStatusName = "clouddk-load-balancer-%s"
+
+	// labelLoadBalancerStatus is the label identifying ConfigMaps which contain the status of a load balancer.
+	labelLoadBalancerStatus = "kubernetes.cloud.dk/load-balancer-status"
+
+	statusKeyConfigHash              = "configHash"
+	statusKeyDeploymentHash          = "deploymentHash"
+	statusKeyExternalDNSOwnership    = "externalDnsOwnership"
+	statusKeyHAProxyVersion          = "haproxyVersion"
+	statusKeyIPAddresses             = "ipAddresses"
+	statusKeyLastError               = "lastError"
+	statusKeyLastErrorTime           = "lastErrorTime"
+	statusKeyLastSuccessfulReconcile = "lastSuccessfulReconcile"
+	statusKeyProvisioningState       = "provisioningState"
+	statusKeyReady                   = "ready"
+	statusKeyReadyReason             = "readyReason"
+	statusKeyReadyTransitionTime     = "readyTransitionTime"
+	statusKeyServerID                = "serverId"
+)
+
+// getConfigHash retrieves the hash of a configuration file.
+func getConfigHash(contents string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(contents)))
+}
+
+// getDeploymentHash retrieves the hash of everything, which is deployed to the server of a load balancer, when its configuration is updated.
+func getDeploymentHash(server *CloudServer, parts ...string) string {
+	return getConfigHash(server.Information.Identifier + "\x00" + strings.Join(parts, "\x00"))
+}
+
+// getLoadBalancerStatus retrieves the values stored in the status of a load balancer.
+// A nil map is returned, if the status is unavailable.
+func getLoadBalancerStatus(c *CloudConfiguration, service *v1.Service) map[string]string {
+	if c.KubeClient == nil || c.MinimalPermissions {
+		return nil
+	}
+
+	configMap, err := c.KubeClient.CoreV1().ConfigMaps(service.Namespace).Get(context.Background(), fmt.Sprintf(fmtLoadBalancerStatusName, service.Name), metav1.GetOptions{})
+
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			debugCloudAction(rtLoadBalancers, "Failed to retrieve load balancer status (name: %s) - Error: %s", getLoadBalancerNameByService(service), err.Error())
+		}
+
+		return nil
+	}
+
+	return configMap.Data
+}
+
+// getServerIPAddresses retrieves the IP addresses of a server as a comma separated list.
+func getServerIPAddresses(server *CloudServer) string {
+	addresses := []string{}
+
+	for _, nic := range server.Information.NetworkInterfaces {
+		for _, ip := range nic.IPAddresses {
+			addresses = append(addresses, ip.Address)
+		}
+	}
+
+	return strings.Join(addresses, ",")
+}
+
+// recordLoadBalancerError stores the most recent error in the status of a load balancer.
+func recordLoadBalancerError(c *CloudConfiguration, service *v1.Service, err error) {
+	values := getLoadBalancerReadyCondition(err)
+	values[statusKeyLastError] = err.Error()
+	values[statusKeyLastErrorTime] = time.Now().UTC().Format(time.RFC3339)
+
+	updateLoadBalancerStatus(c, service, values)
+}
+
+// recordLoadBalancerSuccess stores the state of a successfully reconciled load balancer in its status.
+func recordLoadBalancerSuccess(c *CloudConfiguration, service *v1.Service, server *CloudServer, configHash string, haProxyVersion string) {
+	values := getLoadBalancerReadyCondition(nil)
+	values[statusKeyConfigHash] = configHash
+	values[statusKeyHAProxyVersion] = haProxyVersion
+	values[statusKeyIPAddresses] = getServerIPAddresses(server)
+	values[statusKeyLastError] = ""
+	values[statusKeyLastErrorTime] = ""
+	values[statusKeyLastSuccessfulReconcile] = time.Now().UTC().Format(time.RFC3339)
+	values[statusKeyProvisioningState] = provisioningStateReady
+	values[statusKeyServerID] = server.Information.Identifier
+
+	updateLoadBalancerStatus(c, service, values)
+}
+
+// updateLoadBalancerStatus merges values into the ConfigMap containing the status of a load balancer.
+// The ConfigMap is owned by the service, which is why it is garbage collected together with the service.
+func updateLoadBalancerStatus(c *CloudConfiguration, service *v1.Service, values map[string]string) {
+	if c.KubeClient == nil || c.MinimalPermissions {
+		return
+	}
+
+	configMaps := c.KubeClient.CoreV1().ConfigMaps(service.Namespace)
+	name := fmt.Sprintf(fmtLoadBalancerStatusName, service.Name)
+	configMap, err := configMaps.Get(context.Background(), name, metav1.GetOptions{})
+
+	if err != nil && !errors.IsNotFound(err) {
+		debugCloudAction(rtLoadBalancers, "Failed to retrieve load balancer status (name: %s) - Error: %s", getLoadBalancerNameByService(service), err.Error())
+
+		return
+	}
+
+	notFound := err != nil
+
+	if notFound {
+		ownerAPIVersion := service.APIVersion
+		ownerKind := service.Kind
+
+		if ownerKind == "" {
+			ownerAPIVersion = "v1"
+			ownerKind = "Service"
+		}
+
+		configMap = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: service.Namespace,
+				Labels: map[string]string{
+					labelLoadBalancerStatus: "true",
+				},
+				OwnerReferences: []metav1.OwnerReference{
+					{
+						APIVersion: ownerAPIVersion,
+						Kind:       ownerKind,
+						Name:       service.Name,
+						UID:        service.UID,
+					},
+				},
+			},
+			Data: map[string]string{},
+		}
+	}
+
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+
+	for k, v := range values {
+		// The transition time of the ready condition must only change, when the status of the condition changes.
+		if k == statusKeyReadyTransitionTime && configMap.Data[statusKeyReady] == values[statusKeyReady] && configMap.Data[k] != "" {
+			continue
+		}
+
+		if v == "" {
+			delete(configMap.Data, k)
+		} else {
+			configMap.Data[k] = v
+		}
+	}
+
+	if notFound {
+		_, err = configMaps.Create(context.Background(), configMap, metav1.CreateOptions{})
+	} else {
+		_, err = configMaps.Update(context.Background(), configMap, metav1.UpdateOptions{})
+	}
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to update load balancer status (name: %s) - Error: %s", getLoadBalancerNameByService(service), err.Error())
+	}
+}
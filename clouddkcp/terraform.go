@@ -0,0 +1,68 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// terraformExportFormatCommands specifies the export format, which contains 'terraform import' commands for Terraform releases without import blocks.
+	terraformExportFormatCommands = "commands"
+
+	// terraformExportFormatHCL specifies the export format, which contains import blocks and resource definitions.
+	terraformExportFormatHCL = "hcl"
+
+	// terraformResourceTypeServer specifies the resource type for servers in the Terraform provider for Cloud.dk.
+	terraformResourceTypeServer = "clouddk_server"
+)
+
+// getTerraformResourceName retrieves the name of the Terraform resource for a load balancer.
+func getTerraformResourceName(lb managedLoadBalancer) string {
+	return strings.Replace(lb.Server.Hostname, "-", "_", -1)
+}
+
+// writeTerraformExport writes the load balancers in a format, which can be imported into the Terraform provider for Cloud.dk.
+// The resources ignore every change, as the servers are still managed by the controller after being imported.
+func writeTerraformExport(w io.Writer, loadBalancers []managedLoadBalancer, format string) error {
+	switch format {
+	case terraformExportFormatCommands:
+		for _, lb := range loadBalancers {
+			fmt.Fprintf(w, "terraform import %s.%s %s\n", terraformResourceTypeServer, getTerraformResourceName(lb), strconv.Quote(lb.Server.Identifier))
+		}
+	case terraformExportFormatHCL:
+		for i, lb := range loadBalancers {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+
+			name := getTerraformResourceName(lb)
+
+			fmt.Fprintf(w, "# Load balancer for service %s managed by %s\n", lb.getServiceKey(), componentName)
+			fmt.Fprintf(w, "import {\n")
+			fmt.Fprintf(w, "  to = %s.%s\n", terraformResourceTypeServer, name)
+			fmt.Fprintf(w, "  id = %s\n", strconv.Quote(lb.Server.Identifier))
+			fmt.Fprintf(w, "}\n\n")
+			fmt.Fprintf(w, "resource %s %s {\n", strconv.Quote(terraformResourceTypeServer), strconv.Quote(name))
+			fmt.Fprintf(w, "  hostname    = %s\n", strconv.Quote(lb.Server.Hostname))
+			fmt.Fprintf(w, "  label       = %s\n", strconv.Quote(lb.Server.Label))
+			fmt.Fprintf(w, "  location_id = %s\n", strconv.Quote(lb.Server.Location.Identifier))
+			fmt.Fprintf(w, "  package_id  = %s\n", strconv.Quote(lb.Server.Package.Identifier))
+			fmt.Fprintf(w, "  template_id = %s\n\n", strconv.Quote(lb.Server.Template.Identifier))
+			fmt.Fprintf(w, "  lifecycle {\n")
+			fmt.Fprintf(w, "    ignore_changes  = all\n")
+			fmt.Fprintf(w, "    prevent_destroy = true\n")
+			fmt.Fprintf(w, "  }\n")
+			fmt.Fprintf(w, "}\n")
+		}
+	default:
+		return fmt.Errorf("Unsupported format '%s'", format)
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// rateLimiterMinRate specifies the lowest number of requests per second, which the rate limiter backs off to.
+	rateLimiterMinRate = 0.1
+
+	// rateLimiterRecoverySteps specifies the number of successful requests, which are required to recover from the minimum rate to the maximum rate.
+	rateLimiterRecoverySteps = 100
+)
+
+// AdaptiveRateLimiter limits the rate of API requests across all controllers.
+// The rate is halved whenever the API responds with status code 429 and increased gradually with every other response.
+type AdaptiveRateLimiter struct {
+	blockedUntil time.Time
+	maxRate      float64
+	mutex        sync.Mutex
+	next         time.Time
+	rate         float64
+}
+
+// newAdaptiveRateLimiter initializes a new AdaptiveRateLimiter object. Requests are not limited, if the maximum rate is zero.
+func newAdaptiveRateLimiter(maxRate float64) *AdaptiveRateLimiter {
+	apiRateLimit.Set(maxRate)
+
+	return &AdaptiveRateLimiter{
+		maxRate: maxRate,
+		rate:    maxRate,
+	}
+}
+
+// Recover increases the rate after a request, which was not rate limited.
+func (l *AdaptiveRateLimiter) Recover() {
+	if l == nil || l.maxRate == 0 {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.rate >= l.maxRate {
+		return
+	}
+
+	l.rate = l.rate + l.maxRate/rateLimiterRecoverySteps
+
+	if l.rate > l.maxRate {
+		l.rate = l.maxRate
+	}
+
+	apiRateLimit.Set(l.rate)
+}
+
+// Throttle halves the rate after a rate limited request and suspends all requests for the duration requested by the API.
+func (l *AdaptiveRateLimiter) Throttle(retryAfter time.Duration) {
+	if l == nil || l.maxRate == 0 {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.rate = l.rate / 2
+
+	if l.rate < rateLimiterMinRate {
+		l.rate = rateLimiterMinRate
+	}
+
+	if blockedUntil := time.Now().Add(retryAfter); blockedUntil.After(l.blockedUntil) {
+		l.blockedUntil = blockedUntil
+	}
+
+	debugCloudAction(rtCloud, "WARNING: The API is rate limiting requests - Reducing the request rate to %.2f per second", l.rate)
+
+	apiRateLimit.Set(l.rate)
+}
+
+// Wait blocks until a request may be sent to the API.
+func (l *AdaptiveRateLimiter) Wait() {
+	if l == nil || l.maxRate == 0 {
+		return
+	}
+
+	l.mutex.Lock()
+
+	now := time.Now()
+	start := l.next
+
+	if start.Before(now) {
+		start = now
+	}
+
+	if start.Before(l.blockedUntil) {
+		start = l.blockedUntil
+	}
+
+	l.next = start.Add(time.Duration(float64(time.Second) / l.rate))
+	l.mutex.Unlock()
+
+	time.Sleep(start.Sub(now))
+}
@@ -0,0 +1,87 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// annoLoadBalancerTLSPorts is the annotation specifying the ports, which terminate TLS with the certificate from annoLoadBalancerTLSSecret.
+	// The value is a comma separated list of ports.
+	// Defaults to 443.
+	annoLoadBalancerTLSPorts = "kubernetes.cloud.dk/load-balancer-tls-ports"
+
+	// annoLoadBalancerTLSSecret is the annotation specifying the name of a Secret of type kubernetes.io/tls in the namespace of the service.
+	// The certificate is used to terminate TLS on the load balancer.
+	// Defaults to no TLS termination.
+	annoLoadBalancerTLSSecret = "kubernetes.cloud.dk/load-balancer-tls-secret"
+
+	// fmtTLSCertificatePath specifies the format for the path to the certificate of a load balancer.
+	fmtTLSCertificatePath = "/etc/haproxy/certs/%s.pem"
+)
+
+// getServiceTLSCertificate retrieves the certificate and private key from the TLS Secret of a service in the PEM format expected by HAProxy.
+// An empty string is returned, if the service does not terminate TLS.
+func getServiceTLSCertificate(c *CloudConfiguration, service *v1.Service) (string, error) {
+	name := service.Annotations[annoLoadBalancerTLSSecret]
+
+	if name == "" {
+		return "", nil
+	}
+
+	if c.KubeClient == nil || c.MinimalPermissions {
+		return "", newConfigurationError(fmt.Errorf("The annotation '%s' requires access to Secrets, which is not available with %s", annoLoadBalancerTLSSecret, envMinimalPermissions))
+	}
+
+	secret, err := c.KubeClient.CoreV1().Secrets(service.Namespace).Get(context.Background(), name, metav1.GetOptions{})
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to retrieve the TLS Secret '%s/%s' - Error: %s", service.Namespace, name, err.Error())
+	}
+
+	cert := secret.Data[v1.TLSCertKey]
+	key := secret.Data[v1.TLSPrivateKeyKey]
+
+	if len(cert) == 0 || len(key) == 0 {
+		return "", newConfigurationError(fmt.Errorf("The Secret '%s/%s' does not contain the keys '%s' and '%s'", service.Namespace, name, v1.TLSCertKey, v1.TLSPrivateKeyKey))
+	}
+
+	_, err = tls.X509KeyPair(cert, key)
+
+	if err != nil {
+		return "", newConfigurationError(fmt.Errorf("The Secret '%s/%s' does not contain a valid key pair - Error: %s", service.Namespace, name, err.Error()))
+	}
+
+	return strings.TrimSpace(string(cert)) + "\n" + strings.TrimSpace(string(key)) + "\n", nil
+}
+
+// getTLSCertificatePath retrieves the path to the certificate of a load balancer.
+func getTLSCertificatePath(service *v1.Service) string {
+	return fmt.Sprintf(fmtTLSCertificatePath, getLoadBalancerNameByService(service))
+}
+
+// uploadTLSCertificate uploads the certificate of a load balancer, which must exist, before the HAProxy configuration referencing it is validated.
+func uploadTLSCertificate(server *CloudServer, sshClient *ssh.Client, sftpClient *sftp.Client, service *v1.Service, certificate string) error {
+	certificatePath := getTLSCertificatePath(service)
+
+	_, err := server.RunCommand(sshClient, fmt.Sprintf("mkdir -p %s && chmod 700 %s", path.Dir(certificatePath), path.Dir(certificatePath)))
+
+	if err != nil {
+		return err
+	}
+
+	return server.UploadFile(sshClient, sftpClient, certificatePath, bytes.NewBufferString(certificate), fileModePrivate, 0, 0)
+}
@@ -0,0 +1,69 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// annoTopologyAwareHints is the annotation, which enables topology aware routing for a service.
+	annoTopologyAwareHints = "service.kubernetes.io/topology-aware-hints"
+
+	// annoTopologyMode is the annotation, which replaces annoTopologyAwareHints in newer Kubernetes releases.
+	annoTopologyMode = "service.kubernetes.io/topology-mode"
+
+	// labelTopologyZone is the label, which identifies the zone of a node in newer Kubernetes releases.
+	labelTopologyZone = "topology.kubernetes.io/zone"
+)
+
+// getNodeZone retrieves the zone of a node from its labels.
+func getNodeZone(node *v1.Node) string {
+	if zone := node.Labels[labelTopologyZone]; zone != "" {
+		return zone
+	}
+
+	return node.Labels[v1.LabelZoneFailureDomain]
+}
+
+// getTopologyAwareNodes splits the nodes into the nodes in the zone of a load balancer and the nodes in other zones, if a service requests topology aware routing.
+// Every node is returned as a local node, if the service does not request topology aware routing, or if the zone of the load balancer does not contain any nodes.
+func getTopologyAwareNodes(service *v1.Service, nodes []*v1.Node, zone string) (localNodes []*v1.Node, remoteNodes []*v1.Node) {
+	if !isTopologyAware(service) || zone == "" {
+		return nodes, nil
+	}
+
+	localNodes = make([]*v1.Node, 0, len(nodes))
+	remoteNodes = make([]*v1.Node, 0, len(nodes))
+
+	for _, node := range nodes {
+		if getNodeZone(node) == zone {
+			localNodes = append(localNodes, node)
+		} else {
+			remoteNodes = append(remoteNodes, node)
+		}
+	}
+
+	if len(localNodes) == 0 {
+		debugCloudAction(rtLoadBalancers, "Falling back to all zones, as zone '%s' does not contain any nodes (name: %s)", zone, getLoadBalancerNameByService(service))
+
+		return nodes, nil
+	}
+
+	return localNodes, remoteNodes
+}
+
+// isTopologyAware determines whether a service requests topology aware routing.
+func isTopologyAware(service *v1.Service) bool {
+	for _, name := range []string{annoTopologyMode, annoTopologyAwareHints} {
+		if strings.EqualFold(service.Annotations[name], "auto") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,138 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// traceSpanKey is the context key for the active trace span.
+type traceSpanKey struct{}
+
+// Tracer creates spans for the steps of the provisioning pipeline.
+// Finished spans are always observed by the span duration metric and optionally written to a file in JSON format.
+type Tracer struct {
+	file  *os.File
+	mutex sync.Mutex
+}
+
+// TraceSpan describes a timed step within a trace.
+type TraceSpan struct {
+	Attributes   map[string]string `json:"attributes,omitempty"`
+	EndTime      time.Time         `json:"endTime"`
+	Error        string            `json:"error,omitempty"`
+	Name         string            `json:"name"`
+	ParentSpanID string            `json:"parentSpanId,omitempty"`
+	SpanID       string            `json:"spanId"`
+	StartTime    time.Time         `json:"startTime"`
+	TraceID      string            `json:"traceId"`
+
+	tracer *Tracer
+}
+
+// newTracer initializes a new tracer, which writes finished spans to the specified file, or to standard output if the path is '-'.
+func newTracer(filePath string) (*Tracer, error) {
+	t := &Tracer{}
+
+	if filePath == "-" {
+		t.file = os.Stdout
+	} else if filePath != "" {
+		file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, fileModePrivate)
+
+		if err != nil {
+			return nil, err
+		}
+
+		t.file = file
+	}
+
+	return t, nil
+}
+
+// Start starts a new span, which becomes a child of the active span in the context, if any.
+// Attributes are specified as key/value pairs.
+func (t *Tracer) Start(ctx context.Context, name string, attributes ...string) (context.Context, *TraceSpan) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	span := &TraceSpan{
+		Attributes: map[string]string{},
+		Name:       name,
+		SpanID:     newTraceID(8),
+		StartTime:  time.Now(),
+		tracer:     t,
+	}
+
+	if parent, ok := ctx.Value(traceSpanKey{}).(*TraceSpan); ok && parent != nil {
+		span.ParentSpanID = parent.SpanID
+		span.TraceID = parent.TraceID
+	} else {
+		span.TraceID = newTraceID(16)
+	}
+
+	for i := 0; i+1 < len(attributes); i += 2 {
+		span.Attributes[attributes[i]] = attributes[i+1]
+	}
+
+	return context.WithValue(ctx, traceSpanKey{}, span), span
+}
+
+// End finishes the span and records the error, if any.
+func (s *TraceSpan) End(err error) {
+	if s == nil {
+		return
+	}
+
+	s.EndTime = time.Now()
+
+	result := resultSuccess
+
+	if err != nil {
+		result = resultFailure
+		s.Error = err.Error()
+	}
+
+	traceSpanDuration.WithLabelValues(s.Name, result).Observe(s.EndTime.Sub(s.StartTime).Seconds())
+
+	if s.tracer.file == nil {
+		return
+	}
+
+	body, err := json.Marshal(s)
+
+	if err != nil {
+		debugCloudAction(rtCloud, "Failed to encode trace span '%s' - Error: %s", s.Name, err.Error())
+
+		return
+	}
+
+	s.tracer.mutex.Lock()
+	defer s.tracer.mutex.Unlock()
+
+	_, err = s.tracer.file.Write(append(body, '\n'))
+
+	if err != nil {
+		debugCloudAction(rtCloud, "Failed to write trace span '%s' - Error: %s", s.Name, err.Error())
+	}
+}
+
+// newTraceID generates a random hexadecimal identifier of the specified number of bytes.
+func newTraceID(size int) string {
+	b := make([]byte, size)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,233 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/MakeNowJust/heredoc"
+	"golang.org/x/crypto/ssh"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// annoLoadBalancerTuningProfile is the annotation specifying which kernel tuning profile to apply to a load balancer.
+	// Options are aggressive, custom and default.
+	// Defaults to the value of the environment variable CLOUDDK_TUNING_PROFILE.
+	annoLoadBalancerTuningProfile = "kubernetes.cloud.dk/load-balancer-tuning-profile"
+
+	pathSecurityLimitsConf  = "/etc/security/limits.conf"
+	pathSysctlConf          = "/etc/sysctl.d/20-maximum-performance.conf"
+	pathTuningStatus        = "/var/lib/clouddk/tuning-status"
+	pathVerifyTuningScript  = "/usr/local/sbin/clouddk-verify-tuning"
+	pathVerifyTuningService = "/etc/systemd/system/clouddk-verify-tuning.service"
+
+	// tuningProfileAggressive specifies the tuning profile optimized for maximum throughput.
+	tuningProfileAggressive = "aggressive"
+
+	// tuningProfileCustom specifies the tuning profile read from a user supplied file.
+	tuningProfileCustom = "custom"
+
+	// tuningProfileDefault specifies the conservative tuning profile.
+	tuningProfileDefault = "default"
+)
+
+var (
+	securityLimitsConf = heredoc.Doc(`
+		* soft nproc 1048576
+		* hard nproc 1048576
+		* soft nofile 1048576
+		* hard nofile 1048576
+		* soft stack 1048576
+		* hard stack 1048576
+		* soft memlock unlimited
+		* hard memlock unlimited
+		haproxy soft nproc 1048576
+		haproxy hard nproc 1048576
+		haproxy soft nofile 1048576
+		haproxy hard nofile 1048576
+		haproxy soft stack 1048576
+		haproxy hard stack 1048576
+		haproxy soft memlock unlimited
+		haproxy hard memlock unlimited
+	`)
+	verifyTuningScript = heredoc.Doc(`
+		#!/bin/bash
+		# Re-apply the kernel tuning and record any settings, which do not match the expected values.
+		sysctl --system >/dev/null 2>&1 || true
+
+		mkdir -p /var/lib/clouddk
+		: > /var/lib/clouddk/tuning-status.tmp
+
+		while IFS='=' read -r key value; do
+			if [[ -z "$key" || "$key" == \#* ]]; then
+				continue
+			fi
+
+			expected="$(echo "$value" | tr -s '[:space:]' ' ' | sed 's/^ *//;s/ *$//')"
+			actual="$(sysctl -n "$key" 2>/dev/null | tr -s '[:space:]' ' ' | sed 's/^ *//;s/ *$//')"
+
+			if [[ "$actual" != "$expected" ]]; then
+				echo "${key}: expected '${expected}' but found '${actual}'" >> /var/lib/clouddk/tuning-status.tmp
+			fi
+		done < /etc/sysctl.d/20-maximum-performance.conf
+
+		if systemctl cat haproxy.service >/dev/null 2>&1 && [[ "$(systemctl show haproxy.service -p LimitNOFILE --value)" != "1048576" ]]; then
+			echo "LimitNOFILE: expected '1048576' for the HAProxy service" >> /var/lib/clouddk/tuning-status.tmp
+		fi
+
+		mv -f /var/lib/clouddk/tuning-status.tmp /var/lib/clouddk/tuning-status
+	`)
+	verifyTuningService = heredoc.Doc(`
+		[Unit]
+		Description=Re-apply and verify the kernel tuning for HAProxy
+		After=systemd-sysctl.service
+		Before=haproxy.service
+
+		[Service]
+		Type=oneshot
+		ExecStart=/usr/local/sbin/clouddk-verify-tuning
+
+		[Install]
+		WantedBy=multi-user.target
+	`)
+)
+
+var (
+	// sysctlProfiles contains the kernel parameters for the built-in tuning profiles.
+	sysctlProfiles = map[string]map[string]string{
+		tuningProfileAggressive: {
+			"fs.file-max":                     "1048576",
+			"fs.inotify.max_user_instances":   "1048576",
+			"fs.inotify.max_user_watches":     "1048576",
+			"fs.nr_open":                      "1048576",
+			"net.core.netdev_max_backlog":     "1048576",
+			"net.core.rmem_max":               "16777216",
+			"net.core.somaxconn":              "65535",
+			"net.core.wmem_max":               "16777216",
+			"net.ipv4.tcp_congestion_control": "htcp",
+			"net.ipv4.ip_local_port_range":    "32768 65535",
+			"net.ipv4.tcp_fin_timeout":        "5",
+			"net.ipv4.tcp_max_orphans":        "1048576",
+			"net.ipv4.tcp_max_syn_backlog":    "20480",
+			"net.ipv4.tcp_max_tw_buckets":     "400000",
+			"net.ipv4.tcp_no_metrics_save":    "1",
+			"net.ipv4.tcp_rmem":               "4096 87380 16777216",
+			"net.ipv4.tcp_synack_retries":     "2",
+			"net.ipv4.tcp_syn_retries":        "2",
+			"net.ipv4.tcp_tw_recycle":         "1",
+			"net.ipv4.tcp_tw_reuse":           "1",
+			"net.ipv4.tcp_wmem":               "4096 65535 16777216",
+			"vm.max_map_count":                "1048576",
+			"vm.min_free_kbytes":              "65535",
+			"vm.overcommit_memory":            "1",
+			"vm.swappiness":                   "0",
+			"vm.vfs_cache_pressure":           "50",
+		},
+		tuningProfileDefault: {
+			"fs.file-max":                  "1048576",
+			"fs.nr_open":                   "1048576",
+			"net.core.netdev_max_backlog":  "65536",
+			"net.core.rmem_max":            "16777216",
+			"net.core.somaxconn":           "65535",
+			"net.core.wmem_max":            "16777216",
+			"net.ipv4.ip_local_port_range": "32768 65535",
+			"net.ipv4.tcp_fin_timeout":     "15",
+			"net.ipv4.tcp_max_syn_backlog": "20480",
+			"net.ipv4.tcp_tw_reuse":        "1",
+			"vm.swappiness":                "10",
+		},
+	}
+
+	// sysctlRemovedParameters maps kernel parameters to the kernel version, which removed them.
+	sysctlRemovedParameters = map[string][2]int{
+		"net.ipv4.tcp_tw_recycle": {4, 12},
+	}
+)
+
+// getSysctlConf renders the kernel parameters of a tuning profile for a specific kernel version.
+func getSysctlConf(c *CloudConfiguration, profile string, kernelVersion string) (string, error) {
+	if profile == tuningProfileCustom {
+		if c.TuningProfileFile == "" {
+			return "", fmt.Errorf("The tuning profile '%s' requires the environment variable '%s' to be set", tuningProfileCustom, envTuningProfileFile)
+		}
+
+		contents, err := ioutil.ReadFile(c.TuningProfileFile)
+
+		if err != nil {
+			return "", err
+		}
+
+		return string(contents), nil
+	}
+
+	parameters, ok := sysctlProfiles[profile]
+
+	if !ok {
+		return "", fmt.Errorf("Unsupported tuning profile '%s'", profile)
+	}
+
+	kernelMajor, kernelMinor := parseKernelVersion(kernelVersion)
+	keys := make([]string, 0, len(parameters))
+
+	for k := range parameters {
+		if removedIn, ok := sysctlRemovedParameters[k]; ok {
+			if kernelMajor > removedIn[0] || (kernelMajor == removedIn[0] && kernelMinor >= removedIn[1]) {
+				continue
+			}
+		}
+
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	var b strings.Builder
+
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s=%s\n", k, parameters[k])
+	}
+
+	return b.String(), nil
+}
+
+// parseKernelVersion parses the major and minor version from a kernel release string like '4.15.0-55-generic'.
+func parseKernelVersion(release string) (major int, minor int) {
+	parts := strings.SplitN(strings.TrimSpace(release), ".", 3)
+
+	if len(parts) < 2 {
+		return 0, 0
+	}
+
+	major, _ = strconv.Atoi(parts[0])
+	minor, _ = strconv.Atoi(strings.TrimFunc(parts[1], func(r rune) bool { return r < '0' || r > '9' }))
+
+	return major, minor
+}
+
+// verifyKernelTuning reports kernel tuning settings, which were not applied after the most recent reboot of a load balancer.
+func verifyKernelTuning(server *CloudServer, sshClient *ssh.Client, service *v1.Service) {
+	loadBalancerName := getLoadBalancerNameByService(service)
+	output, err := server.RunCommand(sshClient, fmt.Sprintf("cat %s 2>/dev/null || true", pathTuningStatus))
+
+	if err != nil {
+		debugCloudAction(rtLoadBalancers, "Failed to verify the kernel tuning (name: %s) - Error: %s", loadBalancerName, err.Error())
+
+		return
+	}
+
+	mismatches := strings.TrimSpace(string(output))
+
+	if mismatches != "" {
+		mismatches = strings.Replace(mismatches, "\n", "; ", -1)
+
+		debugCloudAction(rtLoadBalancers, "WARNING: Kernel tuning was not fully applied after reboot (name: %s) - Mismatches: %s", loadBalancerName, mismatches)
+		recordServiceEvent(server.CloudConfiguration, service, v1.EventTypeWarning, eventReasonKernelTuningMismatch, "Kernel tuning was not fully applied after reboot: %s", mismatches)
+	}
+}
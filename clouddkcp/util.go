@@ -23,6 +23,22 @@ func debugCloudAction(resourceType string, format string, v ...interface{}) {
 	log.Printf(fmt.Sprintf("[%s] ", resourceType)+format, v...)
 }
 
+// parseNamespacedName splits a reference of the form <namespace>/<name> into its parts.
+func parseNamespacedName(value string) (string, string, error) {
+	parts := strings.SplitN(value, "/", 2)
+
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("The reference '%s' must be of the form <namespace>/<name>", value)
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// shellQuote quotes a string for safe use as a single argument in a shell command.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'"'"'`, -1) + "'"
+}
+
 // trimProviderID removes the provider name from the id.
 func trimProviderID(id string) string {
 	return strings.TrimPrefix(id, "clouddk://")
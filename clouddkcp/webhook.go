@@ -0,0 +1,389 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package clouddkcp
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+const (
+	// pathWebhookMutateServices specifies the path, which injects the default annotations into services.
+	pathWebhookMutateServices = "/mutate-services"
+
+	// pathWebhookValidateServices specifies the path, which validates services.
+	pathWebhookValidateServices = "/validate-services"
+
+	// prefixAnnotations specifies the prefix of the annotations, which are supported by the cloud controller manager.
+	prefixAnnotations = "kubernetes.cloud.dk/"
+)
+
+// annotationValidator validates the value of an annotation.
+type annotationValidator func(value string) error
+
+// validateBoolAnnotation creates a validator for annotations containing a boolean.
+func validateBoolAnnotation() annotationValidator {
+	return func(value string) error {
+		if value != "true" && value != "false" {
+			return fmt.Errorf("Unsupported value '%s'", value)
+		}
+
+		return nil
+	}
+}
+
+// validateIntAnnotation creates a validator for annotations containing an integer within a range.
+func validateIntAnnotation(minValue int, maxValue int) annotationValidator {
+	return func(value string) error {
+		_, err := parseIntAnnotation(value, 0, minValue, maxValue)
+
+		return err
+	}
+}
+
+// validateNamespacedNameAnnotation creates a validator for annotations containing a reference of the form <namespace>/<name>.
+func validateNamespacedNameAnnotation() annotationValidator {
+	return func(value string) error {
+		_, _, err := parseNamespacedName(value)
+
+		return err
+	}
+}
+
+// validatePortListAnnotation creates a validator for annotations containing a comma separated list of ports.
+func validatePortListAnnotation() annotationValidator {
+	return func(value string) error {
+		_, err := parsePortListAnnotation(value, nil)
+
+		return err
+	}
+}
+
+// validateStringAnnotation creates a validator for annotations containing one of the supported values.
+func validateStringAnnotation(supportedValues []string) annotationValidator {
+	return func(value string) error {
+		_, err := parseStringAnnotation(value, "", supportedValues)
+
+		return err
+	}
+}
+
+// getLoadBalancerAnnotationValidators retrieves the validators for the load balancer annotations.
+// The rules must match the ones applied when the annotations are parsed during reconciliation.
+func getLoadBalancerAnnotationValidators() map[string]annotationValidator {
+	return map[string]annotationValidator{
+		annoLoadBalancerAdoptHostKeyFingerprint:       validateAdoptHostKeyFingerprint,
+		annoLoadBalancerAdoptSSHUser:                  validateAdoptSSHUser,
+		annoLoadBalancerAlgorithm:                     validateStringAnnotation([]string{"leastconn", "roundrobin", "source"}),
+		annoLoadBalancerClientTimeout:                 validateIntAnnotation(1, 86400),
+		annoLoadBalancerConnectionLimit:               validateIntAnnotation(1, 20000),
+		annoLoadBalancerEnableMetrics:                 validateBoolAnnotation(),
+		annoLoadBalancerEnableProxyProtocol:           validateBoolAnnotation(),
+		annoLoadBalancerEnableStats:                   validateBoolAnnotation(),
+		annoLoadBalancerEngine:                        validateStringAnnotation([]string{loadBalancerEngineHAProxy, loadBalancerEngineNginx}),
+		annoLoadBalancerHAProxyDeployment:             validateStringAnnotation([]string{haProxyDeploymentContainer, haProxyDeploymentHost}),
+		annoLoadBalancerHAProxyImage:                  validateContainerImage,
+		annoLoadBalancerHAProxyVersion:                validateHAProxyVersion,
+		annoLoadBalancerHealthCheckInterval:           validateIntAnnotation(3, 300),
+		annoLoadBalancerHealthCheckPath:               validateHealthCheckPath,
+		annoLoadBalancerHealthCheckProtocol:           validateStringAnnotation([]string{loadBalancerProtocolHTTP, loadBalancerProtocolTCP}),
+		annoLoadBalancerHealthCheckStatus:             validateIntAnnotation(100, 599),
+		annoLoadBalancerHealthCheckThresholdHealthy:   validateIntAnnotation(2, 10),
+		annoLoadBalancerHealthCheckThresholdUnhealthy: validateIntAnnotation(2, 10),
+		annoLoadBalancerHealthCheckTimeout:            validateIntAnnotation(3, 300),
+		annoLoadBalancerHostname:                      validateIngressHostname,
+		annoLoadBalancerIP:                            validateLoadBalancerIP,
+		annoLoadBalancerLogShippingEndpoint: func(value string) error {
+			_, err := getFluentBitConf(value)
+
+			return err
+		},
+		annoLoadBalancerMaintenanceWindow: func(value string) error {
+			_, err := parseMaintenanceWindow(value)
+
+			return err
+		},
+		annoLoadBalancerMode:                           validateStringAnnotation([]string{loadBalancerModeNodePassthrough, loadBalancerModeServer}),
+		annoLoadBalancerOSTemplate:                     validateServerTemplate,
+		annoLoadBalancerPackageID:                      validatePackageID,
+		annoLoadBalancerProtocol:                       validateStringAnnotation([]string{loadBalancerProtocolHTTP, loadBalancerProtocolTCP}),
+		annoLoadBalancerProxyProtocolPorts:             validatePortListAnnotation(),
+		annoLoadBalancerRateLimitConcurrentConnections: validateIntAnnotation(1, 100000),
+		annoLoadBalancerRateLimitConnectionsPerSecond:  validateIntAnnotation(1, 100000),
+		annoLoadBalancerServerTimeout:                  validateIntAnnotation(1, 86400),
+		annoLoadBalancerSharedName:                     validateLoadBalancerSharedName,
+		annoLoadBalancerStatsPort:                      validateIntAnnotation(1, 65535),
+		annoLoadBalancerStickySessions:                 validateBoolAnnotation(),
+		annoLoadBalancerTemplate:                       validateNamespacedNameAnnotation(),
+		annoLoadBalancerTLSPorts:                       validatePortListAnnotation(),
+		annoLoadBalancerTuningProfile:                  validateStringAnnotation([]string{tuningProfileAggressive, tuningProfileCustom, tuningProfileDefault}),
+		annoLoadBalancerTunnelTimeout:                  validateIntAnnotation(1, 604800),
+	}
+}
+
+// validateLoadBalancerAnnotations validates the load balancer annotations of a service.
+// Empty values are accepted, as they select the default value.
+func validateLoadBalancerAnnotations(service *v1.Service) []string {
+	validators := getLoadBalancerAnnotationValidators()
+	problems := []string{}
+
+	for name, value := range service.Annotations {
+		validator, ok := validators[name]
+
+		if !ok || value == "" {
+			continue
+		}
+
+		err := validator(value)
+
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", name, err.Error()))
+		}
+	}
+
+	sort.Strings(problems)
+
+	return problems
+}
+
+// reviewService determines whether a service should be admitted.
+func reviewService(request *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+	response := &admissionv1beta1.AdmissionResponse{
+		Allowed: true,
+		UID:     request.UID,
+	}
+
+	service := &v1.Service{}
+	err := json.Unmarshal(request.Object.Raw, service)
+
+	if err != nil {
+		response.Allowed = false
+		response.Result = &metav1.Status{
+			Message: fmt.Sprintf("Failed to decode the service - Error: %s", err.Error()),
+			Reason:  metav1.StatusReasonBadRequest,
+		}
+
+		return response
+	}
+
+	if service.Spec.Type != v1.ServiceTypeLoadBalancer {
+		return response
+	}
+
+	problems := validateLoadBalancerAnnotations(service)
+
+	if len(problems) > 0 {
+		response.Allowed = false
+		response.Result = &metav1.Status{
+			Message: fmt.Sprintf("The service contains invalid annotations: %s", strings.Join(problems, "; ")),
+			Reason:  metav1.StatusReasonInvalid,
+		}
+	}
+
+	return response
+}
+
+// mutateService injects the default annotations into a new service, which does not specify them.
+func mutateService(defaults map[string]string, request *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+	response := &admissionv1beta1.AdmissionResponse{
+		Allowed: true,
+		UID:     request.UID,
+	}
+
+	if len(defaults) == 0 || request.Operation != admissionv1beta1.Create {
+		return response
+	}
+
+	service := &v1.Service{}
+	err := json.Unmarshal(request.Object.Raw, service)
+
+	if err != nil || service.Spec.Type != v1.ServiceTypeLoadBalancer {
+		return response
+	}
+
+	names := make([]string, 0, len(defaults))
+
+	for name := range defaults {
+		if _, ok := service.Annotations[name]; !ok {
+			names = append(names, name)
+		}
+	}
+
+	if len(names) == 0 {
+		return response
+	}
+
+	sort.Strings(names)
+
+	patch := []map[string]interface{}{}
+
+	if service.Annotations == nil {
+		patch = append(patch, map[string]interface{}{
+			"op":    "add",
+			"path":  "/metadata/annotations",
+			"value": map[string]string{},
+		})
+	}
+
+	for _, name := range names {
+		patch = append(patch, map[string]interface{}{
+			"op":    "add",
+			"path":  "/metadata/annotations/" + strings.Replace(strings.Replace(name, "~", "~0", -1), "/", "~1", -1),
+			"value": defaults[name],
+		})
+	}
+
+	response.Patch, err = json.Marshal(patch)
+
+	if err != nil {
+		return response
+	}
+
+	patchType := admissionv1beta1.PatchTypeJSONPatch
+	response.PatchType = &patchType
+
+	debugCloudAction(rtLoadBalancers, "Injecting default annotations into service '%s/%s' - Annotations: %s", request.Namespace, request.Name, strings.Join(names, ", "))
+
+	return response
+}
+
+// loadWebhookDefaultAnnotations loads the annotations injected by the mutating admission webhook from a YAML or JSON file.
+// The annotations are validated, which prevents the webhook from injecting annotations, which would be rejected.
+func loadWebhookDefaultAnnotations(path string) (map[string]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	annotations := map[string]string{}
+	err = yaml.NewYAMLOrJSONDecoder(file, 4096).Decode(&annotations)
+
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	for name := range annotations {
+		if !strings.HasPrefix(name, prefixAnnotations) {
+			return nil, fmt.Errorf("Unsupported annotation '%s'", name)
+		}
+	}
+
+	problems := validateLoadBalancerAnnotations(&v1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: annotations,
+		},
+	})
+
+	if len(problems) > 0 {
+		return nil, errors.New(strings.Join(problems, "; "))
+	}
+
+	return annotations, nil
+}
+
+// handleAdmissionReview decodes an admission review and responds with the result of a review function.
+func handleAdmissionReview(w http.ResponseWriter, r *http.Request, reviewFunc func(request *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 3*1024*1024))
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	review := &admissionv1beta1.AdmissionReview{}
+	err = json.Unmarshal(body, review)
+
+	if err != nil || review.Request == nil {
+		http.Error(w, "The request does not contain an admission review", http.StatusBadRequest)
+
+		return
+	}
+
+	request := review.Request
+	review.Response = reviewFunc(request)
+	review.Request = nil
+
+	if !review.Response.Allowed {
+		debugCloudAction(rtLoadBalancers, "Rejected service '%s/%s' - Reason: %s", request.Namespace, request.Name, review.Response.Result.Message)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(review)
+}
+
+// handleValidateServices handles validating admission reviews for services.
+func handleValidateServices(w http.ResponseWriter, r *http.Request) {
+	handleAdmissionReview(w, r, reviewService)
+}
+
+// startWebhookServer serves the admission webhooks until the stop channel closes.
+func startWebhookServer(c *CloudConfiguration, stop <-chan struct{}) {
+	if c.WebhookBindAddress == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(pathWebhookMutateServices, func(w http.ResponseWriter, r *http.Request) {
+		handleAdmissionReview(w, r, func(request *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+			return mutateService(c.WebhookDefaults, request)
+		})
+	})
+	mux.HandleFunc(pathWebhookValidateServices, handleValidateServices)
+
+	server := &http.Server{
+		Addr:         c.WebhookBindAddress,
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+
+	if c.StrictCrypto {
+		server.TLSConfig = &tls.Config{}
+		applyTLSCryptoPolicy(server.TLSConfig)
+	}
+
+	go func() {
+		debugCloudAction(rtCloud, "Serving the admission webhook on '%s'", c.WebhookBindAddress)
+
+		err := server.ListenAndServeTLS(c.WebhookTLSCertFile, c.WebhookTLSKeyFile)
+
+		if err != nil && err != http.ErrServerClosed {
+			debugCloudAction(rtCloud, "Failed to serve the admission webhook on '%s' - Error: %s", c.WebhookBindAddress, err.Error())
+		}
+	}()
+
+	go func() {
+		<-stop
+		server.Close()
+	}()
+}
@@ -49,6 +49,7 @@ func (z Zones) GetZoneByProviderID(ctx context.Context, providerID string) (clou
 
 	server := CloudServer{
 		CloudConfiguration: z.config,
+		ReadOnly:           true,
 	}
 
 	_, err := server.InitializeByID(providerID)
@@ -72,6 +73,7 @@ func (z Zones) GetZoneByNodeName(ctx context.Context, nodeName types.NodeName) (
 
 	server := CloudServer{
 		CloudConfiguration: z.config,
+		ReadOnly:           true,
 	}
 
 	_, err := server.InitializeByHostname(string(nodeName))
@@ -6,30 +6,47 @@ package main
 
 import (
 	"fmt"
-	"math/rand"
 	"os"
-	"time"
 
 	"github.com/spf13/pflag"
-	"k8s.io/component-base/logs"
-	"k8s.io/kubernetes/cmd/cloud-controller-manager/app"
+	"k8s.io/apimachinery/pkg/util/wait"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/cloud-provider/app"
+	"k8s.io/cloud-provider/app/config"
+	"k8s.io/cloud-provider/names"
+	"k8s.io/cloud-provider/options"
+	"k8s.io/component-base/cli"
+	cliflag "k8s.io/component-base/cli/flag"
+	"k8s.io/klog/v2"
 
-	_ "k8s.io/kubernetes/pkg/util/prometheusclientgo"
-	_ "k8s.io/kubernetes/pkg/version/prometheus"
+	_ "k8s.io/component-base/metrics/prometheus/clientgo"
+	_ "k8s.io/component-base/metrics/prometheus/version"
 
 	"github.com/danitso/clouddk-cloud-controller-manager/clouddkcp"
 )
 
 // main initializes the cloud provider.
 func main() {
-	rand.Seed(time.Now().UnixNano())
+	ccmOptions, err := options.NewCloudControllerManagerOptions()
 
-	command := app.NewCloudControllerManagerCommand()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Failed to initialize the command options: %s\n", err)
+		os.Exit(1)
+	}
+
+	command := app.NewCloudControllerManagerCommand(
+		ccmOptions,
+		cloudInitializer,
+		app.DefaultInitFuncConstructors,
+		names.CCMControllerAliases(),
+		cliflag.NamedFlagSets{},
+		wait.NeverStop,
+	)
 	command.Flags().VisitAll(func(fl *pflag.Flag) {
 		var err error
 
 		switch fl.Name {
-		case "allow-untagged-cloud", "authentication-skip-lookup":
+		case "authentication-skip-lookup":
 			err = fl.Value.Set("true")
 		case "cloud-provider":
 			err = fl.Value.Set(clouddkcp.ProviderName)
@@ -41,11 +58,24 @@ func main() {
 		}
 	})
 
-	logs.InitLogs()
-	defer logs.FlushLogs()
+	command.AddCommand(clouddkcp.NewDebugCommand())
+	command.AddCommand(clouddkcp.NewLoadBalancerCommand())
 
-	if err := command.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
-		os.Exit(1)
+	os.Exit(cli.Run(command))
+}
+
+// cloudInitializer initializes the cloud provider selected by the command line flags.
+func cloudInitializer(c *config.CompletedConfig) cloudprovider.Interface {
+	cloudConfig := c.ComponentConfig.KubeCloudShared.CloudProvider
+	cloud, err := cloudprovider.InitCloudProvider(cloudConfig.Name, cloudConfig.CloudConfigFile)
+
+	if err != nil {
+		klog.Fatalf("Failed to initialize the cloud provider - Error: %s", err.Error())
 	}
+
+	if cloud == nil {
+		klog.Fatalf("Failed to initialize the cloud provider '%s'", cloudConfig.Name)
+	}
+
+	return cloud
 }